@@ -0,0 +1,134 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+
+	"basicthreads/internal/addresses"
+	"basicthreads/internal/database"
+	"basicthreads/internal/products"
+)
+
+func addressError(c echo.Context, err error) error {
+	switch {
+	case errors.Is(err, addresses.ErrNotFound):
+		return jsonError(c, http.StatusNotFound, "Address not found")
+	case errors.Is(err, addresses.ErrTooMany):
+		return jsonError(c, http.StatusConflict, err.Error())
+	}
+	return productError(c, err)
+}
+
+// bookInput reads an address book entry from the form. default=true makes
+// it the default address of its kind.
+func bookInput(c echo.Context) (addresses.Input, error) {
+	in := addresses.Input{
+		Kind:    c.FormValue("kind"),
+		Label:   c.FormValue("label"),
+		Address: addressInput(c),
+	}
+	if value := c.FormValue("default"); value != "" {
+		flag, err := strconv.ParseBool(value)
+		if err != nil {
+			return in, products.ValidationError{"default": "must be true or false"}
+		}
+		in.Default = flag
+	}
+	return in, nil
+}
+
+func list_addresses(c echo.Context) error {
+	customerID, err := database.GetCustomerID(c.Request().Context(), currentUser(c))
+	if err != nil {
+		return addressError(c, err)
+	}
+
+	list, err := addresses.List(c.Request().Context(), customerID)
+	if err != nil {
+		return addressError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, list)
+}
+
+func get_address(c echo.Context) error {
+	customerID, err := database.GetCustomerID(c.Request().Context(), currentUser(c))
+	if err != nil {
+		return addressError(c, err)
+	}
+	id, ok := paramID(c, "id")
+	if !ok {
+		return jsonError(c, http.StatusBadRequest, "Invalid address id")
+	}
+
+	address, err := addresses.Get(c.Request().Context(), customerID, id)
+	if err != nil {
+		return addressError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, address)
+}
+
+func create_address(c echo.Context) error {
+	customerID, err := database.GetCustomerID(c.Request().Context(), currentUser(c))
+	if err != nil {
+		return addressError(c, err)
+	}
+	in, err := bookInput(c)
+	if err != nil {
+		return addressError(c, err)
+	}
+
+	address, err := addresses.Create(c.Request().Context(), customerID, in)
+	if err != nil {
+		return addressError(c, err)
+	}
+
+	return c.JSON(http.StatusCreated, address)
+}
+
+func update_address(c echo.Context) error {
+	customerID, err := database.GetCustomerID(c.Request().Context(), currentUser(c))
+	if err != nil {
+		return addressError(c, err)
+	}
+	id, ok := paramID(c, "id")
+	if !ok {
+		return jsonError(c, http.StatusBadRequest, "Invalid address id")
+	}
+	in, err := bookInput(c)
+	if err != nil {
+		return addressError(c, err)
+	}
+
+	address, err := addresses.Update(c.Request().Context(), customerID, id, in)
+	if err != nil {
+		return addressError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, address)
+}
+
+func delete_address(c echo.Context) error {
+	customerID, err := database.GetCustomerID(c.Request().Context(), currentUser(c))
+	if err != nil {
+		return addressError(c, err)
+	}
+	id, ok := paramID(c, "id")
+	if !ok {
+		return jsonError(c, http.StatusBadRequest, "Invalid address id")
+	}
+
+	if err := addresses.Delete(c.Request().Context(), customerID, id); err != nil {
+		return addressError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, echo.Map{
+		"status":  "success",
+		"code":    200,
+		"message": "Address deleted",
+	})
+}
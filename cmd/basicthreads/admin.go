@@ -0,0 +1,164 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/labstack/echo/v4"
+
+	"basicthreads/internal/categories"
+)
+
+func jsonError(c echo.Context, code int, message string) error {
+	return c.JSON(code, echo.Map{
+		"status":  "error",
+		"code":    code,
+		"message": message,
+	})
+}
+
+// requireAdmin runs after the JWT middleware and rejects tokens that were
+// not issued to an admin.
+func requireAdmin(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		token, ok := c.Get("user").(*jwt.Token)
+		if !ok {
+			return jsonError(c, http.StatusUnauthorized, "Invalid or expired token")
+		}
+		claims, ok := token.Claims.(*jwtCustomClaims)
+		if !ok || !claims.Admin {
+			return jsonError(c, http.StatusForbidden, "Admin access required")
+		}
+		return next(c)
+	}
+}
+
+func paramID(c echo.Context, name string) (int, bool) {
+	id, err := strconv.Atoi(c.Param(name))
+	return id, err == nil && id > 0
+}
+
+// formID parses an optional id form value; an empty value means 0.
+func formID(c echo.Context, name string) (int, bool) {
+	value := c.FormValue(name)
+	if value == "" {
+		return 0, true
+	}
+	id, err := strconv.Atoi(value)
+	return id, err == nil && id >= 0
+}
+
+func categoryError(c echo.Context, err error) error {
+	switch {
+	case errors.Is(err, categories.ErrNotFound):
+		return jsonError(c, http.StatusNotFound, err.Error())
+	case errors.Is(err, categories.ErrNameRequired),
+		errors.Is(err, categories.ErrParentMissing),
+		errors.Is(err, categories.ErrCycle):
+		return jsonError(c, http.StatusBadRequest, err.Error())
+	case errors.Is(err, categories.ErrHasChildren),
+		errors.Is(err, categories.ErrHasProducts):
+		return jsonError(c, http.StatusConflict, err.Error())
+	}
+	return err
+}
+
+func admin_create_category(c echo.Context) error {
+	parentID, ok := formID(c, "parent_id")
+	if !ok {
+		return jsonError(c, http.StatusBadRequest, "Invalid parent_id")
+	}
+
+	id, err := categories.Create(c.Request().Context(), c.FormValue("name"), parentID)
+	if err != nil {
+		return categoryError(c, err)
+	}
+
+	return c.JSON(http.StatusCreated, echo.Map{
+		"status": "success",
+		"code":   201,
+		"id":     id,
+	})
+}
+
+func admin_rename_category(c echo.Context) error {
+	id, ok := paramID(c, "id")
+	if !ok {
+		return jsonError(c, http.StatusBadRequest, "Invalid category id")
+	}
+
+	err := categories.Rename(c.Request().Context(), id, c.FormValue("name"))
+	if err != nil {
+		return categoryError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, echo.Map{
+		"status":  "success",
+		"code":    200,
+		"message": "Category renamed",
+	})
+}
+
+func admin_move_category(c echo.Context) error {
+	id, ok := paramID(c, "id")
+	if !ok {
+		return jsonError(c, http.StatusBadRequest, "Invalid category id")
+	}
+	parentID, ok := formID(c, "parent_id")
+	if !ok {
+		return jsonError(c, http.StatusBadRequest, "Invalid parent_id")
+	}
+
+	err := categories.Move(c.Request().Context(), id, parentID)
+	if err != nil {
+		return categoryError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, echo.Map{
+		"status":  "success",
+		"code":    200,
+		"message": "Category moved",
+	})
+}
+
+func admin_position_category(c echo.Context) error {
+	id, ok := paramID(c, "id")
+	if !ok {
+		return jsonError(c, http.StatusBadRequest, "Invalid category id")
+	}
+	position, err := strconv.Atoi(c.FormValue("position"))
+	if err != nil || position < 0 {
+		return jsonError(c, http.StatusBadRequest, "position must be zero or a positive integer")
+	}
+
+	err = categories.SetPosition(c.Request().Context(), id, position)
+	if err != nil {
+		return categoryError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, echo.Map{
+		"status":  "success",
+		"code":    200,
+		"message": "Category reordered",
+	})
+}
+
+func admin_delete_category(c echo.Context) error {
+	id, ok := paramID(c, "id")
+	if !ok {
+		return jsonError(c, http.StatusBadRequest, "Invalid category id")
+	}
+
+	err := categories.Delete(c.Request().Context(), id)
+	if err != nil {
+		return categoryError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, echo.Map{
+		"status":  "success",
+		"code":    200,
+		"message": "Category deleted",
+	})
+}
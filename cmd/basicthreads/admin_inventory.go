@@ -0,0 +1,75 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+
+	"basicthreads/internal/database"
+	"basicthreads/internal/inventory"
+	"basicthreads/internal/products"
+)
+
+func inventoryError(c echo.Context, err error) error {
+	var insufficient *database.InsufficientStockError
+	if errors.As(err, &insufficient) {
+		return c.JSON(http.StatusConflict, echo.Map{
+			"status":    "error",
+			"code":      409,
+			"message":   "Not enough stock",
+			"variant":   insufficient.VariantID,
+			"available": insufficient.Available,
+		})
+	}
+	return variantError(c, err)
+}
+
+func admin_record_stock(c echo.Context) error {
+	id, ok := paramID(c, "id")
+	if !ok {
+		return jsonError(c, http.StatusBadRequest, "Invalid variant id")
+	}
+	quantity, err := strconv.Atoi(c.FormValue("quantity"))
+	if err != nil {
+		return inventoryError(c, products.ValidationError{"quantity": "must be a whole number"})
+	}
+
+	variant, err := inventory.Record(
+		c.Request().Context(),
+		id,
+		c.FormValue("kind"),
+		quantity,
+		currentUser(c),
+		c.FormValue("note"),
+	)
+	if err != nil {
+		return inventoryError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, variant)
+}
+
+func admin_stock_movements(c echo.Context) error {
+	id, ok := paramID(c, "id")
+	if !ok {
+		return jsonError(c, http.StatusBadRequest, "Invalid variant id")
+	}
+
+	movements, err := inventory.Movements(c.Request().Context(), id)
+	if err != nil {
+		return inventoryError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, movements)
+}
+
+func admin_low_stock(c echo.Context) error {
+	levels, err := inventory.LowStock(c.Request().Context())
+	if err != nil {
+		return inventoryError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, levels)
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/labstack/echo/v4"
+
+	"basicthreads/internal/database"
+	"basicthreads/internal/money"
+	"basicthreads/internal/products"
+)
+
+// currentUser returns the email of the customer the request's token was
+// issued to.
+func currentUser(c echo.Context) string {
+	token, ok := c.Get("user").(*jwt.Token)
+	if !ok {
+		return ""
+	}
+	claims, ok := token.Claims.(*jwtCustomClaims)
+	if !ok {
+		return ""
+	}
+	return claims.Name
+}
+
+func productETag(product database.AdminProduct) string {
+	return `"` + strconv.Itoa(product.Version) + `"`
+}
+
+// expectedVersion reads the version the client last saw from the If-Match
+// header, falling back to a version form value.
+func expectedVersion(c echo.Context) (int, bool) {
+	value := c.Request().Header.Get("If-Match")
+	if value == "" {
+		value = c.FormValue("version")
+	}
+	value = strings.Trim(strings.TrimPrefix(value, "W/"), `"`)
+
+	version, err := strconv.Atoi(value)
+	return version, err == nil && version > 0
+}
+
+// parseIDs reads a comma separated list of ids such as "1,10,101".
+func parseIDs(value string) ([]int, bool) {
+	ids := []int{}
+	for _, part := range strings.Split(value, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		id, err := strconv.Atoi(part)
+		if err != nil || id <= 0 {
+			return nil, false
+		}
+		ids = append(ids, id)
+	}
+	return ids, true
+}
+
+func productInput(c echo.Context) (products.Input, error) {
+	in := products.Input{
+		Name:        c.FormValue("name"),
+		TaxClass:    c.FormValue("tax_class"),
+		Description: c.FormValue("description"),
+		Image:       c.FormValue("image"),
+	}
+
+	price, err := money.Parse(c.FormValue("price"), database.Currency())
+	if err != nil {
+		return in, products.ValidationError{"price": priceError(database.Currency())}
+	}
+	in.Price = price
+
+	params, _ := c.FormParams()
+	if params.Has("weight") {
+		weight, err := strconv.Atoi(c.FormValue("weight"))
+		if err != nil {
+			return in, products.ValidationError{"weight": "must be a whole number of grams"}
+		}
+		in.Weight = &weight
+	}
+	if params.Has("available") {
+		available, err := strconv.ParseBool(c.FormValue("available"))
+		if err != nil {
+			return in, products.ValidationError{"available": "must be true or false"}
+		}
+		in.Available = &available
+	}
+
+	if params.Has("categories") {
+		ids, ok := parseIDs(c.FormValue("categories"))
+		if !ok {
+			return in, products.ValidationError{"categories": "must be a comma separated list of ids"}
+		}
+		in.Categories = ids
+	}
+
+	return in, nil
+}
+
+// priceError describes the amounts accepted in currency.
+func priceError(currency string) string {
+	if money.Exponent(currency) == 0 {
+		return "must be a whole amount"
+	}
+	return fmt.Sprintf("must be a decimal amount with at most %d decimals", money.Exponent(currency))
+}
+
+func productError(c echo.Context, err error) error {
+	var problems products.ValidationError
+	switch {
+	case errors.As(err, &problems):
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"status":  "error",
+			"code":    400,
+			"message": "Invalid product",
+			"errors":  problems,
+		})
+	case errors.Is(err, products.ErrNotFound):
+		return jsonError(c, http.StatusNotFound, "Product not found")
+	case errors.Is(err, products.ErrVersionConflict):
+		return jsonError(c, http.StatusPreconditionFailed, "Product was modified by someone else, reload it and try again")
+	case errors.Is(err, products.ErrNotDeleted):
+		return jsonError(c, http.StatusConflict, err.Error())
+	}
+	return err
+}
+
+func productResponse(c echo.Context, code int, product database.AdminProduct) error {
+	c.Response().Header().Set("ETag", productETag(product))
+	return c.JSON(code, product)
+}
+
+func admin_get_product(c echo.Context) error {
+	id, ok := paramID(c, "id")
+	if !ok {
+		return jsonError(c, http.StatusBadRequest, "Invalid product id")
+	}
+
+	product, err := products.Get(c.Request().Context(), id)
+	if err != nil {
+		return productError(c, err)
+	}
+
+	return productResponse(c, http.StatusOK, product)
+}
+
+func admin_create_product(c echo.Context) error {
+	in, err := productInput(c)
+	if err != nil {
+		return productError(c, err)
+	}
+
+	product, err := products.Create(c.Request().Context(), currentUser(c), in)
+	if err != nil {
+		return productError(c, err)
+	}
+
+	return productResponse(c, http.StatusCreated, product)
+}
+
+func admin_update_product(c echo.Context) error {
+	id, ok := paramID(c, "id")
+	if !ok {
+		return jsonError(c, http.StatusBadRequest, "Invalid product id")
+	}
+	version, ok := expectedVersion(c)
+	if !ok {
+		return jsonError(c, http.StatusPreconditionRequired, "If-Match header or version is required")
+	}
+	in, err := productInput(c)
+	if err != nil {
+		return productError(c, err)
+	}
+
+	product, err := products.Update(c.Request().Context(), currentUser(c), id, version, in)
+	if err != nil {
+		return productError(c, err)
+	}
+
+	return productResponse(c, http.StatusOK, product)
+}
+
+func admin_set_product_categories(c echo.Context) error {
+	id, ok := paramID(c, "id")
+	if !ok {
+		return jsonError(c, http.StatusBadRequest, "Invalid product id")
+	}
+	version, ok := expectedVersion(c)
+	if !ok {
+		return jsonError(c, http.StatusPreconditionRequired, "If-Match header or version is required")
+	}
+	ids, ok := parseIDs(c.FormValue("categories"))
+	if !ok {
+		return jsonError(c, http.StatusBadRequest, "categories must be a comma separated list of ids")
+	}
+
+	product, err := products.SetCategories(c.Request().Context(), currentUser(c), id, version, ids)
+	if err != nil {
+		return productError(c, err)
+	}
+
+	return productResponse(c, http.StatusOK, product)
+}
+
+func admin_delete_product(c echo.Context) error {
+	id, ok := paramID(c, "id")
+	if !ok {
+		return jsonError(c, http.StatusBadRequest, "Invalid product id")
+	}
+	version, ok := expectedVersion(c)
+	if !ok {
+		return jsonError(c, http.StatusPreconditionRequired, "If-Match header or version is required")
+	}
+
+	product, err := products.Delete(c.Request().Context(), currentUser(c), id, version)
+	if err != nil {
+		return productError(c, err)
+	}
+
+	return productResponse(c, http.StatusOK, product)
+}
+
+func admin_restore_product(c echo.Context) error {
+	id, ok := paramID(c, "id")
+	if !ok {
+		return jsonError(c, http.StatusBadRequest, "Invalid product id")
+	}
+	version, ok := expectedVersion(c)
+	if !ok {
+		return jsonError(c, http.StatusPreconditionRequired, "If-Match header or version is required")
+	}
+
+	product, err := products.Restore(c.Request().Context(), currentUser(c), id, version)
+	if err != nil {
+		return productError(c, err)
+	}
+
+	return productResponse(c, http.StatusOK, product)
+}
+
+func admin_product_audit(c echo.Context) error {
+	id, ok := paramID(c, "id")
+	if !ok {
+		return jsonError(c, http.StatusBadRequest, "Invalid product id")
+	}
+
+	entries, err := products.Audit(c.Request().Context(), id)
+	if err != nil {
+		return productError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, entries)
+}
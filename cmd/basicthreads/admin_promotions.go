@@ -0,0 +1,162 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+
+	"basicthreads/internal/database"
+	"basicthreads/internal/money"
+	"basicthreads/internal/products"
+	"basicthreads/internal/promotions"
+)
+
+// promotionInput reads a promotion from the form. Optional numbers and
+// dates left empty mean "no limit"; starts_at and ends_at are RFC 3339
+// timestamps.
+func promotionInput(c echo.Context) (promotions.Input, error) {
+	in := promotions.Input{
+		Name:   c.FormValue("name"),
+		Code:   c.FormValue("code"),
+		Kind:   c.FormValue("kind"),
+		Scope:  c.FormValue("scope"),
+		Active: true,
+	}
+	problems := products.ValidationError{}
+
+	integers := map[string]*int{
+		"percent":  &in.Percent,
+		"target":   &in.TargetID,
+		"buy":      &in.BuyQuantity,
+		"get":      &in.GetQuantity,
+		"priority": &in.Priority,
+	}
+	for name, target := range integers {
+		if value := c.FormValue(name); value != "" {
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				problems[name] = "must be a whole number"
+			}
+			*target = n
+		}
+	}
+
+	limits := map[string]**int{"usage_limit": &in.UsageLimit, "customer_limit": &in.CustomerLimit}
+	for name, target := range limits {
+		if value := c.FormValue(name); value != "" {
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				problems[name] = "must be a whole number"
+			}
+			*target = &n
+		}
+	}
+
+	amounts := map[string]**money.Money{"amount": &in.Amount, "min_subtotal": &in.MinSubtotal}
+	for name, target := range amounts {
+		if value := c.FormValue(name); value != "" {
+			amount, err := money.Parse(value, database.Currency())
+			if err != nil {
+				problems[name] = priceError(database.Currency())
+			}
+			*target = &amount
+		}
+	}
+
+	dates := map[string]**time.Time{"starts_at": &in.StartsAt, "ends_at": &in.EndsAt}
+	for name, target := range dates {
+		if value := c.FormValue(name); value != "" {
+			date, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				problems[name] = "must be a date like 2024-12-01T00:00:00Z"
+			}
+			*target = &date
+		}
+	}
+
+	booleans := map[string]*bool{"exclusive": &in.Exclusive, "active": &in.Active}
+	for name, target := range booleans {
+		if value := c.FormValue(name); value != "" {
+			flag, err := strconv.ParseBool(value)
+			if err != nil {
+				problems[name] = "must be true or false"
+			}
+			*target = flag
+		}
+	}
+
+	if len(problems) > 0 {
+		return in, problems
+	}
+	return in, nil
+}
+
+func promotionError(c echo.Context, err error) error {
+	switch {
+	case errors.Is(err, promotions.ErrNotFound):
+		return jsonError(c, http.StatusNotFound, "Promotion not found")
+	case errors.Is(err, promotions.ErrDuplicate):
+		return jsonError(c, http.StatusConflict, err.Error())
+	case errors.Is(err, promotions.ErrTargetMissing):
+		return jsonError(c, http.StatusBadRequest, err.Error())
+	}
+	return productError(c, err)
+}
+
+func admin_list_promotions(c echo.Context) error {
+	list, err := promotions.List(c.Request().Context())
+	if err != nil {
+		return promotionError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, list)
+}
+
+func admin_get_promotion(c echo.Context) error {
+	id, ok := paramID(c, "id")
+	if !ok {
+		return jsonError(c, http.StatusBadRequest, "Invalid promotion id")
+	}
+
+	promotion, err := promotions.Get(c.Request().Context(), id)
+	if err != nil {
+		return promotionError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, promotion)
+}
+
+func admin_create_promotion(c echo.Context) error {
+	in, err := promotionInput(c)
+	if err != nil {
+		return promotionError(c, err)
+	}
+
+	promotion, err := promotions.Create(c.Request().Context(), in)
+	if err != nil {
+		return promotionError(c, err)
+	}
+
+	return c.JSON(http.StatusCreated, promotion)
+}
+
+func admin_update_promotion(c echo.Context) error {
+	id, ok := paramID(c, "id")
+	if !ok {
+		return jsonError(c, http.StatusBadRequest, "Invalid promotion id")
+	}
+	in, err := promotionInput(c)
+	if err != nil {
+		return promotionError(c, err)
+	}
+
+	promotion, err := promotions.Update(c.Request().Context(), id, in)
+	if err != nil {
+		return promotionError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, promotion)
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+
+	"basicthreads/internal/tax"
+)
+
+func admin_list_tax_rates(c echo.Context) error {
+	rates, err := tax.Rates(c.Request().Context())
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, rates)
+}
+
+func admin_save_tax_rate(c echo.Context) error {
+	rate, err := tax.SaveRate(c.Request().Context(), tax.RateInput{
+		Country:  c.FormValue("country"),
+		Region:   c.FormValue("region"),
+		TaxClass: c.FormValue("tax_class"),
+		Name:     c.FormValue("name"),
+		Rate:     c.FormValue("rate"),
+	})
+	if err != nil {
+		return productError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, rate)
+}
+
+func admin_delete_tax_rate(c echo.Context) error {
+	id, ok := paramID(c, "id")
+	if !ok {
+		return jsonError(c, http.StatusBadRequest, "Invalid tax rate id")
+	}
+
+	err := tax.DeleteRate(c.Request().Context(), id)
+	if errors.Is(err, tax.ErrNotFound) {
+		return jsonError(c, http.StatusNotFound, "Tax rate not found")
+	}
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, echo.Map{
+		"status":  "success",
+		"code":    200,
+		"message": "Tax rate deleted",
+	})
+}
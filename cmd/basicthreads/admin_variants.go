@@ -0,0 +1,120 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+
+	"basicthreads/internal/database"
+	"basicthreads/internal/money"
+	"basicthreads/internal/products"
+	"basicthreads/internal/variants"
+)
+
+func variantInput(c echo.Context) (variants.Input, error) {
+	in := variants.Input{
+		SKU:     c.FormValue("sku"),
+		Size:    c.FormValue("size"),
+		Color:   c.FormValue("color"),
+		Barcode: c.FormValue("barcode"),
+	}
+
+	if value := c.FormValue("price"); value != "" {
+		price, err := money.Parse(value, database.Currency())
+		if err != nil {
+			return in, products.ValidationError{"price": priceError(database.Currency())}
+		}
+		in.Price = &price
+	}
+	if value := c.FormValue("stock"); value != "" {
+		stock, err := strconv.Atoi(value)
+		if err != nil {
+			return in, products.ValidationError{"stock": "must be a whole number"}
+		}
+		in.Stock = stock
+	}
+
+	return in, nil
+}
+
+func variantError(c echo.Context, err error) error {
+	switch {
+	case errors.Is(err, variants.ErrNotFound):
+		return jsonError(c, http.StatusNotFound, "Variant not found")
+	case errors.Is(err, variants.ErrProductNotFound):
+		return jsonError(c, http.StatusNotFound, "Product not found")
+	case errors.Is(err, variants.ErrDuplicate):
+		return jsonError(c, http.StatusConflict, err.Error())
+	}
+	return productError(c, err)
+}
+
+func admin_list_variants(c echo.Context) error {
+	id, ok := paramID(c, "id")
+	if !ok {
+		return jsonError(c, http.StatusBadRequest, "Invalid product id")
+	}
+
+	list, err := variants.List(c.Request().Context(), id)
+	if err != nil {
+		return variantError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, list)
+}
+
+func admin_create_variant(c echo.Context) error {
+	id, ok := paramID(c, "id")
+	if !ok {
+		return jsonError(c, http.StatusBadRequest, "Invalid product id")
+	}
+	in, err := variantInput(c)
+	if err != nil {
+		return variantError(c, err)
+	}
+
+	variant, err := variants.Create(c.Request().Context(), currentUser(c), id, in)
+	if err != nil {
+		return variantError(c, err)
+	}
+
+	return c.JSON(http.StatusCreated, variant)
+}
+
+func admin_update_variant(c echo.Context) error {
+	id, ok := paramID(c, "id")
+	if !ok {
+		return jsonError(c, http.StatusBadRequest, "Invalid variant id")
+	}
+	in, err := variantInput(c)
+	if err != nil {
+		return variantError(c, err)
+	}
+
+	variant, err := variants.Update(c.Request().Context(), id, in)
+	if err != nil {
+		return variantError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, variant)
+}
+
+func admin_delete_variant(c echo.Context) error {
+	id, ok := paramID(c, "id")
+	if !ok {
+		return jsonError(c, http.StatusBadRequest, "Invalid variant id")
+	}
+
+	err := variants.Delete(c.Request().Context(), id)
+	if err != nil {
+		return variantError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, echo.Map{
+		"status":  "success",
+		"code":    200,
+		"message": "Variant deleted",
+	})
+}
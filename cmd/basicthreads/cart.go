@@ -0,0 +1,206 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/labstack/echo/v4"
+
+	"basicthreads/internal/carts"
+	"basicthreads/internal/database"
+	"basicthreads/internal/products"
+	"basicthreads/internal/promotions"
+)
+
+const cartCookie = "cart"
+
+// cartToken reads the guest cart token from its cookie, or from the
+// X-Cart-Token header for clients that do not keep cookies.
+func cartToken(c echo.Context) string {
+	if token := c.Request().Header.Get("X-Cart-Token"); token != "" {
+		return token
+	}
+	if cookie, err := c.Cookie(cartCookie); err == nil {
+		return cookie.Value
+	}
+	return ""
+}
+
+func setCartCookie(c echo.Context, token string, maxAge time.Duration) {
+	c.SetCookie(&http.Cookie{
+		Name:     cartCookie,
+		Value:    token,
+		Path:     "/",
+		MaxAge:   int(maxAge.Seconds()),
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// cartOwner works out whose cart the request is about. The token is
+// optional on the cart routes, so a missing one means a guest.
+func cartOwner(c echo.Context) (carts.Owner, error) {
+	if _, ok := c.Get("user").(*jwt.Token); ok {
+		id, err := database.GetCustomerID(c.Request().Context(), currentUser(c))
+		return carts.Owner{CustomerID: id}, err
+	}
+	return carts.Owner{Token: cartToken(c)}, nil
+}
+
+// cartResponse writes the cart, with the subtotal converted when the client
+// asks for a currency there is a rate for. Unknown currencies are ignored
+// here so a cart change never fails over a display setting.
+func cartResponse(c echo.Context, code int, cart carts.Cart) error {
+	if cart.Token != "" {
+		setCartCookie(c, cart.Token, 30*24*time.Hour)
+	}
+	if currency, problem := displayCurrency(c); currency != "" && problem == "" {
+		cart.DisplaySubtotal = convert(cart.Subtotal, currency)
+	}
+	return c.JSON(code, cart)
+}
+
+func cartError(c echo.Context, err error) error {
+	switch {
+	case errors.Is(err, carts.ErrNotFound):
+		return jsonError(c, http.StatusNotFound, "Cart item not found")
+	case errors.Is(err, carts.ErrProductNotFound), errors.Is(err, database.ErrNotFound):
+		return jsonError(c, http.StatusNotFound, "Product not found")
+	case errors.Is(err, carts.ErrNotEnoughStock):
+		return jsonError(c, http.StatusConflict, "Not enough stock")
+	case errors.Is(err, promotions.ErrCodeNotFound):
+		return jsonError(c, http.StatusNotFound, "Discount code not found")
+	case errors.Is(err, promotions.ErrCodeInactive), errors.Is(err, promotions.ErrCodeUsedUp):
+		return jsonError(c, http.StatusConflict, err.Error())
+	}
+	return productError(c, err)
+}
+
+func quantityValue(c echo.Context) (int, error) {
+	quantity, err := strconv.Atoi(c.FormValue("quantity"))
+	if err != nil {
+		return 0, products.ValidationError{"quantity": "must be a whole number"}
+	}
+	return quantity, nil
+}
+
+func get_cart(c echo.Context) error {
+	owner, err := cartOwner(c)
+	if err != nil {
+		return cartError(c, err)
+	}
+
+	cart, err := carts.Get(c.Request().Context(), owner)
+	if err != nil {
+		return cartError(c, err)
+	}
+
+	return cartResponse(c, http.StatusOK, cart)
+}
+
+func add_cart_item(c echo.Context) error {
+	owner, err := cartOwner(c)
+	if err != nil {
+		return cartError(c, err)
+	}
+	productID, err := strconv.Atoi(c.FormValue("product"))
+	if err != nil {
+		return cartError(c, products.ValidationError{"product": "must be a product id"})
+	}
+	var variantID *int
+	if value := c.FormValue("variant"); value != "" {
+		id, err := strconv.Atoi(value)
+		if err != nil {
+			return cartError(c, products.ValidationError{"variant": "must be a variant id"})
+		}
+		variantID = &id
+	}
+	quantity := 1
+	if c.FormValue("quantity") != "" {
+		if quantity, err = quantityValue(c); err != nil {
+			return cartError(c, err)
+		}
+	}
+
+	cart, err := carts.Add(c.Request().Context(), owner, productID, variantID, quantity)
+	if err != nil {
+		return cartError(c, err)
+	}
+
+	return cartResponse(c, http.StatusCreated, cart)
+}
+
+func update_cart_item(c echo.Context) error {
+	owner, err := cartOwner(c)
+	if err != nil {
+		return cartError(c, err)
+	}
+	id, ok := paramID(c, "id")
+	if !ok {
+		return jsonError(c, http.StatusBadRequest, "Invalid cart item id")
+	}
+	quantity, err := quantityValue(c)
+	if err != nil {
+		return cartError(c, err)
+	}
+
+	cart, err := carts.Update(c.Request().Context(), owner, id, quantity)
+	if err != nil {
+		return cartError(c, err)
+	}
+
+	return cartResponse(c, http.StatusOK, cart)
+}
+
+func remove_cart_item(c echo.Context) error {
+	owner, err := cartOwner(c)
+	if err != nil {
+		return cartError(c, err)
+	}
+	id, ok := paramID(c, "id")
+	if !ok {
+		return jsonError(c, http.StatusBadRequest, "Invalid cart item id")
+	}
+
+	cart, err := carts.Remove(c.Request().Context(), owner, id)
+	if err != nil {
+		return cartError(c, err)
+	}
+
+	return cartResponse(c, http.StatusOK, cart)
+}
+
+func apply_cart_code(c echo.Context) error {
+	owner, err := cartOwner(c)
+	if err != nil {
+		return cartError(c, err)
+	}
+	code := c.FormValue("code")
+	if code == "" {
+		return cartError(c, products.ValidationError{"code": "is required"})
+	}
+
+	cart, err := carts.ApplyCode(c.Request().Context(), owner, code)
+	if err != nil {
+		return cartError(c, err)
+	}
+
+	return cartResponse(c, http.StatusOK, cart)
+}
+
+func remove_cart_code(c echo.Context) error {
+	owner, err := cartOwner(c)
+	if err != nil {
+		return cartError(c, err)
+	}
+
+	cart, err := carts.RemoveCode(c.Request().Context(), owner)
+	if err != nil {
+		return cartError(c, err)
+	}
+
+	return cartResponse(c, http.StatusOK, cart)
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"math/big"
+	"os"
+	"sort"
+
+	"basicthreads/internal/config"
+	"basicthreads/internal/database"
+	"basicthreads/internal/search"
+	"basicthreads/internal/seed"
+)
+
+type command struct {
+	summary string
+	run     func(args []string) error
+}
+
+var commands map[string]command
+
+func init() {
+	commands = map[string]command{
+		"serve":          {"start the HTTP API (default)", serve},
+		"migrate":        {"apply or revert schema migrations: up, down [steps], status", migrate},
+		"seed":           {"load fixture data: [--reset] [--dir path] [--store mysql|memory]", seedData},
+		"create-admin":   {"create or promote an admin: --email --password [--name] [--phone]", createAdmin},
+		"reset-password": {"set a customer's password: --email [--password]", resetPassword},
+		"reindex":        {"refresh table statistics and rebuild the FULLTEXT search indexes", reindex},
+		"export":         {"write the catalog as seed fixtures: [--out file]", export},
+		"config":         {"print the effective configuration with secrets masked", showConfig},
+		"fake-gateway":   {"run a local Stripe-like payment gateway: [--address] [--webhook url] [--delay]", fakeGateway},
+		"fake-s3":        {"run a local S3-compatible object store for STORAGE_BACKEND=s3: [--address]", fakeS3},
+		"help":           {"show this help", func([]string) error { usage(); return nil }},
+	}
+}
+
+func usage() {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fmt.Fprintln(os.Stderr, "usage: basicthreads <command> [arguments]")
+	fmt.Fprintln(os.Stderr)
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %-15s %s\n", name, commands[name].summary)
+	}
+}
+
+func createAdmin(args []string) error {
+	flags := flag.NewFlagSet("create-admin", flag.ContinueOnError)
+	name := flags.String("name", "Admin", "display name")
+	email := flags.String("email", "", "admin email")
+	phone := flags.String("phone", "", "phone number")
+	password := flags.String("password", "", "admin password")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *email == "" || *password == "" {
+		return errors.New("--email and --password are required")
+	}
+
+	err := database.SaveAdmin(context.Background(), *name, *email, *phone, *password)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("%s is now an admin\n", *email)
+	return nil
+}
+
+func resetPassword(args []string) error {
+	flags := flag.NewFlagSet("reset-password", flag.ContinueOnError)
+	email := flags.String("email", "", "customer email")
+	password := flags.String("password", "", "new password, generated when empty")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *email == "" {
+		return errors.New("--email is required")
+	}
+
+	generated := *password == ""
+	if generated {
+		var err error
+		*password, err = randomPassword(16)
+		if err != nil {
+			return err
+		}
+	}
+
+	found, err := database.SetPassword(context.Background(), *email, *password)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return fmt.Errorf("no customer registered with %s", *email)
+	}
+
+	if generated {
+		fmt.Printf("new password for %s: %s\n", *email, *password)
+	} else {
+		fmt.Printf("password updated for %s\n", *email)
+	}
+	return nil
+}
+
+func randomPassword(length int) (string, error) {
+	const alphabet = "abcdefghjkmnpqrstuvwxyzABCDEFGHJKMNPQRSTUVWXYZ23456789"
+
+	password := make([]byte, length)
+	for i := range password {
+		n, err := rand.Int(rand.Reader, big.NewInt(int64(len(alphabet))))
+		if err != nil {
+			return "", err
+		}
+		password[i] = alphabet[n.Int64()]
+	}
+	return string(password), nil
+}
+
+func reindex(args []string) error {
+	ctx := context.Background()
+
+	if err := database.AnalyzeTables(ctx); err != nil {
+		return err
+	}
+	fmt.Println("table statistics refreshed")
+
+	db := database.Connect()
+	defer db.Close()
+
+	if err := search.NewMySQLSearcher(db).Rebuild(ctx); err != nil {
+		return err
+	}
+	fmt.Println("search indexes rebuilt")
+	return nil
+}
+
+func export(args []string) error {
+	flags := flag.NewFlagSet("export", flag.ContinueOnError)
+	out := flags.String("out", "", "file to write, stdout when empty")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+
+	categories, err := database.GetAllCategories(ctx)
+	if err != nil {
+		return err
+	}
+	links, err := database.GetProductCategoryIDs(ctx)
+	if err != nil {
+		return err
+	}
+
+	fixtures := seed.Fixtures{Version: seed.FixtureVersion}
+	for _, category := range parentsFirst(categories) {
+		fixtures.Categories = append(fixtures.Categories, seed.Category{
+			ID:       category.ID,
+			Name:     category.Name,
+			ParentID: category.ParentID,
+		})
+	}
+	for _, product := range database.GetProducts(ctx) {
+		fixtures.Products = append(fixtures.Products, seed.Product{
+			ID:          product.ID,
+			Name:        product.Name,
+			Price:       json.Number(product.Price.String()),
+			Description: product.Description,
+			Image:       product.Image,
+			Categories:  links[product.ID],
+		})
+	}
+
+	body, err := json.MarshalIndent(fixtures, "", "  ")
+	if err != nil {
+		return err
+	}
+	body = append(body, '\n')
+
+	if *out == "" {
+		_, err = os.Stdout.Write(body)
+		return err
+	}
+	return os.WriteFile(*out, body, 0o644)
+}
+
+// parentsFirst orders categories so that every parent precedes its children,
+// which is what the seed loader expects.
+func parentsFirst(categories []database.Category) []database.Category {
+	children := map[int][]database.Category{}
+	for _, category := range categories {
+		children[category.ParentID] = append(children[category.ParentID], category)
+	}
+
+	ordered := make([]database.Category, 0, len(categories))
+	queue := children[0]
+	for len(queue) > 0 {
+		category := queue[0]
+		queue = append(queue[1:], children[category.ID]...)
+		ordered = append(ordered, category)
+	}
+	return ordered
+}
+
+func showConfig(args []string) error {
+	for _, setting := range config.Load().Masked() {
+		fmt.Printf("%-15s %s\n", setting[0], setting[1])
+	}
+	return nil
+}
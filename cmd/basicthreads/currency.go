@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+
+	"basicthreads/internal/database"
+	"basicthreads/internal/money"
+)
+
+// exchangeRates converts prices for display when a client asks for another
+// currency with ?currency=EUR. Amounts are still charged in the shop
+// currency.
+var exchangeRates money.Rates
+
+// checkCurrency refuses a shop currency the price columns cannot hold.
+// They have two decimals, so a currency without cents would be shown
+// wrong and one with three would lose its last digit.
+func checkCurrency(ctx context.Context, code string) error {
+	if !money.ValidCurrency(code) {
+		return fmt.Errorf("CURRENCY %q is not an ISO 4217 code", code)
+	}
+	if exponent := money.Exponent(code); exponent != 2 {
+		return fmt.Errorf("CURRENCY %s has %d decimals, prices are stored with 2", code, exponent)
+	}
+	return database.CheckCurrency(ctx, code)
+}
+
+// displayCurrency reads the currency query parameter. It returns "" when
+// prices should be shown as they are, and a problem when the currency has
+// no exchange rate.
+func displayCurrency(c echo.Context) (string, string) {
+	currency := strings.ToUpper(c.QueryParam("currency"))
+	if currency == "" || currency == exchangeRates.Base {
+		return "", ""
+	}
+	if !exchangeRates.Supports(currency) {
+		codes := exchangeRates.Currencies()
+		slices.Sort(codes)
+		return "", "currency must be one of " + strings.Join(codes, ", ")
+	}
+	return currency, ""
+}
+
+func convert(amount money.Money, currency string) *money.Money {
+	converted, err := exchangeRates.Convert(amount, currency)
+	if err != nil {
+		return nil
+	}
+	return &converted
+}
+
+// showPrices sets the display price of each product.
+func showPrices(items []database.Product, currency string) {
+	if currency == "" {
+		return
+	}
+	for i := range items {
+		items[i].DisplayPrice = convert(items[i].Price, currency)
+	}
+}
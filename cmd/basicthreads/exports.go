@@ -0,0 +1,114 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+
+	"basicthreads/internal/database"
+	"basicthreads/internal/exports"
+	"basicthreads/internal/users"
+)
+
+func exportError(c echo.Context, err error) error {
+	switch {
+	case errors.Is(err, exports.ErrNotFound):
+		return jsonError(c, http.StatusNotFound, "Export not found")
+	case errors.Is(err, exports.ErrInProgress):
+		return jsonError(c, http.StatusConflict, err.Error())
+	}
+	return productError(c, err)
+}
+
+// request_export queues an export of the data of the logged in customer.
+// The download link is emailed to them once the archive is ready.
+func request_export(c echo.Context) error {
+	email := currentUser(c)
+	customerID, err := database.GetCustomerID(c.Request().Context(), email)
+	if err != nil {
+		return exportError(c, err)
+	}
+
+	export, err := exports.Request(c.Request().Context(), customerID, email, email)
+	if err != nil {
+		return exportError(c, err)
+	}
+
+	return c.JSON(http.StatusAccepted, export)
+}
+
+func list_exports(c echo.Context) error {
+	customerID, err := database.GetCustomerID(c.Request().Context(), currentUser(c))
+	if err != nil {
+		return exportError(c, err)
+	}
+
+	list, err := exports.List(c.Request().Context(), customerID)
+	if err != nil {
+		return exportError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, list)
+}
+
+// download_export serves the archive a download link points at. The token
+// is the only credential, so the link works without logging in.
+func download_export(c echo.Context) error {
+	export, err := exports.Open(c.Request().Context(), c.Param("token"))
+	if err != nil {
+		return exportError(c, err)
+	}
+
+	c.Response().Header().Set("Cache-Control", "no-store")
+	return c.Attachment(export.Path, "basicthreads-export-"+strconv.Itoa(export.ID)+".zip")
+}
+
+// admin_request_export exports the data of a customer on their behalf.
+// The link goes to the customer, unless deliver_to=admin sends it to the
+// admin making the request.
+func admin_request_export(c echo.Context) error {
+	customerID, ok := paramID(c, "id")
+	if !ok {
+		return jsonError(c, http.StatusBadRequest, "Invalid customer id")
+	}
+	customer, err := users.Profile(c.Request().Context(), customerID)
+	if errors.Is(err, users.ErrNotFound) {
+		return jsonError(c, http.StatusNotFound, "Customer not found")
+	}
+	if err != nil {
+		return exportError(c, err)
+	}
+
+	admin := currentUser(c)
+	notify := customer.Email
+	switch c.FormValue("deliver_to") {
+	case "", "customer":
+	case "admin":
+		notify = admin
+	default:
+		return jsonError(c, http.StatusBadRequest, "deliver_to must be customer or admin")
+	}
+
+	export, err := exports.Request(c.Request().Context(), customerID, admin, notify)
+	if err != nil {
+		return exportError(c, err)
+	}
+
+	return c.JSON(http.StatusAccepted, export)
+}
+
+func admin_list_exports(c echo.Context) error {
+	customerID, ok := paramID(c, "id")
+	if !ok {
+		return jsonError(c, http.StatusBadRequest, "Invalid customer id")
+	}
+
+	list, err := exports.List(c.Request().Context(), customerID)
+	if err != nil {
+		return exportError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, list)
+}
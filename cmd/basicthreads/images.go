@@ -0,0 +1,139 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+
+	"basicthreads/internal/config"
+	"basicthreads/internal/images"
+	"basicthreads/internal/products"
+	"basicthreads/internal/storage"
+)
+
+var blobStore storage.BlobStore
+
+func imageError(c echo.Context, err error) error {
+	switch {
+	case errors.Is(err, images.ErrNotFound):
+		return jsonError(c, http.StatusNotFound, "Image not found")
+	case errors.Is(err, images.ErrProductNotFound):
+		return jsonError(c, http.StatusNotFound, "Product not found")
+	case errors.Is(err, images.ErrTooMany):
+		return jsonError(c, http.StatusConflict, err.Error())
+	case errors.Is(err, images.ErrTooLarge):
+		return jsonError(c, http.StatusRequestEntityTooLarge, err.Error())
+	case errors.Is(err, images.ErrUnsupported):
+		return jsonError(c, http.StatusUnsupportedMediaType, err.Error())
+	}
+	return productError(c, err)
+}
+
+func admin_list_images(c echo.Context) error {
+	id, ok := paramID(c, "id")
+	if !ok {
+		return jsonError(c, http.StatusBadRequest, "Invalid product id")
+	}
+
+	list, err := images.List(c.Request().Context(), id)
+	if err != nil {
+		return imageError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, list)
+}
+
+// admin_upload_image takes a multipart upload in the image field, with
+// its alternative text in alt.
+func admin_upload_image(c echo.Context) error {
+	id, ok := paramID(c, "id")
+	if !ok {
+		return jsonError(c, http.StatusBadRequest, "Invalid product id")
+	}
+	header, err := c.FormFile("image")
+	if err != nil {
+		return imageError(c, products.ValidationError{"image": "is required"})
+	}
+	file, err := header.Open()
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	// Read one byte past the limit, so the service can tell it was exceeded.
+	data, err := io.ReadAll(io.LimitReader(file, int64(config.Load().ImageMaxBytes)+1))
+	if err != nil {
+		return jsonError(c, http.StatusBadRequest, "Could not read the upload")
+	}
+
+	image, err := images.Upload(c.Request().Context(), blobStore, currentUser(c), id, data, c.FormValue("alt"))
+	if err != nil {
+		return imageError(c, err)
+	}
+
+	return c.JSON(http.StatusCreated, image)
+}
+
+// admin_reorder_images takes the ids of every image of the product, comma
+// separated, in the order they should be shown.
+func admin_reorder_images(c echo.Context) error {
+	id, ok := paramID(c, "id")
+	if !ok {
+		return jsonError(c, http.StatusBadRequest, "Invalid product id")
+	}
+	var ids []int
+	for _, value := range strings.Split(c.FormValue("ids"), ",") {
+		imageID, err := strconv.Atoi(strings.TrimSpace(value))
+		if err != nil {
+			return imageError(c, products.ValidationError{"ids": "must be a comma separated list of image ids"})
+		}
+		ids = append(ids, imageID)
+	}
+
+	list, err := images.Reorder(c.Request().Context(), currentUser(c), id, ids)
+	if err != nil {
+		return imageError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, list)
+}
+
+func admin_delete_image(c echo.Context) error {
+	id, ok := paramID(c, "id")
+	if !ok {
+		return jsonError(c, http.StatusBadRequest, "Invalid image id")
+	}
+
+	if err := images.Delete(c.Request().Context(), blobStore, currentUser(c), id); err != nil {
+		return imageError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, echo.Map{
+		"status":  "success",
+		"code":    200,
+		"message": "Image deleted",
+	})
+}
+
+func fakeS3(args []string) error {
+	cfg := config.Load()
+
+	flags := flag.NewFlagSet("fake-s3", flag.ContinueOnError)
+	address := flags.String("address", ":9000", "address to listen on")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if cfg.S3AccessKey == "" || cfg.S3SecretKey == "" {
+		return errors.New("S3_ACCESS_KEY and S3_SECRET_KEY must be set to check request signatures")
+	}
+
+	fmt.Printf("fake S3 listening on %s, objects are kept in memory\n", *address)
+	fmt.Printf("point S3_ENDPOINT at http://localhost%s to use it\n", *address)
+	return http.ListenAndServe(*address, storage.NewFakeS3(cfg.S3Region, cfg.S3AccessKey, cfg.S3SecretKey))
+}
@@ -1,15 +1,35 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/golang-jwt/jwt/v5"
 	echojwt "github.com/labstack/echo-jwt/v4"
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/labstack/echo/otelecho"
 
+	"basicthreads/internal/categories"
+	"basicthreads/internal/config"
 	"basicthreads/internal/database"
+	"basicthreads/internal/exports"
+	"basicthreads/internal/images"
+	"basicthreads/internal/inventory"
+	"basicthreads/internal/media"
+	"basicthreads/internal/money"
+	"basicthreads/internal/orders"
+	"basicthreads/internal/payments"
+	"basicthreads/internal/products"
+	"basicthreads/internal/search"
+	"basicthreads/internal/storage"
+	"basicthreads/internal/tracing"
 	"basicthreads/internal/users"
 )
 
@@ -19,19 +39,12 @@ type jwtCustomClaims struct {
 	jwt.RegisteredClaims
 }
 
-type Category struct {
-	ID            int
-	Name          string
-	ParentID      int
-	Subcategories []Category
-}
-
 func contact_form(c echo.Context) error {
 	name := c.FormValue("name")
 	email := c.FormValue("email")
 	message := c.FormValue("message")
 
-	response := users.ContactForm(name, email, message)
+	response := users.ContactForm(c.Request().Context(), name, email, message)
 
 	return c.JSON(http.StatusOK, response)
 }
@@ -40,7 +53,7 @@ func getUser(c echo.Context) error {
 	email := c.FormValue("email")
 	fmt.Println(email)
 
-	user := database.GetUser(email)
+	user := database.GetUser(c.Request().Context(), email)
 	fmt.Println(user)
 
 	return c.JSON(http.StatusOK, user)
@@ -52,7 +65,7 @@ func register(c echo.Context) error {
 	phone := c.FormValue("phone")
 	password := c.FormValue("password")
 
-	response := users.RegisterUser(name, email, phone, password)
+	response := users.RegisterUser(c.Request().Context(), name, email, phone, password)
 
 	return c.JSON(http.StatusOK, response)
 }
@@ -61,71 +74,256 @@ func login(c echo.Context) error {
 	username := c.FormValue("email")
 	password := c.FormValue("password")
 
-	response := users.LoginUser(username, password)
+	client := users.Client{IP: c.RealIP(), UserAgent: c.Request().UserAgent()}
+	response := users.LoginUser(c.Request().Context(), username, password, cartToken(c), client)
+	if response["status"] == "success" {
+		// The guest cart now belongs to the customer.
+		setCartCookie(c, "", -time.Second)
+	}
 
 	return c.JSON(http.StatusOK, response)
 }
 
+// listParams reads the paging, sorting and filtering query parameters shared
+// by the product listings.
+func listParams(c echo.Context) (products.ListParams, string) {
+	params := products.ListParams{
+		Sort:   c.QueryParam("sort"),
+		Cursor: c.QueryParam("cursor"),
+	}
+
+	if params.Sort != "" && !products.ValidSort(params.Sort) {
+		return params, "sort must be one of newest, price_asc, price_desc, name_asc, name_desc"
+	}
+
+	if value := c.QueryParam("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > products.MaxLimit {
+			return params, fmt.Sprintf("limit must be between 1 and %d", products.MaxLimit)
+		}
+		params.Limit = limit
+	}
+	if value := c.QueryParam("offset"); value != "" {
+		offset, err := strconv.Atoi(value)
+		if err != nil || offset < 0 {
+			return params, "offset must be zero or a positive integer"
+		}
+		params.Offset = offset
+	}
+
+	for name, target := range map[string]**money.Money{"min_price": &params.MinPrice, "max_price": &params.MaxPrice} {
+		if value := c.QueryParam(name); value != "" {
+			price, err := money.Parse(value, database.Currency())
+			if err != nil || price.IsNegative() {
+				return params, name + " must be a positive amount"
+			}
+			*target = &price
+		}
+	}
+
+	if value := c.QueryParam("available"); value != "" {
+		available, err := strconv.ParseBool(value)
+		if err != nil {
+			return params, "available must be true or false"
+		}
+		params.Available = &available
+	}
+
+	if value := c.QueryParam("category"); value != "" {
+		id, err := strconv.Atoi(value)
+		if err != nil || id < 1 {
+			return params, "Invalid category id"
+		}
+		params.CategoryID = id
+	}
+
+	params.Sizes = splitList(c.QueryParam("size"))
+	params.Colors = splitList(c.QueryParam("color"))
+
+	return params, ""
+}
+
+// splitList reads a comma separated query value such as "S,M,L".
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func list_products(c echo.Context, params products.ListParams) error {
+	currency, problem := displayCurrency(c)
+	if problem != "" {
+		return jsonError(c, http.StatusBadRequest, problem)
+	}
+
+	page, err := products.List(c.Request().Context(), params)
+	if errors.Is(err, products.ErrInvalidCursor) {
+		return jsonError(c, http.StatusBadRequest, "Invalid cursor")
+	}
+	if err != nil {
+		return err
+	}
+
+	showPrices(page.Items, currency)
+	return c.JSON(http.StatusOK, page)
+}
+
 func get_products(c echo.Context) error {
-	products := database.GetProducts()
-	return c.JSON(http.StatusOK, products)
+	params, problem := listParams(c)
+	if problem != "" {
+		return jsonError(c, http.StatusBadRequest, problem)
+	}
+
+	return list_products(c, params)
 }
 
 func get_products_category(c echo.Context) error {
-	id := c.Param("id")
-	products := database.GetProductsCategory(id)
+	params, problem := listParams(c)
+	if problem != "" {
+		return jsonError(c, http.StatusBadRequest, problem)
+	}
+
+	id, ok := paramID(c, "id")
+	if !ok {
+		return jsonError(c, http.StatusBadRequest, "Invalid category id")
+	}
+	params.CategoryID = id
 
-	return c.JSON(http.StatusOK, products)
+	return list_products(c, params)
 }
 
 func get_categories(c echo.Context) error {
-	dbcategories := database.GetCategories("")
-	categories := make([]Category, len(dbcategories))
+	depth := 0
+	if value := c.QueryParam("depth"); value != "" {
+		var err error
+		depth, err = strconv.Atoi(value)
+		if err != nil || depth < 1 {
+			return jsonError(c, http.StatusBadRequest, "depth must be a positive integer")
+		}
+	}
 
-	for i, category := range dbcategories {
+	tree, err := categories.Tree(c.Request().Context(), depth)
+	if err != nil {
+		return err
+	}
 
-		categories[i] = Category{
-			ID:       category.ID,
-			Name:     category.Name,
-			ParentID: category.ParentID,
-		}
+	return c.JSON(http.StatusOK, tree)
+}
 
-		stringID := fmt.Sprintf("%d", category.ID)
-		subcategories := database.GetCategories(stringID)
-		for _, subcategory := range subcategories {
-			categories[i].Subcategories = append(categories[i].Subcategories, Category{
-				ID:       subcategory.ID,
-				Name:     subcategory.Name,
-				ParentID: subcategory.ParentID,
-			})
-		}
+func get_category_breadcrumbs(c echo.Context) error {
+	id, ok := paramID(c, "id")
+	if !ok {
+		return jsonError(c, http.StatusBadRequest, "Invalid category id")
 	}
 
-	return c.JSON(http.StatusOK, categories)
+	path, err := categories.Breadcrumbs(c.Request().Context(), id)
+	if err != nil {
+		return err
+	}
+	if len(path) == 0 {
+		return jsonError(c, http.StatusNotFound, "Category not found")
+	}
+
+	return c.JSON(http.StatusOK, path)
 }
 
 func get_product(c echo.Context) error {
+	currency, problem := displayCurrency(c)
+	if problem != "" {
+		return jsonError(c, http.StatusBadRequest, problem)
+	}
+
 	id := c.Param("id")
-	product := database.GetProduct(id)
+	product := database.GetProduct(c.Request().Context(), id)
+
+	if product.ID != 0 {
+		variants, err := database.GetProductVariants(c.Request().Context(), product.ID)
+		if err != nil {
+			return err
+		}
+		product.Variants = variants
+		product.Images, err = images.List(c.Request().Context(), product.ID)
+		if err != nil {
+			return err
+		}
+		if currency != "" {
+			product.DisplayPrice = convert(product.Price, currency)
+		}
+	}
+
 	return c.JSON(http.StatusOK, product)
 }
 
 func get_category(c echo.Context) error {
 	id := c.Param("id")
-	product := database.GetCategoryName(id)
+	product := database.GetCategoryName(c.Request().Context(), id)
 	return c.JSON(http.StatusOK, product)
 }
 
-func main() {
+func serve(args []string) error {
+	cfg := config.Load()
+
+	// Webhooks signed with an empty secret could come from anyone and mark
+	// orders paid, so the API does not start without one.
+	if cfg.PaymentSecretKey == "" || cfg.PaymentWebhookSecret == "" {
+		return errors.New("PAYMENT_SECRET_KEY and PAYMENT_WEBHOOK_SECRET must be set")
+	}
+
+	if err := checkCurrency(context.Background(), cfg.Currency); err != nil {
+		return err
+	}
+
+	shutdown, err := tracing.Init(context.Background(), cfg.TraceExporter)
+	if err != nil {
+		return err
+	}
+	defer shutdown(context.Background())
+
+	searcher, err = newSearcher(context.Background(), cfg.SearchBackend)
+	if err != nil {
+		return err
+	}
+
+	// Checkouts that never finish give their stock back on their own, and
+	// orders nobody paid for get cancelled.
+	sweepCtx, stopSweep := context.WithCancel(context.Background())
+	defer stopSweep()
+	go inventory.Sweep(sweepCtx, time.Minute)
+	go orders.Sweep(sweepCtx, time.Minute)
+	go exports.Sweep(sweepCtx, time.Minute)
+	if index, ok := searcher.(*search.MemoryIndex); ok {
+		go index.Watch(sweepCtx, time.Minute)
+	}
+
+	paymentProvider = payments.New(cfg)
+
+	blobStore, err = storage.New(cfg)
+	if err != nil {
+		return err
+	}
+	mediaServer = media.NewServer(blobStore, media.NewCache(cfg.MediaCacheDir, int64(cfg.MediaCacheBytes)))
+
+	exchangeRates, err = money.ParseRates(cfg.Currency, cfg.ExchangeRates)
+	if err != nil {
+		return fmt.Errorf("EXCHANGE_RATES: %w", err)
+	}
+
 	e := echo.New()
 
 	// Middleware
+	e.Use(otelecho.Middleware(tracing.ServiceName))
 	e.Use(middleware.Logger())
 	e.Use(middleware.Recover())
 	e.Use(middleware.CORSWithConfig(middleware.CORSConfig{
-		AllowOrigins: []string{"http://localhost:3000", "http://127.0.0.1:3000"},
-		AllowHeaders: []string{echo.HeaderOrigin, echo.HeaderContentType, echo.HeaderAccept},
-		AllowMethods: []string{http.MethodGet, http.MethodPut, http.MethodPost, http.MethodDelete},
+		AllowOrigins:     cfg.AllowOrigins,
+		AllowHeaders:     []string{echo.HeaderOrigin, echo.HeaderContentType, echo.HeaderAccept, echo.HeaderAuthorization, "If-Match", "X-Cart-Token", "traceparent", "tracestate"},
+		ExposeHeaders:    []string{"ETag"},
+		AllowCredentials: true,
+		AllowMethods:     []string{http.MethodGet, http.MethodPut, http.MethodPost, http.MethodDelete},
 	}))
 
 	// Login route
@@ -134,17 +332,19 @@ func main() {
 	e.GET("/products", get_products)
 	e.GET("/products/:id", get_products_category)
 	e.GET("/product/:id", get_product)
+	e.GET("/product/:id/reviews", get_product_reviews)
 	e.GET("/categories", get_categories)
 	e.GET("/categories/:id", get_category)
+	e.GET("/categories/:id/breadcrumbs", get_category_breadcrumbs)
+	e.GET("/search", search_products)
+	e.GET("/media/*", get_media)
+	e.HEAD("/media/*", get_media)
 	e.POST("/getuser", getUser)
 	e.POST("/contactform", contact_form)
 
 	// Configure middleware with the custom claims type
-	config := echojwt.Config{
-		NewClaimsFunc: func(c echo.Context) jwt.Claims {
-			return new(jwtCustomClaims)
-		},
-		SigningKey: []byte("secret"),
+	jwtConfig := echojwt.Config{
+		ParseTokenFunc: parseToken([]byte(cfg.JWTSecret)),
 		ErrorHandler: func(c echo.Context, err error) error {
 			response := echo.Map{
 				"status":  "error",
@@ -155,8 +355,124 @@ func main() {
 		},
 	}
 
-	fmt.Println(config)
-	// r.Use(echojwt.WithConfig(config))
+	// The cart works for guests too, so a missing token is not an error
+	// there, only a bad one.
+	cartJWTConfig := jwtConfig
+	cartJWTConfig.ContinueOnIgnoredError = true
+	cartJWTConfig.ErrorHandler = func(c echo.Context, err error) error {
+		if errors.Is(err, echojwt.ErrJWTMissing) {
+			return nil
+		}
+		return jwtConfig.ErrorHandler(c, err)
+	}
+
+	cart := e.Group("/cart", echojwt.WithConfig(cartJWTConfig))
+	cart.GET("", get_cart)
+	cart.POST("/items", add_cart_item)
+	cart.PUT("/items/:id", update_cart_item)
+	cart.DELETE("/items/:id", remove_cart_item)
+	cart.PUT("/code", apply_cart_code)
+	cart.DELETE("/code", remove_cart_code)
+	cart.GET("/shipping-rates", get_shipping_rates)
+
+	requireUser := echojwt.WithConfig(jwtConfig)
+	e.POST("/checkout", checkout, requireUser)
+	e.GET("/orders", list_orders, requireUser)
+	e.GET("/orders/:id", get_order, requireUser)
+	e.POST("/orders/:id/payment", start_payment, requireUser)
+	e.GET("/addresses", list_addresses, requireUser)
+	e.POST("/addresses", create_address, requireUser)
+	e.GET("/addresses/:id", get_address, requireUser)
+	e.PUT("/addresses/:id", update_address, requireUser)
+	e.DELETE("/addresses/:id", delete_address, requireUser)
+	e.GET("/me", get_me, requireUser)
+	e.PUT("/me", update_me, requireUser)
+	e.DELETE("/me", delete_me, requireUser)
+	e.PUT("/me/email", change_email, requireUser)
+	e.PUT("/me/password", change_password, requireUser)
+	e.GET("/me/exports", list_exports, requireUser)
+	e.POST("/me/exports", request_export, requireUser)
+	e.GET("/exports/:token", download_export)
+	e.GET("/me/reviews", list_my_reviews, requireUser)
+	e.POST("/product/:id/reviews", write_review, requireUser)
+	e.DELETE("/reviews/:id", delete_review, requireUser)
+	e.GET("/wishlists", list_wishlists, requireUser)
+	e.POST("/wishlists", create_wishlist, requireUser)
+	e.GET("/wishlists/shared/:token", get_shared_wishlist)
+	e.GET("/wishlists/:id", get_wishlist, requireUser)
+	e.PUT("/wishlists/:id", update_wishlist, requireUser)
+	e.DELETE("/wishlists/:id", delete_wishlist, requireUser)
+	e.POST("/wishlists/:id/items", add_wishlist_item, requireUser)
+	e.DELETE("/wishlists/:id/items/:item", remove_wishlist_item, requireUser)
+	e.POST("/email/confirm", confirm_email)
+	e.POST("/payments/webhook", payment_webhook)
+
+	admin := e.Group("/admin", echojwt.WithConfig(jwtConfig), requireAdmin, invalidateSearch)
+	admin.POST("/categories", admin_create_category)
+	admin.PUT("/categories/:id", admin_rename_category)
+	admin.PUT("/categories/:id/parent", admin_move_category)
+	admin.PUT("/categories/:id/position", admin_position_category)
+	admin.DELETE("/categories/:id", admin_delete_category)
+	admin.GET("/products/:id", admin_get_product)
+	admin.POST("/products", admin_create_product)
+	admin.PUT("/products/:id", admin_update_product)
+	admin.PUT("/products/:id/categories", admin_set_product_categories)
+	admin.DELETE("/products/:id", admin_delete_product)
+	admin.POST("/products/:id/restore", admin_restore_product)
+	admin.GET("/products/:id/audit", admin_product_audit)
+	admin.GET("/products/:id/images", admin_list_images)
+	admin.POST("/products/:id/images", admin_upload_image)
+	admin.PUT("/products/:id/images/order", admin_reorder_images)
+	admin.DELETE("/images/:id", admin_delete_image)
+	admin.GET("/products/:id/variants", admin_list_variants)
+	admin.POST("/products/:id/variants", admin_create_variant)
+	admin.PUT("/variants/:id", admin_update_variant)
+	admin.DELETE("/variants/:id", admin_delete_variant)
+	admin.POST("/variants/:id/stock", admin_record_stock)
+	admin.GET("/variants/:id/movements", admin_stock_movements)
+	admin.GET("/inventory/low-stock", admin_low_stock)
+	admin.GET("/orders", admin_search_orders)
+	admin.GET("/orders/:id", admin_get_order)
+	admin.PUT("/orders/:id/status", admin_order_status)
+	admin.POST("/orders/:id/shipments", admin_ship_order)
+	admin.GET("/customers/:id/exports", admin_list_exports)
+	admin.GET("/reviews", admin_review_queue)
+	admin.PUT("/reviews/:id/status", admin_moderate_review)
+	admin.POST("/customers/:id/exports", admin_request_export)
+	admin.GET("/promotions", admin_list_promotions)
+	admin.GET("/promotions/:id", admin_get_promotion)
+	admin.POST("/promotions", admin_create_promotion)
+	admin.PUT("/promotions/:id", admin_update_promotion)
+	admin.GET("/tax-rates", admin_list_tax_rates)
+	admin.PUT("/tax-rates", admin_save_tax_rate)
+	admin.DELETE("/tax-rates/:id", admin_delete_tax_rate)
+	admin.GET("/shipping/zones", admin_list_shipping_zones)
+	admin.POST("/shipping/zones", admin_create_shipping_zone)
+	admin.PUT("/shipping/zones/:id", admin_update_shipping_zone)
+	admin.DELETE("/shipping/zones/:id", admin_delete_shipping_zone)
+	admin.POST("/shipping/zones/:id/methods", admin_create_shipping_method)
+	admin.PUT("/shipping/methods/:id", admin_update_shipping_method)
+	admin.DELETE("/shipping/methods/:id", admin_delete_shipping_method)
+
+	return e.Start(cfg.Address)
+}
+
+func main() {
+	name := "serve"
+	args := os.Args[1:]
+	if len(args) > 0 {
+		name, args = args[0], args[1:]
+	}
 
-	e.Logger.Fatal(e.Start(":1323"))
+	cmd, ok := commands[name]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n", name)
+		usage()
+		os.Exit(2)
+	}
+
+	if err := cmd.run(args); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
 }
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/labstack/echo/v4"
+
+	"basicthreads/internal/database"
+	"basicthreads/internal/users"
+)
+
+var errSessionEnded = errors.New("session ended")
+
+// parseToken checks the signature of a token like the default JWT
+// middleware does, and also that its session was not signed out, so
+// changing a password or an email ends the other logins at once.
+func parseToken(key []byte) func(c echo.Context, auth string) (any, error) {
+	return func(c echo.Context, auth string) (any, error) {
+		token, err := jwt.ParseWithClaims(auth, new(jwtCustomClaims), func(*jwt.Token) (any, error) {
+			return key, nil
+		}, jwt.WithValidMethods([]string{"HS256"}))
+		if err != nil {
+			return nil, err
+		}
+
+		claims := token.Claims.(*jwtCustomClaims)
+		active, err := users.SessionActive(c.Request().Context(), claims.ID)
+		if err != nil {
+			return nil, err
+		}
+		if !active {
+			return nil, errSessionEnded
+		}
+		return token, nil
+	}
+}
+
+// currentSession returns the session id of the token of the request.
+func currentSession(c echo.Context) string {
+	token, ok := c.Get("user").(*jwt.Token)
+	if !ok {
+		return ""
+	}
+	claims, ok := token.Claims.(*jwtCustomClaims)
+	if !ok {
+		return ""
+	}
+	return claims.ID
+}
+
+func accountError(c echo.Context, err error) error {
+	switch {
+	case errors.Is(err, users.ErrNotFound):
+		return jsonError(c, http.StatusNotFound, "Customer not found")
+	case errors.Is(err, users.ErrWrongPassword):
+		return jsonError(c, http.StatusForbidden, "Current password is wrong")
+	case errors.Is(err, users.ErrEmailTaken):
+		return jsonError(c, http.StatusConflict, "Email is already registered")
+	case errors.Is(err, users.ErrInvalidToken):
+		return jsonError(c, http.StatusBadRequest, err.Error())
+	}
+	return productError(c, err)
+}
+
+func get_me(c echo.Context) error {
+	customerID, err := database.GetCustomerID(c.Request().Context(), currentUser(c))
+	if err != nil {
+		return accountError(c, err)
+	}
+
+	customer, err := users.Profile(c.Request().Context(), customerID)
+	if err != nil {
+		return accountError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, customer)
+}
+
+func update_me(c echo.Context) error {
+	customerID, err := database.GetCustomerID(c.Request().Context(), currentUser(c))
+	if err != nil {
+		return accountError(c, err)
+	}
+
+	customer, err := users.UpdateProfile(c.Request().Context(), customerID, c.FormValue("name"), c.FormValue("phone"))
+	if err != nil {
+		return accountError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, customer)
+}
+
+// change_email sends a confirmation token to the new email. Nothing
+// changes until the token comes back to confirm_email.
+func change_email(c echo.Context) error {
+	customerID, err := database.GetCustomerID(c.Request().Context(), currentUser(c))
+	if err != nil {
+		return accountError(c, err)
+	}
+
+	err = users.RequestEmailChange(c.Request().Context(), customerID, c.FormValue("password"), c.FormValue("email"))
+	if err != nil {
+		return accountError(c, err)
+	}
+
+	return c.JSON(http.StatusAccepted, echo.Map{
+		"status":  "success",
+		"code":    202,
+		"message": "Check the new email for the confirmation code",
+	})
+}
+
+// confirm_email works without a login: the token proves who asks.
+func confirm_email(c echo.Context) error {
+	token := c.FormValue("token")
+	if token == "" {
+		return jsonError(c, http.StatusBadRequest, "token is required")
+	}
+
+	if err := users.ConfirmEmailChange(c.Request().Context(), token); err != nil {
+		return accountError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, echo.Map{
+		"status":  "success",
+		"code":    200,
+		"message": "Email changed, log in again with the new email",
+	})
+}
+
+func change_password(c echo.Context) error {
+	customerID, err := database.GetCustomerID(c.Request().Context(), currentUser(c))
+	if err != nil {
+		return accountError(c, err)
+	}
+
+	err = users.ChangePassword(
+		c.Request().Context(),
+		customerID,
+		c.FormValue("current_password"),
+		c.FormValue("password"),
+		currentSession(c),
+	)
+	if err != nil {
+		return accountError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, echo.Map{
+		"status":  "success",
+		"code":    200,
+		"message": "Password changed, other sessions were signed out",
+	})
+}
+
+// delete_me anonymizes the account of the customer, who confirms with
+// their password. Orders are kept.
+func delete_me(c echo.Context) error {
+	customerID, err := database.GetCustomerID(c.Request().Context(), currentUser(c))
+	if err != nil {
+		return accountError(c, err)
+	}
+
+	if err := users.DeleteAccount(c.Request().Context(), customerID, c.FormValue("password")); err != nil {
+		return accountError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, echo.Map{
+		"status":  "success",
+		"code":    200,
+		"message": "Account deleted",
+	})
+}
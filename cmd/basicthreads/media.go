@@ -0,0 +1,74 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+
+	"basicthreads/internal/media"
+)
+
+var mediaServer *media.Server
+
+// mediaParams reads the w, h and format query parameters that resize an
+// image.
+func mediaParams(c echo.Context) (media.Params, string) {
+	var params media.Params
+	for name, dest := range map[string]*int{"w": &params.Width, "h": &params.Height} {
+		value := c.QueryParam(name)
+		if value == "" {
+			continue
+		}
+		size, err := strconv.Atoi(value)
+		if err != nil || size < 1 || size > media.MaxSize {
+			return params, fmt.Sprintf("%s must be between 1 and %d", name, media.MaxSize)
+		}
+		*dest = size
+	}
+	if params.Format = c.QueryParam("format"); params.Format != "" && !slices.Contains(media.Formats, params.Format) {
+		return params, "format must be one of " + strings.Join(media.Formats, ", ")
+	}
+	return params, ""
+}
+
+// get_media serves uploaded blobs. Their keys are never reused, so clients
+// may cache them for good. Ranges and conditional requests are handled by
+// http.ServeContent against the strong ETag; a conditional request for a
+// blob served before never downloads it.
+func get_media(c echo.Context) error {
+	params, problem := mediaParams(c)
+	if problem != "" {
+		return jsonError(c, http.StatusBadRequest, problem)
+	}
+
+	blob, err := mediaServer.Open(c.Request().Context(), c.Param("*"), params)
+	switch {
+	case errors.Is(err, media.ErrNotFound):
+		return jsonError(c, http.StatusNotFound, "Not found")
+	case errors.Is(err, media.ErrNotImage):
+		return jsonError(c, http.StatusUnsupportedMediaType, err.Error())
+	case err != nil:
+		return productError(c, err)
+	}
+	defer blob.Content.Close()
+
+	header := c.Response().Header()
+	header.Set(echo.HeaderContentType, blob.ContentType)
+	header.Set("ETag", blob.ETag)
+	header.Set("Cache-Control", "public, max-age=31536000, immutable")
+	header.Set("X-Content-Type-Options", "nosniff")
+	// Only images are shown inline; anything else is downloaded, so a blob
+	// that turns out to be HTML cannot run in the shop's origin.
+	if !strings.HasPrefix(blob.ContentType, "image/") {
+		header.Set(echo.HeaderContentType, "application/octet-stream")
+		header.Set(echo.HeaderContentDisposition, "attachment")
+	}
+
+	http.ServeContent(c.Response(), c.Request(), "", blob.ModTime, blob.Content)
+	return nil
+}
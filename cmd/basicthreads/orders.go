@@ -0,0 +1,223 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+
+	"basicthreads/internal/database"
+	"basicthreads/internal/orders"
+	"basicthreads/internal/payments"
+	"basicthreads/internal/products"
+)
+
+func orderError(c echo.Context, err error) error {
+	var transition *orders.TransitionError
+	switch {
+	case errors.Is(err, orders.ErrNotFound):
+		return jsonError(c, http.StatusNotFound, "Order not found")
+	case errors.Is(err, orders.ErrEmptyCart):
+		return jsonError(c, http.StatusBadRequest, "Cart is empty")
+	case errors.Is(err, orders.ErrUnavailable):
+		return jsonError(c, http.StatusConflict, err.Error())
+	case errors.Is(err, orders.ErrDiscountUsedUp):
+		return jsonError(c, http.StatusConflict, "A discount in the cart is no longer available, review the cart and try again")
+	case errors.Is(err, orders.ErrCartChanged):
+		return jsonError(c, http.StatusConflict, "The cart changed during checkout, review it and try again")
+	case errors.Is(err, orders.ErrInvalidStatus):
+		return jsonError(c, http.StatusBadRequest, "status must be one of pending, paid, shipped, delivered, cancelled, refunded")
+	case errors.Is(err, orders.ErrStatusChanged):
+		return jsonError(c, http.StatusConflict, "Order status changed, reload it and try again")
+	case errors.As(err, &transition):
+		return jsonError(c, http.StatusConflict, err.Error())
+	}
+	return inventoryError(c, err)
+}
+
+// orderFilter reads the paging query parameters shared by the order
+// listings.
+func orderFilter(c echo.Context) (database.OrderFilter, string) {
+	filter := database.OrderFilter{Status: c.QueryParam("status")}
+
+	if value := c.QueryParam("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 1 {
+			return filter, "limit must be a positive number"
+		}
+		filter.Limit = limit
+	}
+	if value := c.QueryParam("offset"); value != "" {
+		offset, err := strconv.Atoi(value)
+		if err != nil || offset < 0 {
+			return filter, "offset must be zero or more"
+		}
+		filter.Offset = offset
+	}
+
+	return filter, ""
+}
+
+// addressInput reads a postal address from the form.
+func addressInput(c echo.Context) database.Address {
+	return database.Address{
+		Name:       c.FormValue("name"),
+		Line1:      c.FormValue("line1"),
+		Line2:      c.FormValue("line2"),
+		City:       c.FormValue("city"),
+		Region:     c.FormValue("region"),
+		PostalCode: c.FormValue("postal_code"),
+		Country:    c.FormValue("country"),
+		Phone:      c.FormValue("phone"),
+	}
+}
+
+func checkout(c echo.Context) error {
+	customerID, err := database.GetCustomerID(c.Request().Context(), currentUser(c))
+	if err != nil {
+		return orderError(c, err)
+	}
+
+	in := orders.CheckoutInput{ShippingAddress: addressInput(c)}
+	ids := map[string]*int{
+		"shipping_method":     &in.ShippingMethod,
+		"shipping_address_id": &in.ShippingAddressID,
+		"billing_address_id":  &in.BillingAddressID,
+	}
+	problems := products.ValidationError{}
+	for name, target := range ids {
+		if value := c.FormValue(name); value != "" {
+			if *target, err = strconv.Atoi(value); err != nil {
+				problems[name] = "must be an id"
+			}
+		}
+	}
+	if len(problems) > 0 {
+		return productError(c, problems)
+	}
+
+	order, err := orders.Checkout(c.Request().Context(), customerID, currentUser(c), in)
+	if err != nil {
+		return orderError(c, err)
+	}
+
+	return c.JSON(http.StatusCreated, order)
+}
+
+func list_orders(c echo.Context) error {
+	customerID, err := database.GetCustomerID(c.Request().Context(), currentUser(c))
+	if err != nil {
+		return orderError(c, err)
+	}
+	filter, problem := orderFilter(c)
+	if problem != "" {
+		return jsonError(c, http.StatusBadRequest, problem)
+	}
+	filter.CustomerID = customerID
+
+	page, err := orders.List(c.Request().Context(), filter)
+	if err != nil {
+		return orderError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, page)
+}
+
+func get_order(c echo.Context) error {
+	customerID, err := database.GetCustomerID(c.Request().Context(), currentUser(c))
+	if err != nil {
+		return orderError(c, err)
+	}
+	id, ok := paramID(c, "id")
+	if !ok {
+		return jsonError(c, http.StatusBadRequest, "Invalid order id")
+	}
+
+	order, err := orders.Get(c.Request().Context(), customerID, id)
+	if err != nil {
+		return orderError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, order)
+}
+
+func admin_search_orders(c echo.Context) error {
+	filter, problem := orderFilter(c)
+	if problem != "" {
+		return jsonError(c, http.StatusBadRequest, problem)
+	}
+	filter.Email = c.QueryParam("email")
+	filter.Reference = c.QueryParam("reference")
+	for name, target := range map[string]*time.Time{"from": &filter.From, "to": &filter.To} {
+		if value := c.QueryParam(name); value != "" {
+			day, err := time.Parse(time.DateOnly, value)
+			if err != nil {
+				return jsonError(c, http.StatusBadRequest, name+" must be a date like 2024-01-31")
+			}
+			*target = day
+		}
+	}
+	// The to date is inclusive.
+	if !filter.To.IsZero() {
+		filter.To = filter.To.AddDate(0, 0, 1)
+	}
+
+	page, err := orders.List(c.Request().Context(), filter)
+	if err != nil {
+		return orderError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, page)
+}
+
+func admin_get_order(c echo.Context) error {
+	id, ok := paramID(c, "id")
+	if !ok {
+		return jsonError(c, http.StatusBadRequest, "Invalid order id")
+	}
+
+	order, err := database.GetOrder(c.Request().Context(), id)
+	if err != nil {
+		return orderError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, order)
+}
+
+func admin_order_status(c echo.Context) error {
+	id, ok := paramID(c, "id")
+	if !ok {
+		return jsonError(c, http.StatusBadRequest, "Invalid order id")
+	}
+	note := c.FormValue("note")
+	if len(note) > 255 {
+		return jsonError(c, http.StatusBadRequest, "note must be at most 255 characters")
+	}
+
+	status := c.FormValue("status")
+	refund := status == orders.StatusRefunded
+	if status == orders.StatusCancelled {
+		captured, err := payments.Captured(c.Request().Context(), id)
+		if err != nil {
+			return paymentError(c, err)
+		}
+		refund = captured
+	}
+
+	var order database.Order
+	var err error
+	// Refunding, and cancelling a paid order, have to give the money back,
+	// not only change the status.
+	if refund {
+		order, err = payments.RefundOrder(c.Request().Context(), paymentProvider, id, status, currentUser(c), note)
+	} else {
+		order, err = orders.Transition(c.Request().Context(), id, status, currentUser(c), note)
+	}
+	if err != nil {
+		return paymentError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, order)
+}
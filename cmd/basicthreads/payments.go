@@ -0,0 +1,90 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+
+	"basicthreads/internal/config"
+	"basicthreads/internal/database"
+	"basicthreads/internal/payments"
+)
+
+// maxWebhookSize bounds the webhook bodies read into memory.
+const maxWebhookSize = 1 << 20
+
+var paymentProvider payments.Provider
+
+func paymentError(c echo.Context, err error) error {
+	switch {
+	case errors.Is(err, payments.ErrNotPayable), errors.Is(err, payments.ErrNotPaid):
+		return jsonError(c, http.StatusConflict, err.Error())
+	}
+	return orderError(c, err)
+}
+
+func start_payment(c echo.Context) error {
+	customerID, err := database.GetCustomerID(c.Request().Context(), currentUser(c))
+	if err != nil {
+		return paymentError(c, err)
+	}
+	id, ok := paramID(c, "id")
+	if !ok {
+		return jsonError(c, http.StatusBadRequest, "Invalid order id")
+	}
+
+	intent, err := payments.Start(c.Request().Context(), paymentProvider, customerID, id)
+	if err != nil {
+		return paymentError(c, err)
+	}
+
+	return c.JSON(http.StatusCreated, intent)
+}
+
+func payment_webhook(c echo.Context) error {
+	payload, err := io.ReadAll(io.LimitReader(c.Request().Body, maxWebhookSize))
+	if err != nil {
+		return jsonError(c, http.StatusBadRequest, "Could not read the request body")
+	}
+
+	event, err := paymentProvider.ParseWebhook(payload, c.Request().Header)
+	if err != nil {
+		return jsonError(c, http.StatusBadRequest, err.Error())
+	}
+
+	// An error makes the provider send the event again later.
+	if err := payments.Handle(c.Request().Context(), paymentProvider, event); err != nil {
+		c.Logger().Errorf("handling payment event %s: %v", event.ID, err)
+		return jsonError(c, http.StatusInternalServerError, "Could not handle the event")
+	}
+
+	return c.JSON(http.StatusOK, echo.Map{"received": true})
+}
+
+func fakeGateway(args []string) error {
+	cfg := config.Load()
+
+	flags := flag.NewFlagSet("fake-gateway", flag.ContinueOnError)
+	address := flags.String("address", ":12111", "address to listen on")
+	webhook := flags.String("webhook", "http://localhost"+cfg.Address+"/payments/webhook", "URL webhooks are sent to")
+	delay := flags.Duration("delay", 2*time.Second, "how long webhooks wait before being sent")
+	delayed := flags.Duration("delayed", 30*time.Second, "how long webhooks of pm_card_delayed wait")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if cfg.PaymentWebhookSecret == "" {
+		return errors.New("PAYMENT_WEBHOOK_SECRET must be set to sign webhooks")
+	}
+
+	gateway := payments.NewFakeGateway(*webhook, cfg.PaymentWebhookSecret, *delay)
+	gateway.DelayedBy = *delayed
+
+	fmt.Printf("fake gateway listening on %s, sending webhooks to %s\n", *address, *webhook)
+	fmt.Printf("point PAYMENT_URL at http://localhost%s to use it\n", *address)
+	return http.ListenAndServe(*address, gateway)
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+
+	"basicthreads/internal/database"
+	"basicthreads/internal/products"
+	"basicthreads/internal/reviews"
+)
+
+func reviewError(c echo.Context, err error) error {
+	switch {
+	case errors.Is(err, reviews.ErrNotFound):
+		return jsonError(c, http.StatusNotFound, "Review not found")
+	case errors.Is(err, reviews.ErrProductNotFound):
+		return jsonError(c, http.StatusNotFound, "Product not found")
+	case errors.Is(err, reviews.ErrNotPurchased):
+		return jsonError(c, http.StatusForbidden, err.Error())
+	}
+	return productError(c, err)
+}
+
+// reviewParams reads the paging, sorting and filtering query parameters of
+// the review listings.
+func reviewParams(c echo.Context) (reviews.ListParams, string) {
+	params := reviews.ListParams{Sort: c.QueryParam("sort")}
+
+	if !reviews.ValidSort(params.Sort) {
+		return params, "sort must be one of " + strings.Join(reviews.Sorts, ", ")
+	}
+	if value := c.QueryParam("rating"); value != "" {
+		rating, err := strconv.Atoi(value)
+		if err != nil || rating < 1 || rating > 5 {
+			return params, "rating must be between 1 and 5"
+		}
+		params.Rating = rating
+	}
+	if value := c.QueryParam("verified"); value != "" {
+		verified, err := strconv.ParseBool(value)
+		if err != nil {
+			return params, "verified must be true or false"
+		}
+		params.Verified = &verified
+	}
+	if value := c.QueryParam("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > reviews.MaxLimit {
+			return params, fmt.Sprintf("limit must be between 1 and %d", reviews.MaxLimit)
+		}
+		params.Limit = limit
+	}
+	if value := c.QueryParam("offset"); value != "" {
+		offset, err := strconv.Atoi(value)
+		if err != nil || offset < 0 {
+			return params, "offset must be zero or a positive integer"
+		}
+		params.Offset = offset
+	}
+	return params, ""
+}
+
+// get_product_reviews lists the approved reviews of a product. The rating
+// and review count are on the product itself.
+func get_product_reviews(c echo.Context) error {
+	id, ok := paramID(c, "id")
+	if !ok {
+		return jsonError(c, http.StatusBadRequest, "Invalid product id")
+	}
+	params, problem := reviewParams(c)
+	if problem != "" {
+		return jsonError(c, http.StatusBadRequest, problem)
+	}
+	if product := database.GetProduct(c.Request().Context(), strconv.Itoa(id)); product.ID == 0 {
+		return jsonError(c, http.StatusNotFound, "Product not found")
+	}
+
+	page, err := reviews.ForProduct(c.Request().Context(), id, params)
+	if err != nil {
+		return reviewError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, page)
+}
+
+// write_review saves the review of the logged in customer on a product. A
+// second review replaces the first.
+func write_review(c echo.Context) error {
+	customerID, err := database.GetCustomerID(c.Request().Context(), currentUser(c))
+	if err != nil {
+		return reviewError(c, err)
+	}
+	id, ok := paramID(c, "id")
+	if !ok {
+		return jsonError(c, http.StatusBadRequest, "Invalid product id")
+	}
+	rating, err := strconv.Atoi(c.FormValue("rating"))
+	if err != nil {
+		return reviewError(c, products.ValidationError{"rating": "must be between 1 and 5"})
+	}
+
+	review, err := reviews.Write(c.Request().Context(), customerID, id, reviews.Input{
+		Rating: rating,
+		Title:  c.FormValue("title"),
+		Body:   c.FormValue("body"),
+	})
+	if err != nil {
+		return reviewError(c, err)
+	}
+
+	return c.JSON(http.StatusCreated, review)
+}
+
+func delete_review(c echo.Context) error {
+	customerID, err := database.GetCustomerID(c.Request().Context(), currentUser(c))
+	if err != nil {
+		return reviewError(c, err)
+	}
+	id, ok := paramID(c, "id")
+	if !ok {
+		return jsonError(c, http.StatusBadRequest, "Invalid review id")
+	}
+
+	if err := reviews.Delete(c.Request().Context(), customerID, id); err != nil {
+		return reviewError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, echo.Map{
+		"status":  "success",
+		"code":    200,
+		"message": "Review deleted",
+	})
+}
+
+func list_my_reviews(c echo.Context) error {
+	customerID, err := database.GetCustomerID(c.Request().Context(), currentUser(c))
+	if err != nil {
+		return reviewError(c, err)
+	}
+	params, problem := reviewParams(c)
+	if problem != "" {
+		return jsonError(c, http.StatusBadRequest, problem)
+	}
+
+	page, err := reviews.Mine(c.Request().Context(), customerID, params.Offset, params.Limit)
+	if err != nil {
+		return reviewError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, page)
+}
+
+// admin_review_queue lists reviews by moderation status, pending ones by
+// default.
+func admin_review_queue(c echo.Context) error {
+	params, problem := reviewParams(c)
+	if problem != "" {
+		return jsonError(c, http.StatusBadRequest, problem)
+	}
+
+	page, err := reviews.Queue(c.Request().Context(), c.QueryParam("status"), params)
+	if err != nil {
+		return reviewError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, page)
+}
+
+func admin_moderate_review(c echo.Context) error {
+	id, ok := paramID(c, "id")
+	if !ok {
+		return jsonError(c, http.StatusBadRequest, "Invalid review id")
+	}
+
+	review, err := reviews.Moderate(c.Request().Context(), id, c.FormValue("status"), currentUser(c), c.FormValue("note"))
+	if err != nil {
+		return reviewError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, review)
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+
+	"basicthreads/internal/database"
+	"basicthreads/internal/search"
+)
+
+const maxSearchLimit = 100
+
+var searcher search.Searcher
+
+func newSearcher(ctx context.Context, backend string) (search.Searcher, error) {
+	switch backend {
+	case "mysql":
+		return search.NewMySQLSearcher(database.Connect()), nil
+	case "memory":
+		return search.LoadMemoryIndex(ctx)
+	}
+	return nil, fmt.Errorf("unknown search backend %q", backend)
+}
+
+// invalidateSearch has the in-memory index rebuilt after an admin request
+// changes the catalog, so searches stop showing what was edited or deleted.
+// The MySQL backend reads the tables directly and needs nothing.
+func invalidateSearch(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		err := next(c)
+		index, ok := searcher.(*search.MemoryIndex)
+		if !ok || err != nil || c.Request().Method == http.MethodGet || c.Response().Status >= 300 {
+			return err
+		}
+		for _, prefix := range []string{"/admin/products", "/admin/categories", "/admin/variants", "/admin/images"} {
+			if strings.HasPrefix(c.Path(), prefix) {
+				index.Invalidate()
+				break
+			}
+		}
+		return err
+	}
+}
+
+func search_products(c echo.Context) error {
+	request := search.Request{
+		Query:     c.QueryParam("q"),
+		PriceBand: c.QueryParam("price_band"),
+		Limit:     20,
+	}
+
+	currency, problem := displayCurrency(c)
+	if problem != "" {
+		return jsonError(c, http.StatusBadRequest, problem)
+	}
+	if request.PriceBand != "" && !search.ValidPriceBand(request.PriceBand) {
+		return jsonError(c, http.StatusBadRequest, "Unknown price_band")
+	}
+	if value := c.QueryParam("category"); value != "" {
+		id, err := strconv.Atoi(value)
+		if err != nil || id < 1 {
+			return jsonError(c, http.StatusBadRequest, "Invalid category id")
+		}
+		request.CategoryID = id
+	}
+	if value := c.QueryParam("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > maxSearchLimit {
+			return jsonError(c, http.StatusBadRequest, fmt.Sprintf("limit must be between 1 and %d", maxSearchLimit))
+		}
+		request.Limit = limit
+	}
+	if value := c.QueryParam("offset"); value != "" {
+		offset, err := strconv.Atoi(value)
+		if err != nil || offset < 0 {
+			return jsonError(c, http.StatusBadRequest, "offset must be zero or a positive integer")
+		}
+		request.Offset = offset
+	}
+
+	results, err := search.Run(c.Request().Context(), searcher, request)
+	if err != nil {
+		return err
+	}
+
+	if currency != "" {
+		for i := range results.Items {
+			results.Items[i].DisplayPrice = convert(results.Items[i].Price, currency)
+		}
+	}
+	return c.JSON(http.StatusOK, results)
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+
+	"basicthreads/internal/database"
+	"basicthreads/internal/seed"
+)
+
+func seedData(args []string) error {
+	flags := flag.NewFlagSet("seed", flag.ContinueOnError)
+	reset := flags.Bool("reset", false, "delete existing catalog and customers before loading")
+	dir := flags.String("dir", "", "load fixtures from this directory instead of the embedded ones")
+	storeName := flags.String("store", "mysql", "store to load into: mysql or memory")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	fixtures, err := seed.Load(*dir)
+	if err != nil {
+		return err
+	}
+
+	var store seed.Store
+	switch *storeName {
+	case "mysql":
+		if err := checkCurrency(context.Background(), database.Currency()); err != nil {
+			return err
+		}
+		db := database.Connect()
+		defer db.Close()
+		store = seed.NewMySQLStore(db)
+	case "memory":
+		store = seed.NewMemoryStore()
+	default:
+		return fmt.Errorf("unknown store %q", *storeName)
+	}
+
+	err = seed.Run(context.Background(), store, fixtures, *reset)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf(
+		"seeded %d categories, %d products and %d customers\n",
+		len(fixtures.Categories),
+		len(fixtures.Products),
+		len(fixtures.Customers),
+	)
+	return nil
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+
+	"basicthreads/internal/carts"
+	"basicthreads/internal/database"
+	"basicthreads/internal/money"
+	"basicthreads/internal/orders"
+	"basicthreads/internal/products"
+	"basicthreads/internal/shipping"
+)
+
+func shippingError(c echo.Context, err error) error {
+	switch {
+	case errors.Is(err, shipping.ErrNotFound):
+		return jsonError(c, http.StatusNotFound, "Shipping zone or method not found")
+	case errors.Is(err, shipping.ErrDuplicate):
+		return jsonError(c, http.StatusConflict, err.Error())
+	}
+	return productError(c, err)
+}
+
+// get_shipping_rates quotes the shipping methods that reach country and
+// region for what is in the cart.
+func get_shipping_rates(c echo.Context) error {
+	country := c.QueryParam("country")
+	if country == "" {
+		return jsonError(c, http.StatusBadRequest, "country is required")
+	}
+	owner, err := cartOwner(c)
+	if err != nil {
+		return cartError(c, err)
+	}
+
+	cart, err := carts.Get(c.Request().Context(), owner)
+	if err != nil {
+		return cartError(c, err)
+	}
+	rates, err := shipping.Quote(c.Request().Context(), country, c.QueryParam("region"), cart.Weight, cart.Total)
+	if err != nil {
+		return shippingError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, rates)
+}
+
+// zoneInput reads a zone from the form. areas is a list like
+// "SV, GT, US:CA" of countries, or regions after a colon.
+func zoneInput(c echo.Context) shipping.ZoneInput {
+	return shipping.ZoneInput{
+		Name:  c.FormValue("name"),
+		Areas: shipping.ParseAreas(c.FormValue("areas")),
+	}
+}
+
+// methodInput reads a shipping method from the form. Optional amounts and
+// max_weight, in grams, left empty mean "none".
+func methodInput(c echo.Context) (shipping.MethodInput, error) {
+	in := shipping.MethodInput{
+		Name:    c.FormValue("name"),
+		Kind:    c.FormValue("kind"),
+		Carrier: c.FormValue("carrier"),
+		Service: c.FormValue("service"),
+		Active:  true,
+	}
+	problems := products.ValidationError{}
+
+	price, err := money.Parse(c.FormValue("price"), database.Currency())
+	if err != nil {
+		problems["price"] = priceError(database.Currency())
+	}
+	in.Price = price
+
+	amounts := map[string]**money.Money{"per_kg": &in.PerKg, "free_above": &in.FreeAbove}
+	for name, target := range amounts {
+		if value := c.FormValue(name); value != "" {
+			amount, err := money.Parse(value, database.Currency())
+			if err != nil {
+				problems[name] = priceError(database.Currency())
+			}
+			*target = &amount
+		}
+	}
+
+	if value := c.FormValue("max_weight"); value != "" {
+		weight, err := strconv.Atoi(value)
+		if err != nil {
+			problems["max_weight"] = "must be a whole number of grams"
+		}
+		in.MaxWeight = &weight
+	}
+
+	if value := c.FormValue("active"); value != "" {
+		active, err := strconv.ParseBool(value)
+		if err != nil {
+			problems["active"] = "must be true or false"
+		}
+		in.Active = active
+	}
+
+	if len(problems) > 0 {
+		return in, problems
+	}
+	return in, nil
+}
+
+func admin_list_shipping_zones(c echo.Context) error {
+	zones, err := shipping.Zones(c.Request().Context())
+	if err != nil {
+		return shippingError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, zones)
+}
+
+func admin_create_shipping_zone(c echo.Context) error {
+	zone, err := shipping.CreateZone(c.Request().Context(), zoneInput(c))
+	if err != nil {
+		return shippingError(c, err)
+	}
+
+	return c.JSON(http.StatusCreated, zone)
+}
+
+func admin_update_shipping_zone(c echo.Context) error {
+	id, ok := paramID(c, "id")
+	if !ok {
+		return jsonError(c, http.StatusBadRequest, "Invalid zone id")
+	}
+
+	zone, err := shipping.UpdateZone(c.Request().Context(), id, zoneInput(c))
+	if err != nil {
+		return shippingError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, zone)
+}
+
+func admin_delete_shipping_zone(c echo.Context) error {
+	id, ok := paramID(c, "id")
+	if !ok {
+		return jsonError(c, http.StatusBadRequest, "Invalid zone id")
+	}
+
+	if err := shipping.DeleteZone(c.Request().Context(), id); err != nil {
+		return shippingError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, echo.Map{
+		"status":  "success",
+		"code":    200,
+		"message": "Shipping zone deleted",
+	})
+}
+
+func admin_create_shipping_method(c echo.Context) error {
+	zoneID, ok := paramID(c, "id")
+	if !ok {
+		return jsonError(c, http.StatusBadRequest, "Invalid zone id")
+	}
+	in, err := methodInput(c)
+	if err != nil {
+		return shippingError(c, err)
+	}
+
+	method, err := shipping.CreateMethod(c.Request().Context(), zoneID, in)
+	if err != nil {
+		return shippingError(c, err)
+	}
+
+	return c.JSON(http.StatusCreated, method)
+}
+
+func admin_update_shipping_method(c echo.Context) error {
+	id, ok := paramID(c, "id")
+	if !ok {
+		return jsonError(c, http.StatusBadRequest, "Invalid method id")
+	}
+	in, err := methodInput(c)
+	if err != nil {
+		return shippingError(c, err)
+	}
+
+	method, err := shipping.UpdateMethod(c.Request().Context(), id, in)
+	if err != nil {
+		return shippingError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, method)
+}
+
+func admin_delete_shipping_method(c echo.Context) error {
+	id, ok := paramID(c, "id")
+	if !ok {
+		return jsonError(c, http.StatusBadRequest, "Invalid method id")
+	}
+
+	if err := shipping.DeleteMethod(c.Request().Context(), id); err != nil {
+		return shippingError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, echo.Map{
+		"status":  "success",
+		"code":    200,
+		"message": "Shipping method deleted",
+	})
+}
+
+// admin_ship_order books the parcel of a paid order with its carrier and
+// marks the order shipped.
+func admin_ship_order(c echo.Context) error {
+	id, ok := paramID(c, "id")
+	if !ok {
+		return jsonError(c, http.StatusBadRequest, "Invalid order id")
+	}
+
+	order, err := orders.Ship(c.Request().Context(), id, currentUser(c))
+	switch {
+	case errors.Is(err, shipping.ErrUnknownCarrier):
+		return jsonError(c, http.StatusConflict, "The order has no carrier that can ship it")
+	case errors.Is(err, database.ErrDuplicate):
+		return jsonError(c, http.StatusConflict, "The carrier returned a tracking number already in use, try again")
+	case err != nil:
+		return orderError(c, err)
+	}
+
+	return c.JSON(http.StatusCreated, order)
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+
+	"basicthreads/internal/database"
+	"basicthreads/internal/products"
+	"basicthreads/internal/wishlists"
+)
+
+func wishlistError(c echo.Context, err error) error {
+	switch {
+	case errors.Is(err, wishlists.ErrNotFound):
+		return jsonError(c, http.StatusNotFound, "Wishlist not found")
+	case errors.Is(err, wishlists.ErrProductNotFound):
+		return jsonError(c, http.StatusNotFound, "Product not found")
+	case errors.Is(err, wishlists.ErrDuplicateName), errors.Is(err, wishlists.ErrTooMany), errors.Is(err, wishlists.ErrFull):
+		return jsonError(c, http.StatusConflict, err.Error())
+	}
+	return productError(c, err)
+}
+
+// boolValue reads an optional true/false form value.
+func boolValue(c echo.Context, name string) (bool, error) {
+	value := c.FormValue(name)
+	if value == "" {
+		return false, nil
+	}
+	flag, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, products.ValidationError{name: "must be true or false"}
+	}
+	return flag, nil
+}
+
+// wishlistInput reads a wishlist from the form. shared=true gives it a
+// share link.
+func wishlistInput(c echo.Context) (wishlists.Input, error) {
+	shared, err := boolValue(c, "shared")
+	return wishlists.Input{Name: c.FormValue("name"), Shared: shared}, err
+}
+
+func list_wishlists(c echo.Context) error {
+	customerID, err := database.GetCustomerID(c.Request().Context(), currentUser(c))
+	if err != nil {
+		return wishlistError(c, err)
+	}
+
+	list, err := wishlists.List(c.Request().Context(), customerID)
+	if err != nil {
+		return wishlistError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, list)
+}
+
+func get_wishlist(c echo.Context) error {
+	customerID, err := database.GetCustomerID(c.Request().Context(), currentUser(c))
+	if err != nil {
+		return wishlistError(c, err)
+	}
+	id, ok := paramID(c, "id")
+	if !ok {
+		return jsonError(c, http.StatusBadRequest, "Invalid wishlist id")
+	}
+
+	wishlist, err := wishlists.Get(c.Request().Context(), customerID, id)
+	if err != nil {
+		return wishlistError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, wishlist)
+}
+
+// get_shared_wishlist shows a wishlist to anyone holding its share token.
+func get_shared_wishlist(c echo.Context) error {
+	wishlist, err := wishlists.Shared(c.Request().Context(), c.Param("token"))
+	if err != nil {
+		return wishlistError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, wishlist)
+}
+
+func create_wishlist(c echo.Context) error {
+	customerID, err := database.GetCustomerID(c.Request().Context(), currentUser(c))
+	if err != nil {
+		return wishlistError(c, err)
+	}
+	in, err := wishlistInput(c)
+	if err != nil {
+		return wishlistError(c, err)
+	}
+
+	wishlist, err := wishlists.Create(c.Request().Context(), customerID, in)
+	if err != nil {
+		return wishlistError(c, err)
+	}
+
+	return c.JSON(http.StatusCreated, wishlist)
+}
+
+func update_wishlist(c echo.Context) error {
+	customerID, err := database.GetCustomerID(c.Request().Context(), currentUser(c))
+	if err != nil {
+		return wishlistError(c, err)
+	}
+	id, ok := paramID(c, "id")
+	if !ok {
+		return jsonError(c, http.StatusBadRequest, "Invalid wishlist id")
+	}
+	in, err := wishlistInput(c)
+	if err != nil {
+		return wishlistError(c, err)
+	}
+
+	wishlist, err := wishlists.Update(c.Request().Context(), customerID, id, in)
+	if err != nil {
+		return wishlistError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, wishlist)
+}
+
+func delete_wishlist(c echo.Context) error {
+	customerID, err := database.GetCustomerID(c.Request().Context(), currentUser(c))
+	if err != nil {
+		return wishlistError(c, err)
+	}
+	id, ok := paramID(c, "id")
+	if !ok {
+		return jsonError(c, http.StatusBadRequest, "Invalid wishlist id")
+	}
+
+	if err := wishlists.Delete(c.Request().Context(), customerID, id); err != nil {
+		return wishlistError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, echo.Map{
+		"status":  "success",
+		"code":    200,
+		"message": "Wishlist deleted",
+	})
+}
+
+// add_wishlist_item puts a product, and optionally one of its variants, on
+// a wishlist. notify=true asks for an email when the variant is restocked.
+func add_wishlist_item(c echo.Context) error {
+	customerID, err := database.GetCustomerID(c.Request().Context(), currentUser(c))
+	if err != nil {
+		return wishlistError(c, err)
+	}
+	id, ok := paramID(c, "id")
+	if !ok {
+		return jsonError(c, http.StatusBadRequest, "Invalid wishlist id")
+	}
+	productID, err := strconv.Atoi(c.FormValue("product"))
+	if err != nil {
+		return wishlistError(c, products.ValidationError{"product": "must be a product id"})
+	}
+	var variantID *int
+	if value := c.FormValue("variant"); value != "" {
+		id, err := strconv.Atoi(value)
+		if err != nil {
+			return wishlistError(c, products.ValidationError{"variant": "must be a variant id"})
+		}
+		variantID = &id
+	}
+	notify, err := boolValue(c, "notify")
+	if err != nil {
+		return wishlistError(c, err)
+	}
+
+	wishlist, err := wishlists.AddItem(c.Request().Context(), customerID, id, productID, variantID, notify)
+	if err != nil {
+		return wishlistError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, wishlist)
+}
+
+func remove_wishlist_item(c echo.Context) error {
+	customerID, err := database.GetCustomerID(c.Request().Context(), currentUser(c))
+	if err != nil {
+		return wishlistError(c, err)
+	}
+	id, ok := paramID(c, "id")
+	if !ok {
+		return jsonError(c, http.StatusBadRequest, "Invalid wishlist id")
+	}
+	itemID, ok := paramID(c, "item")
+	if !ok {
+		return jsonError(c, http.StatusBadRequest, "Invalid wishlist item id")
+	}
+
+	wishlist, err := wishlists.RemoveItem(c.Request().Context(), customerID, id, itemID)
+	if err != nil {
+		return wishlistError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, wishlist)
+}
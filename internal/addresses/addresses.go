@@ -0,0 +1,206 @@
+package addresses
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"basicthreads/internal/database"
+	"basicthreads/internal/products"
+	"basicthreads/internal/tax"
+)
+
+// Kinds of addresses in an address book.
+const (
+	KindShipping = "shipping"
+	KindBilling  = "billing"
+)
+
+// MaxAddresses caps the address book of a customer.
+const MaxAddresses = 20
+
+var (
+	ErrNotFound = database.ErrNotFound
+	ErrTooMany  = fmt.Errorf("an address book holds at most %d addresses", MaxAddresses)
+
+	countryPattern = regexp.MustCompile(`^[A-Z]{2}$`)
+)
+
+// postalCodes has the format of postal codes in the countries that use
+// them for every address. Codes of other countries are optional and only
+// checked for length.
+var postalCodes = map[string]*regexp.Regexp{
+	"AR": regexp.MustCompile(`^([A-Z]\d{4}[A-Z]{3}|\d{4})$`),
+	"AU": regexp.MustCompile(`^\d{4}$`),
+	"BR": regexp.MustCompile(`^\d{5}-?\d{3}$`),
+	"CA": regexp.MustCompile(`^[A-Z]\d[A-Z] ?\d[A-Z]\d$`),
+	"CO": regexp.MustCompile(`^\d{6}$`),
+	"CR": regexp.MustCompile(`^\d{5}$`),
+	"DE": regexp.MustCompile(`^\d{5}$`),
+	"ES": regexp.MustCompile(`^\d{5}$`),
+	"FR": regexp.MustCompile(`^\d{5}$`),
+	"GB": regexp.MustCompile(`^[A-Z]{1,2}\d[A-Z\d]? ?\d[A-Z]{2}$`),
+	"GT": regexp.MustCompile(`^\d{5}$`),
+	"IT": regexp.MustCompile(`^\d{5}$`),
+	"JP": regexp.MustCompile(`^\d{3}-?\d{4}$`),
+	"MX": regexp.MustCompile(`^\d{5}$`),
+	"NL": regexp.MustCompile(`^\d{4} ?[A-Z]{2}$`),
+	"PT": regexp.MustCompile(`^\d{4}-\d{3}$`),
+	"SV": regexp.MustCompile(`^(CP )?\d{4}$`),
+	"US": regexp.MustCompile(`^\d{5}(-\d{4})?$`),
+}
+
+// Normalize tidies an address the way it is stored.
+func Normalize(address database.Address) database.Address {
+	address.Name = strings.TrimSpace(address.Name)
+	address.Line1 = strings.TrimSpace(address.Line1)
+	address.Line2 = strings.TrimSpace(address.Line2)
+	address.City = strings.TrimSpace(address.City)
+	address.Region = tax.NormalizeRegion(address.Region)
+	address.PostalCode = strings.Join(strings.Fields(strings.ToUpper(address.PostalCode)), " ")
+	address.Country = strings.ToUpper(strings.TrimSpace(address.Country))
+	address.Phone = strings.TrimSpace(address.Phone)
+	return address
+}
+
+// Validate checks a normalized address, including the postal code against
+// the format of its country.
+func Validate(address database.Address) error {
+	problems := products.ValidationError{}
+	required := map[string]string{"name": address.Name, "line1": address.Line1, "city": address.City}
+	for field, value := range required {
+		if value == "" {
+			problems[field] = "is required"
+		}
+	}
+	limits := map[string]struct {
+		value string
+		max   int
+	}{
+		"name":        {address.Name, 255},
+		"line1":       {address.Line1, 255},
+		"line2":       {address.Line2, 255},
+		"city":        {address.City, 128},
+		"region":      {address.Region, 64},
+		"postal_code": {address.PostalCode, 16},
+		"phone":       {address.Phone, 32},
+	}
+	for field, limit := range limits {
+		if len(limit.value) > limit.max {
+			problems[field] = fmt.Sprintf("must be at most %d characters", limit.max)
+		}
+	}
+	if !countryPattern.MatchString(address.Country) {
+		problems["country"] = "must be a two letter ISO country code"
+	} else if pattern, ok := postalCodes[address.Country]; ok && problems["postal_code"] == "" {
+		if address.PostalCode == "" {
+			problems["postal_code"] = "is required for " + address.Country
+		} else if !pattern.MatchString(address.PostalCode) {
+			problems["postal_code"] = "is not a valid postal code for " + address.Country
+		}
+	}
+
+	if len(problems) > 0 {
+		return problems
+	}
+	return nil
+}
+
+// Input is an address for the address book. Kind cannot change once the
+// address is saved.
+type Input struct {
+	Kind    string
+	Label   string
+	Address database.Address
+	Default bool
+}
+
+func (in *Input) normalize() {
+	in.Kind = strings.ToLower(strings.TrimSpace(in.Kind))
+	if in.Kind == "" {
+		in.Kind = KindShipping
+	}
+	in.Label = strings.TrimSpace(in.Label)
+	in.Address = Normalize(in.Address)
+}
+
+func (in Input) validate() error {
+	problems := products.ValidationError{}
+	var invalid products.ValidationError
+	if err := Validate(in.Address); errors.As(err, &invalid) {
+		problems = invalid
+	}
+	if in.Kind != KindShipping && in.Kind != KindBilling {
+		problems["kind"] = "must be shipping or billing"
+	}
+	if len(in.Label) > 64 {
+		problems["label"] = "must be at most 64 characters"
+	}
+
+	if len(problems) > 0 {
+		return problems
+	}
+	return nil
+}
+
+func List(ctx context.Context, customerID int) ([]database.CustomerAddress, error) {
+	return database.GetCustomerAddresses(ctx, customerID)
+}
+
+func Get(ctx context.Context, customerID, id int) (database.CustomerAddress, error) {
+	return database.GetCustomerAddress(ctx, customerID, id)
+}
+
+// Default returns the default address of a kind, or ErrNotFound when the
+// customer has none.
+func Default(ctx context.Context, customerID int, kind string) (database.CustomerAddress, error) {
+	return database.GetDefaultAddress(ctx, customerID, kind)
+}
+
+func Create(ctx context.Context, customerID int, in Input) (database.CustomerAddress, error) {
+	in.normalize()
+	if err := in.validate(); err != nil {
+		return database.CustomerAddress{}, err
+	}
+	count, err := database.CountCustomerAddresses(ctx, customerID)
+	if err != nil {
+		return database.CustomerAddress{}, err
+	}
+	if count >= MaxAddresses {
+		return database.CustomerAddress{}, ErrTooMany
+	}
+
+	return database.CreateCustomerAddress(ctx, database.CustomerAddress{
+		CustomerID: customerID,
+		Kind:       in.Kind,
+		Label:      in.Label,
+		Address:    in.Address,
+		Default:    in.Default,
+	})
+}
+
+func Update(ctx context.Context, customerID, id int, in Input) (database.CustomerAddress, error) {
+	current, err := database.GetCustomerAddress(ctx, customerID, id)
+	if err != nil {
+		return current, err
+	}
+	in.Kind = current.Kind
+	in.normalize()
+	if err := in.validate(); err != nil {
+		return current, err
+	}
+
+	return database.UpdateCustomerAddress(ctx, database.CustomerAddress{
+		ID:         id,
+		CustomerID: customerID,
+		Label:      in.Label,
+		Address:    in.Address,
+		Default:    in.Default,
+	})
+}
+
+func Delete(ctx context.Context, customerID, id int) error {
+	return database.DeleteCustomerAddress(ctx, customerID, id)
+}
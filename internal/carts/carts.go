@@ -0,0 +1,391 @@
+package carts
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"strings"
+
+	"basicthreads/internal/config"
+	"basicthreads/internal/database"
+	"basicthreads/internal/money"
+	"basicthreads/internal/products"
+	"basicthreads/internal/promotions"
+)
+
+// MaxQuantity caps the units of a single line.
+const MaxQuantity = 99
+
+var (
+	ErrNotFound        = errors.New("cart item not found")
+	ErrProductNotFound = errors.New("product not found")
+	ErrNotEnoughStock  = errors.New("not enough stock")
+)
+
+// Owner says whose cart a request works on: a logged in customer, or a
+// guest holding a signed cart token. A guest without a token gets one on
+// the first write.
+type Owner struct {
+	CustomerID int
+	Token      string
+}
+
+// Line is a cart item priced with the current catalog.
+type Line struct {
+	ID        int
+	ProductID int
+	VariantID *int `json:",omitempty"`
+	Name      string
+	SKU       string `json:",omitempty"`
+	Size      string `json:",omitempty"`
+	Color     string `json:",omitempty"`
+	Image     string
+	TaxClass  string
+	// Weight is the weight of one unit, in grams.
+	Weight    int
+	UnitPrice money.Money
+	Quantity  int
+	LineTotal money.Money
+	// Discount is the share of the cart discounts taken off this line.
+	Discount money.Money
+	// Available is false when the line cannot be bought as it is: the
+	// product left the catalog or there is not enough stock.
+	Available bool
+}
+
+type Cart struct {
+	// Token is the signed guest token, only set for guest carts.
+	Token     string `json:",omitempty"`
+	Items     []Line
+	ItemCount int
+	// Weight is what the lines that can be bought weigh, in grams.
+	Weight   int
+	Subtotal money.Money
+	// Code is the discount code entered on the cart, which may not apply
+	// until the cart meets its conditions.
+	Code      string `json:",omitempty"`
+	Discounts []promotions.Applied
+	Discount  money.Money
+	Total     money.Money
+	// DisplaySubtotal is the subtotal converted to the currency the client
+	// asked to see, for information only.
+	DisplaySubtotal *money.Money `json:",omitempty"`
+}
+
+// NewToken returns a signed token for a new guest cart.
+func NewToken() (string, error) {
+	raw := make([]byte, 16)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	id := hex.EncodeToString(raw)
+	return id + "." + sign(id), nil
+}
+
+// verify checks the signature of a guest token and returns the cart id it
+// carries.
+func verify(token string) (string, bool) {
+	id, signature, ok := strings.Cut(token, ".")
+	if !ok || len(id) != 32 {
+		return "", false
+	}
+	return id, hmac.Equal([]byte(signature), []byte(sign(id)))
+}
+
+func sign(id string) string {
+	mac := hmac.New(sha256.New, []byte(config.Load().JWTSecret))
+	mac.Write([]byte("cart:" + id))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// find returns the cart id of owner, or 0 when there is no cart yet.
+func find(ctx context.Context, owner Owner) (int, error) {
+	token := ""
+	if owner.CustomerID == 0 {
+		id, ok := verify(owner.Token)
+		if !ok {
+			return 0, nil
+		}
+		token = id
+	}
+
+	id, err := database.FindCart(ctx, owner.CustomerID, token)
+	if errors.Is(err, database.ErrNotFound) {
+		return 0, nil
+	}
+	return id, err
+}
+
+// open returns the cart id of owner, creating the cart and, for guests
+// without a valid token, a new token.
+func open(ctx context.Context, owner *Owner) (int, error) {
+	token := ""
+	if owner.CustomerID == 0 {
+		id, ok := verify(owner.Token)
+		if !ok {
+			signed, err := NewToken()
+			if err != nil {
+				return 0, err
+			}
+			owner.Token = signed
+			id, _ = verify(signed)
+		}
+		token = id
+	}
+	return database.OpenCart(ctx, owner.CustomerID, token)
+}
+
+// Get returns the cart of owner with every price read again from the
+// catalog and the promotions worked out again. Owners without a cart get an
+// empty one.
+func Get(ctx context.Context, owner Owner) (Cart, error) {
+	currency := database.Currency()
+	cart := Cart{
+		Items:     []Line{},
+		Subtotal:  money.Zero(currency),
+		Discounts: []promotions.Applied{},
+		Discount:  money.Zero(currency),
+		Total:     money.Zero(currency),
+	}
+	if owner.CustomerID == 0 {
+		if _, ok := verify(owner.Token); ok {
+			cart.Token = owner.Token
+		}
+	}
+
+	id, err := find(ctx, owner)
+	if err != nil || id == 0 {
+		return cart, err
+	}
+
+	lines, err := database.GetCartLines(ctx, id)
+	if err != nil {
+		return cart, err
+	}
+	if cart.Code, err = database.GetCartCode(ctx, id); err != nil {
+		return cart, err
+	}
+
+	for _, row := range lines {
+		line := Line{
+			ID:        row.ID,
+			ProductID: row.ProductID,
+			VariantID: row.VariantID,
+			Name:      row.Name,
+			SKU:       row.SKU,
+			Size:      row.Size,
+			Color:     row.Color,
+			Image:     row.Image,
+			TaxClass:  row.TaxClass,
+			Weight:    row.Weight,
+			UnitPrice: row.ProductPrice,
+			Quantity:  row.Quantity,
+			Discount:  money.Zero(currency),
+			Available: row.Live && (row.InStock == nil || *row.InStock >= row.Quantity),
+		}
+		if row.VariantPrice != nil {
+			line.UnitPrice = *row.VariantPrice
+		}
+		line.LineTotal = line.UnitPrice.Times(line.Quantity)
+
+		cart.Items = append(cart.Items, line)
+		if line.Available {
+			cart.ItemCount += line.Quantity
+			cart.Weight += line.Weight * line.Quantity
+			cart.Subtotal = cart.Subtotal.Add(line.LineTotal)
+		}
+	}
+
+	return cart, discount(ctx, owner, &cart)
+}
+
+// discount applies the promotions to the lines that can be bought.
+func discount(ctx context.Context, owner Owner, cart *Cart) error {
+	var lines []promotions.Line
+	var indexes []int
+	for i, line := range cart.Items {
+		if line.Available {
+			lines = append(lines, promotions.Line{ProductID: line.ProductID, UnitPrice: line.UnitPrice, Quantity: line.Quantity})
+			indexes = append(indexes, i)
+		}
+	}
+
+	result, err := promotions.Price(ctx, owner.CustomerID, cart.Code, cart.Subtotal, lines)
+	if err != nil {
+		return err
+	}
+	for n, i := range indexes {
+		cart.Items[i].Discount = result.Lines[n]
+	}
+	cart.Discounts = result.Applied
+	cart.Discount = result.Discount
+	cart.Total = cart.Subtotal.Sub(result.Discount)
+	return nil
+}
+
+// Add puts quantity units of a product, or of one of its variants, in the
+// cart of owner. Products with variants can only be added by variant.
+func Add(ctx context.Context, owner Owner, productID int, variantID *int, quantity int) (Cart, error) {
+	if quantity < 1 || quantity > MaxQuantity {
+		return Cart{}, products.ValidationError{"quantity": "must be between 1 and 99"}
+	}
+
+	product, err := database.GetAdminProduct(ctx, productID)
+	if errors.Is(err, database.ErrNotFound) || (err == nil && (product.DeletedAt != nil || !product.Available)) {
+		return Cart{}, ErrProductNotFound
+	}
+	if err != nil {
+		return Cart{}, err
+	}
+
+	variants, err := database.GetProductVariants(ctx, productID)
+	if err != nil {
+		return Cart{}, err
+	}
+	if variantID == nil && len(variants) > 0 {
+		return Cart{}, products.ValidationError{"variant": "is required for this product"}
+	}
+	if variantID != nil {
+		var found *database.Variant
+		for i := range variants {
+			if variants[i].ID == *variantID {
+				found = &variants[i]
+			}
+		}
+		if found == nil {
+			return Cart{}, products.ValidationError{"variant": "does not belong to this product"}
+		}
+		if found.Stock-found.Reserved < quantity {
+			return Cart{}, ErrNotEnoughStock
+		}
+	}
+
+	id, err := open(ctx, &owner)
+	if err != nil {
+		return Cart{}, err
+	}
+	if err := database.AddCartItem(ctx, id, productID, variantID, quantity, MaxQuantity); err != nil {
+		return Cart{}, err
+	}
+	return Get(ctx, owner)
+}
+
+// Update sets the quantity of a line. A quantity of 0 removes it.
+func Update(ctx context.Context, owner Owner, itemID, quantity int) (Cart, error) {
+	if quantity < 0 || quantity > MaxQuantity {
+		return Cart{}, products.ValidationError{"quantity": "must be between 0 and 99"}
+	}
+
+	cart, err := Get(ctx, owner)
+	if err != nil {
+		return cart, err
+	}
+	line, ok := findLine(cart, itemID)
+	if !ok {
+		return cart, ErrNotFound
+	}
+	if quantity == 0 {
+		return Remove(ctx, owner, itemID)
+	}
+
+	if line.VariantID != nil && quantity > line.Quantity {
+		variant, err := database.GetVariant(ctx, *line.VariantID)
+		if err != nil {
+			return cart, err
+		}
+		if variant.Stock-variant.Reserved < quantity {
+			return cart, ErrNotEnoughStock
+		}
+	}
+
+	id, err := find(ctx, owner)
+	if err != nil {
+		return cart, err
+	}
+	if err := database.SetCartItemQuantity(ctx, id, itemID, quantity); err != nil {
+		return cart, err
+	}
+	return Get(ctx, owner)
+}
+
+func Remove(ctx context.Context, owner Owner, itemID int) (Cart, error) {
+	id, err := find(ctx, owner)
+	if err != nil {
+		return Cart{}, err
+	}
+	if id == 0 {
+		return Cart{}, ErrNotFound
+	}
+
+	cart, err := Get(ctx, owner)
+	if err != nil {
+		return cart, err
+	}
+	if _, ok := findLine(cart, itemID); !ok {
+		return cart, ErrNotFound
+	}
+
+	if err := database.DeleteCartItem(ctx, id, itemID); err != nil {
+		return cart, err
+	}
+	return Get(ctx, owner)
+}
+
+// ApplyCode enters a discount code on the cart of owner, replacing any
+// code entered before.
+func ApplyCode(ctx context.Context, owner Owner, code string) (Cart, error) {
+	promotion, err := promotions.Check(ctx, owner.CustomerID, code)
+	if err != nil {
+		return Cart{}, err
+	}
+
+	id, err := open(ctx, &owner)
+	if err != nil {
+		return Cart{}, err
+	}
+	if err := database.SetCartCode(ctx, id, promotion.Code); err != nil {
+		return Cart{}, err
+	}
+	return Get(ctx, owner)
+}
+
+func RemoveCode(ctx context.Context, owner Owner) (Cart, error) {
+	id, err := find(ctx, owner)
+	if err != nil {
+		return Cart{}, err
+	}
+	if id != 0 {
+		if err := database.SetCartCode(ctx, id, ""); err != nil {
+			return Cart{}, err
+		}
+	}
+	return Get(ctx, owner)
+}
+
+func findLine(cart Cart, itemID int) (Line, bool) {
+	for _, line := range cart.Items {
+		if line.ID == itemID {
+			return line, true
+		}
+	}
+	return Line{}, false
+}
+
+// Merge moves the guest cart of token into the cart of a customer who just
+// logged in. Invalid tokens and empty guest carts are ignored.
+func Merge(ctx context.Context, token string, customerID int) error {
+	guest, err := find(ctx, Owner{Token: token})
+	if err != nil || guest == 0 {
+		return err
+	}
+
+	customer, err := database.OpenCart(ctx, customerID, "")
+	if err != nil {
+		return err
+	}
+	return database.MergeCarts(ctx, guest, customer, MaxQuantity)
+}
@@ -0,0 +1,103 @@
+package carts
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"basicthreads/internal/dbtest"
+)
+
+func TestToken(t *testing.T) {
+	t.Setenv("JWT_SECRET", "cart-test-secret")
+
+	token, err := NewToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, ok := verify(token)
+	if !ok || len(id) != 32 || !strings.HasPrefix(token, id+".") {
+		t.Fatalf("verify(%q) = %q, %v, want the id before the dot", token, id, ok)
+	}
+
+	other, err := NewToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other == token {
+		t.Fatal("NewToken returned the same token twice")
+	}
+	otherID, _, _ := strings.Cut(other, ".")
+	_, signature, _ := strings.Cut(token, ".")
+
+	tests := []struct {
+		name  string
+		token string
+	}{
+		{"empty", ""},
+		{"no signature", id},
+		{"empty signature", id + "."},
+		{"signature of another cart", otherID + "." + signature},
+		{"short id", id[:31] + "." + sign(id[:31])},
+		{"tampered signature", id + "." + strings.ToUpper(signature)},
+	}
+	for _, test := range tests {
+		if _, ok := verify(test.token); ok {
+			t.Errorf("%s: verify(%q) accepted the token", test.name, test.token)
+		}
+	}
+
+	t.Setenv("JWT_SECRET", "another-secret")
+	if _, ok := verify(token); ok {
+		t.Error("verify accepted a token signed with another secret")
+	}
+}
+
+func TestMerge(t *testing.T) {
+	ctx := context.Background()
+	db := dbtest.Open(t)
+	customerID := dbtest.Customer(t, db, "shopper@example.com", "secret")
+	teeID, teeVariant := dbtest.Variant(t, db, "TEE-M", "10.00", 200)
+	capID, capVariant := dbtest.Variant(t, db, "CAP", "5.00", 10)
+
+	guest, err := Add(ctx, Owner{}, teeID, &teeVariant, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if guest.Token == "" {
+		t.Fatal("a guest cart got no token")
+	}
+	if _, err := Add(ctx, Owner{Token: guest.Token}, capID, &capVariant, 2); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Add(ctx, Owner{CustomerID: customerID}, teeID, &teeVariant, 97); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := Merge(ctx, "not-a-token", customerID); err != nil {
+		t.Fatalf("Merge with an invalid token: %v", err)
+	}
+	if err := Merge(ctx, guest.Token, customerID); err != nil {
+		t.Fatal(err)
+	}
+
+	cart, err := Get(ctx, Owner{CustomerID: customerID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	quantities := map[int]int{}
+	for _, line := range cart.Items {
+		quantities[*line.VariantID] += line.Quantity
+	}
+	if len(cart.Items) != 2 || quantities[teeVariant] != MaxQuantity || quantities[capVariant] != 2 {
+		t.Errorf("merged quantities = %v, want %d of %d and 2 of %d", quantities, MaxQuantity, teeVariant, capVariant)
+	}
+
+	left, err := Get(ctx, Owner{Token: guest.Token})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(left.Items) != 0 {
+		t.Errorf("guest cart still has %d lines after the merge", len(left.Items))
+	}
+}
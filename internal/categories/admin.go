@@ -0,0 +1,153 @@
+package categories
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"basicthreads/internal/database"
+)
+
+var (
+	ErrNotFound      = errors.New("category not found")
+	ErrParentMissing = errors.New("parent category not found")
+	ErrNameRequired  = errors.New("category name is required")
+	ErrCycle         = errors.New("a category cannot be moved under itself or one of its descendants")
+	ErrHasChildren   = errors.New("category has subcategories")
+	ErrHasProducts   = errors.New("category has products assigned")
+)
+
+func Create(ctx context.Context, name string, parentID int) (int, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return 0, ErrNameRequired
+	}
+
+	if parentID != 0 {
+		all, err := database.GetAllCategories(ctx)
+		if err != nil {
+			return 0, err
+		}
+		if _, ok := find(all, parentID); !ok {
+			return 0, ErrParentMissing
+		}
+	}
+
+	return database.CreateCategory(ctx, name, parentID)
+}
+
+func Rename(ctx context.Context, id int, name string) error {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return ErrNameRequired
+	}
+
+	all, err := database.GetAllCategories(ctx)
+	if err != nil {
+		return err
+	}
+	if _, ok := find(all, id); !ok {
+		return ErrNotFound
+	}
+
+	return database.RenameCategory(ctx, id, name)
+}
+
+// Move attaches a category to a new parent, or makes it a root category when
+// parentID is 0. The category keeps its own subtree.
+func Move(ctx context.Context, id, parentID int) error {
+	all, err := database.GetAllCategories(ctx)
+	if err != nil {
+		return err
+	}
+	if _, ok := find(all, id); !ok {
+		return ErrNotFound
+	}
+
+	if parentID != 0 {
+		if _, ok := find(all, parentID); !ok {
+			return ErrParentMissing
+		}
+		// The new parent must not have the category anywhere on its path to
+		// the root, otherwise the move would close a loop.
+		for _, ancestor := range Path(all, parentID) {
+			if ancestor.ID == id {
+				return ErrCycle
+			}
+		}
+	}
+
+	return database.MoveCategory(ctx, id, parentID)
+}
+
+// SetPosition moves a category to the given zero-based position among its
+// siblings. Positions past the end put it last.
+func SetPosition(ctx context.Context, id, position int) error {
+	all, err := database.GetAllCategories(ctx)
+	if err != nil {
+		return err
+	}
+	category, ok := find(all, id)
+	if !ok {
+		return ErrNotFound
+	}
+
+	siblings := []int{}
+	for _, sibling := range all {
+		if sibling.ParentID == category.ParentID && sibling.ID != id {
+			siblings = append(siblings, sibling.ID)
+		}
+	}
+
+	if position < 0 {
+		position = 0
+	}
+	if position > len(siblings) {
+		position = len(siblings)
+	}
+
+	ordered := make([]int, 0, len(siblings)+1)
+	ordered = append(ordered, siblings[:position]...)
+	ordered = append(ordered, id)
+	ordered = append(ordered, siblings[position:]...)
+
+	return database.SetCategoryOrder(ctx, ordered)
+}
+
+// Delete removes an empty category. Categories that still have subcategories
+// or products are kept so nothing in the catalog disappears by accident;
+// those have to be moved or unassigned first.
+func Delete(ctx context.Context, id int) error {
+	all, err := database.GetAllCategories(ctx)
+	if err != nil {
+		return err
+	}
+	if _, ok := find(all, id); !ok {
+		return ErrNotFound
+	}
+
+	for _, category := range all {
+		if category.ParentID == id {
+			return ErrHasChildren
+		}
+	}
+
+	products, err := database.CountCategoryProducts(ctx, id)
+	if err != nil {
+		return err
+	}
+	if products > 0 {
+		return ErrHasProducts
+	}
+
+	return database.DeleteCategory(ctx, id)
+}
+
+func find(all []database.Category, id int) (database.Category, bool) {
+	for _, category := range all {
+		if category.ID == id {
+			return category, true
+		}
+	}
+	return database.Category{}, false
+}
@@ -0,0 +1,114 @@
+package categories
+
+import (
+	"context"
+
+	"basicthreads/internal/database"
+)
+
+type Category struct {
+	ID            int
+	Name          string
+	ParentID      int
+	Subcategories []Category
+}
+
+// Tree loads every category with a single query and nests them under their
+// parents. A positive maxDepth keeps only that many levels, so 1 returns the
+// root categories alone; zero or less returns the whole tree.
+func Tree(ctx context.Context, maxDepth int) ([]Category, error) {
+	all, err := database.GetAllCategories(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return BuildTree(all, maxDepth), nil
+}
+
+// BuildTree nests a flat list of categories. Categories whose parent is not
+// in the list are dropped along with their descendants.
+func BuildTree(all []database.Category, maxDepth int) []Category {
+	children := map[int][]database.Category{}
+	for _, category := range all {
+		children[category.ParentID] = append(children[category.ParentID], category)
+	}
+
+	var build func(parentID, depth int) []Category
+	build = func(parentID, depth int) []Category {
+		nodes := make([]Category, 0, len(children[parentID]))
+		for _, category := range children[parentID] {
+			node := Category{
+				ID:       category.ID,
+				Name:     category.Name,
+				ParentID: category.ParentID,
+			}
+			if maxDepth <= 0 || depth < maxDepth {
+				if subcategories := build(category.ID, depth+1); len(subcategories) > 0 {
+					node.Subcategories = subcategories
+				}
+			}
+			nodes = append(nodes, node)
+		}
+		return nodes
+	}
+
+	return build(0, 1)
+}
+
+// Breadcrumbs returns the path from the root category down to id, both
+// included. It returns nil when the category does not exist.
+func Breadcrumbs(ctx context.Context, id int) ([]database.Category, error) {
+	all, err := database.GetAllCategories(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return Path(all, id), nil
+}
+
+// Path walks up from id to the root of a flat category list. A corrupt
+// parent chain that loops back on itself stops the walk.
+func Path(all []database.Category, id int) []database.Category {
+	byID := make(map[int]database.Category, len(all))
+	for _, category := range all {
+		byID[category.ID] = category
+	}
+
+	var path []database.Category
+	seen := map[int]bool{}
+	for current, ok := byID[id]; ok && !seen[current.ID]; current, ok = byID[current.ParentID] {
+		seen[current.ID] = true
+		path = append(path, current)
+	}
+
+	for i, j := 0, len(path)-1; i < j; i, j = i+1, j-1 {
+		path[i], path[j] = path[j], path[i]
+	}
+	return path
+}
+
+// Descendants returns id followed by the ids of every category below it.
+// It returns nil when the category does not exist.
+func Descendants(all []database.Category, id int) []int {
+	if _, ok := find(all, id); !ok {
+		return nil
+	}
+
+	children := map[int][]int{}
+	for _, category := range all {
+		children[category.ParentID] = append(children[category.ParentID], category.ID)
+	}
+
+	ids := []int{}
+	seen := map[int]bool{}
+	queue := []int{id}
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+		if seen[current] {
+			continue
+		}
+		seen[current] = true
+		ids = append(ids, current)
+		queue = append(queue, children[current]...)
+	}
+	return ids
+}
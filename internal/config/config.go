@@ -0,0 +1,207 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/joho/godotenv"
+)
+
+type Database struct {
+	User     string
+	Password string
+	Host     string
+	Port     string
+	Name     string
+}
+
+type Config struct {
+	Database      Database
+	Address       string
+	JWTSecret     string
+	AllowOrigins  []string
+	MailAPIKey    string
+	NotifyEmail   string
+	TraceExporter string
+	SearchBackend string
+	// LowStockThreshold is how many units a variant can have available
+	// before the notify address gets a low stock alert.
+	LowStockThreshold int
+	// ReservationTTL is how long stock stays held for a checkout.
+	ReservationTTL time.Duration
+	// Currency is the ISO code prices are charged in.
+	Currency string
+	// ExchangeRates is a table like "EUR=0.92,GBP=0.79" of the currencies
+	// prices can be shown in besides Currency.
+	ExchangeRates string
+	// PricesIncludeTax says catalog prices already have tax in them, as is
+	// usual with VAT, rather than tax being added at checkout.
+	PricesIncludeTax bool
+	// TaxRounding is "line" to round the tax of every order line, or
+	// "invoice" to round each tax once over the whole order.
+	TaxRounding          string
+	PaymentURL           string
+	PaymentSecretKey     string
+	PaymentWebhookSecret string
+	// PublicURL is where clients reach the API, for links sent by email.
+	PublicURL string
+	// ExportDir holds the personal data exports waiting to be downloaded.
+	ExportDir string
+	// ExportTTL is how long an export download link works.
+	ExportTTL time.Duration
+	// VerifiedReviewsOnly keeps customers who never bought a product from
+	// reviewing it. When false their reviews are taken but flagged as
+	// unverified.
+	VerifiedReviewsOnly bool
+	// StorageBackend is where uploaded images are kept: "local" for
+	// MediaDir, or "s3" for a bucket of an S3-compatible service.
+	StorageBackend string
+	MediaDir       string
+	// MediaURL is what image URLs start with. It defaults to the /media
+	// route of the API.
+	MediaURL    string
+	S3Endpoint  string
+	S3Region    string
+	S3Bucket    string
+	S3AccessKey string
+	S3SecretKey string
+	// ImageMaxBytes caps the size of an uploaded image.
+	ImageMaxBytes int
+	// MediaCacheDir holds the images /media resized on request, up to
+	// MediaCacheBytes in total.
+	MediaCacheDir   string
+	MediaCacheBytes int
+}
+
+// Load reads the configuration from the environment, after loading a .env
+// file from the working directory when there is one.
+func Load() Config {
+	godotenv.Load()
+
+	cfg := Config{
+		Database: Database{
+			User:     os.Getenv("DBUSER"),
+			Password: os.Getenv("DBPASS"),
+			Host:     getenv("DBHOST", "127.0.0.1"),
+			Port:     getenv("DBPORT", "3306"),
+			Name:     os.Getenv("DBNAME"),
+		},
+		Address:              getenv("ADDRESS", ":1323"),
+		JWTSecret:            getenv("JWT_SECRET", "secret"),
+		AllowOrigins:         strings.Split(getenv("ALLOW_ORIGINS", "http://localhost:3000,http://127.0.0.1:3000"), ","),
+		MailAPIKey:           os.Getenv("BREVO_API_KEY"),
+		NotifyEmail:          getenv("NOTIFY_EMAIL", "mr1937012020@unab.edu.sv"),
+		TraceExporter:        os.Getenv("TRACE_EXPORTER"),
+		SearchBackend:        getenv("SEARCH_BACKEND", "mysql"),
+		LowStockThreshold:    getint("LOW_STOCK_THRESHOLD", 5),
+		ReservationTTL:       getduration("RESERVATION_TTL", 15*time.Minute),
+		Currency:             strings.ToUpper(getenv("CURRENCY", "USD")),
+		ExchangeRates:        os.Getenv("EXCHANGE_RATES"),
+		PricesIncludeTax:     getbool("PRICES_INCLUDE_TAX", false),
+		TaxRounding:          strings.ToLower(getenv("TAX_ROUNDING", "line")),
+		PaymentURL:           getenv("PAYMENT_URL", "https://api.stripe.com"),
+		PaymentSecretKey:     os.Getenv("PAYMENT_SECRET_KEY"),
+		PaymentWebhookSecret: os.Getenv("PAYMENT_WEBHOOK_SECRET"),
+		PublicURL:            strings.TrimSuffix(getenv("PUBLIC_URL", "http://localhost:1323"), "/"),
+		ExportDir:            getenv("EXPORT_DIR", "exports"),
+		ExportTTL:            getduration("EXPORT_TTL", 24*time.Hour),
+		VerifiedReviewsOnly:  getbool("VERIFIED_REVIEWS_ONLY", true),
+		StorageBackend:       strings.ToLower(getenv("STORAGE_BACKEND", "local")),
+		MediaDir:             getenv("MEDIA_DIR", "media"),
+		S3Endpoint:           strings.TrimSuffix(getenv("S3_ENDPOINT", "http://localhost:9000"), "/"),
+		S3Region:             getenv("S3_REGION", "us-east-1"),
+		S3Bucket:             getenv("S3_BUCKET", "basicthreads"),
+		S3AccessKey:          os.Getenv("S3_ACCESS_KEY"),
+		S3SecretKey:          os.Getenv("S3_SECRET_KEY"),
+		ImageMaxBytes:        getint("IMAGE_MAX_BYTES", 10<<20),
+		MediaCacheDir:        getenv("MEDIA_CACHE_DIR", "media-cache"),
+		MediaCacheBytes:      getint("MEDIA_CACHE_BYTES", 256<<20),
+	}
+	cfg.MediaURL = strings.TrimSuffix(getenv("MEDIA_URL", cfg.PublicURL+"/media"), "/")
+	return cfg
+}
+
+func (d Database) DSN() string {
+	return fmt.Sprintf("%s:%s@tcp(%s:%s)/%s", d.User, d.Password, d.Host, d.Port, d.Name)
+}
+
+// Masked returns the configuration as name/value pairs with secrets hidden,
+// in the order they should be printed.
+func (c Config) Masked() [][2]string {
+	return [][2]string{
+		{"DBUSER", c.Database.User},
+		{"DBPASS", mask(c.Database.Password)},
+		{"DBHOST", c.Database.Host},
+		{"DBPORT", c.Database.Port},
+		{"DBNAME", c.Database.Name},
+		{"ADDRESS", c.Address},
+		{"JWT_SECRET", mask(c.JWTSecret)},
+		{"ALLOW_ORIGINS", strings.Join(c.AllowOrigins, ",")},
+		{"BREVO_API_KEY", mask(c.MailAPIKey)},
+		{"NOTIFY_EMAIL", c.NotifyEmail},
+		{"TRACE_EXPORTER", c.TraceExporter},
+		{"SEARCH_BACKEND", c.SearchBackend},
+		{"LOW_STOCK_THRESHOLD", strconv.Itoa(c.LowStockThreshold)},
+		{"RESERVATION_TTL", c.ReservationTTL.String()},
+		{"CURRENCY", c.Currency},
+		{"EXCHANGE_RATES", c.ExchangeRates},
+		{"PRICES_INCLUDE_TAX", strconv.FormatBool(c.PricesIncludeTax)},
+		{"TAX_ROUNDING", c.TaxRounding},
+		{"PAYMENT_URL", c.PaymentURL},
+		{"PAYMENT_SECRET_KEY", mask(c.PaymentSecretKey)},
+		{"PAYMENT_WEBHOOK_SECRET", mask(c.PaymentWebhookSecret)},
+		{"PUBLIC_URL", c.PublicURL},
+		{"EXPORT_DIR", c.ExportDir},
+		{"EXPORT_TTL", c.ExportTTL.String()},
+		{"VERIFIED_REVIEWS_ONLY", strconv.FormatBool(c.VerifiedReviewsOnly)},
+		{"STORAGE_BACKEND", c.StorageBackend},
+		{"MEDIA_DIR", c.MediaDir},
+		{"MEDIA_URL", c.MediaURL},
+		{"S3_ENDPOINT", c.S3Endpoint},
+		{"S3_REGION", c.S3Region},
+		{"S3_BUCKET", c.S3Bucket},
+		{"S3_ACCESS_KEY", c.S3AccessKey},
+		{"S3_SECRET_KEY", mask(c.S3SecretKey)},
+		{"IMAGE_MAX_BYTES", strconv.Itoa(c.ImageMaxBytes)},
+		{"MEDIA_CACHE_DIR", c.MediaCacheDir},
+		{"MEDIA_CACHE_BYTES", strconv.Itoa(c.MediaCacheBytes)},
+	}
+}
+
+func getenv(key, fallback string) string {
+	if value, ok := os.LookupEnv(key); ok && value != "" {
+		return value
+	}
+	return fallback
+}
+
+func getint(key string, fallback int) int {
+	if value, err := strconv.Atoi(os.Getenv(key)); err == nil {
+		return value
+	}
+	return fallback
+}
+
+func getbool(key string, fallback bool) bool {
+	if value, err := strconv.ParseBool(os.Getenv(key)); err == nil {
+		return value
+	}
+	return fallback
+}
+
+func getduration(key string, fallback time.Duration) time.Duration {
+	if value, err := time.ParseDuration(os.Getenv(key)); err == nil && value > 0 {
+		return value
+	}
+	return fallback
+}
+
+func mask(secret string) string {
+	if secret == "" {
+		return ""
+	}
+	return "********"
+}
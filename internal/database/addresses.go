@@ -0,0 +1,274 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// CustomerAddress is an address in the address book of a customer. Each
+// kind has at most one default address, and always one while the customer
+// has any address of that kind.
+type CustomerAddress struct {
+	ID         int
+	CustomerID int `json:"-"`
+	Kind       string
+	Label      string `json:",omitempty"`
+	Address
+	Default   bool
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+const customerAddressColumns = "id, customer_id, kind, label, name, line1, line2, city, region, postal_code, country, phone, is_default, created_at, updated_at"
+
+func scanCustomerAddress(row rowScanner) (CustomerAddress, error) {
+	var address CustomerAddress
+	var createdAt, updatedAt []byte
+	err := row.Scan(
+		&address.ID,
+		&address.CustomerID,
+		&address.Kind,
+		&address.Label,
+		&address.Name,
+		&address.Line1,
+		&address.Line2,
+		&address.City,
+		&address.Region,
+		&address.PostalCode,
+		&address.Country,
+		&address.Phone,
+		&address.Default,
+		&createdAt,
+		&updatedAt,
+	)
+	if err != nil {
+		return address, err
+	}
+	if address.CreatedAt, err = time.Parse(time.DateTime, string(createdAt)); err != nil {
+		return address, err
+	}
+	address.UpdatedAt, err = time.Parse(time.DateTime, string(updatedAt))
+	return address, err
+}
+
+// GetCustomerAddresses lists the address book of a customer, defaults
+// first.
+func GetCustomerAddresses(ctx context.Context, customerID int) ([]CustomerAddress, error) {
+	db := Connect()
+	defer db.Close()
+
+	result, err := queryContext(
+		ctx,
+		db,
+		"GetCustomerAddresses",
+		"SELECT "+customerAddressColumns+" FROM customer_addresses WHERE customer_id = ? ORDER BY kind DESC, is_default DESC, id",
+		customerID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer result.Close()
+
+	addresses := []CustomerAddress{}
+	for result.Next() {
+		address, err := scanCustomerAddress(result)
+		if err != nil {
+			return nil, err
+		}
+		addresses = append(addresses, address)
+	}
+	return addresses, result.Err()
+}
+
+// GetCustomerAddress returns an address of a customer. Addresses of other
+// customers are not found.
+func GetCustomerAddress(ctx context.Context, customerID, id int) (CustomerAddress, error) {
+	db := Connect()
+	defer db.Close()
+
+	address, err := scanCustomerAddress(queryRowContext(
+		ctx,
+		db,
+		"GetCustomerAddress",
+		"SELECT "+customerAddressColumns+" FROM customer_addresses WHERE id = ? AND customer_id = ?",
+		id,
+		customerID,
+	))
+	if err == sql.ErrNoRows {
+		return address, ErrNotFound
+	}
+	return address, err
+}
+
+// GetDefaultAddress returns the default address of a kind of a customer.
+func GetDefaultAddress(ctx context.Context, customerID int, kind string) (CustomerAddress, error) {
+	db := Connect()
+	defer db.Close()
+
+	address, err := scanCustomerAddress(queryRowContext(
+		ctx,
+		db,
+		"GetDefaultAddress",
+		"SELECT "+customerAddressColumns+" FROM customer_addresses WHERE customer_id = ? AND kind = ? AND is_default",
+		customerID,
+		kind,
+	))
+	if err == sql.ErrNoRows {
+		return address, ErrNotFound
+	}
+	return address, err
+}
+
+func CountCustomerAddresses(ctx context.Context, customerID int) (int, error) {
+	db := Connect()
+	defer db.Close()
+
+	var count int
+	err := queryRowContext(
+		ctx,
+		db,
+		"CountCustomerAddresses",
+		"SELECT COUNT(*) FROM customer_addresses WHERE customer_id = ?",
+		customerID,
+	).Scan(&count)
+	return count, err
+}
+
+// makeDefault turns the default flag of the other addresses of the same
+// kind off, and on for id.
+func makeDefault(ctx context.Context, tx *sql.Tx, customerID int, kind string, id int) error {
+	_, err := tx.ExecContext(
+		ctx,
+		"UPDATE customer_addresses SET is_default = (id = ?) WHERE customer_id = ? AND kind = ?",
+		id,
+		customerID,
+		kind,
+	)
+	return err
+}
+
+// CreateCustomerAddress saves an address. The first address of a kind
+// becomes the default whatever the flag says.
+func CreateCustomerAddress(ctx context.Context, address CustomerAddress) (CustomerAddress, error) {
+	err := inTx(ctx, "CreateCustomerAddress", func(tx *sql.Tx) error {
+		var others int
+		err := tx.QueryRowContext(
+			ctx,
+			"SELECT COUNT(*) FROM customer_addresses WHERE customer_id = ? AND kind = ? FOR UPDATE",
+			address.CustomerID,
+			address.Kind,
+		).Scan(&others)
+		if err != nil {
+			return err
+		}
+
+		result, err := tx.ExecContext(
+			ctx,
+			"INSERT INTO customer_addresses (customer_id, kind, label, name, line1, line2, city, region, postal_code, country, phone) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+			address.CustomerID,
+			address.Kind,
+			address.Label,
+			address.Name,
+			address.Line1,
+			address.Line2,
+			address.City,
+			address.Region,
+			address.PostalCode,
+			address.Country,
+			address.Phone,
+		)
+		if err != nil {
+			return err
+		}
+		id, err := result.LastInsertId()
+		if err != nil {
+			return err
+		}
+		address.ID = int(id)
+
+		if address.Default || others == 0 {
+			return makeDefault(ctx, tx, address.CustomerID, address.Kind, address.ID)
+		}
+		return nil
+	})
+	if err != nil {
+		return address, err
+	}
+	return GetCustomerAddress(ctx, address.CustomerID, address.ID)
+}
+
+// UpdateCustomerAddress changes an address. The kind stays as it was, and
+// a default flag that is off leaves the default alone: a customer picks
+// another default rather than having none.
+func UpdateCustomerAddress(ctx context.Context, address CustomerAddress) (CustomerAddress, error) {
+	current, err := GetCustomerAddress(ctx, address.CustomerID, address.ID)
+	if err != nil {
+		return current, err
+	}
+
+	err = inTx(ctx, "UpdateCustomerAddress", func(tx *sql.Tx) error {
+		_, err := tx.ExecContext(
+			ctx,
+			"UPDATE customer_addresses SET label = ?, name = ?, line1 = ?, line2 = ?, city = ?, region = ?, postal_code = ?, country = ?, phone = ? WHERE id = ? AND customer_id = ?",
+			address.Label,
+			address.Name,
+			address.Line1,
+			address.Line2,
+			address.City,
+			address.Region,
+			address.PostalCode,
+			address.Country,
+			address.Phone,
+			address.ID,
+			address.CustomerID,
+		)
+		if err != nil {
+			return err
+		}
+		if address.Default && !current.Default {
+			return makeDefault(ctx, tx, current.CustomerID, current.Kind, current.ID)
+		}
+		return nil
+	})
+	if err != nil {
+		return current, err
+	}
+	return GetCustomerAddress(ctx, address.CustomerID, address.ID)
+}
+
+// DeleteCustomerAddress removes an address. When it was the default, the
+// newest address left of the same kind takes over.
+func DeleteCustomerAddress(ctx context.Context, customerID, id int) error {
+	return inTx(ctx, "DeleteCustomerAddress", func(tx *sql.Tx) error {
+		var kind string
+		var wasDefault bool
+		err := tx.QueryRowContext(
+			ctx,
+			"SELECT kind, is_default FROM customer_addresses WHERE id = ? AND customer_id = ? FOR UPDATE",
+			id,
+			customerID,
+		).Scan(&kind, &wasDefault)
+		if err == sql.ErrNoRows {
+			return ErrNotFound
+		}
+		if err != nil {
+			return err
+		}
+
+		if _, err := tx.ExecContext(ctx, "DELETE FROM customer_addresses WHERE id = ?", id); err != nil {
+			return err
+		}
+		if !wasDefault {
+			return nil
+		}
+
+		_, err = tx.ExecContext(
+			ctx,
+			"UPDATE customer_addresses SET is_default = TRUE WHERE customer_id = ? AND kind = ? ORDER BY id DESC LIMIT 1",
+			customerID,
+			kind,
+		)
+		return err
+	})
+}
@@ -0,0 +1,278 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+
+	"basicthreads/internal/money"
+)
+
+// CartLine is a cart item joined with the current product and variant, so
+// prices are always read at the time the cart is shown.
+type CartLine struct {
+	ID           int
+	ProductID    int
+	VariantID    *int
+	Quantity     int
+	Name         string
+	Image        string
+	ProductPrice money.Money
+	VariantPrice *money.Money
+	TaxClass     string
+	// Weight is the weight of one unit, in grams.
+	Weight int
+	SKU    string
+	Size   string
+	Color  string
+	// Live is false once the product is deleted or no longer available.
+	Live bool
+	// InStock is how many units of the variant can still be sold, or nil
+	// for products without variants.
+	InStock *int
+}
+
+func GetCustomerID(ctx context.Context, email string) (int, error) {
+	db := Connect()
+	defer db.Close()
+
+	var id int
+	err := queryRowContext(ctx, db, "GetCustomerID", "SELECT id FROM customers WHERE email = ?", email).Scan(&id)
+	if err == sql.ErrNoRows {
+		return 0, ErrNotFound
+	}
+	return id, err
+}
+
+// FindCart returns the cart of a customer, or of a guest token when
+// customerID is 0.
+func FindCart(ctx context.Context, customerID int, token string) (int, error) {
+	db := Connect()
+	defer db.Close()
+
+	query, arg := "SELECT id FROM carts WHERE customer_id = ?", any(customerID)
+	if customerID == 0 {
+		query, arg = "SELECT id FROM carts WHERE token = ?", token
+	}
+
+	var id int
+	err := queryRowContext(ctx, db, "FindCart", query, arg).Scan(&id)
+	if err == sql.ErrNoRows {
+		return 0, ErrNotFound
+	}
+	return id, err
+}
+
+// OpenCart returns the cart of a customer or guest token like FindCart,
+// creating it first when there is none.
+func OpenCart(ctx context.Context, customerID int, token string) (int, error) {
+	db := Connect()
+	defer db.Close()
+
+	_, err := execContext(
+		ctx,
+		db,
+		"OpenCart",
+		"INSERT IGNORE INTO carts (customer_id, token) VALUES (?, ?)",
+		nullableID(customerID),
+		nullableString(token),
+	)
+	if err != nil {
+		return 0, err
+	}
+	return FindCart(ctx, customerID, token)
+}
+
+func GetCartLines(ctx context.Context, cartID int) ([]CartLine, error) {
+	db := Connect()
+	defer db.Close()
+
+	result, err := queryContext(
+		ctx,
+		db,
+		"GetCartLines",
+		`SELECT ci.id, ci.product_id, ci.variant_id, ci.quantity, p.name, p.img, p.price, p.tax_class, p.weight,
+			p.available AND p.deleted_at IS NULL, v.price, COALESCE(v.sku, ''), COALESCE(v.size, ''),
+			COALESCE(v.color, ''), v.stock - v.reserved
+		FROM cart_items AS ci
+		INNER JOIN products AS p ON p.product_id = ci.product_id
+		LEFT JOIN product_variants AS v ON v.id = ci.variant_id
+		WHERE ci.cart_id = ?
+		ORDER BY ci.id`,
+		cartID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer result.Close()
+
+	lines := []CartLine{}
+	for result.Next() {
+		var line CartLine
+		var variantID, inStock sql.NullInt64
+		err := result.Scan(
+			&line.ID,
+			&line.ProductID,
+			&variantID,
+			&line.Quantity,
+			&line.Name,
+			&line.Image,
+			price(&line.ProductPrice),
+			&line.TaxClass,
+			&line.Weight,
+			&line.Live,
+			nullablePrice(&line.VariantPrice),
+			&line.SKU,
+			&line.Size,
+			&line.Color,
+			&inStock,
+		)
+		if err != nil {
+			return nil, err
+		}
+		if variantID.Valid {
+			id := int(variantID.Int64)
+			line.VariantID = &id
+		}
+		if inStock.Valid {
+			n := int(inStock.Int64)
+			line.InStock = &n
+		}
+		lines = append(lines, line)
+	}
+	return lines, result.Err()
+}
+
+// addCartItem adds quantity to the line of a product and variant, creating
+// the line when the cart does not have one yet. The result is capped at
+// maxQuantity.
+func addCartItem(ctx context.Context, tx *sql.Tx, cartID, productID int, variantID *int, quantity, maxQuantity int) error {
+	var id int
+	err := tx.QueryRowContext(
+		ctx,
+		"SELECT id FROM cart_items WHERE cart_id = ? AND product_id = ? AND variant_id <=> ? FOR UPDATE",
+		cartID,
+		productID,
+		variantID,
+	).Scan(&id)
+	if err == sql.ErrNoRows {
+		_, err = tx.ExecContext(
+			ctx,
+			"INSERT INTO cart_items (cart_id, product_id, variant_id, quantity) VALUES (?, ?, ?, LEAST(?, ?))",
+			cartID,
+			productID,
+			variantID,
+			quantity,
+			maxQuantity,
+		)
+		return err
+	}
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(
+		ctx,
+		"UPDATE cart_items SET quantity = LEAST(quantity + ?, ?) WHERE id = ?",
+		quantity,
+		maxQuantity,
+		id,
+	)
+	return err
+}
+
+func AddCartItem(ctx context.Context, cartID, productID int, variantID *int, quantity, maxQuantity int) error {
+	return inTx(ctx, "AddCartItem", func(tx *sql.Tx) error {
+		return addCartItem(ctx, tx, cartID, productID, variantID, quantity, maxQuantity)
+	})
+}
+
+func SetCartItemQuantity(ctx context.Context, cartID, itemID, quantity int) error {
+	db := Connect()
+	defer db.Close()
+
+	_, err := execContext(
+		ctx,
+		db,
+		"SetCartItemQuantity",
+		"UPDATE cart_items SET quantity = ? WHERE id = ? AND cart_id = ?",
+		quantity,
+		itemID,
+		cartID,
+	)
+	return err
+}
+
+func DeleteCartItem(ctx context.Context, cartID, itemID int) error {
+	db := Connect()
+	defer db.Close()
+
+	_, err := execContext(
+		ctx,
+		db,
+		"DeleteCartItem",
+		"DELETE FROM cart_items WHERE id = ? AND cart_id = ?",
+		itemID,
+		cartID,
+	)
+	return err
+}
+
+// MergeCarts moves every line of one cart into another, adding quantities
+// where both have the same product and variant, and deletes the emptied
+// cart. The discount code of the emptied cart is kept when the other cart
+// has none.
+func MergeCarts(ctx context.Context, fromID, intoID, maxQuantity int) error {
+	return inTx(ctx, "MergeCarts", func(tx *sql.Tx) error {
+		rows, err := tx.QueryContext(
+			ctx,
+			"SELECT product_id, variant_id, quantity FROM cart_items WHERE cart_id = ? ORDER BY id FOR UPDATE",
+			fromID,
+		)
+		if err != nil {
+			return err
+		}
+
+		type item struct {
+			productID int
+			variantID *int
+			quantity  int
+		}
+		var items []item
+		for rows.Next() {
+			var it item
+			var variantID sql.NullInt64
+			if err := rows.Scan(&it.productID, &variantID, &it.quantity); err != nil {
+				rows.Close()
+				return err
+			}
+			if variantID.Valid {
+				id := int(variantID.Int64)
+				it.variantID = &id
+			}
+			items = append(items, it)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		for _, it := range items {
+			if err := addCartItem(ctx, tx, intoID, it.productID, it.variantID, it.quantity, maxQuantity); err != nil {
+				return err
+			}
+		}
+
+		_, err = tx.ExecContext(
+			ctx,
+			"UPDATE carts AS c INNER JOIN carts AS f ON f.id = ? SET c.discount_code = COALESCE(c.discount_code, f.discount_code) WHERE c.id = ?",
+			fromID,
+			intoID,
+		)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx, "DELETE FROM carts WHERE id = ?", fromID)
+		return err
+	})
+}
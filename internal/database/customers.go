@@ -0,0 +1,302 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"strconv"
+	"time"
+)
+
+type Customer struct {
+	ID        int
+	Name      string
+	Email     string
+	Phone     string
+	CreatedAt time.Time
+}
+
+// Session is a login of a customer, the token it was given and where it
+// came from. Sessions double as the login history.
+type Session struct {
+	ID         int
+	CustomerID int    `json:"-"`
+	TokenID    string `json:"-"`
+	IP         string
+	UserAgent  string
+	CreatedAt  time.Time
+	ExpiresAt  time.Time
+	RevokedAt  *time.Time `json:",omitempty"`
+}
+
+func GetCustomer(ctx context.Context, id int) (Customer, error) {
+	db := Connect()
+	defer db.Close()
+
+	var customer Customer
+	var createdAt []byte
+	err := queryRowContext(
+		ctx,
+		db,
+		"GetCustomer",
+		"SELECT id, name, email, phone, created_at FROM customers WHERE id = ? AND deleted_at IS NULL",
+		id,
+	).Scan(&customer.ID, &customer.Name, &customer.Email, &customer.Phone, &createdAt)
+	if err == sql.ErrNoRows {
+		return customer, ErrNotFound
+	}
+	if err != nil {
+		return customer, err
+	}
+	customer.CreatedAt, err = time.Parse(time.DateTime, string(createdAt))
+	return customer, err
+}
+
+func UpdateCustomerProfile(ctx context.Context, id int, name, phone string) error {
+	db := Connect()
+	defer db.Close()
+
+	_, err := execContext(
+		ctx,
+		db,
+		"UpdateCustomerProfile",
+		"UPDATE customers SET name = ?, phone = ? WHERE id = ? AND deleted_at IS NULL",
+		name,
+		phone,
+		id,
+	)
+	return err
+}
+
+// CheckPassword reports whether password is the one of a customer.
+func CheckPassword(ctx context.Context, id int, password string) (bool, error) {
+	db := Connect()
+	defer db.Close()
+
+	var matches bool
+	err := queryRowContext(
+		ctx,
+		db,
+		"CheckPassword",
+		"SELECT password = MD5(?) FROM customers WHERE id = ? AND deleted_at IS NULL",
+		password,
+		id,
+	).Scan(&matches)
+	if err == sql.ErrNoRows {
+		return false, ErrNotFound
+	}
+	return matches, err
+}
+
+func revokeSessions(ctx context.Context, tx *sql.Tx, customerID int, except string) error {
+	_, err := tx.ExecContext(
+		ctx,
+		"UPDATE customer_sessions SET revoked_at = CURRENT_TIMESTAMP WHERE customer_id = ? AND token_id <> ? AND revoked_at IS NULL",
+		customerID,
+		except,
+	)
+	return err
+}
+
+// ChangePassword replaces the password of a customer and signs out every
+// session but the one with token id keep.
+func ChangePassword(ctx context.Context, id int, password, keep string) error {
+	return inTx(ctx, "ChangePassword", func(tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, "UPDATE customers SET password = MD5(?) WHERE id = ?", password, id)
+		if err != nil {
+			return err
+		}
+		return revokeSessions(ctx, tx, id, keep)
+	})
+}
+
+// CreateSession records a login whose token lasts ttl.
+func CreateSession(ctx context.Context, session Session, ttl time.Duration) error {
+	db := Connect()
+	defer db.Close()
+
+	_, err := execContext(
+		ctx,
+		db,
+		"CreateSession",
+		"INSERT INTO customer_sessions (customer_id, token_id, ip, user_agent, expires_at) VALUES (?, ?, ?, ?, DATE_ADD(CURRENT_TIMESTAMP, INTERVAL ? SECOND))",
+		session.CustomerID,
+		session.TokenID,
+		session.IP,
+		session.UserAgent,
+		int(ttl.Seconds()),
+	)
+	return err
+}
+
+// SessionActive reports whether the session with a token id exists and
+// was not signed out.
+func SessionActive(ctx context.Context, tokenID string) (bool, error) {
+	db := Connect()
+	defer db.Close()
+
+	var active bool
+	err := queryRowContext(
+		ctx,
+		db,
+		"SessionActive",
+		"SELECT EXISTS(SELECT 1 FROM customer_sessions WHERE token_id = ? AND revoked_at IS NULL AND expires_at > CURRENT_TIMESTAMP)",
+		tokenID,
+	).Scan(&active)
+	return active, err
+}
+
+// GetSessions returns the logins of a customer, newest first.
+func GetSessions(ctx context.Context, customerID int) ([]Session, error) {
+	db := Connect()
+	defer db.Close()
+
+	result, err := queryContext(
+		ctx,
+		db,
+		"GetSessions",
+		"SELECT id, customer_id, token_id, ip, user_agent, created_at, expires_at, revoked_at FROM customer_sessions WHERE customer_id = ? ORDER BY id DESC",
+		customerID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer result.Close()
+
+	sessions := []Session{}
+	for result.Next() {
+		var session Session
+		var createdAt, expiresAt []byte
+		var revokedAt sql.NullString
+		err := result.Scan(
+			&session.ID,
+			&session.CustomerID,
+			&session.TokenID,
+			&session.IP,
+			&session.UserAgent,
+			&createdAt,
+			&expiresAt,
+			&revokedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		if session.CreatedAt, err = time.Parse(time.DateTime, string(createdAt)); err != nil {
+			return nil, err
+		}
+		if session.ExpiresAt, err = time.Parse(time.DateTime, string(expiresAt)); err != nil {
+			return nil, err
+		}
+		if session.RevokedAt, err = nullTime(revokedAt); err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, session)
+	}
+	return sessions, result.Err()
+}
+
+// SaveEmailChange records that a customer wants to move to email, pending
+// the token sent there for ttl. A newer request replaces an older one.
+func SaveEmailChange(ctx context.Context, customerID int, email, tokenHash string, ttl time.Duration) error {
+	db := Connect()
+	defer db.Close()
+
+	_, err := execContext(
+		ctx,
+		db,
+		"SaveEmailChange",
+		"INSERT INTO email_changes (customer_id, email, token_hash, expires_at) VALUES (?, ?, ?, DATE_ADD(CURRENT_TIMESTAMP, INTERVAL ? SECOND)) ON DUPLICATE KEY UPDATE email = VALUES(email), token_hash = VALUES(token_hash), created_at = CURRENT_TIMESTAMP, expires_at = VALUES(expires_at)",
+		customerID,
+		email,
+		tokenHash,
+		int(ttl.Seconds()),
+	)
+	return err
+}
+
+// ConfirmEmailChange moves a customer to the email of the pending change
+// with tokenHash and signs out all their sessions, which carry the old
+// email. It returns the customer as they were before. It fails with
+// ErrNotFound for unknown or expired tokens, and with ErrDuplicate when
+// someone registered the email in the meantime.
+func ConfirmEmailChange(ctx context.Context, tokenHash string) (Customer, string, error) {
+	var customer Customer
+	var email string
+	err := inTx(ctx, "ConfirmEmailChange", func(tx *sql.Tx) error {
+		err := tx.QueryRowContext(
+			ctx,
+			"SELECT customer_id, email FROM email_changes WHERE token_hash = ? AND expires_at > CURRENT_TIMESTAMP FOR UPDATE",
+			tokenHash,
+		).Scan(&customer.ID, &email)
+		if err == sql.ErrNoRows {
+			return ErrNotFound
+		}
+		if err != nil {
+			return err
+		}
+
+		err = tx.QueryRowContext(ctx, "SELECT name, email FROM customers WHERE id = ? AND deleted_at IS NULL", customer.ID).Scan(&customer.Name, &customer.Email)
+		if err == sql.ErrNoRows {
+			return ErrNotFound
+		}
+		if err != nil {
+			return err
+		}
+
+		if _, err := tx.ExecContext(ctx, "UPDATE customers SET email = ? WHERE id = ?", email, customer.ID); err != nil {
+			return duplicateError(err)
+		}
+		if _, err := tx.ExecContext(ctx, "DELETE FROM email_changes WHERE customer_id = ?", customer.ID); err != nil {
+			return err
+		}
+		return revokeSessions(ctx, tx, customer.ID, "")
+	})
+	return customer, email, err
+}
+
+// AnonymizeCustomer wipes the personal data of a customer: name, email,
+// phone and password are replaced, the address book, cart, wishlists and
+// contact messages are emptied, data exports stop being downloadable and
+// every session is signed out. The record stays, so orders keep pointing
+// at it for the accounts.
+func AnonymizeCustomer(ctx context.Context, id int) error {
+	return inTx(ctx, "AnonymizeCustomer", func(tx *sql.Tx) error {
+		var email string
+		err := tx.QueryRowContext(ctx, "SELECT email FROM customers WHERE id = ? AND deleted_at IS NULL FOR UPDATE", id).Scan(&email)
+		if err == sql.ErrNoRows {
+			return ErrNotFound
+		}
+		if err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, "DELETE FROM contact_messages WHERE email = ?", email); err != nil {
+			return err
+		}
+
+		_, err = tx.ExecContext(
+			ctx,
+			// No MD5 is all x, so the password can never match again.
+			"UPDATE customers SET name = 'Deleted customer', email = ?, phone = '', password = REPEAT('x', 32), role = 'customer', deleted_at = CURRENT_TIMESTAMP WHERE id = ? AND deleted_at IS NULL",
+			"deleted-"+strconv.Itoa(id)+"@invalid",
+			id,
+		)
+		if err != nil {
+			return err
+		}
+
+		// The sweep deletes the archives of expired exports.
+		statements := []string{
+			"DELETE FROM customer_addresses WHERE customer_id = ?",
+			"DELETE FROM email_changes WHERE customer_id = ?",
+			"DELETE FROM carts WHERE customer_id = ?",
+			"DELETE FROM wishlists WHERE customer_id = ?",
+			"UPDATE data_exports SET expires_at = CURRENT_TIMESTAMP WHERE customer_id = ? AND status = 'ready'",
+			"UPDATE data_exports SET status = 'failed', error = 'account deleted' WHERE customer_id = ? AND status IN ('pending', 'running')",
+		}
+		for _, statement := range statements {
+			if _, err := tx.ExecContext(ctx, statement, id); err != nil {
+				return err
+			}
+		}
+		return revokeSessions(ctx, tx, id, "")
+	})
+}
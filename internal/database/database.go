@@ -1,57 +1,110 @@
 package database
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
-	"os"
+	"sync"
 
 	_ "github.com/go-sql-driver/mysql"
-	"github.com/joho/godotenv"
+
+	"basicthreads/internal/config"
+	"basicthreads/internal/money"
 )
 
 type Category struct {
-	ID       int
-	Name     string
-	ParentID int
+	ID        int
+	Name      string
+	ParentID  int
+	SortOrder int
 }
 
 type Product struct {
 	ID          int
 	Name        string
-	Price       float64
+	Price       money.Money
 	Description string
 	Image       string
+	Available   bool
+	// Rating is the average of the approved reviews, 0 without any.
+	Rating      float64
+	ReviewCount int
 	Categories  string
+	Variants    []Variant      `json:",omitempty"`
+	Images      []ProductImage `json:",omitempty"`
+	// DisplayPrice is the price converted to the currency the client asked
+	// to see. Products are always sold at Price.
+	DisplayPrice *money.Money `json:",omitempty"`
+}
+
+// Currency is what catalog prices are in. The price columns hold plain
+// decimals of it. It is read from the configuration once.
+func Currency() string {
+	return currency()
 }
 
-func connect() *sql.DB {
-	err := godotenv.Load()
+var currency = sync.OnceValue(func() string {
+	return config.Load().Currency
+})
+
+// CheckCurrency makes sure the stored prices are in code. The first shop
+// to start records its currency; afterwards starting with another one is
+// an error rather than every price silently changing its meaning.
+func CheckCurrency(ctx context.Context, code string) error {
+	db := Connect()
+	defer db.Close()
+
+	_, err := execContext(
+		ctx,
+		db,
+		"CheckCurrency",
+		"INSERT IGNORE INTO shop_settings (name, value) VALUES ('currency', ?)",
+		code,
+	)
 	if err != nil {
-		fmt.Println("Error loading .env file")
+		return err
 	}
 
-	dbuser := os.Getenv("DBUSER")
-	dbpass := os.Getenv("DBPASS")
-	dbhost := os.Getenv("DBHOST")
-	dbname := os.Getenv("DBNAME")
-	dbport := os.Getenv("DBPORT")
-	dsn := fmt.Sprintf("%s:%s@tcp(%s:%s)/%s", dbuser, dbpass, dbhost, dbport, dbname)
+	var stored string
+	err = queryRowContext(ctx, db, "CheckCurrency", "SELECT value FROM shop_settings WHERE name = 'currency'").Scan(&stored)
+	if err != nil {
+		return err
+	}
+	if stored != code {
+		return fmt.Errorf("prices are stored in %s, not %s", stored, code)
+	}
+	return nil
+}
+
+func price(dest *money.Money) sql.Scanner {
+	return money.Column(dest, Currency())
+}
+
+func nullablePrice(dest **money.Money) sql.Scanner {
+	return money.NullColumn(dest, Currency())
+}
 
-	db, err := sql.Open("mysql", dsn)
+// Connect opens a connection pool using the DB* settings from the
+// environment or the .env file.
+func Connect() *sql.DB {
+	db, err := sql.Open("mysql", config.Load().Database.DSN())
 	if err != nil {
 		panic(err.Error())
 	}
 	return db
 }
 
-func AuthUser(user string, password string) bool {
-	db := connect()
+func AuthUser(ctx context.Context, user string, password string) bool {
+	db := Connect()
 	defer db.Close()
-	err := db.Ping()
+	err := db.PingContext(ctx)
 	if err != nil {
 		panic(err.Error())
 	}
-	result, err := db.Query(
+	result, err := queryContext(
+		ctx,
+		db,
+		"AuthUser",
 		"SELECT email, password FROM customers WHERE email = ? and password = MD5(?)",
 		user,
 		password,
@@ -64,14 +117,17 @@ func AuthUser(user string, password string) bool {
 	return result.Next()
 }
 
-func ValidateUserExists(user string) bool {
-	db := connect()
+func ValidateUserExists(ctx context.Context, user string) bool {
+	db := Connect()
 	defer db.Close()
-	err := db.Ping()
+	err := db.PingContext(ctx)
 	if err != nil {
 		panic(err.Error())
 	}
-	result, err := db.Query(
+	result, err := queryContext(
+		ctx,
+		db,
+		"ValidateUserExists",
 		"SELECT email FROM customers WHERE email = ? ",
 		user,
 	)
@@ -91,14 +147,17 @@ func ValidateUserExists(user string) bool {
 	return username == user
 }
 
-func RegisterUser(name, email, phone, password string) error {
-	db := connect()
+func RegisterUser(ctx context.Context, name, email, phone, password string) error {
+	db := Connect()
 	defer db.Close()
-	err := db.Ping()
+	err := db.PingContext(ctx)
 	if err != nil {
 		panic(err.Error())
 	}
-	_, err = db.Exec(
+	_, err = execContext(
+		ctx,
+		db,
+		"RegisterUser",
 		"INSERT INTO customers (name, email, phone, password) VALUES (?, ?, ?,MD5(?))",
 		name,
 		email,
@@ -111,11 +170,11 @@ func RegisterUser(name, email, phone, password string) error {
 	return nil
 }
 
-func GetProducts() []Product {
-	db := connect()
+func GetProducts(ctx context.Context) []Product {
+	db := Connect()
 	defer db.Close()
 
-	result, err := db.Query("SELECT product_id as id, name, price, description, img FROM products")
+	result, err := queryContext(ctx, db, "GetProducts", "SELECT product_id as id, name, price, description, img, available, rating_average, rating_count FROM products where deleted_at is null")
 	if err != nil {
 		panic(err.Error())
 	}
@@ -127,9 +186,12 @@ func GetProducts() []Product {
 		err = result.Scan(
 			&product.ID,
 			&product.Name,
-			&product.Price,
+			price(&product.Price),
 			&product.Description,
 			&product.Image,
+			&product.Available,
+			&product.Rating,
+			&product.ReviewCount,
 		)
 		if err != nil {
 			panic(err.Error())
@@ -142,12 +204,15 @@ func GetProducts() []Product {
 	return Products
 }
 
-func GetProduct(id string) Product {
-	db := connect()
+func GetProduct(ctx context.Context, id string) Product {
+	db := Connect()
 	defer db.Close()
 
-	result, err := db.Query(
-		"SELECT p.product_id as id, p.name, p.price, p.description, p.img ,(select group_concat(c.name) from categories as c where c.id in (select group_concat(cp.id_category) from categories_product as cp where cp.id_product = ? group by cp.id_category)) as categories FROM products as p where p.product_id = ? ",
+	result, err := queryContext(
+		ctx,
+		db,
+		"GetProduct",
+		"SELECT p.product_id as id, p.name, p.price, p.description, p.img, p.available, p.rating_average, p.rating_count, (select group_concat(c.name) from categories as c where c.id in (select group_concat(cp.id_category) from categories_product as cp where cp.id_product = ? group by cp.id_category)) as categories FROM products as p where p.product_id = ? and p.deleted_at is null",
 		id,
 		id,
 	)
@@ -160,9 +225,12 @@ func GetProduct(id string) Product {
 		err = result.Scan(
 			&product.ID,
 			&product.Name,
-			&product.Price,
+			price(&product.Price),
 			&product.Description,
 			&product.Image,
+			&product.Available,
+			&product.Rating,
+			&product.ReviewCount,
 			&product.Categories,
 		)
 		if err != nil {
@@ -172,19 +240,22 @@ func GetProduct(id string) Product {
 	return product
 }
 
-func GetCategories(id_category string) []Category {
-	db := connect()
+func GetCategories(ctx context.Context, id_category string) []Category {
+	db := Connect()
 	defer db.Close()
 
-	err := db.Ping()
+	err := db.PingContext(ctx)
 	if err != nil {
 		panic(err.Error())
 	}
 	Categories := []Category{}
 
 	if id_category == "" {
-		result, err := db.Query(
-			"SELECT id,name FROM categories where parent_category_id is null",
+		result, err := queryContext(
+			ctx,
+			db,
+			"GetCategories",
+			"SELECT id,name,sort_order FROM categories where parent_category_id is null order by sort_order, id",
 		)
 		if err != nil {
 			panic(err.Error())
@@ -194,6 +265,7 @@ func GetCategories(id_category string) []Category {
 			err = result.Scan(
 				&category.ID,
 				&category.Name,
+				&category.SortOrder,
 			)
 			if err != nil {
 				panic(err.Error())
@@ -203,8 +275,11 @@ func GetCategories(id_category string) []Category {
 		}
 
 	} else {
-		result, err := db.Query(
-			"SELECT id,name,parent_category_id FROM categories where parent_category_id = ?",
+		result, err := queryContext(
+			ctx,
+			db,
+			"GetCategories",
+			"SELECT id,name,parent_category_id,sort_order FROM categories where parent_category_id = ? order by sort_order, id",
 			id_category,
 		)
 		if err != nil {
@@ -217,6 +292,7 @@ func GetCategories(id_category string) []Category {
 				&category.ID,
 				&category.Name,
 				&category.ParentID,
+				&category.SortOrder,
 			)
 			if err != nil {
 				panic(err.Error())
@@ -230,12 +306,15 @@ func GetCategories(id_category string) []Category {
 	return Categories
 }
 
-func GetProductsCategory(id_category string) []Product {
-	db := connect()
+func GetProductsCategory(ctx context.Context, id_category string) []Product {
+	db := Connect()
 	defer db.Close()
 
-	result, err := db.Query(
-		"SELECT p.product_id as id, p.name, p.price, p.description, p.img FROM products as p inner join categories_product as cp on cp.id_product = p.product_id where cp.id_category = ?",
+	result, err := queryContext(
+		ctx,
+		db,
+		"GetProductsCategory",
+		"SELECT p.product_id as id, p.name, p.price, p.description, p.img, p.available, p.rating_average, p.rating_count FROM products as p inner join categories_product as cp on cp.id_product = p.product_id where cp.id_category = ? and p.deleted_at is null",
 		id_category,
 	)
 	if err != nil {
@@ -249,9 +328,12 @@ func GetProductsCategory(id_category string) []Product {
 		err = result.Scan(
 			&product.ID,
 			&product.Name,
-			&product.Price,
+			price(&product.Price),
 			&product.Description,
 			&product.Image,
+			&product.Available,
+			&product.Rating,
+			&product.ReviewCount,
 		)
 		if err != nil {
 			panic(err.Error())
@@ -264,11 +346,14 @@ func GetProductsCategory(id_category string) []Product {
 	return Products
 }
 
-func GetCategoryName(id string) string {
-	db := connect()
+func GetCategoryName(ctx context.Context, id string) string {
+	db := Connect()
 	defer db.Close()
 
-	result, err := db.Query(
+	result, err := queryContext(
+		ctx,
+		db,
+		"GetCategoryName",
 		"SELECT name FROM categories where id = ?",
 		id,
 	)
@@ -285,15 +370,15 @@ func GetCategoryName(id string) string {
 	return name
 }
 
-func GetUser(email string) string {
-	db := connect()
+func GetUser(ctx context.Context, email string) string {
+	db := Connect()
 	defer db.Close()
-	err := db.Ping()
+	err := db.PingContext(ctx)
 	if err != nil {
 		panic(err.Error())
 	}
 
-	result, err := db.Query("SELECT  name FROM customers WHERE email = ?", email)
+	result, err := queryContext(ctx, db, "GetUser", "SELECT  name FROM customers WHERE email = ?", email)
 	if err != nil {
 		panic(err.Error())
 	}
@@ -313,3 +398,269 @@ func GetUser(email string) string {
 func ContactForm(name, email, message string) string {
 	return "Message sent"
 }
+
+// SaveAdmin creates an admin customer, or promotes the customer already
+// registered with email and replaces their password.
+func SaveAdmin(ctx context.Context, name, email, phone, password string) error {
+	db := Connect()
+	defer db.Close()
+
+	_, err := execContext(
+		ctx,
+		db,
+		"SaveAdmin",
+		"INSERT INTO customers (name, email, phone, password, role) VALUES (?, ?, ?, MD5(?), 'admin') ON DUPLICATE KEY UPDATE password = VALUES(password), role = 'admin'",
+		name,
+		email,
+		phone,
+		password,
+	)
+	return err
+}
+
+// SetPassword replaces the password of the customer registered with email,
+// signs out all of their sessions and reports whether such a customer
+// exists.
+func SetPassword(ctx context.Context, email, password string) (bool, error) {
+	var found bool
+	err := inTx(ctx, "SetPassword", func(tx *sql.Tx) error {
+		var id int
+		err := tx.QueryRowContext(ctx, "SELECT id FROM customers WHERE email = ? FOR UPDATE", email).Scan(&id)
+		if err == sql.ErrNoRows {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		found = true
+
+		if _, err := tx.ExecContext(ctx, "UPDATE customers SET password = MD5(?) WHERE id = ?", password, id); err != nil {
+			return err
+		}
+		return revokeSessions(ctx, tx, id, "")
+	})
+	return found && err == nil, err
+}
+
+// AnalyzeTables refreshes the index statistics MySQL uses to plan catalog
+// queries.
+func AnalyzeTables(ctx context.Context) error {
+	db := Connect()
+	defer db.Close()
+
+	_, err := execContext(
+		ctx,
+		db,
+		"AnalyzeTables",
+		"ANALYZE TABLE customers, categories, products, categories_product",
+	)
+	return err
+}
+
+// GetAllCategories returns every category with ParentID set to 0 for roots.
+// Siblings come back in their sort order.
+func GetAllCategories(ctx context.Context) ([]Category, error) {
+	db := Connect()
+	defer db.Close()
+
+	result, err := queryContext(
+		ctx,
+		db,
+		"GetAllCategories",
+		"SELECT id, name, COALESCE(parent_category_id, 0), sort_order FROM categories ORDER BY parent_category_id IS NOT NULL, sort_order, id",
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer result.Close()
+
+	Categories := []Category{}
+	for result.Next() {
+		var category Category
+		err = result.Scan(&category.ID, &category.Name, &category.ParentID, &category.SortOrder)
+		if err != nil {
+			return nil, err
+		}
+		Categories = append(Categories, category)
+	}
+	return Categories, result.Err()
+}
+
+// GetProductCategoryIDs maps each product id to the ids of its categories.
+func GetProductCategoryIDs(ctx context.Context) (map[int][]int, error) {
+	db := Connect()
+	defer db.Close()
+
+	result, err := queryContext(
+		ctx,
+		db,
+		"GetProductCategoryIDs",
+		"SELECT id_product, id_category FROM categories_product ORDER BY id_product, id_category",
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer result.Close()
+
+	links := map[int][]int{}
+	for result.Next() {
+		var productID, categoryID int
+		err = result.Scan(&productID, &categoryID)
+		if err != nil {
+			return nil, err
+		}
+		links[productID] = append(links[productID], categoryID)
+	}
+	return links, result.Err()
+}
+
+// GetCustomerRole returns the role of the customer registered with email, or
+// an empty string when there is none.
+func GetCustomerRole(ctx context.Context, email string) (string, error) {
+	db := Connect()
+	defer db.Close()
+
+	var role string
+	err := queryRowContext(
+		ctx,
+		db,
+		"GetCustomerRole",
+		"SELECT role FROM customers WHERE email = ?",
+		email,
+	).Scan(&role)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return role, err
+}
+
+// CreateCategory inserts a category after its last sibling and returns its
+// id. A parentID of 0 creates a root category.
+func CreateCategory(ctx context.Context, name string, parentID int) (int, error) {
+	db := Connect()
+	defer db.Close()
+
+	result, err := execContext(
+		ctx,
+		db,
+		"CreateCategory",
+		"INSERT INTO categories (name, parent_category_id, sort_order) SELECT ?, ?, COALESCE(MAX(sort_order) + 1, 0) FROM categories WHERE parent_category_id <=> ?",
+		name,
+		nullableID(parentID),
+		nullableID(parentID),
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	id, err := result.LastInsertId()
+	return int(id), err
+}
+
+func RenameCategory(ctx context.Context, id int, name string) error {
+	db := Connect()
+	defer db.Close()
+
+	_, err := execContext(
+		ctx,
+		db,
+		"RenameCategory",
+		"UPDATE categories SET name = ? WHERE id = ?",
+		name,
+		id,
+	)
+	return err
+}
+
+// MoveCategory attaches a category to a new parent, after the parent's
+// existing children.
+func MoveCategory(ctx context.Context, id, parentID int) error {
+	db := Connect()
+	defer db.Close()
+
+	var next int
+	err := queryRowContext(
+		ctx,
+		db,
+		"MoveCategory",
+		"SELECT COALESCE(MAX(sort_order) + 1, 0) FROM categories WHERE parent_category_id <=> ? AND id <> ?",
+		nullableID(parentID),
+		id,
+	).Scan(&next)
+	if err != nil {
+		return err
+	}
+
+	_, err = execContext(
+		ctx,
+		db,
+		"MoveCategory",
+		"UPDATE categories SET parent_category_id = ?, sort_order = ? WHERE id = ?",
+		nullableID(parentID),
+		next,
+		id,
+	)
+	return err
+}
+
+// SetCategoryOrder renumbers the given sibling categories so they sort in
+// the order of ids.
+func SetCategoryOrder(ctx context.Context, ids []int) error {
+	db := Connect()
+	defer db.Close()
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for position, id := range ids {
+		_, err = tx.ExecContext(
+			ctx,
+			"UPDATE categories SET sort_order = ? WHERE id = ?",
+			position,
+			id,
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func CountCategoryProducts(ctx context.Context, id int) (int, error) {
+	db := Connect()
+	defer db.Close()
+
+	var count int
+	err := queryRowContext(
+		ctx,
+		db,
+		"CountCategoryProducts",
+		"SELECT COUNT(*) FROM categories_product WHERE id_category = ?",
+		id,
+	).Scan(&count)
+	return count, err
+}
+
+func DeleteCategory(ctx context.Context, id int) error {
+	db := Connect()
+	defer db.Close()
+
+	_, err := execContext(
+		ctx,
+		db,
+		"DeleteCategory",
+		"DELETE FROM categories WHERE id = ?",
+		id,
+	)
+	return err
+}
+
+func nullableID(id int) any {
+	if id == 0 {
+		return nil
+	}
+	return id
+}
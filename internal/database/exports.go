@@ -0,0 +1,358 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// Statuses of a data export.
+const (
+	ExportPending = "pending"
+	ExportRunning = "running"
+	ExportReady   = "ready"
+	ExportFailed  = "failed"
+	ExportExpired = "expired"
+)
+
+// ErrExportInProgress is returned when a customer already has an export
+// being generated.
+var ErrExportInProgress = errors.New("an export is already being generated")
+
+// DataExport is an archive of the personal data of a customer. The
+// download token is only sent by email; the table keeps its hash.
+type DataExport struct {
+	ID          int
+	CustomerID  int
+	RequestedBy string
+	NotifyEmail string `json:"-"`
+	Status      string
+	Error       string `json:",omitempty"`
+	Path        string `json:"-"`
+	CreatedAt   time.Time
+	CompletedAt *time.Time `json:",omitempty"`
+	ExpiresAt   *time.Time `json:",omitempty"`
+}
+
+type ContactMessage struct {
+	ID        int
+	Name      string
+	Email     string
+	Message   string
+	CreatedAt time.Time
+}
+
+const dataExportColumns = "id, customer_id, requested_by, notify_email, status, error, path, created_at, completed_at, expires_at"
+
+func scanDataExport(row rowScanner) (DataExport, error) {
+	var export DataExport
+	var createdAt []byte
+	var completedAt, expiresAt sql.NullString
+	err := row.Scan(
+		&export.ID,
+		&export.CustomerID,
+		&export.RequestedBy,
+		&export.NotifyEmail,
+		&export.Status,
+		&export.Error,
+		&export.Path,
+		&createdAt,
+		&completedAt,
+		&expiresAt,
+	)
+	if err != nil {
+		return export, err
+	}
+	if export.CreatedAt, err = time.Parse(time.DateTime, string(createdAt)); err != nil {
+		return export, err
+	}
+	if export.CompletedAt, err = nullTime(completedAt); err != nil {
+		return export, err
+	}
+	export.ExpiresAt, err = nullTime(expiresAt)
+	return export, err
+}
+
+// CreateDataExport queues an export of a customer. It fails with
+// ErrExportInProgress while another one is pending or running.
+func CreateDataExport(ctx context.Context, export DataExport) (DataExport, error) {
+	err := inTx(ctx, "CreateDataExport", func(tx *sql.Tx) error {
+		// Lock the customer so two requests cannot both see no export.
+		var id int
+		err := tx.QueryRowContext(ctx, "SELECT id FROM customers WHERE id = ? FOR UPDATE", export.CustomerID).Scan(&id)
+		if err == sql.ErrNoRows {
+			return ErrNotFound
+		}
+		if err != nil {
+			return err
+		}
+
+		var busy bool
+		err = tx.QueryRowContext(
+			ctx,
+			"SELECT EXISTS(SELECT 1 FROM data_exports WHERE customer_id = ? AND status IN ('pending', 'running'))",
+			export.CustomerID,
+		).Scan(&busy)
+		if err != nil {
+			return err
+		}
+		if busy {
+			return ErrExportInProgress
+		}
+
+		result, err := tx.ExecContext(
+			ctx,
+			"INSERT INTO data_exports (customer_id, requested_by, notify_email) VALUES (?, ?, ?)",
+			export.CustomerID,
+			export.RequestedBy,
+			export.NotifyEmail,
+		)
+		if err != nil {
+			return err
+		}
+		inserted, err := result.LastInsertId()
+		export.ID = int(inserted)
+		return err
+	})
+	if err != nil {
+		return export, err
+	}
+	return GetDataExport(ctx, export.ID)
+}
+
+func GetDataExport(ctx context.Context, id int) (DataExport, error) {
+	db := Connect()
+	defer db.Close()
+
+	export, err := scanDataExport(queryRowContext(ctx, db, "GetDataExport", "SELECT "+dataExportColumns+" FROM data_exports WHERE id = ?", id))
+	if err == sql.ErrNoRows {
+		return export, ErrNotFound
+	}
+	return export, err
+}
+
+// GetDataExports lists the exports of a customer, newest first.
+func GetDataExports(ctx context.Context, customerID int) ([]DataExport, error) {
+	return queryDataExports(
+		ctx,
+		"GetDataExports",
+		"SELECT "+dataExportColumns+" FROM data_exports WHERE customer_id = ? ORDER BY id DESC",
+		customerID,
+	)
+}
+
+// ClaimDataExport marks a pending export as running and reports whether
+// this caller got it. Runs that have not finished after stuckAfter are up
+// for grabs again, as their worker is taken to have died.
+func ClaimDataExport(ctx context.Context, id int, stuckAfter time.Duration) (bool, error) {
+	db := Connect()
+	defer db.Close()
+
+	result, err := execContext(
+		ctx,
+		db,
+		"ClaimDataExport",
+		`UPDATE data_exports SET status = 'running', started_at = CURRENT_TIMESTAMP
+		WHERE id = ? AND (status = 'pending' OR (status = 'running' AND started_at < DATE_SUB(CURRENT_TIMESTAMP, INTERVAL ? SECOND)))`,
+		id,
+		int(stuckAfter.Seconds()),
+	)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n == 1, err
+}
+
+// CompleteDataExport records the archive of an export and the hash of the
+// token that downloads it until ttl passes. It reports false when the
+// export is no longer running, such as when it was cancelled or deleted
+// meanwhile, and nothing was recorded.
+func CompleteDataExport(ctx context.Context, id int, path, tokenHash string, ttl time.Duration) (bool, error) {
+	db := Connect()
+	defer db.Close()
+
+	result, err := execContext(
+		ctx,
+		db,
+		"CompleteDataExport",
+		"UPDATE data_exports SET status = 'ready', path = ?, token_hash = ?, completed_at = CURRENT_TIMESTAMP, expires_at = DATE_ADD(CURRENT_TIMESTAMP, INTERVAL ? SECOND) WHERE id = ? AND status = 'running'",
+		path,
+		tokenHash,
+		int(ttl.Seconds()),
+		id,
+	)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n == 1, err
+}
+
+func FailDataExport(ctx context.Context, id int, reason string) error {
+	db := Connect()
+	defer db.Close()
+
+	if len(reason) > 255 {
+		reason = reason[:255]
+	}
+	_, err := execContext(
+		ctx,
+		db,
+		"FailDataExport",
+		"UPDATE data_exports SET status = 'failed', error = ?, completed_at = CURRENT_TIMESTAMP WHERE id = ?",
+		reason,
+		id,
+	)
+	return err
+}
+
+// GetReadyDataExport returns the export a download token is for, while
+// its link works.
+func GetReadyDataExport(ctx context.Context, tokenHash string) (DataExport, error) {
+	db := Connect()
+	defer db.Close()
+
+	export, err := scanDataExport(queryRowContext(
+		ctx,
+		db,
+		"GetReadyDataExport",
+		"SELECT "+dataExportColumns+" FROM data_exports WHERE token_hash = ? AND status = 'ready' AND expires_at > CURRENT_TIMESTAMP",
+		tokenHash,
+	))
+	if err == sql.ErrNoRows {
+		return export, ErrNotFound
+	}
+	return export, err
+}
+
+func queryDataExports(ctx context.Context, name, query string, args ...any) ([]DataExport, error) {
+	db := Connect()
+	defer db.Close()
+
+	result, err := queryContext(ctx, db, name, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer result.Close()
+
+	exports := []DataExport{}
+	for result.Next() {
+		export, err := scanDataExport(result)
+		if err != nil {
+			return nil, err
+		}
+		exports = append(exports, export)
+	}
+	return exports, result.Err()
+}
+
+// GetWaitingDataExports lists the exports no worker is generating: pending
+// ones older than olderThan, and runs stuck longer than that.
+func GetWaitingDataExports(ctx context.Context, olderThan time.Duration) ([]DataExport, error) {
+	return queryDataExports(
+		ctx,
+		"GetWaitingDataExports",
+		`SELECT `+dataExportColumns+` FROM data_exports
+		WHERE (status = 'pending' AND created_at < DATE_SUB(CURRENT_TIMESTAMP, INTERVAL ? SECOND))
+			OR (status = 'running' AND started_at < DATE_SUB(CURRENT_TIMESTAMP, INTERVAL ? SECOND))
+		ORDER BY id`,
+		int(olderThan.Seconds()),
+		int(olderThan.Seconds()),
+	)
+}
+
+// GetExpiredDataExports lists the ready exports whose link stopped working.
+func GetExpiredDataExports(ctx context.Context) ([]DataExport, error) {
+	return queryDataExports(
+		ctx,
+		"GetExpiredDataExports",
+		"SELECT "+dataExportColumns+" FROM data_exports WHERE status = 'ready' AND expires_at <= CURRENT_TIMESTAMP ORDER BY id",
+	)
+}
+
+// ExpireDataExport forgets the archive of an export once it is deleted.
+func ExpireDataExport(ctx context.Context, id int) error {
+	db := Connect()
+	defer db.Close()
+
+	_, err := execContext(
+		ctx,
+		db,
+		"ExpireDataExport",
+		"UPDATE data_exports SET status = 'expired', path = '', token_hash = NULL WHERE id = ?",
+		id,
+	)
+	return err
+}
+
+func SaveContactMessage(ctx context.Context, name, email, message string) error {
+	db := Connect()
+	defer db.Close()
+
+	_, err := execContext(
+		ctx,
+		db,
+		"SaveContactMessage",
+		"INSERT INTO contact_messages (name, email, message) VALUES (?, ?, ?)",
+		name,
+		email,
+		message,
+	)
+	return err
+}
+
+// GetContactMessages returns the contact form messages sent from email.
+func GetContactMessages(ctx context.Context, email string) ([]ContactMessage, error) {
+	db := Connect()
+	defer db.Close()
+
+	result, err := queryContext(
+		ctx,
+		db,
+		"GetContactMessages",
+		"SELECT id, name, email, message, created_at FROM contact_messages WHERE email = ? ORDER BY id",
+		email,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer result.Close()
+
+	messages := []ContactMessage{}
+	for result.Next() {
+		var message ContactMessage
+		var createdAt []byte
+		if err := result.Scan(&message.ID, &message.Name, &message.Email, &message.Message, &createdAt); err != nil {
+			return nil, err
+		}
+		if message.CreatedAt, err = time.Parse(time.DateTime, string(createdAt)); err != nil {
+			return nil, err
+		}
+		messages = append(messages, message)
+	}
+	return messages, result.Err()
+}
+
+// GetCustomerOrderIDs lists the orders of a customer, oldest first.
+func GetCustomerOrderIDs(ctx context.Context, customerID int) ([]int, error) {
+	db := Connect()
+	defer db.Close()
+
+	result, err := queryContext(ctx, db, "GetCustomerOrderIDs", "SELECT id FROM orders WHERE customer_id = ? ORDER BY id", customerID)
+	if err != nil {
+		return nil, err
+	}
+	defer result.Close()
+
+	ids := []int{}
+	for result.Next() {
+		var id int
+		if err := result.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, result.Err()
+}
@@ -0,0 +1,191 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// ErrTooManyImages means a product already has as many images as it may.
+var ErrTooManyImages = errors.New("too many images")
+
+// ProductImage is an uploaded picture of a product. Its blobs are stored
+// under Key: the original as uploaded plus resized variants in Format. The
+// image at the lowest position is the main one.
+type ProductImage struct {
+	ID        int
+	ProductID int
+	Position  int
+	Key       string `json:"-"`
+	// Original is the format of the uploaded file: jpeg, png, gif or webp.
+	Original string `json:"-"`
+	// Format is jpeg, or png for images with transparency.
+	Format string `json:"-"`
+	Width  int
+	Height int
+	Bytes  int
+	Alt    string
+	// URLs maps variant names to where they are served.
+	URLs      map[string]string `json:",omitempty"`
+	CreatedAt time.Time
+}
+
+const productImageColumns = "id, product_id, position, storage_key, original_format, variant_format, width, height, bytes, alt, created_at"
+
+func scanProductImage(row rowScanner) (ProductImage, error) {
+	var image ProductImage
+	var createdAt []byte
+	err := row.Scan(
+		&image.ID,
+		&image.ProductID,
+		&image.Position,
+		&image.Key,
+		&image.Original,
+		&image.Format,
+		&image.Width,
+		&image.Height,
+		&image.Bytes,
+		&image.Alt,
+		&createdAt,
+	)
+	if err != nil {
+		return image, err
+	}
+	image.CreatedAt, err = time.Parse(time.DateTime, string(createdAt))
+	return image, err
+}
+
+// GetProductImages lists the images of a product in display order.
+func GetProductImages(ctx context.Context, productID int) ([]ProductImage, error) {
+	db := Connect()
+	defer db.Close()
+
+	result, err := queryContext(
+		ctx,
+		db,
+		"GetProductImages",
+		"SELECT "+productImageColumns+" FROM product_images WHERE product_id = ? ORDER BY position, id",
+		productID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer result.Close()
+
+	images := []ProductImage{}
+	for result.Next() {
+		image, err := scanProductImage(result)
+		if err != nil {
+			return nil, err
+		}
+		images = append(images, image)
+	}
+	return images, result.Err()
+}
+
+func GetProductImage(ctx context.Context, id int) (ProductImage, error) {
+	db := Connect()
+	defer db.Close()
+
+	image, err := scanProductImage(queryRowContext(
+		ctx,
+		db,
+		"GetProductImage",
+		"SELECT "+productImageColumns+" FROM product_images WHERE id = ?",
+		id,
+	))
+	if err == sql.ErrNoRows {
+		return image, ErrNotFound
+	}
+	return image, err
+}
+
+// AddProductImage puts an image after the other images of its product,
+// unless the product already has max images.
+func AddProductImage(ctx context.Context, image ProductImage, max int) (ProductImage, error) {
+	var id int64
+	err := inTx(ctx, "AddProductImage", func(tx *sql.Tx) error {
+		// Lock the product so two uploads cannot take the same position or
+		// both take the last free one.
+		var productID int
+		err := tx.QueryRowContext(ctx, "SELECT product_id FROM products WHERE product_id = ? AND deleted_at IS NULL FOR UPDATE", image.ProductID).Scan(&productID)
+		if err == sql.ErrNoRows {
+			return ErrNotFound
+		}
+		if err != nil {
+			return err
+		}
+
+		var count int
+		if err := tx.QueryRowContext(ctx, "SELECT COUNT(*) FROM product_images WHERE product_id = ?", image.ProductID).Scan(&count); err != nil {
+			return err
+		}
+		if count >= max {
+			return ErrTooManyImages
+		}
+
+		result, err := tx.ExecContext(
+			ctx,
+			`INSERT INTO product_images (product_id, position, storage_key, original_format, variant_format, width, height, bytes, alt)
+			SELECT ?, COALESCE(MAX(position) + 1, 0), ?, ?, ?, ?, ?, ?, ? FROM product_images WHERE product_id = ?`,
+			image.ProductID,
+			image.Key,
+			image.Original,
+			image.Format,
+			image.Width,
+			image.Height,
+			image.Bytes,
+			image.Alt,
+			image.ProductID,
+		)
+		if err != nil {
+			return err
+		}
+		id, err = result.LastInsertId()
+		return err
+	})
+	if err != nil {
+		return image, err
+	}
+	return GetProductImage(ctx, int(id))
+}
+
+// DeleteProductImage removes an image and returns it, so its blobs can be
+// deleted too.
+func DeleteProductImage(ctx context.Context, id int) (ProductImage, error) {
+	var image ProductImage
+	err := inTx(ctx, "DeleteProductImage", func(tx *sql.Tx) error {
+		var err error
+		image, err = scanProductImage(tx.QueryRowContext(ctx, "SELECT "+productImageColumns+" FROM product_images WHERE id = ? FOR UPDATE", id))
+		if err == sql.ErrNoRows {
+			return ErrNotFound
+		}
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, "DELETE FROM product_images WHERE id = ?", id)
+		return err
+	})
+	return image, err
+}
+
+// ReorderProductImages puts the images of a product in the order of ids,
+// which must list each of them once.
+func ReorderProductImages(ctx context.Context, productID int, ids []int) error {
+	return inTx(ctx, "ReorderProductImages", func(tx *sql.Tx) error {
+		for position, id := range ids {
+			_, err := tx.ExecContext(
+				ctx,
+				"UPDATE product_images SET position = ? WHERE id = ? AND product_id = ?",
+				position,
+				id,
+				productID,
+			)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,497 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sort"
+	"time"
+)
+
+const (
+	MovementReceipt    = "receipt"
+	MovementSale       = "sale"
+	MovementAdjustment = "adjustment"
+	MovementReturn     = "return"
+)
+
+const (
+	ReservationActive    = "active"
+	ReservationCommitted = "committed"
+	ReservationReleased  = "released"
+)
+
+var (
+	ErrInsufficientStock = errors.New("insufficient stock")
+	// ErrNoReservations means nothing was ever reserved under a reference,
+	// so there is no stock to sell for it.
+	ErrNoReservations = errors.New("no stock was reserved for this reference")
+)
+
+// InsufficientStockError names the variant that could not be reserved.
+type InsufficientStockError struct {
+	VariantID int
+	Available int
+}
+
+func (e *InsufficientStockError) Error() string {
+	return ErrInsufficientStock.Error()
+}
+
+func (e *InsufficientStockError) Unwrap() error {
+	return ErrInsufficientStock
+}
+
+type Movement struct {
+	ID         int
+	VariantID  int
+	Kind       string
+	Quantity   int
+	StockAfter int
+	Reference  string
+	Actor      string
+	Note       string
+	CreatedAt  time.Time
+}
+
+// StockChange reports how much of a variant could be sold before and after
+// a change, so callers can tell when it crossed a threshold.
+type StockChange struct {
+	VariantID       int
+	SKU             string
+	AvailableBefore int
+	AvailableAfter  int
+}
+
+type StockLevel struct {
+	VariantID int
+	ProductID int
+	SKU       string
+	Stock     int
+	Reserved  int
+	Available int
+}
+
+type sqlExecer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// inTx runs fn inside a transaction traced as name.
+func inTx(ctx context.Context, name string, fn func(tx *sql.Tx) error) error {
+	ctx, span := startSpan(ctx, name, "BEGIN")
+	db := Connect()
+	defer db.Close()
+
+	err := func() error {
+		tx, err := db.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+
+		if err := fn(tx); err != nil {
+			return err
+		}
+		return tx.Commit()
+	}()
+
+	endSpan(span, err)
+	return err
+}
+
+// ReserveStock holds quantities of several variants for reference, all or
+// nothing, until ttl passes. The conditional update never lets reserved
+// stock exceed what is on hand, however many checkouts run at once.
+func ReserveStock(ctx context.Context, reference string, quantities map[int]int, ttl time.Duration) ([]StockChange, error) {
+	var changes []StockChange
+
+	// Lock variants in id order so concurrent reservations cannot deadlock.
+	ids := make([]int, 0, len(quantities))
+	for id := range quantities {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+
+	err := inTx(ctx, "ReserveStock", func(tx *sql.Tx) error {
+		for _, id := range ids {
+			quantity := quantities[id]
+			result, err := tx.ExecContext(
+				ctx,
+				"UPDATE product_variants SET reserved = reserved + ? WHERE id = ? AND stock - reserved >= ?",
+				quantity,
+				id,
+				quantity,
+			)
+			if err != nil {
+				return err
+			}
+			if affected, err := result.RowsAffected(); err != nil {
+				return err
+			} else if affected == 0 {
+				var available int
+				err := tx.QueryRowContext(ctx, "SELECT stock - reserved FROM product_variants WHERE id = ?", id).Scan(&available)
+				if err == sql.ErrNoRows {
+					return ErrNotFound
+				}
+				if err != nil {
+					return err
+				}
+				return &InsufficientStockError{VariantID: id, Available: available}
+			}
+
+			_, err = tx.ExecContext(
+				ctx,
+				"INSERT INTO stock_reservations (variant_id, quantity, reference, expires_at) VALUES (?, ?, ?, DATE_ADD(CURRENT_TIMESTAMP, INTERVAL ? SECOND))",
+				id,
+				quantity,
+				reference,
+				int(ttl.Seconds()),
+			)
+			if err != nil {
+				return err
+			}
+
+			sku, _, available, err := availableStock(ctx, tx, id)
+			if err != nil {
+				return err
+			}
+			changes = append(changes, StockChange{
+				VariantID:       id,
+				SKU:             sku,
+				AvailableBefore: available + quantity,
+				AvailableAfter:  available,
+			})
+		}
+		return nil
+	})
+
+	return changes, err
+}
+
+type reservation struct {
+	id        int
+	variantID int
+	quantity  int
+}
+
+func lockReservations(ctx context.Context, tx *sql.Tx, where string, args ...any) ([]reservation, error) {
+	rows, err := tx.QueryContext(
+		ctx,
+		"SELECT id, variant_id, quantity FROM stock_reservations WHERE status = 'active' AND "+where+" ORDER BY variant_id FOR UPDATE",
+		args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var reservations []reservation
+	for rows.Next() {
+		var r reservation
+		if err := rows.Scan(&r.id, &r.variantID, &r.quantity); err != nil {
+			return nil, err
+		}
+		reservations = append(reservations, r)
+	}
+	return reservations, rows.Err()
+}
+
+func availableStock(ctx context.Context, q sqlExecer, variantID int) (string, int, int, error) {
+	var sku string
+	var stock, available int
+	err := q.QueryRowContext(
+		ctx,
+		"SELECT sku, stock, stock - reserved FROM product_variants WHERE id = ? FOR UPDATE",
+		variantID,
+	).Scan(&sku, &stock, &available)
+	if err == sql.ErrNoRows {
+		return "", 0, 0, ErrNotFound
+	}
+	return sku, stock, available, err
+}
+
+// commitReservations turns the reservations of reference into sales: the
+// stock leaves the warehouse and a sale is written to the ledger. What is
+// available does not change for active reservations, which already took it
+// out. Reservations the sweep released in the meantime are taken again with
+// the same conditional update as ReserveStock, failing with an
+// InsufficientStockError when the stock went to someone else. Committing
+// twice does nothing the second time.
+func commitReservations(ctx context.Context, tx *sql.Tx, reference, actor string) error {
+	rows, err := tx.QueryContext(
+		ctx,
+		"SELECT id, variant_id, quantity, status FROM stock_reservations WHERE reference = ? ORDER BY variant_id FOR UPDATE",
+		reference,
+	)
+	if err != nil {
+		return err
+	}
+	var reservations []reservation
+	var statuses []string
+	for rows.Next() {
+		var r reservation
+		var status string
+		if err := rows.Scan(&r.id, &r.variantID, &r.quantity, &status); err != nil {
+			rows.Close()
+			return err
+		}
+		reservations = append(reservations, r)
+		statuses = append(statuses, status)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if len(reservations) == 0 {
+		return ErrNoReservations
+	}
+
+	for i, r := range reservations {
+		switch statuses[i] {
+		case ReservationCommitted:
+			continue
+		case ReservationActive:
+			_, err = tx.ExecContext(
+				ctx,
+				"UPDATE product_variants SET stock = stock - ?, reserved = reserved - ? WHERE id = ?",
+				r.quantity,
+				r.quantity,
+				r.variantID,
+			)
+		case ReservationReleased:
+			var result sql.Result
+			result, err = tx.ExecContext(
+				ctx,
+				"UPDATE product_variants SET stock = stock - ? WHERE id = ? AND stock - reserved >= ?",
+				r.quantity,
+				r.variantID,
+				r.quantity,
+			)
+			if err == nil {
+				if affected, err := result.RowsAffected(); err != nil {
+					return err
+				} else if affected == 0 {
+					_, _, available, err := availableStock(ctx, tx, r.variantID)
+					if err != nil {
+						return err
+					}
+					return &InsufficientStockError{VariantID: r.variantID, Available: available}
+				}
+			}
+		}
+		if err != nil {
+			return err
+		}
+
+		var stock int
+		if err := tx.QueryRowContext(ctx, "SELECT stock FROM product_variants WHERE id = ?", r.variantID).Scan(&stock); err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(
+			ctx,
+			"INSERT INTO stock_movements (variant_id, kind, quantity, stock_after, reference, actor) VALUES (?, ?, ?, ?, ?, ?)",
+			r.variantID,
+			MovementSale,
+			-r.quantity,
+			stock,
+			reference,
+			actor,
+		)
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, "UPDATE stock_reservations SET status = ? WHERE id = ?", ReservationCommitted, r.id)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReleaseReservations gives the stock held for reference back.
+func ReleaseReservations(ctx context.Context, reference string) ([]StockChange, error) {
+	var changes []StockChange
+	err := inTx(ctx, "ReleaseReservations", func(tx *sql.Tx) error {
+		reservations, err := lockReservations(ctx, tx, "reference = ?", reference)
+		if err != nil {
+			return err
+		}
+		changes, err = release(ctx, tx, reservations)
+		return err
+	})
+	return changes, err
+}
+
+// ReleaseExpiredReservations gives back the stock of every reservation past
+// its expiry, with one change for each reservation released.
+func ReleaseExpiredReservations(ctx context.Context) ([]StockChange, error) {
+	var changes []StockChange
+	err := inTx(ctx, "ReleaseExpiredReservations", func(tx *sql.Tx) error {
+		reservations, err := lockReservations(ctx, tx, "expires_at < CURRENT_TIMESTAMP")
+		if err != nil {
+			return err
+		}
+		changes, err = release(ctx, tx, reservations)
+		return err
+	})
+	return changes, err
+}
+
+func release(ctx context.Context, tx *sql.Tx, reservations []reservation) ([]StockChange, error) {
+	var changes []StockChange
+	for _, r := range reservations {
+		sku, _, available, err := availableStock(ctx, tx, r.variantID)
+		if err != nil {
+			return nil, err
+		}
+		_, err = tx.ExecContext(
+			ctx,
+			"UPDATE product_variants SET reserved = reserved - ? WHERE id = ?",
+			r.quantity,
+			r.variantID,
+		)
+		if err != nil {
+			return nil, err
+		}
+		_, err = tx.ExecContext(ctx, "UPDATE stock_reservations SET status = ? WHERE id = ?", ReservationReleased, r.id)
+		if err != nil {
+			return nil, err
+		}
+		changes = append(changes, StockChange{
+			VariantID:       r.variantID,
+			SKU:             sku,
+			AvailableBefore: available,
+			AvailableAfter:  available + r.quantity,
+		})
+	}
+	return changes, nil
+}
+
+// RecordMovement changes the stock on hand of a variant by quantity and
+// writes the movement to the ledger. Stock can never drop below what is
+// reserved.
+func RecordMovement(ctx context.Context, variantID int, kind string, quantity int, reference, actor, note string) (StockChange, error) {
+	var change StockChange
+	err := inTx(ctx, "RecordMovement", func(tx *sql.Tx) error {
+		var err error
+		change, err = recordMovement(ctx, tx, variantID, kind, quantity, reference, actor, note)
+		return err
+	})
+	return change, err
+}
+
+func recordMovement(ctx context.Context, tx *sql.Tx, variantID int, kind string, quantity int, reference, actor, note string) (StockChange, error) {
+	sku, stock, available, err := availableStock(ctx, tx, variantID)
+	if err != nil {
+		return StockChange{}, err
+	}
+	if available+quantity < 0 {
+		return StockChange{}, &InsufficientStockError{VariantID: variantID, Available: available}
+	}
+
+	_, err = tx.ExecContext(
+		ctx,
+		"UPDATE product_variants SET stock = stock + ? WHERE id = ?",
+		quantity,
+		variantID,
+	)
+	if err != nil {
+		return StockChange{}, err
+	}
+	_, err = tx.ExecContext(
+		ctx,
+		"INSERT INTO stock_movements (variant_id, kind, quantity, stock_after, reference, actor, note) VALUES (?, ?, ?, ?, ?, ?, ?)",
+		variantID,
+		kind,
+		quantity,
+		stock+quantity,
+		reference,
+		actor,
+		note,
+	)
+	if err != nil {
+		return StockChange{}, err
+	}
+
+	return StockChange{
+		VariantID:       variantID,
+		SKU:             sku,
+		AvailableBefore: available,
+		AvailableAfter:  available + quantity,
+	}, nil
+}
+
+// GetStockMovements returns the ledger of a variant, newest first.
+func GetStockMovements(ctx context.Context, variantID int) ([]Movement, error) {
+	db := Connect()
+	defer db.Close()
+
+	result, err := queryContext(
+		ctx,
+		db,
+		"GetStockMovements",
+		"SELECT id, variant_id, kind, quantity, stock_after, reference, actor, note, created_at FROM stock_movements WHERE variant_id = ? ORDER BY id DESC",
+		variantID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer result.Close()
+
+	movements := []Movement{}
+	for result.Next() {
+		var movement Movement
+		var createdAt []byte
+		err = result.Scan(
+			&movement.ID,
+			&movement.VariantID,
+			&movement.Kind,
+			&movement.Quantity,
+			&movement.StockAfter,
+			&movement.Reference,
+			&movement.Actor,
+			&movement.Note,
+			&createdAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		movement.CreatedAt, err = time.Parse(time.DateTime, string(createdAt))
+		if err != nil {
+			return nil, err
+		}
+		movements = append(movements, movement)
+	}
+	return movements, result.Err()
+}
+
+// GetLowStock lists the variants of live products with at most threshold
+// units available.
+func GetLowStock(ctx context.Context, threshold int) ([]StockLevel, error) {
+	db := Connect()
+	defer db.Close()
+
+	result, err := queryContext(
+		ctx,
+		db,
+		"GetLowStock",
+		"SELECT v.id, v.product_id, v.sku, v.stock, v.reserved, v.stock - v.reserved AS available FROM product_variants AS v INNER JOIN products AS p ON p.product_id = v.product_id WHERE p.deleted_at IS NULL AND v.stock - v.reserved <= ? ORDER BY available, v.id",
+		threshold,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer result.Close()
+
+	levels := []StockLevel{}
+	for result.Next() {
+		var level StockLevel
+		err = result.Scan(&level.VariantID, &level.ProductID, &level.SKU, &level.Stock, &level.Reserved, &level.Available)
+		if err != nil {
+			return nil, err
+		}
+		levels = append(levels, level)
+	}
+	return levels, result.Err()
+}
@@ -0,0 +1,122 @@
+package database_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"basicthreads/internal/database"
+	"basicthreads/internal/dbtest"
+)
+
+// TestStockAccounting runs one variant through reservations, a sale, a
+// cancellation and hand-made movements, checking the stock on hand, what is
+// reserved and the changes to what can be sold after every step.
+func TestStockAccounting(t *testing.T) {
+	ctx := context.Background()
+	db := dbtest.Open(t)
+	customerID := dbtest.Customer(t, db, "stock@example.com", "secret")
+	productID, variantID := dbtest.Variant(t, db, "TEE-M", "10.00", 10)
+	orderID := dbtest.Order(t, db, customerID, productID, variantID, "pending", "10.00", 6)
+	order, err := database.GetOrder(ctx, orderID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reserve := func(reference string, quantity int, ttl time.Duration) func() ([]database.StockChange, error) {
+		return func() ([]database.StockChange, error) {
+			return database.ReserveStock(ctx, reference, map[int]int{variantID: quantity}, ttl)
+		}
+	}
+	record := func(kind string, quantity int) func() ([]database.StockChange, error) {
+		return func() ([]database.StockChange, error) {
+			change, err := database.RecordMovement(ctx, variantID, kind, quantity, "", "test", "")
+			return []database.StockChange{change}, err
+		}
+	}
+	status := func(from, to, stock string) func() ([]database.StockChange, error) {
+		return func() ([]database.StockChange, error) {
+			return database.ChangeOrderStatus(ctx, orderID, from, to, stock, "test", "")
+		}
+	}
+
+	type change struct{ before, after int }
+	steps := []struct {
+		name     string
+		do       func() ([]database.StockChange, error)
+		err      error
+		stock    int
+		reserved int
+		changes  []change
+	}{
+		{"reserve a checkout", reserve("CHECKOUT-1", 4, time.Hour), nil, 10, 4, []change{{10, 6}}},
+		{"reserve more than is left", reserve("CHECKOUT-2", 7, time.Hour), database.ErrInsufficientStock, 10, 4, nil},
+		{"release the checkout", func() ([]database.StockChange, error) {
+			return database.ReleaseReservations(ctx, "CHECKOUT-1")
+		}, nil, 10, 0, []change{{6, 10}}},
+		{"reserve the order", reserve(order.Reference, 6, time.Hour), nil, 10, 6, []change{{10, 4}}},
+		{"sell the order", status("pending", "paid", database.StockCommit), nil, 4, 0, nil},
+		{"sell it again", status("pending", "paid", database.StockCommit), database.ErrStatusChanged, 4, 0, nil},
+		{"adjust below zero", record(database.MovementAdjustment, -5), database.ErrInsufficientStock, 4, 0, nil},
+		{"sell the rest by hand", record(database.MovementAdjustment, -4), nil, 0, 0, []change{{4, 0}}},
+		{"receive stock", record(database.MovementReceipt, 3), nil, 3, 0, []change{{0, 3}}},
+		{"let a reservation lapse", reserve("CHECKOUT-3", 2, -time.Minute), nil, 3, 2, []change{{3, 1}}},
+		{"sweep lapsed reservations", func() ([]database.StockChange, error) {
+			return database.ReleaseExpiredReservations(ctx)
+		}, nil, 3, 0, []change{{1, 3}}},
+		{"cancel the paid order", status("paid", "cancelled", database.StockReturn), nil, 9, 0, []change{{3, 9}}},
+	}
+	for _, step := range steps {
+		changes, err := step.do()
+		if !errors.Is(err, step.err) {
+			t.Fatalf("%s: err = %v, want %v", step.name, err, step.err)
+		}
+
+		variant, err := database.GetVariant(ctx, variantID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if variant.Stock != step.stock || variant.Reserved != step.reserved {
+			t.Errorf("%s: stock %d, reserved %d, want %d and %d", step.name, variant.Stock, variant.Reserved, step.stock, step.reserved)
+		}
+
+		if step.err != nil || step.changes == nil {
+			continue
+		}
+		got := []change{}
+		for _, c := range changes {
+			if c.VariantID != variantID || c.SKU != "TEE-M" {
+				t.Errorf("%s: change of %d %q, want %d TEE-M", step.name, c.VariantID, c.SKU, variantID)
+			}
+			got = append(got, change{c.AvailableBefore, c.AvailableAfter})
+		}
+		if len(got) != len(step.changes) {
+			t.Errorf("%s: changes %v, want %v", step.name, got, step.changes)
+			continue
+		}
+		for i := range got {
+			if got[i] != step.changes[i] {
+				t.Errorf("%s: changes %v, want %v", step.name, got, step.changes)
+				break
+			}
+		}
+	}
+
+	movements, err := database.GetStockMovements(ctx, variantID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kinds := []string{}
+	for _, m := range movements {
+		kinds = append(kinds, m.Kind)
+	}
+	want := []string{database.MovementReturn, database.MovementReceipt, database.MovementAdjustment, database.MovementSale}
+	if len(kinds) != len(want) {
+		t.Fatalf("movements %v, want %v", kinds, want)
+	}
+	for i := range want {
+		if kinds[i] != want[i] {
+			t.Fatalf("movements %v, want %v", kinds, want)
+		}
+	}
+}
@@ -0,0 +1,754 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"basicthreads/internal/money"
+)
+
+// ErrStatusChanged is returned when an order moved to another status
+// between reading it and changing it.
+var ErrStatusChanged = errors.New("order status changed")
+
+// ErrCartChanged is returned when the cart of a checkout was changed or
+// ordered by another request while the order was being made.
+var ErrCartChanged = errors.New("cart changed during checkout")
+
+// OrderItem is a line of an order. Names and prices are copied from the
+// catalog at checkout and never change afterwards.
+type OrderItem struct {
+	ID        int
+	ProductID *int `json:",omitempty"`
+	VariantID *int `json:",omitempty"`
+	Name      string
+	SKU       string `json:",omitempty"`
+	Size      string `json:",omitempty"`
+	Color     string `json:",omitempty"`
+	Image     string
+	UnitPrice money.Money
+	Quantity  int
+	LineTotal money.Money
+	// Discount is the share of the order discounts taken off this line.
+	Discount money.Money
+	TaxClass string
+	Tax      money.Money
+}
+
+// Address is a postal address, as kept in the address book of a customer
+// or as it was when an order was placed.
+type Address struct {
+	Name       string
+	Line1      string
+	Line2      string `json:",omitempty"`
+	City       string
+	Region     string `json:",omitempty"`
+	PostalCode string `json:",omitempty"`
+	// Country is the two letter ISO code.
+	Country string
+	Phone   string `json:",omitempty"`
+}
+
+// OrderTax is one tax charged on an order.
+type OrderTax struct {
+	Name    string
+	Rate    string
+	Taxable money.Money
+	Amount  money.Money
+}
+
+// OrderDiscount is a promotion applied to an order, copied at checkout.
+type OrderDiscount struct {
+	PromotionID *int   `json:",omitempty"`
+	Code        string `json:",omitempty"`
+	Name        string
+	Amount      money.Money
+}
+
+type StatusChange struct {
+	From      string
+	To        string
+	Actor     string
+	Note      string
+	CreatedAt time.Time
+}
+
+type Order struct {
+	ID         int
+	Reference  string
+	CustomerID int
+	Email      string
+	Status     string
+	Currency   string
+	Subtotal   money.Money
+	Discount   money.Money
+	Tax        money.Money
+	// PricesIncludeTax is true when Tax is already part of Subtotal, and
+	// false when it was added on top.
+	PricesIncludeTax bool
+	Shipping         money.Money
+	Total            money.Money
+	ShippingAddress  Address
+	BillingAddress   Address
+	// ShippingMethod, ShippingCarrier and ShippingService are copied from
+	// the method picked at checkout.
+	ShippingMethod  string
+	ShippingCarrier string
+	ShippingService string `json:",omitempty"`
+	// Weight is what the items weigh, in grams.
+	Weight    int
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	Items     []OrderItem     `json:",omitempty"`
+	Discounts []OrderDiscount `json:",omitempty"`
+	Taxes     []OrderTax      `json:",omitempty"`
+	Shipments []Shipment      `json:",omitempty"`
+	History   []StatusChange  `json:",omitempty"`
+}
+
+const orderColumns = "id, reference, customer_id, email, status, currency, subtotal, discount, tax, prices_include_tax, shipping, total, " +
+	"ship_name, ship_line1, ship_line2, ship_city, ship_region, ship_postal_code, ship_country, ship_phone, " +
+	"bill_name, bill_line1, bill_line2, bill_city, bill_region, bill_postal_code, bill_country, bill_phone, " +
+	"shipping_method, shipping_carrier, shipping_service, weight, created_at, updated_at"
+
+func scanOrder(row rowScanner) (Order, error) {
+	var order Order
+	var subtotal, discount, tax, shipping, total string
+	var createdAt, updatedAt []byte
+	err := row.Scan(
+		&order.ID,
+		&order.Reference,
+		&order.CustomerID,
+		&order.Email,
+		&order.Status,
+		&order.Currency,
+		&subtotal,
+		&discount,
+		&tax,
+		&order.PricesIncludeTax,
+		&shipping,
+		&total,
+		&order.ShippingAddress.Name,
+		&order.ShippingAddress.Line1,
+		&order.ShippingAddress.Line2,
+		&order.ShippingAddress.City,
+		&order.ShippingAddress.Region,
+		&order.ShippingAddress.PostalCode,
+		&order.ShippingAddress.Country,
+		&order.ShippingAddress.Phone,
+		&order.BillingAddress.Name,
+		&order.BillingAddress.Line1,
+		&order.BillingAddress.Line2,
+		&order.BillingAddress.City,
+		&order.BillingAddress.Region,
+		&order.BillingAddress.PostalCode,
+		&order.BillingAddress.Country,
+		&order.BillingAddress.Phone,
+		&order.ShippingMethod,
+		&order.ShippingCarrier,
+		&order.ShippingService,
+		&order.Weight,
+		&createdAt,
+		&updatedAt,
+	)
+	if err != nil {
+		return order, err
+	}
+	// Amounts are in the currency of the order, which the scan only just
+	// read.
+	if order.Subtotal, err = money.Parse(subtotal, order.Currency); err != nil {
+		return order, err
+	}
+	if order.Discount, err = money.Parse(discount, order.Currency); err != nil {
+		return order, err
+	}
+	if order.Tax, err = money.Parse(tax, order.Currency); err != nil {
+		return order, err
+	}
+	if order.Shipping, err = money.Parse(shipping, order.Currency); err != nil {
+		return order, err
+	}
+	if order.Total, err = money.Parse(total, order.Currency); err != nil {
+		return order, err
+	}
+	if order.CreatedAt, err = time.Parse(time.DateTime, string(createdAt)); err != nil {
+		return order, err
+	}
+	order.UpdatedAt, err = time.Parse(time.DateTime, string(updatedAt))
+	return order, err
+}
+
+// CreateOrder saves a pending order with its items and discounts, counts
+// the promotions it used and empties the cart it came from, all in one
+// transaction. It fails with ErrPromotionUsedUp when a promotion reached a
+// limit in the meantime.
+func CreateOrder(ctx context.Context, order Order, cartID int, actor string) (Order, error) {
+	err := inTx(ctx, "CreateOrder", func(tx *sql.Tx) error {
+		if err := lockCartLines(ctx, tx, cartID, order.Items); err != nil {
+			return err
+		}
+
+		result, err := tx.ExecContext(
+			ctx,
+			`INSERT INTO orders (reference, customer_id, email, status, currency, subtotal, discount, tax, prices_include_tax, shipping, total,
+				ship_name, ship_line1, ship_line2, ship_city, ship_region, ship_postal_code, ship_country, ship_phone,
+				bill_name, bill_line1, bill_line2, bill_city, bill_region, bill_postal_code, bill_country, bill_phone,
+				shipping_method, shipping_carrier, shipping_service, weight)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			order.Reference,
+			order.CustomerID,
+			order.Email,
+			order.Status,
+			order.Currency,
+			order.Subtotal,
+			order.Discount,
+			order.Tax,
+			order.PricesIncludeTax,
+			order.Shipping,
+			order.Total,
+			order.ShippingAddress.Name,
+			order.ShippingAddress.Line1,
+			order.ShippingAddress.Line2,
+			order.ShippingAddress.City,
+			order.ShippingAddress.Region,
+			order.ShippingAddress.PostalCode,
+			order.ShippingAddress.Country,
+			order.ShippingAddress.Phone,
+			order.BillingAddress.Name,
+			order.BillingAddress.Line1,
+			order.BillingAddress.Line2,
+			order.BillingAddress.City,
+			order.BillingAddress.Region,
+			order.BillingAddress.PostalCode,
+			order.BillingAddress.Country,
+			order.BillingAddress.Phone,
+			order.ShippingMethod,
+			order.ShippingCarrier,
+			order.ShippingService,
+			order.Weight,
+		)
+		if err != nil {
+			return err
+		}
+		id, err := result.LastInsertId()
+		if err != nil {
+			return err
+		}
+		order.ID = int(id)
+
+		for i, item := range order.Items {
+			result, err := tx.ExecContext(
+				ctx,
+				"INSERT INTO order_items (order_id, product_id, variant_id, name, sku, size, color, image, unit_price, quantity, line_total, discount, tax_class, tax) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+				order.ID,
+				item.ProductID,
+				item.VariantID,
+				item.Name,
+				item.SKU,
+				item.Size,
+				item.Color,
+				item.Image,
+				item.UnitPrice,
+				item.Quantity,
+				item.LineTotal,
+				item.Discount,
+				item.TaxClass,
+				item.Tax,
+			)
+			if err != nil {
+				return err
+			}
+			id, err := result.LastInsertId()
+			if err != nil {
+				return err
+			}
+			order.Items[i].ID = int(id)
+		}
+
+		for _, discount := range order.Discounts {
+			if discount.PromotionID != nil {
+				if err := redeemPromotion(ctx, tx, *discount.PromotionID, order.ID, order.CustomerID); err != nil {
+					return err
+				}
+			}
+			_, err := tx.ExecContext(
+				ctx,
+				"INSERT INTO order_discounts (order_id, promotion_id, code, name, amount) VALUES (?, ?, ?, ?, ?)",
+				order.ID,
+				discount.PromotionID,
+				discount.Code,
+				discount.Name,
+				discount.Amount,
+			)
+			if err != nil {
+				return err
+			}
+		}
+
+		for _, tax := range order.Taxes {
+			_, err := tx.ExecContext(
+				ctx,
+				"INSERT INTO order_taxes (order_id, name, rate, taxable, amount) VALUES (?, ?, ?, ?, ?)",
+				order.ID,
+				tax.Name,
+				tax.Rate,
+				tax.Taxable,
+				tax.Amount,
+			)
+			if err != nil {
+				return err
+			}
+		}
+
+		if err := insertStatusChange(ctx, tx, order.ID, "", order.Status, actor, ""); err != nil {
+			return err
+		}
+
+		if _, err := tx.ExecContext(ctx, "DELETE FROM cart_items WHERE cart_id = ?", cartID); err != nil {
+			return err
+		}
+		// The code was spent on this order; the next cart starts without it.
+		_, err = tx.ExecContext(ctx, "UPDATE carts SET discount_code = NULL WHERE id = ?", cartID)
+		return err
+	})
+	if err != nil {
+		return order, err
+	}
+
+	return GetOrder(ctx, order.ID)
+}
+
+// lockCartLines locks a cart for the rest of tx and checks that it still
+// holds exactly the lines of items. A checkout running at the same time,
+// such as from a double click, waits here and then finds the cart emptied,
+// so the same lines never become two orders.
+func lockCartLines(ctx context.Context, tx *sql.Tx, cartID int, items []OrderItem) error {
+	var id int
+	err := tx.QueryRowContext(ctx, "SELECT id FROM carts WHERE id = ? FOR UPDATE", cartID).Scan(&id)
+	if err == sql.ErrNoRows {
+		return ErrCartChanged
+	}
+	if err != nil {
+		return err
+	}
+
+	type line struct{ product, variant int }
+	want := map[line]int{}
+	for _, item := range items {
+		var key line
+		if item.ProductID != nil {
+			key.product = *item.ProductID
+		}
+		if item.VariantID != nil {
+			key.variant = *item.VariantID
+		}
+		want[key] += item.Quantity
+	}
+
+	rows, err := tx.QueryContext(ctx, "SELECT product_id, variant_id, quantity FROM cart_items WHERE cart_id = ?", cartID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var productID, quantity int
+		var variantID sql.NullInt64
+		if err := rows.Scan(&productID, &variantID, &quantity); err != nil {
+			return err
+		}
+		key := line{product: productID, variant: int(variantID.Int64)}
+		want[key] -= quantity
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for _, left := range want {
+		if left != 0 {
+			return ErrCartChanged
+		}
+	}
+	return nil
+}
+
+func insertStatusChange(ctx context.Context, tx *sql.Tx, orderID int, from, to, actor, note string) error {
+	_, err := tx.ExecContext(
+		ctx,
+		"INSERT INTO order_status_history (order_id, from_status, to_status, actor, note) VALUES (?, ?, ?, ?, ?)",
+		orderID,
+		from,
+		to,
+		actor,
+		note,
+	)
+	return err
+}
+
+// GetOrder returns an order with its items, shipments and status history.
+func GetOrder(ctx context.Context, id int) (Order, error) {
+	db := Connect()
+	defer db.Close()
+
+	order, err := scanOrder(queryRowContext(ctx, db, "GetOrder", "SELECT "+orderColumns+" FROM orders WHERE id = ?", id))
+	if err == sql.ErrNoRows {
+		return order, ErrNotFound
+	}
+	if err != nil {
+		return order, err
+	}
+
+	if order.Items, err = getOrderItems(ctx, db, id, order.Currency); err != nil {
+		return order, err
+	}
+	if order.Discounts, err = getOrderDiscounts(ctx, db, id, order.Currency); err != nil {
+		return order, err
+	}
+	if order.Taxes, err = getOrderTaxes(ctx, db, id, order.Currency); err != nil {
+		return order, err
+	}
+	if order.Shipments, err = getOrderShipments(ctx, db, id); err != nil {
+		return order, err
+	}
+	order.History, err = getOrderHistory(ctx, db, id)
+	return order, err
+}
+
+func getOrderItems(ctx context.Context, db *sql.DB, orderID int, currency string) ([]OrderItem, error) {
+	result, err := queryContext(
+		ctx,
+		db,
+		"GetOrderItems",
+		"SELECT id, product_id, variant_id, name, sku, size, color, image, unit_price, quantity, line_total, discount, tax_class, tax FROM order_items WHERE order_id = ? ORDER BY id",
+		orderID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer result.Close()
+
+	items := []OrderItem{}
+	for result.Next() {
+		var item OrderItem
+		var productID, variantID sql.NullInt64
+		err := result.Scan(
+			&item.ID,
+			&productID,
+			&variantID,
+			&item.Name,
+			&item.SKU,
+			&item.Size,
+			&item.Color,
+			&item.Image,
+			money.Column(&item.UnitPrice, currency),
+			&item.Quantity,
+			money.Column(&item.LineTotal, currency),
+			money.Column(&item.Discount, currency),
+			&item.TaxClass,
+			money.Column(&item.Tax, currency),
+		)
+		if err != nil {
+			return nil, err
+		}
+		item.ProductID = nullInt(productID)
+		item.VariantID = nullInt(variantID)
+		items = append(items, item)
+	}
+	return items, result.Err()
+}
+
+func getOrderDiscounts(ctx context.Context, db *sql.DB, orderID int, currency string) ([]OrderDiscount, error) {
+	result, err := queryContext(
+		ctx,
+		db,
+		"GetOrderDiscounts",
+		"SELECT promotion_id, code, name, amount FROM order_discounts WHERE order_id = ? ORDER BY id",
+		orderID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer result.Close()
+
+	discounts := []OrderDiscount{}
+	for result.Next() {
+		var discount OrderDiscount
+		var promotionID sql.NullInt64
+		err := result.Scan(&promotionID, &discount.Code, &discount.Name, money.Column(&discount.Amount, currency))
+		if err != nil {
+			return nil, err
+		}
+		discount.PromotionID = nullInt(promotionID)
+		discounts = append(discounts, discount)
+	}
+	return discounts, result.Err()
+}
+
+func getOrderTaxes(ctx context.Context, db *sql.DB, orderID int, currency string) ([]OrderTax, error) {
+	result, err := queryContext(
+		ctx,
+		db,
+		"GetOrderTaxes",
+		"SELECT name, TRIM(TRAILING '.' FROM TRIM(TRAILING '0' FROM rate)), taxable, amount FROM order_taxes WHERE order_id = ? ORDER BY id",
+		orderID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer result.Close()
+
+	taxes := []OrderTax{}
+	for result.Next() {
+		var tax OrderTax
+		err := result.Scan(&tax.Name, &tax.Rate, money.Column(&tax.Taxable, currency), money.Column(&tax.Amount, currency))
+		if err != nil {
+			return nil, err
+		}
+		taxes = append(taxes, tax)
+	}
+	return taxes, result.Err()
+}
+
+func getOrderHistory(ctx context.Context, db *sql.DB, orderID int) ([]StatusChange, error) {
+	result, err := queryContext(
+		ctx,
+		db,
+		"GetOrderHistory",
+		"SELECT from_status, to_status, actor, note, created_at FROM order_status_history WHERE order_id = ? ORDER BY id",
+		orderID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer result.Close()
+
+	history := []StatusChange{}
+	for result.Next() {
+		var change StatusChange
+		var createdAt []byte
+		if err := result.Scan(&change.From, &change.To, &change.Actor, &change.Note, &createdAt); err != nil {
+			return nil, err
+		}
+		if change.CreatedAt, err = time.Parse(time.DateTime, string(createdAt)); err != nil {
+			return nil, err
+		}
+		history = append(history, change)
+	}
+	return history, result.Err()
+}
+
+func nullInt(value sql.NullInt64) *int {
+	if !value.Valid {
+		return nil
+	}
+	n := int(value.Int64)
+	return &n
+}
+
+// OrderFilter narrows an order listing. Zero values match everything.
+type OrderFilter struct {
+	CustomerID int
+	Status     string
+	Email      string
+	Reference  string
+	From       time.Time
+	To         time.Time
+	Offset     int
+	Limit      int
+}
+
+// ListOrders returns a page of orders, newest first, without their items,
+// and how many orders match in total.
+func ListOrders(ctx context.Context, filter OrderFilter) ([]Order, int, error) {
+	db := Connect()
+	defer db.Close()
+
+	where := []string{"1 = 1"}
+	args := []any{}
+	if filter.CustomerID != 0 {
+		where = append(where, "customer_id = ?")
+		args = append(args, filter.CustomerID)
+	}
+	if filter.Status != "" {
+		where = append(where, "status = ?")
+		args = append(args, filter.Status)
+	}
+	if filter.Email != "" {
+		where = append(where, "email LIKE ?")
+		args = append(args, "%"+escapeLike(filter.Email)+"%")
+	}
+	if filter.Reference != "" {
+		where = append(where, "reference LIKE ?")
+		args = append(args, escapeLike(filter.Reference)+"%")
+	}
+	if !filter.From.IsZero() {
+		where = append(where, "created_at >= ?")
+		args = append(args, filter.From.UTC().Format(time.DateTime))
+	}
+	if !filter.To.IsZero() {
+		where = append(where, "created_at < ?")
+		args = append(args, filter.To.UTC().Format(time.DateTime))
+	}
+	conditions := strings.Join(where, " AND ")
+
+	var total int
+	err := queryRowContext(ctx, db, "CountOrders", "SELECT COUNT(*) FROM orders WHERE "+conditions, args...).Scan(&total)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	result, err := queryContext(
+		ctx,
+		db,
+		"ListOrders",
+		"SELECT "+orderColumns+" FROM orders WHERE "+conditions+" ORDER BY created_at DESC, id DESC LIMIT ? OFFSET ?",
+		append(args, filter.Limit, filter.Offset)...,
+	)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer result.Close()
+
+	orders := []Order{}
+	for result.Next() {
+		order, err := scanOrder(result)
+		if err != nil {
+			return nil, 0, err
+		}
+		orders = append(orders, order)
+	}
+	return orders, total, result.Err()
+}
+
+func escapeLike(value string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(value)
+}
+
+// What happens to the stock of an order when its status changes.
+const (
+	StockKeep    = ""
+	StockCommit  = "commit"
+	StockRelease = "release"
+	// StockReturn puts the goods of a paid order back on the shelf.
+	StockReturn = "return"
+)
+
+// ChangeOrderStatus moves an order from one status to another and records
+// the change. It fails with ErrStatusChanged when the order is no longer in
+// the from status. The stock of the order moves as stock says, and a
+// cancelled order gives its promotion uses back, in the same transaction,
+// so nothing moves for a status change that lost a race and nothing is
+// left undone once the order has its new status. It returns the changes
+// to the stock that can be sold.
+func ChangeOrderStatus(ctx context.Context, id int, from, to, stock, actor, note string) ([]StockChange, error) {
+	var changes []StockChange
+	err := inTx(ctx, "ChangeOrderStatus", func(tx *sql.Tx) error {
+		var status, reference string
+		err := tx.QueryRowContext(ctx, "SELECT status, reference FROM orders WHERE id = ? FOR UPDATE", id).Scan(&status, &reference)
+		if err == sql.ErrNoRows {
+			return ErrNotFound
+		}
+		if err != nil {
+			return err
+		}
+		if status != from {
+			return ErrStatusChanged
+		}
+
+		switch stock {
+		case StockCommit:
+			err = commitReservations(ctx, tx, reference, actor)
+		case StockRelease:
+			var reservations []reservation
+			reservations, err = lockReservations(ctx, tx, "reference = ?", reference)
+			if err == nil {
+				changes, err = release(ctx, tx, reservations)
+			}
+		case StockReturn:
+			changes, err = returnStock(ctx, tx, id, reference, actor)
+		}
+		if err != nil {
+			return err
+		}
+		if to == "cancelled" {
+			if err := releaseRedemptions(ctx, tx, id); err != nil {
+				return err
+			}
+		}
+
+		if _, err := tx.ExecContext(ctx, "UPDATE orders SET status = ? WHERE id = ?", to, id); err != nil {
+			return err
+		}
+		return insertStatusChange(ctx, tx, id, from, to, actor, note)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return changes, nil
+}
+
+// returnStock records a return of every variant on an order.
+func returnStock(ctx context.Context, tx *sql.Tx, orderID int, reference, actor string) ([]StockChange, error) {
+	rows, err := tx.QueryContext(
+		ctx,
+		"SELECT variant_id, SUM(quantity) FROM order_items WHERE order_id = ? AND variant_id IS NOT NULL GROUP BY variant_id ORDER BY variant_id",
+		orderID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	quantities := map[int]int{}
+	var ids []int
+	for rows.Next() {
+		var id, quantity int
+		if err := rows.Scan(&id, &quantity); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		ids = append(ids, id)
+		quantities[id] = quantity
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var changes []StockChange
+	for _, id := range ids {
+		change, err := recordMovement(ctx, tx, id, MovementReturn, quantities[id], reference, actor, "order cancelled")
+		if err != nil {
+			return nil, err
+		}
+		changes = append(changes, change)
+	}
+	return changes, nil
+}
+
+// GetStalePendingOrders lists the orders still pending after maxAge.
+func GetStalePendingOrders(ctx context.Context, maxAge time.Duration) ([]int, error) {
+	db := Connect()
+	defer db.Close()
+
+	result, err := queryContext(
+		ctx,
+		db,
+		"GetStalePendingOrders",
+		"SELECT id FROM orders WHERE status = 'pending' AND created_at < DATE_SUB(CURRENT_TIMESTAMP, INTERVAL ? SECOND) ORDER BY id",
+		int(maxAge.Seconds()),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer result.Close()
+
+	ids := []int{}
+	for result.Next() {
+		var id int
+		if err := result.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, result.Err()
+}
@@ -0,0 +1,148 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"basicthreads/internal/money"
+)
+
+// Payment is an attempt to pay an order through a payment provider.
+type Payment struct {
+	ID        int
+	OrderID   int
+	Provider  string
+	IntentID  string
+	Status    string
+	Amount    money.Money
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+const paymentColumns = "id, order_id, provider, intent_id, status, amount, currency, created_at, updated_at"
+
+func scanPayment(row rowScanner) (Payment, error) {
+	var payment Payment
+	var amount, currency string
+	var createdAt, updatedAt []byte
+	err := row.Scan(
+		&payment.ID,
+		&payment.OrderID,
+		&payment.Provider,
+		&payment.IntentID,
+		&payment.Status,
+		&amount,
+		&currency,
+		&createdAt,
+		&updatedAt,
+	)
+	if err != nil {
+		return payment, err
+	}
+	if payment.Amount, err = money.Parse(amount, currency); err != nil {
+		return payment, err
+	}
+	if payment.CreatedAt, err = time.Parse(time.DateTime, string(createdAt)); err != nil {
+		return payment, err
+	}
+	payment.UpdatedAt, err = time.Parse(time.DateTime, string(updatedAt))
+	return payment, err
+}
+
+// SavePayment inserts a payment, or updates the status of the one with the
+// same provider and intent.
+func SavePayment(ctx context.Context, payment Payment) error {
+	db := Connect()
+	defer db.Close()
+
+	_, err := execContext(
+		ctx,
+		db,
+		"SavePayment",
+		`INSERT INTO payments (order_id, provider, intent_id, status, amount, currency) VALUES (?, ?, ?, ?, ?, ?)
+		ON DUPLICATE KEY UPDATE status = VALUES(status)`,
+		payment.OrderID,
+		payment.Provider,
+		payment.IntentID,
+		payment.Status,
+		payment.Amount,
+		payment.Amount.Currency,
+	)
+	return err
+}
+
+func GetPayment(ctx context.Context, provider, intentID string) (Payment, error) {
+	db := Connect()
+	defer db.Close()
+
+	payment, err := scanPayment(queryRowContext(
+		ctx,
+		db,
+		"GetPayment",
+		"SELECT "+paymentColumns+" FROM payments WHERE provider = ? AND intent_id = ?",
+		provider,
+		intentID,
+	))
+	if err == sql.ErrNoRows {
+		return payment, ErrNotFound
+	}
+	return payment, err
+}
+
+// GetOrderPayment returns the latest payment of an order.
+func GetOrderPayment(ctx context.Context, orderID int) (Payment, error) {
+	db := Connect()
+	defer db.Close()
+
+	payment, err := scanPayment(queryRowContext(
+		ctx,
+		db,
+		"GetOrderPayment",
+		"SELECT "+paymentColumns+" FROM payments WHERE order_id = ? ORDER BY id DESC LIMIT 1",
+		orderID,
+	))
+	if err == sql.ErrNoRows {
+		return payment, ErrNotFound
+	}
+	return payment, err
+}
+
+// RecordPaymentEvent remembers a webhook event and reports whether it is
+// new. Providers retry webhooks, so the same event can arrive many times.
+func RecordPaymentEvent(ctx context.Context, provider, id, kind string) (bool, error) {
+	db := Connect()
+	defer db.Close()
+
+	result, err := execContext(
+		ctx,
+		db,
+		"RecordPaymentEvent",
+		"INSERT IGNORE INTO payment_events (provider, id, type) VALUES (?, ?, ?)",
+		provider,
+		id,
+		kind,
+	)
+	if err != nil {
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	return affected == 1, err
+}
+
+// ForgetPaymentEvent drops an event whose handling failed, so the retry
+// from the provider is handled again.
+func ForgetPaymentEvent(ctx context.Context, provider, id string) error {
+	db := Connect()
+	defer db.Close()
+
+	_, err := execContext(
+		ctx,
+		db,
+		"ForgetPaymentEvent",
+		"DELETE FROM payment_events WHERE provider = ? AND id = ?",
+		provider,
+		id,
+	)
+	return err
+}
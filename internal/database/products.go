@@ -0,0 +1,542 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"slices"
+	"strings"
+	"time"
+
+	"basicthreads/internal/money"
+)
+
+var (
+	ErrNotFound        = errors.New("not found")
+	ErrVersionConflict = errors.New("version conflict")
+)
+
+// AdminProduct is the full product row as administrators see it, including
+// soft-deleted products and the version used for optimistic locking.
+type AdminProduct struct {
+	ID       int
+	Name     string
+	Price    money.Money
+	TaxClass string
+	// Weight is the shipping weight in grams.
+	Weight      int
+	Description string
+	Image       string
+	Available   bool
+	Categories  []int
+	Version     int
+	UpdatedAt   time.Time
+	DeletedAt   *time.Time
+}
+
+type AuditEntry struct {
+	ID        int
+	ProductID int
+	Actor     string
+	Action    string
+	Changes   json.RawMessage
+	CreatedAt time.Time
+}
+
+// auditChange records one changed field as its value before and after.
+type auditChange struct {
+	From any `json:"from"`
+	To   any `json:"to"`
+}
+
+const adminProductColumns = "product_id, name, price, tax_class, weight, description, img, available, version, updated_at, deleted_at"
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanAdminProduct(row rowScanner) (AdminProduct, error) {
+	var product AdminProduct
+	var updatedAt []byte
+	var deletedAt sql.NullString
+	err := row.Scan(
+		&product.ID,
+		&product.Name,
+		price(&product.Price),
+		&product.TaxClass,
+		&product.Weight,
+		&product.Description,
+		&product.Image,
+		&product.Available,
+		&product.Version,
+		&updatedAt,
+		&deletedAt,
+	)
+	if err != nil {
+		return product, err
+	}
+
+	product.UpdatedAt, err = time.Parse(time.DateTime, string(updatedAt))
+	if err != nil {
+		return product, err
+	}
+	if deletedAt.Valid {
+		parsed, err := time.Parse(time.DateTime, deletedAt.String)
+		if err != nil {
+			return product, err
+		}
+		product.DeletedAt = &parsed
+	}
+	return product, nil
+}
+
+type querier interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+}
+
+func productCategoryIDs(ctx context.Context, q querier, id int) ([]int, error) {
+	rows, err := q.QueryContext(
+		ctx,
+		"SELECT id_category FROM categories_product WHERE id_product = ? ORDER BY id_category",
+		id,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := []int{}
+	for rows.Next() {
+		var categoryID int
+		if err := rows.Scan(&categoryID); err != nil {
+			return nil, err
+		}
+		ids = append(ids, categoryID)
+	}
+	return ids, rows.Err()
+}
+
+// GetAdminProduct returns a product whether or not it has been deleted.
+func GetAdminProduct(ctx context.Context, id int) (AdminProduct, error) {
+	db := Connect()
+	defer db.Close()
+
+	product, err := scanAdminProduct(queryRowContext(
+		ctx,
+		db,
+		"GetAdminProduct",
+		"SELECT "+adminProductColumns+" FROM products WHERE product_id = ?",
+		id,
+	))
+	if err == sql.ErrNoRows {
+		return product, ErrNotFound
+	}
+	if err != nil {
+		return product, err
+	}
+
+	product.Categories, err = productCategoryIDs(ctx, db, id)
+	return product, err
+}
+
+// CreateProduct inserts a product with its category links and records the
+// creation in the audit log.
+func CreateProduct(ctx context.Context, actor string, product AdminProduct) (AdminProduct, error) {
+	ctx, span := startSpan(ctx, "CreateProduct", "INSERT INTO products")
+	db := Connect()
+	defer db.Close()
+
+	created, err := func() (AdminProduct, error) {
+		tx, err := db.BeginTx(ctx, nil)
+		if err != nil {
+			return AdminProduct{}, err
+		}
+		defer tx.Rollback()
+
+		result, err := tx.ExecContext(
+			ctx,
+			"INSERT INTO products (name, price, tax_class, weight, description, img, available) VALUES (?, ?, ?, ?, ?, ?, ?)",
+			product.Name,
+			product.Price,
+			product.TaxClass,
+			product.Weight,
+			product.Description,
+			product.Image,
+			product.Available,
+		)
+		if err != nil {
+			return AdminProduct{}, err
+		}
+		id, err := result.LastInsertId()
+		if err != nil {
+			return AdminProduct{}, err
+		}
+		product.ID = int(id)
+
+		if err := replaceProductCategories(ctx, tx, product.ID, product.Categories); err != nil {
+			return AdminProduct{}, err
+		}
+		if err := insertAudit(ctx, tx, product.ID, actor, "create", diffProducts(AdminProduct{}, product)); err != nil {
+			return AdminProduct{}, err
+		}
+
+		created, err := scanAdminProduct(tx.QueryRowContext(
+			ctx,
+			"SELECT "+adminProductColumns+" FROM products WHERE product_id = ?",
+			product.ID,
+		))
+		if err != nil {
+			return AdminProduct{}, err
+		}
+		created.Categories = product.Categories
+		return created, tx.Commit()
+	}()
+
+	endSpan(span, err)
+	return created, err
+}
+
+// ChangeProduct locks a product, checks that it is still at
+// expectedVersion, lets change modify it and writes it back with the
+// version bumped. Every changed field is recorded in the audit log under
+// action. Deleted products can only be changed by an action that restores
+// them, signalled by change clearing DeletedAt.
+func ChangeProduct(ctx context.Context, id, expectedVersion int, actor, action string, change func(*AdminProduct) error) (AdminProduct, error) {
+	ctx, span := startSpan(ctx, "ChangeProduct", "UPDATE products")
+	db := Connect()
+	defer db.Close()
+
+	changed, err := func() (AdminProduct, error) {
+		tx, err := db.BeginTx(ctx, nil)
+		if err != nil {
+			return AdminProduct{}, err
+		}
+		defer tx.Rollback()
+
+		before, err := scanAdminProduct(tx.QueryRowContext(
+			ctx,
+			"SELECT "+adminProductColumns+" FROM products WHERE product_id = ? FOR UPDATE",
+			id,
+		))
+		if err == sql.ErrNoRows {
+			return AdminProduct{}, ErrNotFound
+		}
+		if err != nil {
+			return AdminProduct{}, err
+		}
+		before.Categories, err = productCategoryIDs(ctx, tx, id)
+		if err != nil {
+			return AdminProduct{}, err
+		}
+		if before.Version != expectedVersion {
+			return before, ErrVersionConflict
+		}
+
+		after := before
+		after.Categories = slices.Clone(before.Categories)
+		if err := change(&after); err != nil {
+			return before, err
+		}
+		if before.DeletedAt != nil && after.DeletedAt != nil {
+			return before, ErrNotFound
+		}
+
+		_, err = tx.ExecContext(
+			ctx,
+			"UPDATE products SET name = ?, price = ?, tax_class = ?, weight = ?, description = ?, img = ?, available = ?, deleted_at = IF(?, COALESCE(deleted_at, CURRENT_TIMESTAMP), NULL), version = version + 1 WHERE product_id = ?",
+			after.Name,
+			after.Price,
+			after.TaxClass,
+			after.Weight,
+			after.Description,
+			after.Image,
+			after.Available,
+			after.DeletedAt != nil,
+			id,
+		)
+		if err != nil {
+			return before, err
+		}
+
+		if !slices.Equal(before.Categories, after.Categories) {
+			if err := replaceProductCategories(ctx, tx, id, after.Categories); err != nil {
+				return before, err
+			}
+		}
+		if err := insertAudit(ctx, tx, id, actor, action, diffProducts(before, after)); err != nil {
+			return before, err
+		}
+
+		saved, err := scanAdminProduct(tx.QueryRowContext(
+			ctx,
+			"SELECT "+adminProductColumns+" FROM products WHERE product_id = ?",
+			id,
+		))
+		if err != nil {
+			return before, err
+		}
+		saved.Categories = after.Categories
+		return saved, tx.Commit()
+	}()
+
+	endSpan(span, err)
+	return changed, err
+}
+
+func replaceProductCategories(ctx context.Context, tx *sql.Tx, id int, categories []int) error {
+	_, err := tx.ExecContext(ctx, "DELETE FROM categories_product WHERE id_product = ?", id)
+	if err != nil {
+		return err
+	}
+	for _, categoryID := range categories {
+		_, err = tx.ExecContext(
+			ctx,
+			"INSERT INTO categories_product (id_product, id_category) VALUES (?, ?)",
+			id,
+			categoryID,
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func diffProducts(before, after AdminProduct) map[string]auditChange {
+	changes := map[string]auditChange{}
+	add := func(field string, from, to any, equal bool) {
+		if !equal {
+			changes[field] = auditChange{From: from, To: to}
+		}
+	}
+
+	add("name", before.Name, after.Name, before.Name == after.Name)
+	add("price", before.Price, after.Price, before.Price == after.Price)
+	add("tax_class", before.TaxClass, after.TaxClass, before.TaxClass == after.TaxClass)
+	add("weight", before.Weight, after.Weight, before.Weight == after.Weight)
+	add("description", before.Description, after.Description, before.Description == after.Description)
+	add("image", before.Image, after.Image, before.Image == after.Image)
+	add("available", before.Available, after.Available, before.Available == after.Available)
+	add("categories", before.Categories, after.Categories, slices.Equal(before.Categories, after.Categories))
+	add("deleted", before.DeletedAt != nil, after.DeletedAt != nil, (before.DeletedAt == nil) == (after.DeletedAt == nil))
+	return changes
+}
+
+func insertAudit(ctx context.Context, tx *sql.Tx, productID int, actor, action string, changes map[string]auditChange) error {
+	body, err := json.Marshal(changes)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(
+		ctx,
+		"INSERT INTO product_audit (product_id, actor, action, changes) VALUES (?, ?, ?, ?)",
+		productID,
+		actor,
+		action,
+		body,
+	)
+	return err
+}
+
+// GetProductAudit returns the audit log of a product, newest first.
+func GetProductAudit(ctx context.Context, id int) ([]AuditEntry, error) {
+	db := Connect()
+	defer db.Close()
+
+	result, err := queryContext(
+		ctx,
+		db,
+		"GetProductAudit",
+		"SELECT id, product_id, actor, action, changes, created_at FROM product_audit WHERE product_id = ? ORDER BY id DESC",
+		id,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer result.Close()
+
+	entries := []AuditEntry{}
+	for result.Next() {
+		var entry AuditEntry
+		var changes, createdAt []byte
+		err = result.Scan(&entry.ID, &entry.ProductID, &entry.Actor, &entry.Action, &changes, &createdAt)
+		if err != nil {
+			return nil, err
+		}
+		entry.Changes = json.RawMessage(changes)
+		entry.CreatedAt, err = time.Parse(time.DateTime, string(createdAt))
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, result.Err()
+}
+
+const (
+	SortNewest    = "newest"
+	SortPriceAsc  = "price_asc"
+	SortPriceDesc = "price_desc"
+	SortNameAsc   = "name_asc"
+	SortNameDesc  = "name_desc"
+)
+
+// ProductKey is the position of a product in a sorted listing. Listings
+// resume after the key instead of counting rows, so pages stay stable while
+// products are added or removed.
+type ProductKey struct {
+	ID    int
+	Price money.Money
+	Name  string
+}
+
+type ProductFilter struct {
+	// CategoryIDs limits the listing to products in any of the categories.
+	// Nil means every category; an empty slice matches nothing.
+	CategoryIDs []int
+	MinPrice    *money.Money
+	MaxPrice    *money.Money
+	Available   *bool
+	// Sizes and Colors keep products with at least one variant in one of
+	// the sizes and one of the colors.
+	Sizes  []string
+	Colors []string
+	Sort   string
+	After  *ProductKey
+	Offset int
+	Limit  int
+}
+
+// ListProducts returns one page of the products matching filter, whether
+// there are more after it, and the number of matching products overall.
+func ListProducts(ctx context.Context, filter ProductFilter) ([]Product, bool, int, error) {
+	if filter.CategoryIDs != nil && len(filter.CategoryIDs) == 0 {
+		return []Product{}, false, 0, nil
+	}
+
+	where := []string{"p.deleted_at IS NULL"}
+	args := []any{}
+
+	if filter.CategoryIDs != nil {
+		where = append(where, "p.product_id IN (SELECT cp.id_product FROM categories_product AS cp WHERE cp.id_category IN ("+placeholders(len(filter.CategoryIDs))+"))")
+		for _, id := range filter.CategoryIDs {
+			args = append(args, id)
+		}
+	}
+	if filter.MinPrice != nil {
+		where = append(where, "p.price >= CAST(? AS DECIMAL(10, 2))")
+		args = append(args, *filter.MinPrice)
+	}
+	if filter.MaxPrice != nil {
+		where = append(where, "p.price <= CAST(? AS DECIMAL(10, 2))")
+		args = append(args, *filter.MaxPrice)
+	}
+	if filter.Available != nil {
+		where = append(where, "p.available = ?")
+		args = append(args, *filter.Available)
+	}
+	if len(filter.Sizes) > 0 || len(filter.Colors) > 0 {
+		variantWhere := []string{"v.product_id = p.product_id"}
+		if len(filter.Sizes) > 0 {
+			variantWhere = append(variantWhere, "v.size IN ("+placeholders(len(filter.Sizes))+")")
+			for _, size := range filter.Sizes {
+				args = append(args, size)
+			}
+		}
+		if len(filter.Colors) > 0 {
+			variantWhere = append(variantWhere, "v.color IN ("+placeholders(len(filter.Colors))+")")
+			for _, color := range filter.Colors {
+				args = append(args, color)
+			}
+		}
+		where = append(where, "EXISTS (SELECT 1 FROM product_variants AS v WHERE "+strings.Join(variantWhere, " AND ")+")")
+	}
+
+	db := Connect()
+	defer db.Close()
+
+	var total int
+	countQuery := "SELECT COUNT(*) FROM products AS p WHERE " + strings.Join(where, " AND ")
+	err := queryRowContext(ctx, db, "ListProducts", countQuery, args...).Scan(&total)
+	if err != nil {
+		return nil, false, 0, err
+	}
+
+	var order string
+	switch filter.Sort {
+	case SortPriceAsc:
+		order = "p.price, p.product_id"
+		if filter.After != nil {
+			where = append(where, "(p.price > CAST(? AS DECIMAL(10, 2)) OR (p.price = CAST(? AS DECIMAL(10, 2)) AND p.product_id > ?))")
+			args = append(args, filter.After.Price, filter.After.Price, filter.After.ID)
+		}
+	case SortPriceDesc:
+		order = "p.price DESC, p.product_id DESC"
+		if filter.After != nil {
+			where = append(where, "(p.price < CAST(? AS DECIMAL(10, 2)) OR (p.price = CAST(? AS DECIMAL(10, 2)) AND p.product_id < ?))")
+			args = append(args, filter.After.Price, filter.After.Price, filter.After.ID)
+		}
+	case SortNameAsc:
+		order = "p.name, p.product_id"
+		if filter.After != nil {
+			where = append(where, "(p.name > ? OR (p.name = ? AND p.product_id > ?))")
+			args = append(args, filter.After.Name, filter.After.Name, filter.After.ID)
+		}
+	case SortNameDesc:
+		order = "p.name DESC, p.product_id DESC"
+		if filter.After != nil {
+			where = append(where, "(p.name < ? OR (p.name = ? AND p.product_id < ?))")
+			args = append(args, filter.After.Name, filter.After.Name, filter.After.ID)
+		}
+	default:
+		order = "p.product_id DESC"
+		if filter.After != nil {
+			where = append(where, "p.product_id < ?")
+			args = append(args, filter.After.ID)
+		}
+	}
+
+	// One extra row tells whether another page follows.
+	query := "SELECT p.product_id, p.name, p.price, p.description, p.img, p.available, p.rating_average, p.rating_count FROM products AS p WHERE " +
+		strings.Join(where, " AND ") + " ORDER BY " + order + " LIMIT ? OFFSET ?"
+	args = append(args, filter.Limit+1, filter.Offset)
+
+	result, err := queryContext(ctx, db, "ListProducts", query, args...)
+	if err != nil {
+		return nil, false, 0, err
+	}
+	defer result.Close()
+
+	Products := []Product{}
+	for result.Next() {
+		var product Product
+		err = result.Scan(
+			&product.ID,
+			&product.Name,
+			price(&product.Price),
+			&product.Description,
+			&product.Image,
+			&product.Available,
+			&product.Rating,
+			&product.ReviewCount,
+		)
+		if err != nil {
+			return nil, false, 0, err
+		}
+		Products = append(Products, product)
+	}
+	if err := result.Err(); err != nil {
+		return nil, false, 0, err
+	}
+
+	more := len(Products) > filter.Limit
+	if more {
+		Products = Products[:filter.Limit]
+	}
+	return Products, more, total, nil
+}
+
+func placeholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?, ", n), ", ")
+}
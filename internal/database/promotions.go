@@ -0,0 +1,410 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"basicthreads/internal/money"
+)
+
+// ErrPromotionUsedUp is returned at checkout when a promotion reached its
+// usage limit, overall or for the customer, since the cart was priced.
+var ErrPromotionUsedUp = errors.New("promotion used up")
+
+// Promotion is a discount rule. Promotions without a code apply on their
+// own to every cart that meets them; the others need the code entered.
+type Promotion struct {
+	ID   int
+	Name string
+	Code string `json:",omitempty"`
+	Kind string
+	// Percent is the discount of percentage promotions, and how much off
+	// the free units of buy X get Y promotions are (100 for free).
+	Percent int
+	// Amount is the discount of fixed promotions.
+	Amount *money.Money `json:",omitempty"`
+	// Scope is order, category or product; TargetID is the category or
+	// product.
+	Scope       string
+	TargetID    int          `json:",omitempty"`
+	MinSubtotal *money.Money `json:",omitempty"`
+	BuyQuantity int          `json:",omitempty"`
+	GetQuantity int          `json:",omitempty"`
+	Priority    int
+	// Exclusive promotions never share a cart with other promotions.
+	Exclusive     bool
+	UsageLimit    *int `json:",omitempty"`
+	CustomerLimit *int `json:",omitempty"`
+	Used          int
+	StartsAt      *time.Time `json:",omitempty"`
+	EndsAt        *time.Time `json:",omitempty"`
+	Active        bool
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+}
+
+const promotionColumns = "id, name, COALESCE(code, ''), kind, percent, amount, scope, target_id, min_subtotal, buy_quantity, get_quantity, priority, exclusive, usage_limit, customer_limit, used, starts_at, ends_at, active, created_at, updated_at"
+
+func scanPromotion(row rowScanner) (Promotion, error) {
+	var promotion Promotion
+	var usageLimit, customerLimit sql.NullInt64
+	var startsAt, endsAt sql.NullString
+	var createdAt, updatedAt []byte
+	err := row.Scan(
+		&promotion.ID,
+		&promotion.Name,
+		&promotion.Code,
+		&promotion.Kind,
+		&promotion.Percent,
+		nullablePrice(&promotion.Amount),
+		&promotion.Scope,
+		&promotion.TargetID,
+		nullablePrice(&promotion.MinSubtotal),
+		&promotion.BuyQuantity,
+		&promotion.GetQuantity,
+		&promotion.Priority,
+		&promotion.Exclusive,
+		&usageLimit,
+		&customerLimit,
+		&promotion.Used,
+		&startsAt,
+		&endsAt,
+		&promotion.Active,
+		&createdAt,
+		&updatedAt,
+	)
+	if err != nil {
+		return promotion, err
+	}
+
+	promotion.UsageLimit = nullInt(usageLimit)
+	promotion.CustomerLimit = nullInt(customerLimit)
+	if promotion.StartsAt, err = nullTime(startsAt); err != nil {
+		return promotion, err
+	}
+	if promotion.EndsAt, err = nullTime(endsAt); err != nil {
+		return promotion, err
+	}
+	if promotion.CreatedAt, err = time.Parse(time.DateTime, string(createdAt)); err != nil {
+		return promotion, err
+	}
+	promotion.UpdatedAt, err = time.Parse(time.DateTime, string(updatedAt))
+	return promotion, err
+}
+
+func nullTime(value sql.NullString) (*time.Time, error) {
+	if !value.Valid {
+		return nil, nil
+	}
+	parsed, err := time.Parse(time.DateTime, value.String)
+	if err != nil {
+		return nil, err
+	}
+	return &parsed, nil
+}
+
+func timeValue(value *time.Time) any {
+	if value == nil {
+		return nil
+	}
+	return value.UTC().Format(time.DateTime)
+}
+
+func intValue(value *int) any {
+	if value == nil {
+		return nil
+	}
+	return *value
+}
+
+func GetPromotions(ctx context.Context) ([]Promotion, error) {
+	db := Connect()
+	defer db.Close()
+
+	return queryPromotions(ctx, db, "GetPromotions", "SELECT "+promotionColumns+" FROM promotions ORDER BY id DESC")
+}
+
+// GetActivePromotions returns the automatic promotions that are switched
+// on, and the one with code when code is not empty. Validity windows and
+// limits are left for the caller to check.
+func GetActivePromotions(ctx context.Context, code string) ([]Promotion, error) {
+	db := Connect()
+	defer db.Close()
+
+	return queryPromotions(
+		ctx,
+		db,
+		"GetActivePromotions",
+		"SELECT "+promotionColumns+" FROM promotions WHERE active AND (code IS NULL OR code = ?) ORDER BY priority DESC, id",
+		code,
+	)
+}
+
+func queryPromotions(ctx context.Context, db *sql.DB, name, query string, args ...any) ([]Promotion, error) {
+	result, err := queryContext(ctx, db, name, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer result.Close()
+
+	promotions := []Promotion{}
+	for result.Next() {
+		promotion, err := scanPromotion(result)
+		if err != nil {
+			return nil, err
+		}
+		promotions = append(promotions, promotion)
+	}
+	return promotions, result.Err()
+}
+
+func GetPromotion(ctx context.Context, id int) (Promotion, error) {
+	db := Connect()
+	defer db.Close()
+
+	promotion, err := scanPromotion(queryRowContext(ctx, db, "GetPromotion", "SELECT "+promotionColumns+" FROM promotions WHERE id = ?", id))
+	if err == sql.ErrNoRows {
+		return promotion, ErrNotFound
+	}
+	return promotion, err
+}
+
+func GetPromotionByCode(ctx context.Context, code string) (Promotion, error) {
+	db := Connect()
+	defer db.Close()
+
+	promotion, err := scanPromotion(queryRowContext(ctx, db, "GetPromotionByCode", "SELECT "+promotionColumns+" FROM promotions WHERE code = ?", code))
+	if err == sql.ErrNoRows {
+		return promotion, ErrNotFound
+	}
+	return promotion, err
+}
+
+func promotionArgs(promotion Promotion) []any {
+	return []any{
+		promotion.Name,
+		nullableString(promotion.Code),
+		promotion.Kind,
+		promotion.Percent,
+		promotion.Amount,
+		promotion.Scope,
+		promotion.TargetID,
+		promotion.MinSubtotal,
+		promotion.BuyQuantity,
+		promotion.GetQuantity,
+		promotion.Priority,
+		promotion.Exclusive,
+		intValue(promotion.UsageLimit),
+		intValue(promotion.CustomerLimit),
+		timeValue(promotion.StartsAt),
+		timeValue(promotion.EndsAt),
+		promotion.Active,
+	}
+}
+
+func CreatePromotion(ctx context.Context, promotion Promotion) (Promotion, error) {
+	db := Connect()
+	defer db.Close()
+
+	result, err := execContext(
+		ctx,
+		db,
+		"CreatePromotion",
+		"INSERT INTO promotions (name, code, kind, percent, amount, scope, target_id, min_subtotal, buy_quantity, get_quantity, priority, exclusive, usage_limit, customer_limit, starts_at, ends_at, active) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		promotionArgs(promotion)...,
+	)
+	if err != nil {
+		return promotion, duplicateError(err)
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return promotion, err
+	}
+	return GetPromotion(ctx, int(id))
+}
+
+// UpdatePromotion saves every field of a promotion except how many times it
+// was used.
+func UpdatePromotion(ctx context.Context, promotion Promotion) (Promotion, error) {
+	db := Connect()
+	defer db.Close()
+
+	_, err := execContext(
+		ctx,
+		db,
+		"UpdatePromotion",
+		"UPDATE promotions SET name = ?, code = ?, kind = ?, percent = ?, amount = ?, scope = ?, target_id = ?, min_subtotal = ?, buy_quantity = ?, get_quantity = ?, priority = ?, exclusive = ?, usage_limit = ?, customer_limit = ?, starts_at = ?, ends_at = ?, active = ? WHERE id = ?",
+		append(promotionArgs(promotion), promotion.ID)...,
+	)
+	if err != nil {
+		return promotion, duplicateError(err)
+	}
+	return GetPromotion(ctx, promotion.ID)
+}
+
+// GetCustomerRedemptions counts how many orders of a customer used each
+// promotion.
+func GetCustomerRedemptions(ctx context.Context, customerID int) (map[int]int, error) {
+	db := Connect()
+	defer db.Close()
+
+	result, err := queryContext(
+		ctx,
+		db,
+		"GetCustomerRedemptions",
+		"SELECT promotion_id, COUNT(*) FROM promotion_redemptions WHERE customer_id = ? GROUP BY promotion_id",
+		customerID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer result.Close()
+
+	counts := map[int]int{}
+	for result.Next() {
+		var id, count int
+		if err := result.Scan(&id, &count); err != nil {
+			return nil, err
+		}
+		counts[id] = count
+	}
+	return counts, result.Err()
+}
+
+// redeemPromotion counts one use of a promotion by an order, failing with
+// ErrPromotionUsedUp when a limit would be passed. The promotion row stays
+// locked until the transaction ends, so concurrent checkouts take turns.
+func redeemPromotion(ctx context.Context, tx *sql.Tx, promotionID, orderID, customerID int) error {
+	var used int
+	var usageLimit, customerLimit sql.NullInt64
+	err := tx.QueryRowContext(
+		ctx,
+		"SELECT used, usage_limit, customer_limit FROM promotions WHERE id = ? FOR UPDATE",
+		promotionID,
+	).Scan(&used, &usageLimit, &customerLimit)
+	if err == sql.ErrNoRows {
+		return ErrPromotionUsedUp
+	}
+	if err != nil {
+		return err
+	}
+	if usageLimit.Valid && int64(used) >= usageLimit.Int64 {
+		return ErrPromotionUsedUp
+	}
+
+	if customerLimit.Valid {
+		var count int64
+		err := tx.QueryRowContext(
+			ctx,
+			"SELECT COUNT(*) FROM promotion_redemptions WHERE promotion_id = ? AND customer_id = ?",
+			promotionID,
+			customerID,
+		).Scan(&count)
+		if err != nil {
+			return err
+		}
+		if count >= customerLimit.Int64 {
+			return ErrPromotionUsedUp
+		}
+	}
+
+	if _, err := tx.ExecContext(ctx, "UPDATE promotions SET used = used + 1 WHERE id = ?", promotionID); err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(
+		ctx,
+		"INSERT INTO promotion_redemptions (promotion_id, order_id, customer_id) VALUES (?, ?, ?)",
+		promotionID,
+		orderID,
+		customerID,
+	)
+	return err
+}
+
+// releaseRedemptions gives back the promotion uses of an order, so that a
+// cancelled order does not count against any limit.
+func releaseRedemptions(ctx context.Context, tx *sql.Tx, orderID int) error {
+	_, err := tx.ExecContext(
+		ctx,
+		`UPDATE promotions AS p
+		INNER JOIN promotion_redemptions AS r ON r.promotion_id = p.id
+		SET p.used = p.used - 1
+		WHERE r.order_id = ? AND p.used > 0`,
+		orderID,
+	)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, "DELETE FROM promotion_redemptions WHERE order_id = ?", orderID)
+	return err
+}
+
+// GetCartCode returns the discount code entered on a cart, if any.
+func GetCartCode(ctx context.Context, cartID int) (string, error) {
+	db := Connect()
+	defer db.Close()
+
+	var code sql.NullString
+	err := queryRowContext(ctx, db, "GetCartCode", "SELECT discount_code FROM carts WHERE id = ?", cartID).Scan(&code)
+	if err == sql.ErrNoRows {
+		return "", ErrNotFound
+	}
+	return code.String, err
+}
+
+// SetCartCode enters a discount code on a cart, or clears it when code is
+// empty.
+func SetCartCode(ctx context.Context, cartID int, code string) error {
+	db := Connect()
+	defer db.Close()
+
+	_, err := execContext(
+		ctx,
+		db,
+		"SetCartCode",
+		"UPDATE carts SET discount_code = ? WHERE id = ?",
+		nullableString(code),
+		cartID,
+	)
+	return err
+}
+
+// GetCategoryIDsOf maps each of productIDs to the ids of its categories.
+func GetCategoryIDsOf(ctx context.Context, productIDs []int) (map[int][]int, error) {
+	links := map[int][]int{}
+	if len(productIDs) == 0 {
+		return links, nil
+	}
+
+	db := Connect()
+	defer db.Close()
+
+	args := make([]any, len(productIDs))
+	for i, id := range productIDs {
+		args[i] = id
+	}
+
+	result, err := queryContext(
+		ctx,
+		db,
+		"GetCategoryIDsOf",
+		"SELECT id_product, id_category FROM categories_product WHERE id_product IN ("+placeholders(len(productIDs))+") ORDER BY id_product, id_category",
+		args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer result.Close()
+
+	for result.Next() {
+		var productID, categoryID int
+		if err := result.Scan(&productID, &categoryID); err != nil {
+			return nil, err
+		}
+		links[productID] = append(links[productID], categoryID)
+	}
+	return links, result.Err()
+}
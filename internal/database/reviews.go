@@ -0,0 +1,307 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+)
+
+// Moderation statuses of a review. Only approved reviews are shown and
+// count towards the rating of their product.
+const (
+	ReviewPending  = "pending"
+	ReviewApproved = "approved"
+	ReviewRejected = "rejected"
+)
+
+// Orders of reviews.
+const (
+	ReviewSortNewest     = "newest"
+	ReviewSortOldest     = "oldest"
+	ReviewSortRatingDesc = "rating_desc"
+	ReviewSortRatingAsc  = "rating_asc"
+)
+
+// Review is the star rating and text a customer left on a product.
+// Verified says they had bought it when they wrote the review.
+type Review struct {
+	ID          int
+	ProductID   int
+	ProductName string
+	CustomerID  int `json:"-"`
+	// Author is the first name of the customer.
+	Author         string
+	Rating         int
+	Title          string
+	Body           string
+	Verified       bool
+	Status         string
+	ModeratedBy    string     `json:",omitempty"`
+	ModerationNote string     `json:",omitempty"`
+	ModeratedAt    *time.Time `json:",omitempty"`
+	CreatedAt      time.Time
+	UpdatedAt      time.Time
+}
+
+// ReviewFilter narrows a review listing. Zero values match everything.
+type ReviewFilter struct {
+	ProductID  int
+	CustomerID int
+	Status     string
+	Rating     int
+	Verified   *bool
+	Sort       string
+	Offset     int
+	Limit      int
+}
+
+const reviewColumns = `r.id, r.product_id, p.name, r.customer_id, SUBSTRING_INDEX(c.name, ' ', 1), r.rating, r.title, r.body,
+	r.verified, r.status, r.moderated_by, r.moderation_note, r.moderated_at, r.created_at, r.updated_at`
+
+const reviewTables = `product_reviews AS r
+	INNER JOIN products AS p ON p.product_id = r.product_id
+	INNER JOIN customers AS c ON c.id = r.customer_id`
+
+func scanReview(row rowScanner) (Review, error) {
+	var review Review
+	var moderatedAt sql.NullString
+	var createdAt, updatedAt []byte
+	err := row.Scan(
+		&review.ID,
+		&review.ProductID,
+		&review.ProductName,
+		&review.CustomerID,
+		&review.Author,
+		&review.Rating,
+		&review.Title,
+		&review.Body,
+		&review.Verified,
+		&review.Status,
+		&review.ModeratedBy,
+		&review.ModerationNote,
+		&moderatedAt,
+		&createdAt,
+		&updatedAt,
+	)
+	if err != nil {
+		return review, err
+	}
+	if review.ModeratedAt, err = nullTime(moderatedAt); err != nil {
+		return review, err
+	}
+	if review.CreatedAt, err = time.Parse(time.DateTime, string(createdAt)); err != nil {
+		return review, err
+	}
+	review.UpdatedAt, err = time.Parse(time.DateTime, string(updatedAt))
+	return review, err
+}
+
+// GetReviews returns one page of the reviews matching filter and how many
+// match overall.
+func GetReviews(ctx context.Context, filter ReviewFilter) ([]Review, int, error) {
+	where := []string{"1 = 1"}
+	args := []any{}
+	if filter.ProductID != 0 {
+		where = append(where, "r.product_id = ?")
+		args = append(args, filter.ProductID)
+	}
+	if filter.CustomerID != 0 {
+		where = append(where, "r.customer_id = ?")
+		args = append(args, filter.CustomerID)
+	}
+	if filter.Status != "" {
+		where = append(where, "r.status = ?")
+		args = append(args, filter.Status)
+	}
+	if filter.Rating != 0 {
+		where = append(where, "r.rating = ?")
+		args = append(args, filter.Rating)
+	}
+	if filter.Verified != nil {
+		where = append(where, "r.verified = ?")
+		args = append(args, *filter.Verified)
+	}
+
+	db := Connect()
+	defer db.Close()
+
+	var total int
+	countQuery := "SELECT COUNT(*) FROM product_reviews AS r WHERE " + strings.Join(where, " AND ")
+	if err := queryRowContext(ctx, db, "GetReviews", countQuery, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	var order string
+	switch filter.Sort {
+	case ReviewSortOldest:
+		order = "r.created_at, r.id"
+	case ReviewSortRatingDesc:
+		order = "r.rating DESC, r.created_at DESC, r.id DESC"
+	case ReviewSortRatingAsc:
+		order = "r.rating, r.created_at DESC, r.id DESC"
+	default:
+		order = "r.created_at DESC, r.id DESC"
+	}
+
+	query := "SELECT " + reviewColumns + " FROM " + reviewTables + " WHERE " + strings.Join(where, " AND ") +
+		" ORDER BY " + order + " LIMIT ? OFFSET ?"
+	args = append(args, filter.Limit, filter.Offset)
+
+	result, err := queryContext(ctx, db, "GetReviews", query, args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer result.Close()
+
+	reviews := []Review{}
+	for result.Next() {
+		review, err := scanReview(result)
+		if err != nil {
+			return nil, 0, err
+		}
+		reviews = append(reviews, review)
+	}
+	return reviews, total, result.Err()
+}
+
+func GetReview(ctx context.Context, id int) (Review, error) {
+	db := Connect()
+	defer db.Close()
+
+	review, err := scanReview(queryRowContext(ctx, db, "GetReview", "SELECT "+reviewColumns+" FROM "+reviewTables+" WHERE r.id = ?", id))
+	if err == sql.ErrNoRows {
+		return review, ErrNotFound
+	}
+	return review, err
+}
+
+// HasPurchased reports whether a customer has a paid order with a product.
+func HasPurchased(ctx context.Context, customerID, productID int) (bool, error) {
+	db := Connect()
+	defer db.Close()
+
+	var purchased bool
+	err := queryRowContext(
+		ctx,
+		db,
+		"HasPurchased",
+		`SELECT EXISTS(
+			SELECT 1 FROM orders AS o
+			INNER JOIN order_items AS oi ON oi.order_id = o.id
+			WHERE o.customer_id = ? AND oi.product_id = ? AND o.status IN ('paid', 'shipped', 'delivered')
+		)`,
+		customerID,
+		productID,
+	).Scan(&purchased)
+	return purchased, err
+}
+
+// refreshRating recomputes the rating of a product from its approved
+// reviews.
+func refreshRating(ctx context.Context, tx *sql.Tx, productID int) error {
+	_, err := tx.ExecContext(
+		ctx,
+		`UPDATE products SET
+			rating_average = COALESCE((SELECT ROUND(AVG(r.rating), 2) FROM product_reviews AS r WHERE r.product_id = ? AND r.status = 'approved'), 0),
+			rating_count = (SELECT COUNT(*) FROM product_reviews AS r WHERE r.product_id = ? AND r.status = 'approved')
+		WHERE product_id = ?`,
+		productID,
+		productID,
+		productID,
+	)
+	return err
+}
+
+// SaveReview writes the review of a customer on a product, replacing the
+// one they left before. Either way it goes back to the moderation queue.
+func SaveReview(ctx context.Context, review Review) (Review, error) {
+	var id int
+	err := inTx(ctx, "SaveReview", func(tx *sql.Tx) error {
+		_, err := tx.ExecContext(
+			ctx,
+			`INSERT INTO product_reviews (product_id, customer_id, rating, title, body, verified) VALUES (?, ?, ?, ?, ?, ?)
+			ON DUPLICATE KEY UPDATE rating = VALUES(rating), title = VALUES(title), body = VALUES(body), verified = VALUES(verified),
+				status = 'pending', moderated_by = '', moderation_note = '', moderated_at = NULL`,
+			review.ProductID,
+			review.CustomerID,
+			review.Rating,
+			review.Title,
+			review.Body,
+			review.Verified,
+		)
+		if err != nil {
+			return err
+		}
+		err = tx.QueryRowContext(
+			ctx,
+			"SELECT id FROM product_reviews WHERE product_id = ? AND customer_id = ?",
+			review.ProductID,
+			review.CustomerID,
+		).Scan(&id)
+		if err != nil {
+			return err
+		}
+		return refreshRating(ctx, tx, review.ProductID)
+	})
+	if err != nil {
+		return review, err
+	}
+	return GetReview(ctx, id)
+}
+
+// DeleteReview removes a review of a customer. Reviews of other customers
+// are not found.
+func DeleteReview(ctx context.Context, customerID, id int) error {
+	return inTx(ctx, "DeleteReview", func(tx *sql.Tx) error {
+		var productID int
+		err := tx.QueryRowContext(
+			ctx,
+			"SELECT product_id FROM product_reviews WHERE id = ? AND customer_id = ? FOR UPDATE",
+			id,
+			customerID,
+		).Scan(&productID)
+		if err == sql.ErrNoRows {
+			return ErrNotFound
+		}
+		if err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, "DELETE FROM product_reviews WHERE id = ?", id); err != nil {
+			return err
+		}
+		return refreshRating(ctx, tx, productID)
+	})
+}
+
+// ModerateReview approves or rejects a review and updates the rating of
+// its product.
+func ModerateReview(ctx context.Context, id int, status, actor, note string) (Review, error) {
+	err := inTx(ctx, "ModerateReview", func(tx *sql.Tx) error {
+		var productID int
+		err := tx.QueryRowContext(ctx, "SELECT product_id FROM product_reviews WHERE id = ? FOR UPDATE", id).Scan(&productID)
+		if err == sql.ErrNoRows {
+			return ErrNotFound
+		}
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.ExecContext(
+			ctx,
+			"UPDATE product_reviews SET status = ?, moderated_by = ?, moderation_note = ?, moderated_at = CURRENT_TIMESTAMP WHERE id = ?",
+			status,
+			actor,
+			note,
+			id,
+		)
+		if err != nil {
+			return err
+		}
+		return refreshRating(ctx, tx, productID)
+	})
+	if err != nil {
+		return Review{}, err
+	}
+	return GetReview(ctx, id)
+}
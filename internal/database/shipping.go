@@ -0,0 +1,449 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"basicthreads/internal/money"
+)
+
+// ShippingArea is a country, or one region of it, that a zone covers.
+type ShippingArea struct {
+	Country string
+	Region  string `json:",omitempty"`
+}
+
+type ShippingZone struct {
+	ID      int
+	Name    string
+	Areas   []ShippingArea
+	Methods []ShippingMethod
+}
+
+// ShippingMethod is a way to ship to a zone. Flat methods cost Price;
+// weight methods cost Price plus PerKg for every started kilogram. Any
+// method is free when the goods are worth FreeAbove or more.
+type ShippingMethod struct {
+	ID        int
+	ZoneID    int
+	Name      string
+	Kind      string
+	Price     money.Money
+	PerKg     *money.Money `json:",omitempty"`
+	FreeAbove *money.Money `json:",omitempty"`
+	// MaxWeight, in grams, is the heaviest order the method takes.
+	MaxWeight *int `json:",omitempty"`
+	Carrier   string
+	Service   string `json:",omitempty"`
+	Active    bool
+}
+
+// Shipment is a parcel handed to a carrier for an order.
+type Shipment struct {
+	ID             int
+	OrderID        int
+	Carrier        string
+	Service        string `json:",omitempty"`
+	TrackingNumber string
+	TrackingURL    string `json:",omitempty"`
+	CreatedBy      string `json:"-"`
+	CreatedAt      time.Time
+}
+
+func GetShippingZones(ctx context.Context) ([]ShippingZone, error) {
+	db := Connect()
+	defer db.Close()
+
+	result, err := queryContext(ctx, db, "GetShippingZones", "SELECT id, name FROM shipping_zones ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer result.Close()
+
+	zones := []ShippingZone{}
+	for result.Next() {
+		var zone ShippingZone
+		if err := result.Scan(&zone.ID, &zone.Name); err != nil {
+			return nil, err
+		}
+		zones = append(zones, zone)
+	}
+	if err := result.Err(); err != nil {
+		return nil, err
+	}
+
+	for i := range zones {
+		if err := fillShippingZone(ctx, db, &zones[i]); err != nil {
+			return nil, err
+		}
+	}
+	return zones, nil
+}
+
+func GetShippingZone(ctx context.Context, id int) (ShippingZone, error) {
+	db := Connect()
+	defer db.Close()
+
+	var zone ShippingZone
+	err := queryRowContext(ctx, db, "GetShippingZone", "SELECT id, name FROM shipping_zones WHERE id = ?", id).Scan(&zone.ID, &zone.Name)
+	if err == sql.ErrNoRows {
+		return zone, ErrNotFound
+	}
+	if err != nil {
+		return zone, err
+	}
+	return zone, fillShippingZone(ctx, db, &zone)
+}
+
+func fillShippingZone(ctx context.Context, db *sql.DB, zone *ShippingZone) error {
+	result, err := queryContext(
+		ctx,
+		db,
+		"GetShippingZoneAreas",
+		"SELECT country, region FROM shipping_zone_areas WHERE zone_id = ? ORDER BY country, region",
+		zone.ID,
+	)
+	if err != nil {
+		return err
+	}
+	defer result.Close()
+
+	zone.Areas = []ShippingArea{}
+	for result.Next() {
+		var area ShippingArea
+		if err := result.Scan(&area.Country, &area.Region); err != nil {
+			return err
+		}
+		zone.Areas = append(zone.Areas, area)
+	}
+	if err := result.Err(); err != nil {
+		return err
+	}
+
+	zone.Methods, err = queryShippingMethods(ctx, db, "GetShippingMethods", "SELECT "+shippingMethodColumns+" FROM shipping_methods WHERE zone_id = ? ORDER BY id", zone.ID)
+	return err
+}
+
+// FindShippingZone returns the zone that ships to country and region. A
+// zone naming the region wins over one covering the whole country.
+func FindShippingZone(ctx context.Context, country, region string) (int, error) {
+	db := Connect()
+	defer db.Close()
+
+	var id int
+	err := queryRowContext(
+		ctx,
+		db,
+		"FindShippingZone",
+		"SELECT zone_id FROM shipping_zone_areas WHERE country = ? AND (region = '' OR region = ?) ORDER BY region DESC LIMIT 1",
+		country,
+		region,
+	).Scan(&id)
+	if err == sql.ErrNoRows {
+		return 0, ErrNotFound
+	}
+	return id, err
+}
+
+func insertShippingAreas(ctx context.Context, tx *sql.Tx, zoneID int, areas []ShippingArea) error {
+	if _, err := tx.ExecContext(ctx, "DELETE FROM shipping_zone_areas WHERE zone_id = ?", zoneID); err != nil {
+		return err
+	}
+	for _, area := range areas {
+		_, err := tx.ExecContext(
+			ctx,
+			"INSERT INTO shipping_zone_areas (zone_id, country, region) VALUES (?, ?, ?)",
+			zoneID,
+			area.Country,
+			area.Region,
+		)
+		if err != nil {
+			return duplicateError(err)
+		}
+	}
+	return nil
+}
+
+// CreateShippingZone saves a zone with its areas. It fails with
+// ErrDuplicate when another zone already covers one of the areas.
+func CreateShippingZone(ctx context.Context, zone ShippingZone) (ShippingZone, error) {
+	err := inTx(ctx, "CreateShippingZone", func(tx *sql.Tx) error {
+		result, err := tx.ExecContext(ctx, "INSERT INTO shipping_zones (name) VALUES (?)", zone.Name)
+		if err != nil {
+			return err
+		}
+		id, err := result.LastInsertId()
+		if err != nil {
+			return err
+		}
+		zone.ID = int(id)
+		return insertShippingAreas(ctx, tx, zone.ID, zone.Areas)
+	})
+	if err != nil {
+		return zone, err
+	}
+	return GetShippingZone(ctx, zone.ID)
+}
+
+// UpdateShippingZone renames a zone and replaces its areas.
+func UpdateShippingZone(ctx context.Context, zone ShippingZone) (ShippingZone, error) {
+	err := inTx(ctx, "UpdateShippingZone", func(tx *sql.Tx) error {
+		result, err := tx.ExecContext(ctx, "UPDATE shipping_zones SET name = ? WHERE id = ?", zone.Name, zone.ID)
+		if err != nil {
+			return err
+		}
+		if n, err := result.RowsAffected(); err == nil && n == 0 {
+			var exists bool
+			if err := tx.QueryRowContext(ctx, "SELECT EXISTS(SELECT 1 FROM shipping_zones WHERE id = ?)", zone.ID).Scan(&exists); err != nil {
+				return err
+			}
+			if !exists {
+				return ErrNotFound
+			}
+		}
+		return insertShippingAreas(ctx, tx, zone.ID, zone.Areas)
+	})
+	if err != nil {
+		return zone, err
+	}
+	return GetShippingZone(ctx, zone.ID)
+}
+
+// DeleteShippingZone removes a zone with its areas and methods.
+func DeleteShippingZone(ctx context.Context, id int) error {
+	db := Connect()
+	defer db.Close()
+
+	result, err := execContext(ctx, db, "DeleteShippingZone", "DELETE FROM shipping_zones WHERE id = ?", id)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return ErrNotFound
+	}
+	return err
+}
+
+const shippingMethodColumns = "id, zone_id, name, kind, price, per_kg, free_above, max_weight, carrier, service, active"
+
+func scanShippingMethod(row rowScanner) (ShippingMethod, error) {
+	var method ShippingMethod
+	var maxWeight sql.NullInt64
+	err := row.Scan(
+		&method.ID,
+		&method.ZoneID,
+		&method.Name,
+		&method.Kind,
+		price(&method.Price),
+		nullablePrice(&method.PerKg),
+		nullablePrice(&method.FreeAbove),
+		&maxWeight,
+		&method.Carrier,
+		&method.Service,
+		&method.Active,
+	)
+	method.MaxWeight = nullInt(maxWeight)
+	return method, err
+}
+
+func queryShippingMethods(ctx context.Context, db *sql.DB, name, query string, args ...any) ([]ShippingMethod, error) {
+	result, err := queryContext(ctx, db, name, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer result.Close()
+
+	methods := []ShippingMethod{}
+	for result.Next() {
+		method, err := scanShippingMethod(result)
+		if err != nil {
+			return nil, err
+		}
+		methods = append(methods, method)
+	}
+	return methods, result.Err()
+}
+
+// GetActiveShippingMethods lists the methods of a zone customers can pick.
+func GetActiveShippingMethods(ctx context.Context, zoneID int) ([]ShippingMethod, error) {
+	db := Connect()
+	defer db.Close()
+
+	return queryShippingMethods(
+		ctx,
+		db,
+		"GetActiveShippingMethods",
+		"SELECT "+shippingMethodColumns+" FROM shipping_methods WHERE zone_id = ? AND active ORDER BY id",
+		zoneID,
+	)
+}
+
+func GetShippingMethod(ctx context.Context, id int) (ShippingMethod, error) {
+	db := Connect()
+	defer db.Close()
+
+	method, err := scanShippingMethod(queryRowContext(ctx, db, "GetShippingMethod", "SELECT "+shippingMethodColumns+" FROM shipping_methods WHERE id = ?", id))
+	if err == sql.ErrNoRows {
+		return method, ErrNotFound
+	}
+	return method, err
+}
+
+func shippingMethodArgs(method ShippingMethod) []any {
+	return []any{
+		method.Name,
+		method.Kind,
+		method.Price,
+		method.PerKg,
+		method.FreeAbove,
+		intValue(method.MaxWeight),
+		method.Carrier,
+		method.Service,
+		method.Active,
+	}
+}
+
+func CreateShippingMethod(ctx context.Context, method ShippingMethod) (ShippingMethod, error) {
+	db := Connect()
+	defer db.Close()
+
+	result, err := execContext(
+		ctx,
+		db,
+		"CreateShippingMethod",
+		"INSERT INTO shipping_methods (name, kind, price, per_kg, free_above, max_weight, carrier, service, active, zone_id) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		append(shippingMethodArgs(method), method.ZoneID)...,
+	)
+	if err != nil {
+		return method, err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return method, err
+	}
+	return GetShippingMethod(ctx, int(id))
+}
+
+func UpdateShippingMethod(ctx context.Context, method ShippingMethod) (ShippingMethod, error) {
+	db := Connect()
+	defer db.Close()
+
+	_, err := execContext(
+		ctx,
+		db,
+		"UpdateShippingMethod",
+		"UPDATE shipping_methods SET name = ?, kind = ?, price = ?, per_kg = ?, free_above = ?, max_weight = ?, carrier = ?, service = ?, active = ? WHERE id = ?",
+		append(shippingMethodArgs(method), method.ID)...,
+	)
+	if err != nil {
+		return method, err
+	}
+	return GetShippingMethod(ctx, method.ID)
+}
+
+func DeleteShippingMethod(ctx context.Context, id int) error {
+	db := Connect()
+	defer db.Close()
+
+	result, err := execContext(ctx, db, "DeleteShippingMethod", "DELETE FROM shipping_methods WHERE id = ?", id)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return ErrNotFound
+	}
+	return err
+}
+
+// ShipOrder books the parcel of a paid order through book, then records
+// the shipment and moves the order to shipped in the same transaction. The
+// order stays locked while book runs, so a second call or a cancellation
+// waits for it and then finds the order no longer paid, failing with
+// ErrStatusChanged, rather than buying another label. It fails with
+// ErrDuplicate when the carrier already used the tracking number.
+func ShipOrder(ctx context.Context, id int, actor string, book func() (Shipment, error)) (Shipment, error) {
+	var shipment Shipment
+	err := inTx(ctx, "ShipOrder", func(tx *sql.Tx) error {
+		var status string
+		err := tx.QueryRowContext(ctx, "SELECT status FROM orders WHERE id = ? FOR UPDATE", id).Scan(&status)
+		if err == sql.ErrNoRows {
+			return ErrNotFound
+		}
+		if err != nil {
+			return err
+		}
+		if status != "paid" {
+			return ErrStatusChanged
+		}
+
+		if shipment, err = book(); err != nil {
+			return err
+		}
+		shipment.OrderID = id
+		shipment.CreatedBy = actor
+		result, err := tx.ExecContext(
+			ctx,
+			"INSERT INTO shipments (order_id, carrier, service, tracking_number, tracking_url, created_by) VALUES (?, ?, ?, ?, ?, ?)",
+			shipment.OrderID,
+			shipment.Carrier,
+			shipment.Service,
+			shipment.TrackingNumber,
+			shipment.TrackingURL,
+			shipment.CreatedBy,
+		)
+		if err != nil {
+			return duplicateError(err)
+		}
+		shipmentID, err := result.LastInsertId()
+		if err != nil {
+			return err
+		}
+		shipment.ID = int(shipmentID)
+		shipment.CreatedAt = time.Now().UTC().Truncate(time.Second)
+
+		if _, err := tx.ExecContext(ctx, "UPDATE orders SET status = 'shipped' WHERE id = ?", id); err != nil {
+			return err
+		}
+		return insertStatusChange(ctx, tx, id, status, "shipped", actor, shipment.Carrier+" "+shipment.TrackingNumber)
+	})
+	return shipment, err
+}
+
+func getOrderShipments(ctx context.Context, db *sql.DB, orderID int) ([]Shipment, error) {
+	result, err := queryContext(
+		ctx,
+		db,
+		"GetOrderShipments",
+		"SELECT id, order_id, carrier, service, tracking_number, tracking_url, created_by, created_at FROM shipments WHERE order_id = ? ORDER BY id",
+		orderID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer result.Close()
+
+	shipments := []Shipment{}
+	for result.Next() {
+		var shipment Shipment
+		var createdAt []byte
+		err := result.Scan(
+			&shipment.ID,
+			&shipment.OrderID,
+			&shipment.Carrier,
+			&shipment.Service,
+			&shipment.TrackingNumber,
+			&shipment.TrackingURL,
+			&shipment.CreatedBy,
+			&createdAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		if shipment.CreatedAt, err = time.Parse(time.DateTime, string(createdAt)); err != nil {
+			return nil, err
+		}
+		shipments = append(shipments, shipment)
+	}
+	return shipments, result.Err()
+}
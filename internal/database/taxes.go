@@ -0,0 +1,109 @@
+package database
+
+import "context"
+
+// TaxRate is a VAT or sales tax rate for a tax class in a country, or in
+// one region of it. An empty Region covers the whole country; rates for the
+// whole country and for the region of an address both apply, so a region
+// can add its own tax on top of a national one.
+type TaxRate struct {
+	ID       int
+	Country  string
+	Region   string
+	TaxClass string
+	Name     string
+	// Rate is a percentage, such as "13.0000".
+	Rate string
+}
+
+const taxRateColumns = "id, country, region, tax_class, name, rate"
+
+func queryTaxRates(ctx context.Context, name, query string, args ...any) ([]TaxRate, error) {
+	db := Connect()
+	defer db.Close()
+
+	result, err := queryContext(ctx, db, name, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer result.Close()
+
+	rates := []TaxRate{}
+	for result.Next() {
+		var rate TaxRate
+		err := result.Scan(&rate.ID, &rate.Country, &rate.Region, &rate.TaxClass, &rate.Name, &rate.Rate)
+		if err != nil {
+			return nil, err
+		}
+		rates = append(rates, rate)
+	}
+	return rates, result.Err()
+}
+
+func GetTaxRates(ctx context.Context) ([]TaxRate, error) {
+	return queryTaxRates(
+		ctx,
+		"GetTaxRates",
+		"SELECT "+taxRateColumns+" FROM tax_rates ORDER BY country, region, tax_class, id",
+	)
+}
+
+// GetTaxRatesFor returns the rates that apply to an address in country and
+// region, national ones first.
+func GetTaxRatesFor(ctx context.Context, country, region string) ([]TaxRate, error) {
+	return queryTaxRates(
+		ctx,
+		"GetTaxRatesFor",
+		"SELECT "+taxRateColumns+" FROM tax_rates WHERE country = ? AND (region = '' OR region = ?) ORDER BY region, tax_class, id",
+		country,
+		region,
+	)
+}
+
+// SaveTaxRate creates a rate, or changes the rate of the one with the same
+// country, region, class and name.
+func SaveTaxRate(ctx context.Context, rate TaxRate) (TaxRate, error) {
+	db := Connect()
+	defer db.Close()
+
+	_, err := execContext(
+		ctx,
+		db,
+		"SaveTaxRate",
+		"INSERT INTO tax_rates (country, region, tax_class, name, rate) VALUES (?, ?, ?, ?, ?) ON DUPLICATE KEY UPDATE rate = VALUES(rate)",
+		rate.Country,
+		rate.Region,
+		rate.TaxClass,
+		rate.Name,
+		rate.Rate,
+	)
+	if err != nil {
+		return rate, err
+	}
+
+	err = queryRowContext(
+		ctx,
+		db,
+		"GetTaxRate",
+		"SELECT "+taxRateColumns+" FROM tax_rates WHERE country = ? AND region = ? AND tax_class = ? AND name = ?",
+		rate.Country,
+		rate.Region,
+		rate.TaxClass,
+		rate.Name,
+	).Scan(&rate.ID, &rate.Country, &rate.Region, &rate.TaxClass, &rate.Name, &rate.Rate)
+	return rate, err
+}
+
+func DeleteTaxRate(ctx context.Context, id int) error {
+	db := Connect()
+	defer db.Close()
+
+	result, err := execContext(ctx, db, "DeleteTaxRate", "DELETE FROM tax_rates WHERE id = ?", id)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return ErrNotFound
+	}
+	return err
+}
@@ -0,0 +1,88 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer("basicthreads/internal/database")
+
+func startSpan(ctx context.Context, name, query string) (context.Context, trace.Span) {
+	return tracer.Start(ctx, name,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			semconv.DBSystemMySQL,
+			semconv.DBStatement(query),
+		),
+	)
+}
+
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// tracedRows ends the span of its query once the rows are done with:
+// when Next runs out or when they are closed, whichever comes first.
+type tracedRows struct {
+	*sql.Rows
+	span trace.Span
+	once sync.Once
+}
+
+func (r *tracedRows) Next() bool {
+	if r.Rows.Next() {
+		return true
+	}
+	r.end()
+	return false
+}
+
+func (r *tracedRows) Close() error {
+	err := r.Rows.Close()
+	r.end()
+	return err
+}
+
+func (r *tracedRows) end() {
+	r.once.Do(func() { endSpan(r.span, r.Rows.Err()) })
+}
+
+// queryContext runs query inside a span named after the calling function.
+// The span lasts until the rows are read or closed, so it covers fetching
+// them and reports errors met on the way.
+func queryContext(ctx context.Context, db *sql.DB, name, query string, args ...any) (*tracedRows, error) {
+	ctx, span := startSpan(ctx, name, query)
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		endSpan(span, err)
+		return nil, err
+	}
+	return &tracedRows{Rows: rows, span: span}, nil
+}
+
+// execContext runs statement inside a span named after the calling function.
+func execContext(ctx context.Context, db *sql.DB, name, statement string, args ...any) (sql.Result, error) {
+	ctx, span := startSpan(ctx, name, statement)
+	result, err := db.ExecContext(ctx, statement, args...)
+	endSpan(span, err)
+	return result, err
+}
+
+// queryRowContext runs a single-row query inside a span named after the
+// calling function. The span covers the round trip, not the Scan.
+func queryRowContext(ctx context.Context, db *sql.DB, name, query string, args ...any) *sql.Row {
+	ctx, span := startSpan(ctx, name, query)
+	row := db.QueryRowContext(ctx, query, args...)
+	endSpan(span, row.Err())
+	return row
+}
@@ -0,0 +1,172 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/go-sql-driver/mysql"
+
+	"basicthreads/internal/money"
+)
+
+// ErrDuplicate is returned when a write would break a unique key, such as
+// a second variant with the same SKU.
+var ErrDuplicate = errors.New("duplicate")
+
+const mysqlDuplicateEntry = 1062
+
+func duplicateError(err error) error {
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) && mysqlErr.Number == mysqlDuplicateEntry {
+		return ErrDuplicate
+	}
+	return err
+}
+
+// Variant is one purchasable version of a product. Price is nil when the
+// variant sells at the product price. Stock is what is on hand and Reserved
+// the part of it held for checkouts in progress.
+type Variant struct {
+	ID        int
+	ProductID int
+	SKU       string
+	Size      string
+	Color     string
+	Price     *money.Money
+	Barcode   string
+	Stock     int
+	Reserved  int
+}
+
+const variantColumns = "id, product_id, sku, size, color, price, COALESCE(barcode, ''), stock, reserved"
+
+func scanVariant(row rowScanner) (Variant, error) {
+	var variant Variant
+	err := row.Scan(
+		&variant.ID,
+		&variant.ProductID,
+		&variant.SKU,
+		&variant.Size,
+		&variant.Color,
+		nullablePrice(&variant.Price),
+		&variant.Barcode,
+		&variant.Stock,
+		&variant.Reserved,
+	)
+	return variant, err
+}
+
+func nullableString(value string) any {
+	if value == "" {
+		return nil
+	}
+	return value
+}
+
+func GetProductVariants(ctx context.Context, productID int) ([]Variant, error) {
+	db := Connect()
+	defer db.Close()
+
+	result, err := queryContext(
+		ctx,
+		db,
+		"GetProductVariants",
+		"SELECT "+variantColumns+" FROM product_variants WHERE product_id = ? ORDER BY id",
+		productID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer result.Close()
+
+	variants := []Variant{}
+	for result.Next() {
+		variant, err := scanVariant(result)
+		if err != nil {
+			return nil, err
+		}
+		variants = append(variants, variant)
+	}
+	return variants, result.Err()
+}
+
+func GetVariant(ctx context.Context, id int) (Variant, error) {
+	db := Connect()
+	defer db.Close()
+
+	variant, err := scanVariant(queryRowContext(
+		ctx,
+		db,
+		"GetVariant",
+		"SELECT "+variantColumns+" FROM product_variants WHERE id = ?",
+		id,
+	))
+	if err == sql.ErrNoRows {
+		return variant, ErrNotFound
+	}
+	return variant, err
+}
+
+// CreateVariant inserts a variant with no stock. Stock arrives through
+// RecordMovement so that it shows up in the ledger.
+func CreateVariant(ctx context.Context, variant Variant) (Variant, error) {
+	db := Connect()
+	defer db.Close()
+
+	result, err := execContext(
+		ctx,
+		db,
+		"CreateVariant",
+		"INSERT INTO product_variants (product_id, sku, size, color, price, barcode) VALUES (?, ?, ?, ?, ?, ?)",
+		variant.ProductID,
+		variant.SKU,
+		variant.Size,
+		variant.Color,
+		variant.Price,
+		nullableString(variant.Barcode),
+	)
+	if err != nil {
+		return variant, duplicateError(err)
+	}
+
+	id, err := result.LastInsertId()
+	variant.ID = int(id)
+	variant.Stock = 0
+	return variant, err
+}
+
+// UpdateVariant saves the descriptive fields of a variant. Stock only
+// changes through the inventory functions.
+func UpdateVariant(ctx context.Context, variant Variant) error {
+	db := Connect()
+	defer db.Close()
+
+	_, err := execContext(
+		ctx,
+		db,
+		"UpdateVariant",
+		"UPDATE product_variants SET sku = ?, size = ?, color = ?, price = ?, barcode = ? WHERE id = ?",
+		variant.SKU,
+		variant.Size,
+		variant.Color,
+		variant.Price,
+		nullableString(variant.Barcode),
+		variant.ID,
+	)
+	return duplicateError(err)
+}
+
+func DeleteVariant(ctx context.Context, id int) error {
+	db := Connect()
+	defer db.Close()
+
+	_, err := execContext(
+		ctx,
+		db,
+		"DeleteVariant",
+		"DELETE FROM product_variants WHERE id = ?",
+		id,
+	)
+	return err
+}
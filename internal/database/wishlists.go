@@ -0,0 +1,420 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"basicthreads/internal/money"
+)
+
+// Wishlist is a named list of products a customer keeps for later. A list
+// with a share token can be read by anyone holding the token.
+type Wishlist struct {
+	ID         int
+	CustomerID int `json:"-"`
+	Name       string
+	ShareToken string `json:",omitempty"`
+	ItemCount  int
+	Items      []WishlistItem `json:",omitempty"`
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+}
+
+// WishlistItem is a product on a wishlist, optionally narrowed to one of
+// its variants, with the current catalog data.
+type WishlistItem struct {
+	ID        int
+	ProductID int
+	VariantID *int `json:",omitempty"`
+	Name      string
+	Image     string
+	Price     money.Money
+	SKU       string `json:",omitempty"`
+	Size      string `json:",omitempty"`
+	Color     string `json:",omitempty"`
+	// NotifyRestock asks for an email when the variant is back in stock.
+	NotifyRestock bool
+	// Live is false once the product is deleted or no longer available.
+	Live bool
+	// InStock is how many units of the variant can be sold, or nil when
+	// the item is not narrowed to a variant.
+	InStock   *int `json:",omitempty"`
+	CreatedAt time.Time
+}
+
+// RestockSubscriber is a customer waiting for a variant to be back in
+// stock.
+type RestockSubscriber struct {
+	ItemID      int
+	WishlistID  int
+	Name        string
+	Email       string
+	ProductID   int
+	ProductName string
+	SKU         string
+	Size        string
+	Color       string
+}
+
+const wishlistColumns = `w.id, w.customer_id, w.name, COALESCE(w.share_token, ''), w.created_at, w.updated_at,
+	(SELECT COUNT(*) FROM wishlist_items AS wi WHERE wi.wishlist_id = w.id)`
+
+func scanWishlist(row rowScanner) (Wishlist, error) {
+	var wishlist Wishlist
+	var createdAt, updatedAt []byte
+	err := row.Scan(
+		&wishlist.ID,
+		&wishlist.CustomerID,
+		&wishlist.Name,
+		&wishlist.ShareToken,
+		&createdAt,
+		&updatedAt,
+		&wishlist.ItemCount,
+	)
+	if err != nil {
+		return wishlist, err
+	}
+	if wishlist.CreatedAt, err = time.Parse(time.DateTime, string(createdAt)); err != nil {
+		return wishlist, err
+	}
+	wishlist.UpdatedAt, err = time.Parse(time.DateTime, string(updatedAt))
+	return wishlist, err
+}
+
+// GetWishlists lists the wishlists of a customer, oldest first, without
+// their items.
+func GetWishlists(ctx context.Context, customerID int) ([]Wishlist, error) {
+	db := Connect()
+	defer db.Close()
+
+	result, err := queryContext(
+		ctx,
+		db,
+		"GetWishlists",
+		"SELECT "+wishlistColumns+" FROM wishlists AS w WHERE w.customer_id = ? ORDER BY w.id",
+		customerID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer result.Close()
+
+	wishlists := []Wishlist{}
+	for result.Next() {
+		wishlist, err := scanWishlist(result)
+		if err != nil {
+			return nil, err
+		}
+		wishlists = append(wishlists, wishlist)
+	}
+	return wishlists, result.Err()
+}
+
+// GetWishlist returns a wishlist of a customer without its items.
+// Wishlists of other customers are not found.
+func GetWishlist(ctx context.Context, customerID, id int) (Wishlist, error) {
+	db := Connect()
+	defer db.Close()
+
+	wishlist, err := scanWishlist(queryRowContext(
+		ctx,
+		db,
+		"GetWishlist",
+		"SELECT "+wishlistColumns+" FROM wishlists AS w WHERE w.id = ? AND w.customer_id = ?",
+		id,
+		customerID,
+	))
+	if err == sql.ErrNoRows {
+		return wishlist, ErrNotFound
+	}
+	return wishlist, err
+}
+
+// GetSharedWishlist returns the wishlist a share token is for.
+func GetSharedWishlist(ctx context.Context, token string) (Wishlist, error) {
+	db := Connect()
+	defer db.Close()
+
+	wishlist, err := scanWishlist(queryRowContext(
+		ctx,
+		db,
+		"GetSharedWishlist",
+		"SELECT "+wishlistColumns+" FROM wishlists AS w WHERE w.share_token = ?",
+		token,
+	))
+	if err == sql.ErrNoRows {
+		return wishlist, ErrNotFound
+	}
+	return wishlist, err
+}
+
+func CountWishlists(ctx context.Context, customerID int) (int, error) {
+	db := Connect()
+	defer db.Close()
+
+	var count int
+	err := queryRowContext(ctx, db, "CountWishlists", "SELECT COUNT(*) FROM wishlists WHERE customer_id = ?", customerID).Scan(&count)
+	return count, err
+}
+
+// CreateWishlist fails with ErrDuplicate when the customer already has a
+// wishlist with the same name.
+func CreateWishlist(ctx context.Context, wishlist Wishlist) (Wishlist, error) {
+	db := Connect()
+	defer db.Close()
+
+	result, err := execContext(
+		ctx,
+		db,
+		"CreateWishlist",
+		"INSERT INTO wishlists (customer_id, name, share_token) VALUES (?, ?, ?)",
+		wishlist.CustomerID,
+		wishlist.Name,
+		nullableString(wishlist.ShareToken),
+	)
+	if err != nil {
+		return wishlist, duplicateError(err)
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return wishlist, err
+	}
+	return GetWishlist(ctx, wishlist.CustomerID, int(id))
+}
+
+// UpdateWishlist renames a wishlist and sets its share token; an empty
+// token stops sharing it.
+func UpdateWishlist(ctx context.Context, wishlist Wishlist) (Wishlist, error) {
+	db := Connect()
+	defer db.Close()
+
+	_, err := execContext(
+		ctx,
+		db,
+		"UpdateWishlist",
+		"UPDATE wishlists SET name = ?, share_token = ? WHERE id = ? AND customer_id = ?",
+		wishlist.Name,
+		nullableString(wishlist.ShareToken),
+		wishlist.ID,
+		wishlist.CustomerID,
+	)
+	if err != nil {
+		return wishlist, duplicateError(err)
+	}
+	return GetWishlist(ctx, wishlist.CustomerID, wishlist.ID)
+}
+
+func DeleteWishlist(ctx context.Context, customerID, id int) error {
+	db := Connect()
+	defer db.Close()
+
+	result, err := execContext(ctx, db, "DeleteWishlist", "DELETE FROM wishlists WHERE id = ? AND customer_id = ?", id, customerID)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return ErrNotFound
+	}
+	return err
+}
+
+// GetWishlistItems lists the items of a wishlist, newest first.
+func GetWishlistItems(ctx context.Context, wishlistID int) ([]WishlistItem, error) {
+	db := Connect()
+	defer db.Close()
+
+	result, err := queryContext(
+		ctx,
+		db,
+		"GetWishlistItems",
+		`SELECT wi.id, wi.product_id, wi.variant_id, p.name, p.img, COALESCE(v.price, p.price),
+			COALESCE(v.sku, ''), COALESCE(v.size, ''), COALESCE(v.color, ''), wi.notify_restock,
+			p.available AND p.deleted_at IS NULL, v.stock - v.reserved, wi.created_at
+		FROM wishlist_items AS wi
+		INNER JOIN products AS p ON p.product_id = wi.product_id
+		LEFT JOIN product_variants AS v ON v.id = wi.variant_id
+		WHERE wi.wishlist_id = ?
+		ORDER BY wi.id DESC`,
+		wishlistID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer result.Close()
+
+	items := []WishlistItem{}
+	for result.Next() {
+		var item WishlistItem
+		var variantID, inStock sql.NullInt64
+		var createdAt []byte
+		err := result.Scan(
+			&item.ID,
+			&item.ProductID,
+			&variantID,
+			&item.Name,
+			&item.Image,
+			price(&item.Price),
+			&item.SKU,
+			&item.Size,
+			&item.Color,
+			&item.NotifyRestock,
+			&item.Live,
+			&inStock,
+			&createdAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		item.VariantID = nullInt(variantID)
+		item.InStock = nullInt(inStock)
+		if item.CreatedAt, err = time.Parse(time.DateTime, string(createdAt)); err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	return items, result.Err()
+}
+
+func CountWishlistItems(ctx context.Context, wishlistID int) (int, error) {
+	db := Connect()
+	defer db.Close()
+
+	var count int
+	err := queryRowContext(ctx, db, "CountWishlistItems", "SELECT COUNT(*) FROM wishlist_items WHERE wishlist_id = ?", wishlistID).Scan(&count)
+	return count, err
+}
+
+// SaveWishlistItem puts a product and variant on a wishlist, or updates
+// the restock flag of the item already there. It reports whether the
+// item is new.
+func SaveWishlistItem(ctx context.Context, wishlistID, productID int, variantID *int, notify bool) (bool, error) {
+	created := false
+	err := inTx(ctx, "SaveWishlistItem", func(tx *sql.Tx) error {
+		// Lock the wishlist so two requests cannot both add the item.
+		var id int
+		err := tx.QueryRowContext(ctx, "SELECT id FROM wishlists WHERE id = ? FOR UPDATE", wishlistID).Scan(&id)
+		if err == sql.ErrNoRows {
+			return ErrNotFound
+		}
+		if err != nil {
+			return err
+		}
+
+		err = tx.QueryRowContext(
+			ctx,
+			"SELECT id FROM wishlist_items WHERE wishlist_id = ? AND product_id = ? AND variant_id <=> ?",
+			wishlistID,
+			productID,
+			intValue(variantID),
+		).Scan(&id)
+		if err == sql.ErrNoRows {
+			created = true
+			_, err = tx.ExecContext(
+				ctx,
+				"INSERT INTO wishlist_items (wishlist_id, product_id, variant_id, notify_restock) VALUES (?, ?, ?, ?)",
+				wishlistID,
+				productID,
+				intValue(variantID),
+				notify,
+			)
+			return err
+		}
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx, "UPDATE wishlist_items SET notify_restock = ? WHERE id = ?", notify, id)
+		return err
+	})
+	return created, err
+}
+
+func DeleteWishlistItem(ctx context.Context, wishlistID, itemID int) error {
+	db := Connect()
+	defer db.Close()
+
+	result, err := execContext(
+		ctx,
+		db,
+		"DeleteWishlistItem",
+		"DELETE FROM wishlist_items WHERE id = ? AND wishlist_id = ?",
+		itemID,
+		wishlistID,
+	)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return ErrNotFound
+	}
+	return err
+}
+
+// GetRestockSubscribers lists the wishlist items waiting for a variant to
+// be back in stock, with who to tell.
+func GetRestockSubscribers(ctx context.Context, variantID int) ([]RestockSubscriber, error) {
+	db := Connect()
+	defer db.Close()
+
+	result, err := queryContext(
+		ctx,
+		db,
+		"GetRestockSubscribers",
+		`SELECT wi.id, w.id, c.name, c.email, p.product_id, p.name, v.sku, v.size, v.color
+		FROM wishlist_items AS wi
+		INNER JOIN wishlists AS w ON w.id = wi.wishlist_id
+		INNER JOIN customers AS c ON c.id = w.customer_id AND c.deleted_at IS NULL
+		INNER JOIN product_variants AS v ON v.id = wi.variant_id
+		INNER JOIN products AS p ON p.product_id = wi.product_id AND p.available AND p.deleted_at IS NULL
+		WHERE wi.variant_id = ? AND wi.notify_restock
+		ORDER BY wi.id`,
+		variantID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer result.Close()
+
+	subscribers := []RestockSubscriber{}
+	for result.Next() {
+		var s RestockSubscriber
+		err := result.Scan(&s.ItemID, &s.WishlistID, &s.Name, &s.Email, &s.ProductID, &s.ProductName, &s.SKU, &s.Size, &s.Color)
+		if err != nil {
+			return nil, err
+		}
+		subscribers = append(subscribers, s)
+	}
+	return subscribers, result.Err()
+}
+
+// ClaimRestockNotification turns off the restock flag of an item before
+// its customer is told, and reports whether this call turned it off. Of
+// two restocks racing to email the same customer only one gets the item.
+func ClaimRestockNotification(ctx context.Context, itemID int) (bool, error) {
+	db := Connect()
+	defer db.Close()
+
+	result, err := execContext(
+		ctx,
+		db,
+		"ClaimRestockNotification",
+		"UPDATE wishlist_items SET notify_restock = FALSE WHERE id = ? AND notify_restock",
+		itemID,
+	)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n == 1, err
+}
+
+// RestoreRestockNotification turns the restock flag of an item back on
+// when its email could not be sent, so the next restock tries again.
+func RestoreRestockNotification(ctx context.Context, itemID int) error {
+	db := Connect()
+	defer db.Close()
+
+	_, err := execContext(ctx, db, "RestoreRestockNotification", "UPDATE wishlist_items SET notify_restock = TRUE WHERE id = ?", itemID)
+	return err
+}
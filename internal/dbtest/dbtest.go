@@ -0,0 +1,138 @@
+package dbtest
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"testing"
+
+	"basicthreads/internal/config"
+	"basicthreads/internal/database"
+	"basicthreads/internal/migrations"
+)
+
+// lockName serializes the test binaries of different packages, which go
+// test runs in parallel against the same database.
+const lockName = "basicthreads_dbtest"
+
+// Open points the database package at the schema named by TEST_DBNAME,
+// migrates it and empties every table. Tests calling it are skipped when
+// TEST_DBNAME is not set. The database stays locked to the calling test
+// until it ends.
+func Open(t *testing.T) *sql.DB {
+	t.Helper()
+	name := os.Getenv("TEST_DBNAME")
+	if name == "" {
+		t.Skip("TEST_DBNAME is not set")
+	}
+	t.Setenv("DBNAME", name)
+
+	ctx := context.Background()
+	db := database.Connect()
+	t.Cleanup(func() { db.Close() })
+
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var locked sql.NullInt64
+	if err := conn.QueryRowContext(ctx, "SELECT GET_LOCK(?, 60)", lockName).Scan(&locked); err != nil {
+		t.Fatal(err)
+	}
+	if locked.Int64 != 1 {
+		t.Fatal("timed out waiting for the test database")
+	}
+	t.Cleanup(func() {
+		conn.ExecContext(context.Background(), "SELECT RELEASE_LOCK(?)", lockName)
+		conn.Close()
+	})
+
+	migrator, err := migrations.New(config.Load().Database.DSN())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer migrator.Close()
+	if _, err := migrator.Up(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	rows, err := conn.QueryContext(ctx,
+		"SELECT table_name FROM information_schema.tables "+
+			"WHERE table_schema = DATABASE() AND table_type = 'BASE TABLE' AND table_name <> 'schema_migrations'")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var tables []string
+	for rows.Next() {
+		var table string
+		if err := rows.Scan(&table); err != nil {
+			t.Fatal(err)
+		}
+		tables = append(tables, table)
+	}
+	rows.Close()
+	if _, err := conn.ExecContext(ctx, "SET FOREIGN_KEY_CHECKS = 0"); err != nil {
+		t.Fatal(err)
+	}
+	for _, table := range tables {
+		if _, err := conn.ExecContext(ctx, "TRUNCATE TABLE `"+table+"`"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := conn.ExecContext(ctx, "SET FOREIGN_KEY_CHECKS = 1"); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+// Insert runs an INSERT and returns the id of the new row.
+func Insert(t *testing.T, db *sql.DB, query string, args ...any) int {
+	t.Helper()
+	result, err := db.Exec(query, args...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return int(id)
+}
+
+// Customer adds a customer with the given email and password.
+func Customer(t *testing.T, db *sql.DB, email, password string) int {
+	t.Helper()
+	return Insert(t, db,
+		"INSERT INTO customers (name, email, phone, password, role) VALUES (?, ?, '', MD5(?), 'customer')",
+		email, email, password)
+}
+
+// Variant adds a product at price with one variant holding stock units,
+// and returns the ids of both.
+func Variant(t *testing.T, db *sql.DB, sku, price string, stock int) (productID, variantID int) {
+	t.Helper()
+	productID = Insert(t, db,
+		"INSERT INTO products (name, price, description, img, available) VALUES (?, ?, '', '', TRUE)",
+		sku, price)
+	variantID = Insert(t, db,
+		"INSERT INTO product_variants (product_id, sku, size, color, stock) VALUES (?, ?, '', '', ?)",
+		productID, sku, stock)
+	return productID, variantID
+}
+
+// Order adds an order in status for quantity units of a variant at price
+// each, without tax, shipping or discounts.
+func Order(t *testing.T, db *sql.DB, customerID, productID, variantID int, status, price string, quantity int) int {
+	t.Helper()
+	reference := fmt.Sprintf("T%d-%d-%d", customerID, variantID, quantity)
+	orderID := Insert(t, db,
+		"INSERT INTO orders (reference, customer_id, email, status, subtotal, total) "+
+			"SELECT ?, id, email, ?, ? * ?, ? * ? FROM customers WHERE id = ?",
+		reference, status, price, quantity, price, quantity, customerID)
+	Insert(t, db,
+		"INSERT INTO order_items (order_id, product_id, variant_id, name, sku, size, color, image, unit_price, quantity, line_total) "+
+			"SELECT ?, product_id, id, sku, sku, size, color, '', ?, ?, ? * ? FROM product_variants WHERE id = ? AND product_id = ?",
+		orderID, price, quantity, price, quantity, variantID, productID)
+	return orderID
+}
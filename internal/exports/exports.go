@@ -0,0 +1,320 @@
+package exports
+
+import (
+	"archive/zip"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"basicthreads/internal/addresses"
+	"basicthreads/internal/config"
+	"basicthreads/internal/database"
+	"basicthreads/internal/mailer"
+	"basicthreads/internal/wishlists"
+)
+
+// stuckAfter is how long a run may take before the sweep retries it.
+const stuckAfter = 10 * time.Minute
+
+var (
+	ErrNotFound   = database.ErrNotFound
+	ErrInProgress = database.ErrExportInProgress
+)
+
+// Request queues an export of the data of a customer and starts generating
+// it. The download link goes to notifyEmail once the archive is ready.
+func Request(ctx context.Context, customerID int, requestedBy, notifyEmail string) (database.DataExport, error) {
+	export, err := database.CreateDataExport(ctx, database.DataExport{
+		CustomerID:  customerID,
+		RequestedBy: requestedBy,
+		NotifyEmail: notifyEmail,
+	})
+	if err != nil {
+		return export, err
+	}
+
+	// The request is over long before the archive is; the sweep picks the
+	// export up if this process dies first.
+	go func() {
+		if err := Generate(context.Background(), export.ID); err != nil {
+			log.Printf("generating data export %d: %v", export.ID, err)
+		}
+	}()
+	return export, nil
+}
+
+func List(ctx context.Context, customerID int) ([]database.DataExport, error) {
+	return database.GetDataExports(ctx, customerID)
+}
+
+// Open returns the export a download token is for, or ErrNotFound once the
+// link expired.
+func Open(ctx context.Context, token string) (database.DataExport, error) {
+	return database.GetReadyDataExport(ctx, hashToken(token))
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func randomToken() (string, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(raw), nil
+}
+
+// Generate builds the archive of an export and emails its download link.
+// It does nothing when another worker already claimed the export.
+func Generate(ctx context.Context, id int) error {
+	claimed, err := database.ClaimDataExport(ctx, id, stuckAfter)
+	if err != nil || !claimed {
+		return err
+	}
+	export, err := database.GetDataExport(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	path, err := build(ctx, export)
+	if err != nil {
+		if failErr := database.FailDataExport(ctx, id, err.Error()); failErr != nil {
+			log.Printf("failing data export %d: %v", id, failErr)
+		}
+		return err
+	}
+
+	cfg := config.Load()
+	token, err := randomToken()
+	completed := false
+	if err == nil {
+		completed, err = database.CompleteDataExport(ctx, id, path, hashToken(token), cfg.ExportTTL)
+	}
+	if err == nil && !completed {
+		// The export went away while it was built, so nobody may download
+		// the archive.
+		log.Printf("data export %d is no longer running, discarding its archive", id)
+		os.Remove(path)
+		return nil
+	}
+	if err != nil {
+		os.Remove(path)
+		if failErr := database.FailDataExport(ctx, id, err.Error()); failErr != nil {
+			log.Printf("failing data export %d: %v", id, failErr)
+		}
+		return err
+	}
+
+	notify(ctx, export, cfg.PublicURL+"/exports/"+token, cfg.ExportTTL)
+	return nil
+}
+
+// build writes the data of the customer of an export to a zip archive in
+// the export directory and returns its path.
+func build(ctx context.Context, export database.DataExport) (string, error) {
+	customer, err := database.GetCustomer(ctx, export.CustomerID)
+	if err != nil {
+		return "", err
+	}
+	book, err := addresses.List(ctx, customer.ID)
+	if err != nil {
+		return "", err
+	}
+	ids, err := database.GetCustomerOrderIDs(ctx, customer.ID)
+	if err != nil {
+		return "", err
+	}
+	orders := make([]database.Order, 0, len(ids))
+	for _, id := range ids {
+		order, err := database.GetOrder(ctx, id)
+		if err != nil {
+			return "", err
+		}
+		orders = append(orders, order)
+	}
+	messages, err := database.GetContactMessages(ctx, customer.Email)
+	if err != nil {
+		return "", err
+	}
+	logins, err := database.GetSessions(ctx, customer.ID)
+	if err != nil {
+		return "", err
+	}
+	written := []database.Review{}
+	for {
+		page, total, err := database.GetReviews(ctx, database.ReviewFilter{CustomerID: customer.ID, Offset: len(written), Limit: 100})
+		if err != nil {
+			return "", err
+		}
+		written = append(written, page...)
+		if len(page) == 0 || len(written) >= total {
+			break
+		}
+	}
+	lists, err := wishlists.List(ctx, customer.ID)
+	if err != nil {
+		return "", err
+	}
+	for i := range lists {
+		if lists[i], err = wishlists.Get(ctx, customer.ID, lists[i].ID); err != nil {
+			return "", err
+		}
+	}
+
+	files := []struct {
+		name string
+		data any
+	}{
+		{"manifest.json", newManifest(export)},
+		{"profile.json", customer},
+		{"addresses.json", book},
+		{"orders.json", orders},
+		{"contact_messages.json", messages},
+		{"login_history.json", logins},
+		{"wishlists.json", lists},
+		{"reviews.json", written},
+	}
+
+	dir := config.Load().ExportDir
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return "", err
+	}
+	name, err := randomToken()
+	if err != nil {
+		return "", err
+	}
+	path := filepath.Join(dir, name+".zip")
+
+	// Write under a temporary name so a crash never leaves half an archive
+	// where a download could find it.
+	tmp, err := os.CreateTemp(dir, "export-*.tmp")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+
+	archive := zip.NewWriter(tmp)
+	for _, file := range files {
+		w, err := archive.Create(file.name)
+		if err != nil {
+			tmp.Close()
+			return "", err
+		}
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(file.data); err != nil {
+			tmp.Close()
+			return "", err
+		}
+	}
+	if err := archive.Close(); err != nil {
+		tmp.Close()
+		return "", err
+	}
+	if err := tmp.Close(); err != nil {
+		return "", err
+	}
+	return path, os.Rename(tmp.Name(), path)
+}
+
+type manifest struct {
+	ExportID    int
+	CustomerID  int
+	RequestedBy string
+	CreatedAt   time.Time
+	GeneratedAt time.Time
+	Files       []string
+}
+
+func newManifest(export database.DataExport) manifest {
+	return manifest{
+		ExportID:    export.ID,
+		CustomerID:  export.CustomerID,
+		RequestedBy: export.RequestedBy,
+		CreatedAt:   export.CreatedAt,
+		GeneratedAt: time.Now().UTC().Truncate(time.Second),
+		Files: []string{
+			"profile.json",
+			"addresses.json",
+			"orders.json",
+			"contact_messages.json",
+			"login_history.json",
+			"wishlists.json",
+			"reviews.json",
+		},
+	}
+}
+
+func notify(ctx context.Context, export database.DataExport, link string, ttl time.Duration) {
+	err := mailer.Send(ctx, mailer.Message{
+		To:      []mailer.Address{{Email: export.NotifyEmail}},
+		Subject: "Tu copia de datos de Threads está lista",
+		HTML: fmt.Sprintf(
+			`<html><body><p>La copia de los datos personales que pediste está lista.</p><p><a href="%s">Descargar</a></p><p>El enlace funciona durante %d horas.</p></body></html>`,
+			html.EscapeString(link),
+			int(ttl.Hours()),
+		),
+	})
+	if err != nil {
+		log.Printf("sending data export %d link: %v", export.ID, err)
+	}
+}
+
+// Cleanup generates the exports no worker is on, and deletes the archives
+// whose link expired.
+func Cleanup(ctx context.Context) error {
+	waiting, err := database.GetWaitingDataExports(ctx, stuckAfter)
+	if err != nil {
+		return err
+	}
+	for _, export := range waiting {
+		if err := Generate(ctx, export.ID); err != nil {
+			log.Printf("generating data export %d: %v", export.ID, err)
+		}
+	}
+
+	expired, err := database.GetExpiredDataExports(ctx)
+	if err != nil {
+		return err
+	}
+	for _, export := range expired {
+		if export.Path != "" {
+			if err := os.Remove(export.Path); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return err
+			}
+		}
+		if err := database.ExpireDataExport(ctx, export.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Sweep runs Cleanup every interval until ctx is done.
+func Sweep(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := Cleanup(ctx); err != nil && !errors.Is(err, context.Canceled) {
+				log.Printf("sweeping data exports: %v", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,372 @@
+package images
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	_ "image/gif"
+
+	xdraw "golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+
+	"basicthreads/internal/config"
+	"basicthreads/internal/database"
+	"basicthreads/internal/products"
+	"basicthreads/internal/storage"
+)
+
+const (
+	// MaxImages is how many images a product can have.
+	MaxImages = 20
+	// MaxDimension bounds the width and height of uploads, so decoding one
+	// cannot take gigabytes of memory.
+	MaxDimension = 8000
+
+	jpegQuality = 85
+)
+
+var (
+	ErrNotFound        = database.ErrNotFound
+	ErrProductNotFound = errors.New("product not found")
+	ErrTooMany         = fmt.Errorf("a product can have at most %d images", MaxImages)
+	ErrTooLarge        = errors.New("image is too large")
+	ErrUnsupported     = errors.New("image must be a JPEG, PNG, GIF or WebP file")
+)
+
+// Variant is a resized copy made of every upload, fitting in Size pixels
+// on its longest side.
+type Variant struct {
+	Name string
+	Size int
+}
+
+var Variants = []Variant{
+	{"thumbnail", 160},
+	{"medium", 640},
+	{"large", 1280},
+}
+
+// formats maps the sniffed content types of uploads to their format names.
+var formats = map[string]string{
+	"image/jpeg": "jpeg",
+	"image/png":  "png",
+	"image/gif":  "gif",
+	"image/webp": "webp",
+}
+
+// Extension returns the file extension used for a format.
+func Extension(format string) string {
+	if format == "jpeg" {
+		return "jpg"
+	}
+	return format
+}
+
+// originalKey and variantKey name the blobs of an image.
+func originalKey(img database.ProductImage) string {
+	return img.Key + "/original." + Extension(img.Original)
+}
+
+func variantKey(img database.ProductImage, variant, format string) string {
+	return img.Key + "/" + variant + "." + Extension(format)
+}
+
+// keys lists every blob of an image.
+func keys(img database.ProductImage) []string {
+	list := []string{originalKey(img)}
+	for _, variant := range Variants {
+		list = append(list, variantKey(img, variant.Name, img.Format), variantKey(img, variant.Name, "webp"))
+	}
+	return list
+}
+
+// withURLs fills in where the blobs of an image are served. Variants are
+// named by size, with a _webp suffix for their WebP copies.
+func withURLs(img database.ProductImage) database.ProductImage {
+	base := config.Load().MediaURL + "/"
+	img.URLs = map[string]string{"original": base + originalKey(img)}
+	for _, variant := range Variants {
+		img.URLs[variant.Name] = base + variantKey(img, variant.Name, img.Format)
+		img.URLs[variant.Name+"_webp"] = base + variantKey(img, variant.Name, "webp")
+	}
+	return img
+}
+
+// List returns the images of a product in display order.
+func List(ctx context.Context, productID int) ([]database.ProductImage, error) {
+	list, err := database.GetProductImages(ctx, productID)
+	for i := range list {
+		list[i] = withURLs(list[i])
+	}
+	return list, err
+}
+
+// Decode checks that data is an image in one of the accepted formats and
+// of reasonable dimensions before decoding it.
+func Decode(data []byte) (image.Image, string, error) {
+	format, ok := formats[http.DetectContentType(data)]
+	if !ok {
+		return nil, "", ErrUnsupported
+	}
+	size, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", products.ValidationError{"image": "could not be read: " + err.Error()}
+	}
+	if size.Width > MaxDimension || size.Height > MaxDimension {
+		return nil, "", fmt.Errorf("%w: at most %dx%d pixels", ErrTooLarge, MaxDimension, MaxDimension)
+	}
+	m, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", products.ValidationError{"image": "could not be read: " + err.Error()}
+	}
+	return m, format, nil
+}
+
+// Resize scales m down to fit in size pixels on its longest side. Smaller
+// images keep their dimensions; they are never scaled up.
+func Resize(m image.Image, size int) image.Image {
+	bounds := m.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width > size || height > size {
+		if width >= height {
+			width, height = size, max(1, height*size/width)
+		} else {
+			width, height = max(1, width*size/height), size
+		}
+	}
+
+	out := image.NewNRGBA(image.Rect(0, 0, width, height))
+	if width == bounds.Dx() && height == bounds.Dy() {
+		draw.Draw(out, out.Bounds(), m, bounds.Min, draw.Src)
+	} else {
+		xdraw.CatmullRom.Scale(out, out.Bounds(), m, bounds, draw.Src, nil)
+	}
+	return out
+}
+
+// Opaque reports whether every pixel of m is fully opaque, in which case
+// its variants can be JPEG.
+func Opaque(m image.Image) bool {
+	if o, ok := m.(interface{ Opaque() bool }); ok {
+		return o.Opaque()
+	}
+	bounds := m.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			if _, _, _, a := m.At(x, y).RGBA(); a != 0xffff {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// Encode writes m in format: jpeg, png or webp.
+func Encode(m image.Image, format string) ([]byte, error) {
+	var out bytes.Buffer
+	var err error
+	switch format {
+	case "jpeg":
+		err = jpeg.Encode(&out, m, &jpeg.Options{Quality: jpegQuality})
+	case "png":
+		err = png.Encode(&out, m)
+	case "webp":
+		err = EncodeWebP(&out, m)
+	default:
+		err = fmt.Errorf("cannot encode %s images", format)
+	}
+	return out.Bytes(), err
+}
+
+// ContentType returns the media type of a format.
+func ContentType(format string) string {
+	return "image/" + format
+}
+
+// Upload checks an uploaded image, stores it with its resized variants and
+// adds it after the other images of the product. Animated GIFs keep only
+// their first frame in the variants.
+func Upload(ctx context.Context, store storage.BlobStore, actor string, productID int, data []byte, alt string) (database.ProductImage, error) {
+	alt = strings.TrimSpace(alt)
+	if utf8.RuneCountInString(alt) > 255 {
+		return database.ProductImage{}, products.ValidationError{"alt": "must be at most 255 characters"}
+	}
+	if limit := config.Load().ImageMaxBytes; len(data) > limit {
+		return database.ProductImage{}, fmt.Errorf("%w: at most %d bytes", ErrTooLarge, limit)
+	}
+
+	product, err := database.GetAdminProduct(ctx, productID)
+	if errors.Is(err, database.ErrNotFound) || (err == nil && product.DeletedAt != nil) {
+		return database.ProductImage{}, ErrProductNotFound
+	}
+	if err != nil {
+		return database.ProductImage{}, err
+	}
+	m, format, err := Decode(data)
+	if err != nil {
+		return database.ProductImage{}, err
+	}
+
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return database.ProductImage{}, err
+	}
+	img := database.ProductImage{
+		ProductID: productID,
+		Key:       "products/" + strconv.Itoa(productID) + "/" + hex.EncodeToString(id),
+		Original:  format,
+		Format:    "jpeg",
+		Width:     m.Bounds().Dx(),
+		Height:    m.Bounds().Dy(),
+		Bytes:     len(data),
+		Alt:       alt,
+	}
+	if !Opaque(m) {
+		img.Format = "png"
+	}
+
+	blobs := map[string][]byte{originalKey(img): data}
+	for _, variant := range Variants {
+		resized := Resize(m, variant.Size)
+		for _, format := range []string{img.Format, "webp"} {
+			encoded, err := Encode(resized, format)
+			if err != nil {
+				return database.ProductImage{}, err
+			}
+			blobs[variantKey(img, variant.Name, format)] = encoded
+		}
+	}
+
+	for key, blob := range blobs {
+		contentType := ContentType(img.Format)
+		if strings.HasSuffix(key, ".webp") {
+			contentType = ContentType("webp")
+		} else if key == originalKey(img) {
+			contentType = ContentType(img.Original)
+		}
+		if err := store.Put(ctx, key, blob, contentType); err != nil {
+			removeBlobs(store, img)
+			return database.ProductImage{}, err
+		}
+	}
+
+	saved, err := database.AddProductImage(ctx, img, MaxImages)
+	switch {
+	case errors.Is(err, database.ErrNotFound):
+		err = ErrProductNotFound
+	case errors.Is(err, database.ErrTooManyImages):
+		err = ErrTooMany
+	}
+	if err != nil {
+		removeBlobs(store, img)
+		return database.ProductImage{}, err
+	}
+
+	if err := syncMainImage(ctx, actor, productID); err != nil {
+		log.Printf("updating the main image of product %d: %v", productID, err)
+	}
+	return withURLs(saved), nil
+}
+
+// removeBlobs deletes what was stored of an image. Failures only leave
+// unreferenced blobs behind, so they are logged.
+func removeBlobs(store storage.BlobStore, img database.ProductImage) {
+	ctx := context.Background()
+	for _, key := range keys(img) {
+		if err := store.Delete(ctx, key); err != nil {
+			log.Printf("deleting %s: %v", key, err)
+		}
+	}
+}
+
+// Delete removes an image of a product and its blobs.
+func Delete(ctx context.Context, store storage.BlobStore, actor string, id int) error {
+	img, err := database.DeleteProductImage(ctx, id)
+	if err != nil {
+		return err
+	}
+	removeBlobs(store, img)
+
+	if err := syncMainImage(ctx, actor, img.ProductID); err != nil {
+		log.Printf("updating the main image of product %d: %v", img.ProductID, err)
+	}
+	return nil
+}
+
+// Reorder puts the images of a product in the order of ids, which must
+// list every image of the product once.
+func Reorder(ctx context.Context, actor string, productID int, ids []int) ([]database.ProductImage, error) {
+	current, err := database.GetProductImages(ctx, productID)
+	if err != nil {
+		return nil, err
+	}
+	seen := map[int]bool{}
+	for _, img := range current {
+		seen[img.ID] = false
+	}
+	valid := len(ids) == len(current)
+	for _, id := range ids {
+		done, ok := seen[id]
+		if !ok || done {
+			valid = false
+			break
+		}
+		seen[id] = true
+	}
+	if !valid {
+		return nil, products.ValidationError{"ids": "must list every image of the product once"}
+	}
+
+	if err := database.ReorderProductImages(ctx, productID, ids); err != nil {
+		return nil, err
+	}
+	if err := syncMainImage(ctx, actor, productID); err != nil {
+		log.Printf("updating the main image of product %d: %v", productID, err)
+	}
+	return List(ctx, productID)
+}
+
+// syncMainImage points the image of a product, which carts, orders and
+// listings show, at the large variant of its first uploaded image. When the
+// last upload goes, an image set by hand elsewhere is left alone.
+func syncMainImage(ctx context.Context, actor string, productID int) error {
+	list, err := List(ctx, productID)
+	if err != nil {
+		return err
+	}
+	want := ""
+	if len(list) > 0 {
+		want = list[0].URLs["large"]
+	}
+
+	// Retry when an admin edits the product at the same time.
+	for attempt := 0; ; attempt++ {
+		product, err := database.GetAdminProduct(ctx, productID)
+		if err != nil {
+			return err
+		}
+		if product.Image == want || (want == "" && !strings.HasPrefix(product.Image, config.Load().MediaURL+"/")) {
+			return nil
+		}
+		_, err = database.ChangeProduct(ctx, productID, product.Version, actor, "image", func(p *database.AdminProduct) error {
+			p.Image = want
+			return nil
+		})
+		if !errors.Is(err, database.ErrVersionConflict) || attempt == 2 {
+			return err
+		}
+	}
+}
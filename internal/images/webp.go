@@ -0,0 +1,464 @@
+package images
+
+import (
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/draw"
+	"io"
+	"sort"
+)
+
+// The encoder below writes lossless WebP (VP8L) with the subtract green and
+// predictor transforms and one set of prefix codes for the whole image. It
+// skips backward references and the color transforms, so files come out
+// larger than those of libwebp, but it needs no cgo. golang.org/x/image/webp
+// only decodes, which the tests use to check every encoded image.
+
+const (
+	maxWebPSize       = 1 << 14
+	codeLengthLimit   = 15
+	codeLengthCodes   = 19
+	codeLengthCodeMax = 7
+	greenAlphabet     = 256 + 24
+	distanceAlphabet  = 40
+	predictor         = 0
+	subtractGreen     = 2
+	predictorBits     = 4
+	vp8lSignature     = 0x2f
+)
+
+var codeLengthOrder = [codeLengthCodes]int{17, 18, 0, 1, 2, 3, 4, 5, 16, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15}
+
+var errWebPTooLarge = errors.New("image too large for WebP")
+
+type bitWriter struct {
+	buf  []byte
+	acc  uint64
+	bits uint
+}
+
+// write appends the low n bits of v, least significant bit first.
+func (w *bitWriter) write(v uint32, n uint) {
+	w.acc |= uint64(v) << w.bits
+	w.bits += n
+	for w.bits >= 8 {
+		w.buf = append(w.buf, byte(w.acc))
+		w.acc >>= 8
+		w.bits -= 8
+	}
+}
+
+func (w *bitWriter) flush() []byte {
+	if w.bits > 0 {
+		w.buf = append(w.buf, byte(w.acc))
+		w.acc, w.bits = 0, 0
+	}
+	return w.buf
+}
+
+// prefixCode is a canonical prefix code, with the codes bit-reversed so
+// they can be written least significant bit first.
+type prefixCode struct {
+	lengths []uint8
+	codes   []uint32
+}
+
+func (c prefixCode) write(w *bitWriter, symbol int) {
+	if n := c.lengths[symbol]; n > 0 {
+		w.write(c.codes[symbol], uint(n))
+	}
+}
+
+// huffmanLengths returns the code length of every symbol with a count,
+// none above limit. Counts are flattened until the tree is shallow enough.
+func huffmanLengths(counts []int, limit int) []uint8 {
+	counts = append([]int(nil), counts...)
+	for {
+		lengths := buildLengths(counts)
+		longest := uint8(0)
+		for _, n := range lengths {
+			if n > longest {
+				longest = n
+			}
+		}
+		if int(longest) <= limit {
+			return lengths
+		}
+		for i, count := range counts {
+			if count > 0 {
+				counts[i] = (count + 1) / 2
+			}
+		}
+	}
+}
+
+func buildLengths(counts []int) []uint8 {
+	type node struct {
+		weight int
+		parent int
+	}
+	lengths := make([]uint8, len(counts))
+	nodes := []node{}
+	leaves := []int{}
+	for symbol, count := range counts {
+		if count > 0 {
+			leaves = append(leaves, symbol)
+		}
+	}
+	if len(leaves) < 2 {
+		for _, symbol := range leaves {
+			lengths[symbol] = 1
+		}
+		return lengths
+	}
+	sort.SliceStable(leaves, func(i, j int) bool { return counts[leaves[i]] < counts[leaves[j]] })
+	for _, symbol := range leaves {
+		nodes = append(nodes, node{weight: counts[symbol], parent: -1})
+	}
+
+	// Two queues: the sorted leaves, and the merged nodes, which come out
+	// in order of weight by construction.
+	nextLeaf, nextMerged := 0, len(leaves)
+	pick := func() int {
+		if nextLeaf < len(leaves) && (nextMerged >= len(nodes) || nodes[nextLeaf].weight <= nodes[nextMerged].weight) {
+			nextLeaf++
+			return nextLeaf - 1
+		}
+		nextMerged++
+		return nextMerged - 1
+	}
+	for merges := 0; merges < len(leaves)-1; merges++ {
+		a, b := pick(), pick()
+		nodes = append(nodes, node{weight: nodes[a].weight + nodes[b].weight, parent: -1})
+		nodes[a].parent = len(nodes) - 1
+		nodes[b].parent = len(nodes) - 1
+	}
+
+	for i, symbol := range leaves {
+		depth := uint8(0)
+		for n := i; nodes[n].parent >= 0; n = nodes[n].parent {
+			depth++
+		}
+		lengths[symbol] = depth
+	}
+	return lengths
+}
+
+// canonical assigns the canonical codes of a set of code lengths.
+func canonical(lengths []uint8) prefixCode {
+	var perLength [codeLengthLimit + 2]uint32
+	for _, n := range lengths {
+		if n > 0 {
+			perLength[n]++
+		}
+	}
+	// Codes of each length follow on from the shorter ones, as in DEFLATE.
+	var next [codeLengthLimit + 2]uint32
+	code := uint32(0)
+	for n := 1; n < len(next); n++ {
+		code = (code + perLength[n-1]) << 1
+		next[n] = code
+	}
+
+	codes := make([]uint32, len(lengths))
+	for symbol, n := range lengths {
+		if n == 0 {
+			continue
+		}
+		c := next[n]
+		next[n]++
+		reversed := uint32(0)
+		for i := uint8(0); i < n; i++ {
+			reversed = reversed<<1 | (c>>i)&1
+		}
+		codes[symbol] = reversed
+	}
+	return prefixCode{lengths: lengths, codes: codes}
+}
+
+// writePrefixCode writes the code for a histogram and returns it. One or
+// two small symbols fit the simple form; anything else has its code
+// lengths written with a code of their own.
+func writePrefixCode(w *bitWriter, histogram []int) prefixCode {
+	used := []int{}
+	for symbol, count := range histogram {
+		if count > 0 {
+			used = append(used, symbol)
+		}
+	}
+	if len(used) == 0 {
+		used = []int{0}
+	}
+
+	if len(used) <= 2 && used[len(used)-1] < 256 {
+		w.write(1, 1)
+		w.write(uint32(len(used)-1), 1)
+		if used[0] < 2 {
+			w.write(0, 1)
+			w.write(uint32(used[0]), 1)
+		} else {
+			w.write(1, 1)
+			w.write(uint32(used[0]), 8)
+		}
+		lengths := make([]uint8, len(histogram))
+		if len(used) == 2 {
+			w.write(uint32(used[1]), 8)
+			lengths[used[0]], lengths[used[1]] = 1, 1
+		}
+		return canonical(lengths)
+	}
+
+	lengths := huffmanLengths(histogram, codeLengthLimit)
+	lengthCounts := make([]int, codeLengthCodes)
+	for _, n := range lengths {
+		lengthCounts[n]++
+	}
+	// A code needs two symbols; lend the length code a second one.
+	distinct := 0
+	for _, count := range lengthCounts {
+		if count > 0 {
+			distinct++
+		}
+	}
+	if distinct == 1 {
+		if lengthCounts[0] == 0 {
+			lengthCounts[0] = 1
+		} else {
+			lengthCounts[1] = 1
+		}
+	}
+	lengthCode := canonical(huffmanLengths(lengthCounts, codeLengthCodeMax))
+
+	w.write(0, 1)
+	w.write(codeLengthCodes-4, 4)
+	for _, symbol := range codeLengthOrder {
+		w.write(uint32(lengthCode.lengths[symbol]), 3)
+	}
+	// Every symbol of the alphabet has its length written.
+	w.write(0, 1)
+	for _, n := range lengths {
+		lengthCode.write(w, int(n))
+	}
+	return canonical(lengths)
+}
+
+// writeEntropyImage writes the prefix codes and pixels of an image in
+// r, g, b, a order. Only the main image says whether it has meta prefix
+// codes.
+func writeEntropyImage(w *bitWriter, pix []byte, main bool) {
+	green := make([]int, greenAlphabet)
+	red := make([]int, 256)
+	blue := make([]int, 256)
+	alpha := make([]int, 256)
+	for i := 0; i < len(pix); i += 4 {
+		red[pix[i]]++
+		green[pix[i+1]]++
+		blue[pix[i+2]]++
+		alpha[pix[i+3]]++
+	}
+
+	// No color cache, and one group of prefix codes for the whole image.
+	w.write(0, 1)
+	if main {
+		w.write(0, 1)
+	}
+	greenCode := writePrefixCode(w, green)
+	redCode := writePrefixCode(w, red)
+	blueCode := writePrefixCode(w, blue)
+	alphaCode := writePrefixCode(w, alpha)
+	writePrefixCode(w, make([]int, distanceAlphabet))
+
+	for i := 0; i < len(pix); i += 4 {
+		greenCode.write(w, int(pix[i+1]))
+		redCode.write(w, int(pix[i]))
+		blueCode.write(w, int(pix[i+2]))
+		alphaCode.write(w, int(pix[i+3]))
+	}
+}
+
+// Predictor modes the encoder picks from, per block.
+const (
+	predictLeft     = 1
+	predictTop      = 2
+	predictAverage  = 7
+	predictSelect   = 11
+	predictGradient = 12
+)
+
+var predictors = []int{predictLeft, predictTop, predictAverage, predictSelect, predictGradient}
+
+func abs(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
+
+func clampByte(v int) byte {
+	switch {
+	case v < 0:
+		return 0
+	case v > 255:
+		return 255
+	}
+	return byte(v)
+}
+
+// predict writes into out the prediction of mode from the left, top and
+// top left pixels.
+func predict(out, left, top, topLeft []byte, mode int) {
+	switch mode {
+	case predictLeft:
+		copy(out, left)
+	case predictTop:
+		copy(out, top)
+	case predictAverage:
+		for c := 0; c < 4; c++ {
+			out[c] = byte((int(left[c]) + int(top[c])) / 2)
+		}
+	case predictSelect:
+		distLeft, distTop := 0, 0
+		for c := 0; c < 4; c++ {
+			estimate := int(left[c]) + int(top[c]) - int(topLeft[c])
+			distLeft += abs(estimate - int(left[c]))
+			distTop += abs(estimate - int(top[c]))
+		}
+		if distLeft < distTop {
+			copy(out, left)
+		} else {
+			copy(out, top)
+		}
+	case predictGradient:
+		for c := 0; c < 4; c++ {
+			out[c] = clampByte(int(left[c]) + int(top[c]) - int(topLeft[c]))
+		}
+	}
+}
+
+// applyPredictor replaces pix with its residuals against the predictor
+// each block of 1<<bits pixels square does best with, and returns the
+// modes as a sub-image.
+func applyPredictor(pix []byte, width, height int, bits uint) []byte {
+	size := 1 << bits
+	blocksWide := (width + size - 1) / size
+	blocksHigh := (height + size - 1) / size
+	modes := make([]byte, blocksWide*blocksHigh*4)
+	residuals := make([]byte, len(pix))
+	guess := make([]byte, 4)
+
+	at := func(x, y int) []byte { return pix[(y*width+x)*4 : (y*width+x)*4+4] }
+	// prediction follows the decoder: black for the first pixel, the left
+	// pixel along the top row and the top pixel down the left column.
+	prediction := func(x, y, mode int) []byte {
+		switch {
+		case x == 0 && y == 0:
+			guess[0], guess[1], guess[2], guess[3] = 0, 0, 0, 0xff
+		case y == 0:
+			copy(guess, at(x-1, y))
+		case x == 0:
+			copy(guess, at(x, y-1))
+		default:
+			predict(guess, at(x-1, y), at(x, y-1), at(x-1, y-1), mode)
+		}
+		return guess
+	}
+
+	for by := 0; by < blocksHigh; by++ {
+		for bx := 0; bx < blocksWide; bx++ {
+			best, bestCost := predictors[0], -1
+			for _, mode := range predictors {
+				cost := 0
+				for y := by * size; y < min(height, (by+1)*size); y++ {
+					for x := bx * size; x < min(width, (bx+1)*size); x++ {
+						p, g := at(x, y), prediction(x, y, mode)
+						for c := 0; c < 4; c++ {
+							cost += abs(int(int8(p[c] - g[c])))
+						}
+					}
+				}
+				if bestCost < 0 || cost < bestCost {
+					best, bestCost = mode, cost
+				}
+			}
+
+			block := modes[(by*blocksWide+bx)*4:]
+			block[1], block[3] = byte(best), 0xff
+			for y := by * size; y < min(height, (by+1)*size); y++ {
+				for x := bx * size; x < min(width, (bx+1)*size); x++ {
+					p, g := at(x, y), prediction(x, y, best)
+					i := (y*width + x) * 4
+					for c := 0; c < 4; c++ {
+						residuals[i+c] = p[c] - g[c]
+					}
+				}
+			}
+		}
+	}
+	copy(pix, residuals)
+	return modes
+}
+
+// EncodeWebP writes m as a lossless WebP image.
+func EncodeWebP(out io.Writer, m image.Image) error {
+	bounds := m.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width < 1 || height < 1 || width > maxWebPSize || height > maxWebPSize {
+		return errWebPTooLarge
+	}
+	nrgba := image.NewNRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(nrgba, nrgba.Rect, m, bounds.Min, draw.Src)
+	pix := nrgba.Pix
+
+	opaque := true
+	for i := 0; i < len(pix); i += 4 {
+		opaque = opaque && pix[i+3] == 0xff
+		// Subtract green: red and blue become their difference from
+		// green, which is small in most photos.
+		pix[i] -= pix[i+1]
+		pix[i+2] -= pix[i+1]
+	}
+
+	w := &bitWriter{}
+	w.write(vp8lSignature, 8)
+	w.write(uint32(width-1), 14)
+	w.write(uint32(height-1), 14)
+	if opaque {
+		w.write(0, 1)
+	} else {
+		w.write(1, 1)
+	}
+	w.write(0, 3)
+
+	// The decoder undoes transforms in reverse, so the predictor, written
+	// last, runs on the image with green already subtracted.
+	w.write(1, 1)
+	w.write(subtractGreen, 2)
+	w.write(1, 1)
+	w.write(predictor, 2)
+	w.write(predictorBits-2, 3)
+	modes := applyPredictor(pix, width, height, predictorBits)
+	writeEntropyImage(w, modes, false)
+	w.write(0, 1)
+
+	writeEntropyImage(w, pix, true)
+	data := w.flush()
+
+	padding := len(data) & 1
+	header := make([]byte, 20)
+	copy(header[0:], "RIFF")
+	binary.LittleEndian.PutUint32(header[4:], uint32(4+8+len(data)+padding))
+	copy(header[8:], "WEBPVP8L")
+	binary.LittleEndian.PutUint32(header[16:], uint32(len(data)))
+	if _, err := out.Write(header); err != nil {
+		return err
+	}
+	if _, err := out.Write(data); err != nil {
+		return err
+	}
+	if padding == 1 {
+		_, err := out.Write([]byte{0})
+		return err
+	}
+	return nil
+}
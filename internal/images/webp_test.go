@@ -0,0 +1,201 @@
+package images
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"math/rand"
+	"testing"
+
+	"golang.org/x/image/webp"
+)
+
+func filled(width, height int, pixel func(x, y int) color.NRGBA) *image.NRGBA {
+	m := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			m.SetNRGBA(x, y, pixel(x, y))
+		}
+	}
+	return m
+}
+
+func TestEncodeWebPRoundTrip(t *testing.T) {
+	random := rand.New(rand.NewSource(1))
+	noise := func(x, y int) color.NRGBA {
+		return color.NRGBA{uint8(random.Intn(256)), uint8(random.Intn(256)), uint8(random.Intn(256)), 0xff}
+	}
+	gradient := func(x, y int) color.NRGBA {
+		return color.NRGBA{uint8(x * 3), uint8(y * 5), uint8(x + y), 0xff}
+	}
+
+	tests := []struct {
+		name  string
+		image image.Image
+	}{
+		{"one pixel", filled(1, 1, func(x, y int) color.NRGBA { return color.NRGBA{200, 10, 30, 0xff} })},
+		{"one color", filled(40, 30, func(x, y int) color.NRGBA { return color.NRGBA{0x33, 0x66, 0x99, 0xff} })},
+		{"gradient", filled(97, 61, gradient)},
+		{"noise", filled(64, 48, noise)},
+		{"one row", filled(300, 1, gradient)},
+		{"one column", filled(1, 300, gradient)},
+		{"transparent", filled(33, 17, func(x, y int) color.NRGBA {
+			return color.NRGBA{uint8(x * 7), uint8(y * 11), 0x80, uint8(x * y)}
+		})},
+		{"not at the origin", filled(20, 20, gradient).SubImage(image.Rect(5, 3, 18, 19))},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var out bytes.Buffer
+			if err := EncodeWebP(&out, test.image); err != nil {
+				t.Fatalf("encoding: %v", err)
+			}
+			decoded, err := webp.Decode(bytes.NewReader(out.Bytes()))
+			if err != nil {
+				t.Fatalf("decoding: %v", err)
+			}
+
+			bounds := test.image.Bounds()
+			if decoded.Bounds().Dx() != bounds.Dx() || decoded.Bounds().Dy() != bounds.Dy() {
+				t.Fatalf("decoded %v, want %dx%d", decoded.Bounds(), bounds.Dx(), bounds.Dy())
+			}
+			for y := 0; y < bounds.Dy(); y++ {
+				for x := 0; x < bounds.Dx(); x++ {
+					want := color.NRGBAModel.Convert(test.image.At(bounds.Min.X+x, bounds.Min.Y+y)).(color.NRGBA)
+					got := color.NRGBAModel.Convert(decoded.At(decoded.Bounds().Min.X+x, decoded.Bounds().Min.Y+y)).(color.NRGBA)
+					if got != want {
+						t.Fatalf("pixel %d,%d is %v, want %v", x, y, got, want)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestEncodeWebPTooLarge(t *testing.T) {
+	for _, rect := range []image.Rectangle{
+		image.Rect(0, 0, 0, 10),
+		image.Rect(0, 0, maxWebPSize+1, 1),
+	} {
+		err := EncodeWebP(&bytes.Buffer{}, image.NewNRGBA(rect))
+		if !errors.Is(err, errWebPTooLarge) {
+			t.Errorf("EncodeWebP(%v) error = %v, want errWebPTooLarge", rect, err)
+		}
+	}
+}
+
+func TestEncodeThroughDecode(t *testing.T) {
+	m := filled(50, 20, func(x, y int) color.NRGBA { return color.NRGBA{uint8(x * 5), 0x40, uint8(y * 12), 0xff} })
+	data, err := Encode(m, "webp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, format, err := Decode(data)
+	if err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	if format != "webp" {
+		t.Errorf("format %q, want webp", format)
+	}
+	if !Opaque(decoded) {
+		t.Error("an opaque image came back with transparency")
+	}
+	if size := decoded.Bounds().Size(); size != (image.Point{50, 20}) {
+		t.Errorf("size %v, want 50x20", size)
+	}
+}
+
+func TestBitWriter(t *testing.T) {
+	var w bitWriter
+	w.write(1, 1)
+	w.write(2, 2)
+	w.write(0xff, 8)
+	if got := w.flush(); !bytes.Equal(got, []byte{0xfd, 0x07}) {
+		t.Errorf("bits = %x, want fd07", got)
+	}
+}
+
+func TestHuffmanLengths(t *testing.T) {
+	// Fibonacci counts make the deepest Huffman trees, one level per symbol.
+	fibonacci := make([]int, 30)
+	fibonacci[0], fibonacci[1] = 1, 1
+	for i := 2; i < len(fibonacci); i++ {
+		fibonacci[i] = fibonacci[i-1] + fibonacci[i-2]
+	}
+	random := rand.New(rand.NewSource(1))
+	sparse := make([]int, greenAlphabet)
+	for i := range sparse {
+		if random.Intn(3) == 0 {
+			sparse[i] = random.Intn(10000)
+		}
+	}
+
+	tests := []struct {
+		name   string
+		counts []int
+		limit  int
+		want   []uint8
+	}{
+		{"skewed", []int{1, 1, 2, 4}, codeLengthLimit, []uint8{3, 3, 2, 1}},
+		{"unused symbols", []int{0, 5, 0, 5}, codeLengthLimit, []uint8{0, 1, 0, 1}},
+		{"one symbol", []int{0, 0, 9}, codeLengthLimit, []uint8{0, 0, 1}},
+		{"none", []int{0, 0}, codeLengthLimit, []uint8{0, 0}},
+		{"fibonacci", fibonacci, codeLengthLimit, nil},
+		{"fibonacci, code length codes", fibonacci[:codeLengthCodes], codeLengthCodeMax, nil},
+		{"sparse", sparse, codeLengthLimit, nil},
+	}
+	for _, test := range tests {
+		lengths := huffmanLengths(test.counts, test.limit)
+		if test.want != nil && !bytes.Equal(lengths, test.want) {
+			t.Errorf("%s: lengths %v, want %v", test.name, lengths, test.want)
+		}
+
+		// Every used symbol needs a code no longer than the limit, and with
+		// two or more of them the code must be complete: the Kraft sum of
+		// the lengths is exactly one.
+		used := 0
+		kraft := 0
+		for symbol, n := range lengths {
+			if (n > 0) != (test.counts[symbol] > 0) || int(n) > test.limit {
+				t.Errorf("%s: symbol %d with count %d has length %d", test.name, symbol, test.counts[symbol], n)
+			}
+			if n > 0 {
+				used++
+				kraft += 1 << (codeLengthLimit - n)
+			}
+		}
+		if used >= 2 && kraft != 1<<codeLengthLimit {
+			t.Errorf("%s: Kraft sum %d/%d, want 1", test.name, kraft, 1<<codeLengthLimit)
+		}
+	}
+}
+
+func TestCanonical(t *testing.T) {
+	// The lengths of the example in RFC 1951 section 3.2.2, whose codes
+	// are 010, 011, 100, 101, 110, 00, 1110 and 1111 before reversal.
+	lengths := []uint8{3, 3, 3, 3, 3, 2, 4, 4}
+	want := []uint32{0b010, 0b110, 0b001, 0b101, 0b011, 0b00, 0b0111, 0b1111}
+
+	code := canonical(lengths)
+	for symbol := range lengths {
+		if code.codes[symbol] != want[symbol] {
+			t.Errorf("symbol %d: code %0*b, want %0*b", symbol, lengths[symbol], code.codes[symbol], lengths[symbol], want[symbol])
+		}
+	}
+
+	// No code may be the start of another, read least significant bit
+	// first as the decoder does.
+	for a := range lengths {
+		for b := range lengths {
+			if a == b || lengths[a] > lengths[b] {
+				continue
+			}
+			mask := uint32(1)<<lengths[a] - 1
+			if code.codes[b]&mask == code.codes[a] {
+				t.Errorf("code of %d is a prefix of the code of %d", a, b)
+			}
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package inventory
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html"
+	"log"
+	"time"
+
+	"basicthreads/internal/config"
+	"basicthreads/internal/database"
+	"basicthreads/internal/mailer"
+	"basicthreads/internal/products"
+	"basicthreads/internal/wishlists"
+)
+
+var (
+	ErrNotFound          = database.ErrNotFound
+	ErrInsufficientStock = database.ErrInsufficientStock
+)
+
+// Kinds lists the movements an admin can record by hand. Sales only come
+// from committed reservations.
+var Kinds = []string{database.MovementReceipt, database.MovementAdjustment, database.MovementReturn}
+
+// Reserve holds stock of several variants for reference, all or nothing,
+// for the configured reservation time.
+func Reserve(ctx context.Context, reference string, quantities map[int]int) error {
+	for id, quantity := range quantities {
+		if quantity <= 0 {
+			return products.ValidationError{"quantity": fmt.Sprintf("must be greater than zero for variant %d", id)}
+		}
+	}
+
+	changes, err := database.ReserveStock(ctx, reference, quantities, config.Load().ReservationTTL)
+	if err != nil {
+		return err
+	}
+	alert(ctx, changes...)
+	return nil
+}
+
+// Release gives the stock held for reference back, when a checkout is
+// cancelled.
+func Release(ctx context.Context, reference string) error {
+	changes, err := database.ReleaseReservations(ctx, reference)
+	if err != nil {
+		return err
+	}
+	Restocked(ctx, changes...)
+	return nil
+}
+
+// Record writes a movement by hand. Receipts and returns add stock, an
+// adjustment adds or removes it.
+func Record(ctx context.Context, variantID int, kind string, quantity int, actor, note string) (database.Variant, error) {
+	problems := products.ValidationError{}
+	switch kind {
+	case database.MovementReceipt, database.MovementReturn:
+		if quantity <= 0 {
+			problems["quantity"] = "must be greater than zero"
+		}
+	case database.MovementAdjustment:
+		if quantity == 0 {
+			problems["quantity"] = "cannot be zero"
+		}
+	default:
+		problems["kind"] = "must be receipt, adjustment or return"
+	}
+	if len(note) > 255 {
+		problems["note"] = "must be at most 255 characters"
+	}
+	if len(problems) > 0 {
+		return database.Variant{}, problems
+	}
+
+	change, err := database.RecordMovement(ctx, variantID, kind, quantity, "", actor, note)
+	if err != nil {
+		return database.Variant{}, err
+	}
+	alert(ctx, change)
+	Restocked(ctx, change)
+	return database.GetVariant(ctx, variantID)
+}
+
+func Movements(ctx context.Context, variantID int) ([]database.Movement, error) {
+	if _, err := database.GetVariant(ctx, variantID); err != nil {
+		return nil, err
+	}
+	return database.GetStockMovements(ctx, variantID)
+}
+
+// LowStock lists the variants at or below the low stock threshold.
+func LowStock(ctx context.Context) ([]database.StockLevel, error) {
+	return database.GetLowStock(ctx, config.Load().LowStockThreshold)
+}
+
+// Sweep releases expired reservations every interval until ctx is done.
+func Sweep(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			released, err := database.ReleaseExpiredReservations(ctx)
+			if err != nil && !errors.Is(err, context.Canceled) {
+				log.Printf("releasing expired reservations: %v", err)
+			} else if len(released) > 0 {
+				log.Printf("released %d expired reservations", len(released))
+				Restocked(ctx, released...)
+			}
+		}
+	}
+}
+
+// Restocked tells the customers waiting for a variant when changes brought
+// it back in stock. They are emailed one by one in the background, so
+// whoever moved the stock need not wait.
+func Restocked(ctx context.Context, changes ...database.StockChange) {
+	notified := map[int]bool{}
+	for _, change := range changes {
+		if change.AvailableBefore > 0 || change.AvailableAfter <= 0 || notified[change.VariantID] {
+			continue
+		}
+		notified[change.VariantID] = true
+		go wishlists.NotifyRestocked(context.WithoutCancel(ctx), change.VariantID)
+	}
+}
+
+// alert emails the notify address about every variant that just dropped
+// to the low stock threshold. Each crossing sends one email, so a variant
+// that stays low does not keep sending them.
+func alert(ctx context.Context, changes ...database.StockChange) {
+	cfg := config.Load()
+
+	for _, change := range changes {
+		if change.AvailableBefore <= cfg.LowStockThreshold || change.AvailableAfter > cfg.LowStockThreshold {
+			continue
+		}
+
+		err := mailer.Send(ctx, mailer.Message{
+			To:      []mailer.Address{{Email: cfg.NotifyEmail}},
+			Subject: "Stock bajo: " + change.SKU,
+			HTML: fmt.Sprintf(
+				"<html><body><p>La variante <b>%s</b> tiene %d unidades disponibles.</p></body></html>",
+				html.EscapeString(change.SKU),
+				change.AvailableAfter,
+			),
+		})
+		if err != nil {
+			log.Printf("sending low stock alert for %s: %v", change.SKU, err)
+		}
+	}
+}
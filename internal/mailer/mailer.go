@@ -0,0 +1,68 @@
+package mailer
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+
+	"basicthreads/internal/config"
+)
+
+const endpoint = "https://api.brevo.com/v3/smtp/email"
+
+type Address struct {
+	Name  string `json:"name,omitempty"`
+	Email string `json:"email"`
+}
+
+type Message struct {
+	To      []Address
+	Subject string
+	HTML    string
+}
+
+// Sender is who every email comes from.
+var Sender = Address{Name: "Basic Threads", Email: "basic@threads.com"}
+
+var client = &http.Client{Transport: otelhttp.NewTransport(http.DefaultTransport)}
+
+// Send delivers message through the Brevo transactional email API.
+func Send(ctx context.Context, message Message) error {
+	payload, err := json.Marshal(struct {
+		Sender      Address   `json:"sender"`
+		To          []Address `json:"to"`
+		Subject     string    `json:"subject"`
+		HTMLContent string    `json:"htmlContent"`
+	}{Sender, message.To, message.Subject, message.HTML})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Add("accept", "application/json")
+	req.Header.Add("api-key", config.Load().MailAPIKey)
+	req.Header.Add("content-type", "application/json")
+
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+	if res.StatusCode >= 300 {
+		return fmt.Errorf("sending email: %s: %s", res.Status, body)
+	}
+	return nil
+}
@@ -0,0 +1,130 @@
+package media
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Cache keeps rendered images as files in a directory, dropping the least
+// recently used ones once they take more than MaxBytes. The modification
+// time of an entry is when it was last used.
+type Cache struct {
+	Dir      string
+	MaxBytes int64
+
+	mu     sync.Mutex
+	size   int64
+	loaded bool
+}
+
+func NewCache(dir string, maxBytes int64) *Cache {
+	return &Cache{Dir: dir, MaxBytes: maxBytes}
+}
+
+// Open returns the entry called name, or false when it is not cached.
+func (c *Cache) Open(name string) (*os.File, bool) {
+	path := filepath.Join(c.Dir, name)
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, false
+	}
+	now := time.Now()
+	os.Chtimes(path, now, now)
+	return file, true
+}
+
+// Put stores an entry, then evicts old ones if the cache grew too large.
+// Entries larger than the whole cache are not kept.
+func (c *Cache) Put(name string, data []byte) error {
+	if int64(len(data)) > c.MaxBytes {
+		return nil
+	}
+	if err := os.MkdirAll(c.Dir, 0o755); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(c.Dir, ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), filepath.Join(c.Dir, name)); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// The size is counted from disk the first time, so entries left by an
+	// earlier run count too. The scan sees the entry just written.
+	if !c.loaded {
+		c.loaded = true
+		return c.evict(false)
+	}
+	c.size += int64(len(data))
+	if c.size > c.MaxBytes {
+		return c.evict(true)
+	}
+	return nil
+}
+
+// evict recounts the size of the cache and, when it is over MaxBytes or
+// force is set, removes the least recently used entries until it is down
+// to 90% of it, so not every Put has to scan the directory.
+func (c *Cache) evict(force bool) error {
+	entries, err := os.ReadDir(c.Dir)
+	if err != nil {
+		return err
+	}
+
+	type entry struct {
+		name string
+		size int64
+		used time.Time
+	}
+	var files []entry
+	c.size = 0
+	for _, item := range entries {
+		if item.IsDir() || strings.HasPrefix(item.Name(), ".tmp-") {
+			continue
+		}
+		info, err := item.Info()
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		files = append(files, entry{item.Name(), info.Size(), info.ModTime()})
+		c.size += info.Size()
+	}
+	if !force && c.size <= c.MaxBytes {
+		return nil
+	}
+
+	sort.Slice(files, func(i, j int) bool { return files[i].used.Before(files[j].used) })
+	target := c.MaxBytes / 10 * 9
+	for _, file := range files {
+		if c.size <= target {
+			break
+		}
+		err := os.Remove(filepath.Join(c.Dir, file.name))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		c.size -= file.size
+	}
+	return nil
+}
@@ -0,0 +1,357 @@
+package media
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"io"
+	"log"
+	"net/http"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"basicthreads/internal/images"
+	"basicthreads/internal/storage"
+)
+
+const (
+	// MaxSize bounds the width and height images can be resized to.
+	MaxSize = 2048
+
+	// maxETags bounds how many blobs have their ETag remembered.
+	maxETags = 10000
+)
+
+var (
+	ErrNotFound = storage.ErrNotFound
+	ErrNotImage = errors.New("only JPEG, PNG, GIF and WebP images can be resized")
+)
+
+// Formats lists what resized images can be encoded as.
+var Formats = []string{"jpeg", "png", "webp"}
+
+// Params asks for a blob to be resized to fit in Width by Height pixels,
+// keeping its aspect ratio, and encoded as Format. A zero dimension leaves
+// that side free and an empty format keeps the one of the blob.
+type Params struct {
+	Width  int
+	Height int
+	Format string
+}
+
+func (p Params) resize() bool {
+	return p.Width != 0 || p.Height != 0 || p.Format != ""
+}
+
+// Blob is what to send for a request. Content must be closed.
+type Blob struct {
+	Content     io.ReadSeekCloser
+	ContentType string
+	// ETag is strong: it changes whenever a byte of the content does.
+	ETag    string
+	ModTime time.Time
+}
+
+// Server reads blobs for the /media route, resizing images on request.
+type Server struct {
+	Store storage.BlobStore
+	Cache *Cache
+
+	mu sync.Mutex
+	// seen remembers the ETag and content type of blobs already read, so
+	// later requests for them only ask the store for the blob's size and
+	// date.
+	seen map[string]blobMeta
+	// slots limits how many images are resized at once, as decoding a
+	// large one takes a lot of memory and CPU.
+	slots chan struct{}
+}
+
+type blobMeta struct {
+	etag        string
+	contentType string
+}
+
+func NewServer(store storage.BlobStore, cache *Cache) *Server {
+	return &Server{
+		Store: store,
+		Cache: cache,
+		seen:  map[string]blobMeta{},
+		slots: make(chan struct{}, runtime.NumCPU()),
+	}
+}
+
+// Open returns a blob, resized when params ask for it. Blob keys are never
+// reused for other content, so the result of a resize is cached under the
+// key, size and date of the blob it was made from.
+//
+// A blob read before is not downloaded again until its content is needed:
+// conditional requests are answered from its remembered ETag, and resized
+// images come from the cache.
+func (s *Server) Open(ctx context.Context, key string, params Params) (Blob, error) {
+	if !storage.ValidKey(key) {
+		return Blob{}, ErrNotFound
+	}
+	info, err := s.Store.Stat(ctx, key)
+	if errors.Is(err, storage.ErrInvalidKey) {
+		return Blob{}, ErrNotFound
+	}
+	if err != nil {
+		return Blob{}, err
+	}
+
+	var content io.ReadSeekCloser
+	meta, ok := s.remembered(key, info)
+	if !ok {
+		content, info, err = s.Store.Open(ctx, key)
+		if errors.Is(err, storage.ErrInvalidKey) {
+			return Blob{}, ErrNotFound
+		}
+		if err != nil {
+			return Blob{}, err
+		}
+		meta, err = s.inspect(key, info, content)
+		if err != nil {
+			content.Close()
+			return Blob{}, err
+		}
+	}
+
+	if !params.resize() {
+		if content == nil {
+			content = &lazyContent{ctx: ctx, store: s.Store, key: key, size: info.Size}
+		}
+		return Blob{Content: content, ContentType: meta.contentType, ETag: meta.etag, ModTime: info.ModTime}, nil
+	}
+	if content != nil {
+		defer content.Close()
+	}
+
+	format := params.Format
+	if format == "" {
+		format = outputFormat(meta.contentType)
+	}
+	if format == "" {
+		return Blob{}, ErrNotImage
+	}
+	blob := Blob{
+		ContentType: images.ContentType(format),
+		// Resizing the same bytes the same way gives the same bytes.
+		ETag:    fmt.Sprintf(`"%s-%dx%d.%s"`, strings.Trim(meta.etag, `"`), params.Width, params.Height, format),
+		ModTime: info.ModTime,
+	}
+
+	name := cacheName(key, info, params.Width, params.Height, format)
+	if file, ok := s.Cache.Open(name); ok {
+		blob.Content = file
+		return blob, nil
+	}
+
+	if content == nil {
+		content, _, err = s.Store.Open(ctx, key)
+		if err != nil {
+			return Blob{}, err
+		}
+		defer content.Close()
+	}
+	data, err := io.ReadAll(content)
+	if err != nil {
+		return Blob{}, err
+	}
+	rendered, err := s.render(ctx, data, params.Width, params.Height, format)
+	if err != nil {
+		return Blob{}, err
+	}
+	// The image can be sent all the same, it is only rendered again next
+	// time.
+	if err := s.Cache.Put(name, rendered); err != nil {
+		log.Printf("caching %s: %v", name, err)
+	}
+	blob.Content = nopCloser{bytes.NewReader(rendered)}
+	return blob, nil
+}
+
+// render decodes an image and encodes it again to fit in width by height.
+func (s *Server) render(ctx context.Context, data []byte, width, height int, format string) ([]byte, error) {
+	select {
+	case s.slots <- struct{}{}:
+		defer func() { <-s.slots }()
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	m, _, err := images.Decode(data)
+	if errors.Is(err, images.ErrUnsupported) {
+		return nil, ErrNotImage
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	bounds := m.Bounds()
+	size := max(bounds.Dx(), bounds.Dy())
+	if width != 0 && bounds.Dx() > width {
+		size = min(size, max(width, width*bounds.Dy()/bounds.Dx()))
+	}
+	if height != 0 && bounds.Dy() > height {
+		size = min(size, max(height, height*bounds.Dx()/bounds.Dy()))
+	}
+	m = images.Resize(m, size)
+
+	// JPEG has no transparency, so transparent pixels are made white
+	// rather than left to turn black.
+	if format == "jpeg" && !images.Opaque(m) {
+		flat := image.NewRGBA(m.Bounds())
+		draw.Draw(flat, flat.Bounds(), image.White, image.Point{}, draw.Src)
+		draw.Draw(flat, flat.Bounds(), m, m.Bounds().Min, draw.Over)
+		m = flat
+	}
+	return images.Encode(m, format)
+}
+
+func blobID(key string, info storage.Info) string {
+	return key + "|" + strconv.FormatInt(info.Size, 10) + "|" + strconv.FormatInt(info.ModTime.UnixNano(), 10)
+}
+
+func (s *Server) remembered(key string, info storage.Info) (blobMeta, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	meta, ok := s.seen[blobID(key, info)]
+	return meta, ok
+}
+
+// inspect hashes a blob for its strong ETag and sniffs its content type,
+// remembers both, and rewinds the content.
+func (s *Server) inspect(key string, info storage.Info, content io.ReadSeeker) (blobMeta, error) {
+	hash := sha256.New()
+	if _, err := io.Copy(hash, content); err != nil {
+		return blobMeta{}, err
+	}
+	if _, err := content.Seek(0, io.SeekStart); err != nil {
+		return blobMeta{}, err
+	}
+	contentType, err := sniff(content)
+	if err != nil {
+		return blobMeta{}, err
+	}
+	meta := blobMeta{etag: `"` + hex.EncodeToString(hash.Sum(nil)[:16]) + `"`, contentType: contentType}
+
+	s.mu.Lock()
+	if len(s.seen) >= maxETags {
+		s.seen = map[string]blobMeta{}
+	}
+	s.seen[blobID(key, info)] = meta
+	s.mu.Unlock()
+	return meta, nil
+}
+
+// sniff tells the content type of a blob from its first bytes rather than
+// trusting its name.
+func sniff(content io.ReadSeeker) (string, error) {
+	head := make([]byte, 512)
+	n, err := io.ReadFull(content, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return "", err
+	}
+	if _, err := content.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	return http.DetectContentType(head[:n]), nil
+}
+
+// outputFormat picks the format a resized image keeps. GIFs become PNGs,
+// which can hold everything a single GIF frame does.
+func outputFormat(contentType string) string {
+	switch contentType {
+	case "image/jpeg":
+		return "jpeg"
+	case "image/png", "image/gif":
+		return "png"
+	case "image/webp":
+		return "webp"
+	}
+	return ""
+}
+
+func cacheName(key string, info storage.Info, width, height int, format string) string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s|%d|%d|%dx%d", key, info.Size, info.ModTime.UnixNano(), width, height)))
+	return hex.EncodeToString(sum[:]) + "." + images.Extension(format)
+}
+
+type nopCloser struct {
+	*bytes.Reader
+}
+
+func (nopCloser) Close() error {
+	return nil
+}
+
+// lazyContent opens a blob on the first read. Seeking before then only
+// moves the offset, so http.ServeContent can answer conditional requests
+// and learn the size without the blob being downloaded.
+type lazyContent struct {
+	ctx    context.Context
+	store  storage.BlobStore
+	key    string
+	size   int64
+	offset int64
+	opened io.ReadSeekCloser
+}
+
+func (l *lazyContent) open() error {
+	if l.opened != nil {
+		return nil
+	}
+	content, _, err := l.store.Open(l.ctx, l.key)
+	if err != nil {
+		return err
+	}
+	if _, err := content.Seek(l.offset, io.SeekStart); err != nil {
+		content.Close()
+		return err
+	}
+	l.opened = content
+	return nil
+}
+
+func (l *lazyContent) Read(p []byte) (int, error) {
+	if err := l.open(); err != nil {
+		return 0, err
+	}
+	return l.opened.Read(p)
+}
+
+func (l *lazyContent) Seek(offset int64, whence int) (int64, error) {
+	if l.opened != nil {
+		return l.opened.Seek(offset, whence)
+	}
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += l.offset
+	case io.SeekEnd:
+		offset += l.size
+	default:
+		return 0, errors.New("media: invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("media: negative position")
+	}
+	l.offset = offset
+	return offset, nil
+}
+
+func (l *lazyContent) Close() error {
+	if l.opened == nil {
+		return nil
+	}
+	return l.opened.Close()
+}
@@ -0,0 +1,127 @@
+package media
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"basicthreads/internal/storage"
+)
+
+// countingStore counts the blobs read from a store.
+type countingStore struct {
+	storage.BlobStore
+	opens int
+}
+
+func (s *countingStore) Open(ctx context.Context, key string) (io.ReadSeekCloser, storage.Info, error) {
+	s.opens++
+	return s.BlobStore.Open(ctx, key)
+}
+
+func testPNG(t *testing.T) []byte {
+	t.Helper()
+	m := image.NewRGBA(image.Rect(0, 0, 40, 20))
+	for x := 0; x < 40; x++ {
+		for y := 0; y < 20; y++ {
+			m.Set(x, y, color.RGBA{uint8(x * 6), uint8(y * 12), 90, 255})
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, m); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// serve answers a request for key the way the /media route does.
+func serve(t *testing.T, server *Server, key string, params Params, ifNoneMatch string) (*httptest.ResponseRecorder, Blob) {
+	t.Helper()
+	blob, err := server.Open(context.Background(), key, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer blob.Content.Close()
+
+	req := httptest.NewRequest(http.MethodGet, "/media/"+key, nil)
+	if ifNoneMatch != "" {
+		req.Header.Set("If-None-Match", ifNoneMatch)
+	}
+	w := httptest.NewRecorder()
+	w.Header().Set("Content-Type", blob.ContentType)
+	w.Header().Set("ETag", blob.ETag)
+	http.ServeContent(w, req, "", blob.ModTime, blob.Content)
+	return w, blob
+}
+
+func TestServerReadsBlobsOnlyWhenNeeded(t *testing.T) {
+	ctx := context.Background()
+	store := &countingStore{BlobStore: storage.NewLocalStore(t.TempDir())}
+	server := NewServer(store, NewCache(t.TempDir(), 1<<20))
+	key := "products/1/abc/original.png"
+	data := testPNG(t)
+	if err := store.Put(ctx, key, data, "image/png"); err != nil {
+		t.Fatal(err)
+	}
+
+	w, blob := serve(t, server, key, Params{}, "")
+	if w.Code != http.StatusOK || !bytes.Equal(w.Body.Bytes(), data) || blob.ContentType != "image/png" {
+		t.Fatalf("first request: %d, %d bytes of %s", w.Code, w.Body.Len(), blob.ContentType)
+	}
+	if store.opens != 1 {
+		t.Fatalf("first request read the blob %d times, want 1", store.opens)
+	}
+
+	w, _ = serve(t, server, key, Params{}, blob.ETag)
+	if w.Code != http.StatusNotModified {
+		t.Errorf("conditional request: %d, want 304", w.Code)
+	}
+	if store.opens != 1 {
+		t.Errorf("conditional request read the blob")
+	}
+
+	w, _ = serve(t, server, key, Params{}, "")
+	if w.Code != http.StatusOK || !bytes.Equal(w.Body.Bytes(), data) {
+		t.Errorf("second request: %d, %d bytes", w.Code, w.Body.Len())
+	}
+	if store.opens != 2 {
+		t.Errorf("second request read the blob %d times in all, want 2", store.opens)
+	}
+
+	params := Params{Width: 10}
+	w, resized := serve(t, server, key, params, "")
+	if w.Code != http.StatusOK || resized.ContentType != "image/png" {
+		t.Fatalf("resize: %d, %s", w.Code, resized.ContentType)
+	}
+	m, err := png.Decode(bytes.NewReader(w.Body.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size := m.Bounds().Size(); size != image.Pt(10, 5) {
+		t.Errorf("resized to %v, want 10x5", size)
+	}
+	opens := store.opens
+
+	w, again := serve(t, server, key, params, "")
+	if w.Code != http.StatusOK || again.ETag != resized.ETag {
+		t.Errorf("cached resize: %d, ETag %s, want %s", w.Code, again.ETag, resized.ETag)
+	}
+	if store.opens != opens {
+		t.Errorf("cached resize read the original")
+	}
+}
+
+func TestServerNotFound(t *testing.T) {
+	server := NewServer(storage.NewLocalStore(t.TempDir()), NewCache(t.TempDir(), 1<<20))
+	for _, key := range []string{"missing.png", "../etc/passwd", ""} {
+		if _, err := server.Open(context.Background(), key, Params{}); err != ErrNotFound {
+			t.Errorf("Open(%q): %v, want ErrNotFound", key, err)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package migrations_test
+
+import (
+	"context"
+	"testing"
+
+	"basicthreads/internal/config"
+	"basicthreads/internal/dbtest"
+	"basicthreads/internal/migrations"
+)
+
+func TestLoad(t *testing.T) {
+	all, err := migrations.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, migration := range all {
+		if migration.Version != i+1 {
+			t.Fatalf("migration %d_%s, want version %d: versions must not skip", migration.Version, migration.Name, i+1)
+		}
+	}
+}
+
+// TestDownAndUp reverts every migration and applies them again, so each
+// down script is known to undo its up script.
+func TestDownAndUp(t *testing.T) {
+	ctx := context.Background()
+	dbtest.Open(t)
+
+	migrator, err := migrations.New(config.Load().Database.DSN())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer migrator.Close()
+
+	all, err := migrations.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	reverted, err := migrator.Down(ctx, len(all))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reverted) != len(all) {
+		t.Errorf("reverted %d migrations, want %d", len(reverted), len(all))
+	}
+
+	statuses, err := migrator.Status(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, status := range statuses {
+		if status.AppliedAt != nil {
+			t.Errorf("%04d_%s still applied", status.Version, status.Name)
+		}
+	}
+
+	applied, err := migrator.Up(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(applied) != len(all) {
+		t.Errorf("applied %d migrations, want %d", len(applied), len(all))
+	}
+}
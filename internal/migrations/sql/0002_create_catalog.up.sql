@@ -0,0 +1,34 @@
+CREATE TABLE categories (
+    id INT UNSIGNED NOT NULL AUTO_INCREMENT,
+    name VARCHAR(255) NOT NULL,
+    parent_category_id INT UNSIGNED NULL,
+    PRIMARY KEY (id),
+    KEY categories_parent_category_id_index (parent_category_id),
+    CONSTRAINT categories_parent_category_id_foreign
+        FOREIGN KEY (parent_category_id) REFERENCES categories (id)
+        ON DELETE RESTRICT
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+CREATE TABLE products (
+    product_id INT UNSIGNED NOT NULL AUTO_INCREMENT,
+    name VARCHAR(255) NOT NULL,
+    price DECIMAL(10, 2) NOT NULL,
+    description TEXT NOT NULL,
+    img VARCHAR(512) NOT NULL DEFAULT '',
+    PRIMARY KEY (product_id),
+    KEY products_name_index (name),
+    KEY products_price_index (price)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+CREATE TABLE categories_product (
+    id_product INT UNSIGNED NOT NULL,
+    id_category INT UNSIGNED NOT NULL,
+    PRIMARY KEY (id_product, id_category),
+    KEY categories_product_id_category_index (id_category),
+    CONSTRAINT categories_product_id_product_foreign
+        FOREIGN KEY (id_product) REFERENCES products (product_id)
+        ON DELETE CASCADE,
+    CONSTRAINT categories_product_id_category_foreign
+        FOREIGN KEY (id_category) REFERENCES categories (id)
+        ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
@@ -0,0 +1,2 @@
+ALTER TABLE customers
+    ADD COLUMN role VARCHAR(16) NOT NULL DEFAULT 'customer' AFTER password;
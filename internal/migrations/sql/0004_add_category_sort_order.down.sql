@@ -0,0 +1,3 @@
+ALTER TABLE categories
+    DROP KEY categories_parent_sort_order_index,
+    DROP COLUMN sort_order;
@@ -0,0 +1,3 @@
+ALTER TABLE categories
+    ADD COLUMN sort_order INT NOT NULL DEFAULT 0 AFTER parent_category_id,
+    ADD KEY categories_parent_sort_order_index (parent_category_id, sort_order);
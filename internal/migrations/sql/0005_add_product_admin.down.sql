@@ -0,0 +1,7 @@
+DROP TABLE IF EXISTS product_audit;
+
+ALTER TABLE products
+    DROP KEY products_deleted_at_index,
+    DROP COLUMN deleted_at,
+    DROP COLUMN updated_at,
+    DROP COLUMN version;
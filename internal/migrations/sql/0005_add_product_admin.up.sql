@@ -0,0 +1,19 @@
+ALTER TABLE products
+    ADD COLUMN version INT UNSIGNED NOT NULL DEFAULT 1,
+    ADD COLUMN updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    ADD COLUMN deleted_at TIMESTAMP NULL,
+    ADD KEY products_deleted_at_index (deleted_at);
+
+CREATE TABLE product_audit (
+    id BIGINT UNSIGNED NOT NULL AUTO_INCREMENT,
+    product_id INT UNSIGNED NOT NULL,
+    actor VARCHAR(255) NOT NULL,
+    action VARCHAR(32) NOT NULL,
+    changes JSON NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (id),
+    KEY product_audit_product_id_index (product_id, created_at),
+    CONSTRAINT product_audit_product_id_foreign
+        FOREIGN KEY (product_id) REFERENCES products (product_id)
+        ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
@@ -0,0 +1,3 @@
+ALTER TABLE products
+    DROP KEY products_available_price_index,
+    DROP COLUMN available;
@@ -0,0 +1,3 @@
+ALTER TABLE products
+    ADD COLUMN available TINYINT(1) NOT NULL DEFAULT 1 AFTER img,
+    ADD KEY products_available_price_index (available, price);
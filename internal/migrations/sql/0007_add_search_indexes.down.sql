@@ -0,0 +1,6 @@
+ALTER TABLE categories
+    DROP KEY categories_name_fulltext;
+
+ALTER TABLE products
+    DROP KEY products_name_description_fulltext,
+    DROP KEY products_name_fulltext;
@@ -0,0 +1,6 @@
+-- InnoDB builds one FULLTEXT index per ALTER TABLE.
+ALTER TABLE products ADD FULLTEXT KEY products_name_fulltext (name);
+
+ALTER TABLE products ADD FULLTEXT KEY products_name_description_fulltext (name, description);
+
+ALTER TABLE categories ADD FULLTEXT KEY categories_name_fulltext (name);
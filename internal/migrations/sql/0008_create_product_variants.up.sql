@@ -0,0 +1,20 @@
+CREATE TABLE product_variants (
+    id INT UNSIGNED NOT NULL AUTO_INCREMENT,
+    product_id INT UNSIGNED NOT NULL,
+    sku VARCHAR(64) NOT NULL,
+    size VARCHAR(16) NOT NULL DEFAULT '',
+    color VARCHAR(32) NOT NULL DEFAULT '',
+    price DECIMAL(10, 2) NULL,
+    barcode VARCHAR(14) NULL,
+    stock INT NOT NULL DEFAULT 0,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (id),
+    UNIQUE KEY product_variants_sku_unique (sku),
+    UNIQUE KEY product_variants_barcode_unique (barcode),
+    UNIQUE KEY product_variants_product_size_color_unique (product_id, size, color),
+    KEY product_variants_size_color_index (size, color),
+    KEY product_variants_color_index (color),
+    CONSTRAINT product_variants_product_id_foreign
+        FOREIGN KEY (product_id) REFERENCES products (product_id)
+        ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
@@ -0,0 +1,6 @@
+DROP TABLE IF EXISTS stock_movements;
+DROP TABLE IF EXISTS stock_reservations;
+
+ALTER TABLE product_variants
+    DROP CHECK product_variants_stock_check,
+    DROP COLUMN reserved;
@@ -0,0 +1,36 @@
+ALTER TABLE product_variants
+    ADD COLUMN reserved INT NOT NULL DEFAULT 0 AFTER stock,
+    ADD CONSTRAINT product_variants_stock_check CHECK (stock >= reserved AND reserved >= 0);
+
+CREATE TABLE stock_reservations (
+    id BIGINT UNSIGNED NOT NULL AUTO_INCREMENT,
+    variant_id INT UNSIGNED NOT NULL,
+    quantity INT UNSIGNED NOT NULL,
+    reference VARCHAR(64) NOT NULL,
+    status VARCHAR(16) NOT NULL DEFAULT 'active',
+    expires_at TIMESTAMP NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (id),
+    KEY stock_reservations_reference_index (reference, status),
+    KEY stock_reservations_status_expires_at_index (status, expires_at),
+    CONSTRAINT stock_reservations_variant_id_foreign
+        FOREIGN KEY (variant_id) REFERENCES product_variants (id)
+        ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+CREATE TABLE stock_movements (
+    id BIGINT UNSIGNED NOT NULL AUTO_INCREMENT,
+    variant_id INT UNSIGNED NOT NULL,
+    kind VARCHAR(16) NOT NULL,
+    quantity INT NOT NULL,
+    stock_after INT NOT NULL,
+    reference VARCHAR(64) NOT NULL DEFAULT '',
+    actor VARCHAR(255) NOT NULL DEFAULT '',
+    note VARCHAR(255) NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (id),
+    KEY stock_movements_variant_id_index (variant_id, created_at),
+    CONSTRAINT stock_movements_variant_id_foreign
+        FOREIGN KEY (variant_id) REFERENCES product_variants (id)
+        ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
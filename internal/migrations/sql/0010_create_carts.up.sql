@@ -0,0 +1,33 @@
+CREATE TABLE carts (
+    id INT UNSIGNED NOT NULL AUTO_INCREMENT,
+    token CHAR(32) NULL,
+    customer_id INT UNSIGNED NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    PRIMARY KEY (id),
+    UNIQUE KEY carts_token_unique (token),
+    UNIQUE KEY carts_customer_id_unique (customer_id),
+    CONSTRAINT carts_customer_id_foreign
+        FOREIGN KEY (customer_id) REFERENCES customers (id)
+        ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+CREATE TABLE cart_items (
+    id INT UNSIGNED NOT NULL AUTO_INCREMENT,
+    cart_id INT UNSIGNED NOT NULL,
+    product_id INT UNSIGNED NOT NULL,
+    variant_id INT UNSIGNED NULL,
+    quantity INT UNSIGNED NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (id),
+    KEY cart_items_cart_id_index (cart_id, product_id),
+    CONSTRAINT cart_items_cart_id_foreign
+        FOREIGN KEY (cart_id) REFERENCES carts (id)
+        ON DELETE CASCADE,
+    CONSTRAINT cart_items_product_id_foreign
+        FOREIGN KEY (product_id) REFERENCES products (product_id)
+        ON DELETE CASCADE,
+    CONSTRAINT cart_items_variant_id_foreign
+        FOREIGN KEY (variant_id) REFERENCES product_variants (id)
+        ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
@@ -0,0 +1,59 @@
+CREATE TABLE orders (
+    id INT UNSIGNED NOT NULL AUTO_INCREMENT,
+    reference VARCHAR(32) NOT NULL,
+    customer_id INT UNSIGNED NOT NULL,
+    email VARCHAR(255) NOT NULL,
+    status VARCHAR(16) NOT NULL DEFAULT 'pending',
+    subtotal DECIMAL(10, 2) NOT NULL,
+    total DECIMAL(10, 2) NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    PRIMARY KEY (id),
+    UNIQUE KEY orders_reference_unique (reference),
+    KEY orders_customer_id_index (customer_id, created_at),
+    KEY orders_status_index (status, created_at),
+    CONSTRAINT orders_customer_id_foreign
+        FOREIGN KEY (customer_id) REFERENCES customers (id)
+        ON DELETE RESTRICT
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+CREATE TABLE order_items (
+    id INT UNSIGNED NOT NULL AUTO_INCREMENT,
+    order_id INT UNSIGNED NOT NULL,
+    product_id INT UNSIGNED NULL,
+    variant_id INT UNSIGNED NULL,
+    name VARCHAR(255) NOT NULL,
+    sku VARCHAR(64) NOT NULL DEFAULT '',
+    size VARCHAR(16) NOT NULL DEFAULT '',
+    color VARCHAR(32) NOT NULL DEFAULT '',
+    image VARCHAR(512) NOT NULL DEFAULT '',
+    unit_price DECIMAL(10, 2) NOT NULL,
+    quantity INT UNSIGNED NOT NULL,
+    line_total DECIMAL(10, 2) NOT NULL,
+    PRIMARY KEY (id),
+    KEY order_items_order_id_index (order_id),
+    CONSTRAINT order_items_order_id_foreign
+        FOREIGN KEY (order_id) REFERENCES orders (id)
+        ON DELETE CASCADE,
+    CONSTRAINT order_items_product_id_foreign
+        FOREIGN KEY (product_id) REFERENCES products (product_id)
+        ON DELETE SET NULL,
+    CONSTRAINT order_items_variant_id_foreign
+        FOREIGN KEY (variant_id) REFERENCES product_variants (id)
+        ON DELETE SET NULL
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+CREATE TABLE order_status_history (
+    id INT UNSIGNED NOT NULL AUTO_INCREMENT,
+    order_id INT UNSIGNED NOT NULL,
+    from_status VARCHAR(16) NOT NULL DEFAULT '',
+    to_status VARCHAR(16) NOT NULL,
+    actor VARCHAR(255) NOT NULL DEFAULT '',
+    note VARCHAR(255) NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (id),
+    KEY order_status_history_order_id_index (order_id),
+    CONSTRAINT order_status_history_order_id_foreign
+        FOREIGN KEY (order_id) REFERENCES orders (id)
+        ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
@@ -0,0 +1,25 @@
+CREATE TABLE payments (
+    id INT UNSIGNED NOT NULL AUTO_INCREMENT,
+    order_id INT UNSIGNED NOT NULL,
+    provider VARCHAR(32) NOT NULL,
+    intent_id VARCHAR(255) NOT NULL,
+    status VARCHAR(32) NOT NULL,
+    amount DECIMAL(10, 2) NOT NULL,
+    currency CHAR(3) NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    PRIMARY KEY (id),
+    UNIQUE KEY payments_intent_id_unique (provider, intent_id),
+    KEY payments_order_id_index (order_id),
+    CONSTRAINT payments_order_id_foreign
+        FOREIGN KEY (order_id) REFERENCES orders (id)
+        ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+CREATE TABLE payment_events (
+    id VARCHAR(255) NOT NULL,
+    provider VARCHAR(32) NOT NULL,
+    type VARCHAR(64) NOT NULL,
+    received_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (provider, id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
@@ -0,0 +1,2 @@
+ALTER TABLE orders
+    ADD COLUMN currency CHAR(3) NOT NULL DEFAULT 'USD' AFTER status;
@@ -0,0 +1,12 @@
+ALTER TABLE carts
+    DROP COLUMN discount_code;
+
+ALTER TABLE order_items
+    DROP COLUMN discount;
+
+ALTER TABLE orders
+    DROP COLUMN discount;
+
+DROP TABLE IF EXISTS order_discounts;
+DROP TABLE IF EXISTS promotion_redemptions;
+DROP TABLE IF EXISTS promotions;
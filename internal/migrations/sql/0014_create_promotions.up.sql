@@ -0,0 +1,69 @@
+CREATE TABLE promotions (
+    id INT UNSIGNED NOT NULL AUTO_INCREMENT,
+    name VARCHAR(255) NOT NULL,
+    code VARCHAR(64) NULL,
+    kind VARCHAR(16) NOT NULL,
+    percent TINYINT UNSIGNED NOT NULL DEFAULT 0,
+    amount DECIMAL(10, 2) NULL,
+    scope VARCHAR(16) NOT NULL DEFAULT 'order',
+    target_id INT UNSIGNED NOT NULL DEFAULT 0,
+    min_subtotal DECIMAL(10, 2) NULL,
+    buy_quantity INT UNSIGNED NOT NULL DEFAULT 0,
+    get_quantity INT UNSIGNED NOT NULL DEFAULT 0,
+    priority INT NOT NULL DEFAULT 0,
+    exclusive BOOLEAN NOT NULL DEFAULT FALSE,
+    usage_limit INT UNSIGNED NULL,
+    customer_limit INT UNSIGNED NULL,
+    used INT UNSIGNED NOT NULL DEFAULT 0,
+    starts_at TIMESTAMP NULL,
+    ends_at TIMESTAMP NULL,
+    active BOOLEAN NOT NULL DEFAULT TRUE,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    PRIMARY KEY (id),
+    UNIQUE KEY promotions_code_unique (code),
+    KEY promotions_active_index (active, code)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+CREATE TABLE promotion_redemptions (
+    id INT UNSIGNED NOT NULL AUTO_INCREMENT,
+    promotion_id INT UNSIGNED NOT NULL,
+    order_id INT UNSIGNED NOT NULL,
+    customer_id INT UNSIGNED NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (id),
+    UNIQUE KEY promotion_redemptions_order_unique (promotion_id, order_id),
+    KEY promotion_redemptions_customer_index (promotion_id, customer_id),
+    CONSTRAINT promotion_redemptions_promotion_id_foreign
+        FOREIGN KEY (promotion_id) REFERENCES promotions (id)
+        ON DELETE CASCADE,
+    CONSTRAINT promotion_redemptions_order_id_foreign
+        FOREIGN KEY (order_id) REFERENCES orders (id)
+        ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+CREATE TABLE order_discounts (
+    id INT UNSIGNED NOT NULL AUTO_INCREMENT,
+    order_id INT UNSIGNED NOT NULL,
+    promotion_id INT UNSIGNED NULL,
+    code VARCHAR(64) NOT NULL DEFAULT '',
+    name VARCHAR(255) NOT NULL,
+    amount DECIMAL(10, 2) NOT NULL,
+    PRIMARY KEY (id),
+    KEY order_discounts_order_id_index (order_id),
+    CONSTRAINT order_discounts_order_id_foreign
+        FOREIGN KEY (order_id) REFERENCES orders (id)
+        ON DELETE CASCADE,
+    CONSTRAINT order_discounts_promotion_id_foreign
+        FOREIGN KEY (promotion_id) REFERENCES promotions (id)
+        ON DELETE SET NULL
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+ALTER TABLE orders
+    ADD COLUMN discount DECIMAL(10, 2) NOT NULL DEFAULT 0 AFTER subtotal;
+
+ALTER TABLE order_items
+    ADD COLUMN discount DECIMAL(10, 2) NOT NULL DEFAULT 0 AFTER line_total;
+
+ALTER TABLE carts
+    ADD COLUMN discount_code VARCHAR(64) NULL AFTER customer_id;
@@ -0,0 +1,22 @@
+DROP TABLE IF EXISTS order_taxes;
+
+ALTER TABLE order_items
+    DROP COLUMN tax,
+    DROP COLUMN tax_class;
+
+ALTER TABLE orders
+    DROP COLUMN ship_phone,
+    DROP COLUMN ship_country,
+    DROP COLUMN ship_postal_code,
+    DROP COLUMN ship_region,
+    DROP COLUMN ship_city,
+    DROP COLUMN ship_line2,
+    DROP COLUMN ship_line1,
+    DROP COLUMN ship_name,
+    DROP COLUMN prices_include_tax,
+    DROP COLUMN tax;
+
+ALTER TABLE products
+    DROP COLUMN tax_class;
+
+DROP TABLE IF EXISTS tax_rates;
@@ -0,0 +1,45 @@
+CREATE TABLE tax_rates (
+    id INT UNSIGNED NOT NULL AUTO_INCREMENT,
+    country CHAR(2) NOT NULL,
+    region VARCHAR(64) NOT NULL DEFAULT '',
+    tax_class VARCHAR(32) NOT NULL DEFAULT 'standard',
+    name VARCHAR(64) NOT NULL,
+    rate DECIMAL(7, 4) NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    PRIMARY KEY (id),
+    UNIQUE KEY tax_rates_unique (country, region, tax_class, name)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+ALTER TABLE products
+    ADD COLUMN tax_class VARCHAR(32) NOT NULL DEFAULT 'standard' AFTER price;
+
+ALTER TABLE orders
+    ADD COLUMN tax DECIMAL(10, 2) NOT NULL DEFAULT 0 AFTER discount,
+    ADD COLUMN prices_include_tax BOOLEAN NOT NULL DEFAULT FALSE AFTER tax,
+    ADD COLUMN ship_name VARCHAR(255) NOT NULL DEFAULT '' AFTER total,
+    ADD COLUMN ship_line1 VARCHAR(255) NOT NULL DEFAULT '' AFTER ship_name,
+    ADD COLUMN ship_line2 VARCHAR(255) NOT NULL DEFAULT '' AFTER ship_line1,
+    ADD COLUMN ship_city VARCHAR(128) NOT NULL DEFAULT '' AFTER ship_line2,
+    ADD COLUMN ship_region VARCHAR(64) NOT NULL DEFAULT '' AFTER ship_city,
+    ADD COLUMN ship_postal_code VARCHAR(16) NOT NULL DEFAULT '' AFTER ship_region,
+    ADD COLUMN ship_country CHAR(2) NOT NULL DEFAULT '' AFTER ship_postal_code,
+    ADD COLUMN ship_phone VARCHAR(32) NOT NULL DEFAULT '' AFTER ship_country;
+
+ALTER TABLE order_items
+    ADD COLUMN tax_class VARCHAR(32) NOT NULL DEFAULT 'standard' AFTER discount,
+    ADD COLUMN tax DECIMAL(10, 2) NOT NULL DEFAULT 0 AFTER tax_class;
+
+CREATE TABLE order_taxes (
+    id INT UNSIGNED NOT NULL AUTO_INCREMENT,
+    order_id INT UNSIGNED NOT NULL,
+    name VARCHAR(64) NOT NULL,
+    rate DECIMAL(7, 4) NOT NULL,
+    taxable DECIMAL(10, 2) NOT NULL,
+    amount DECIMAL(10, 2) NOT NULL,
+    PRIMARY KEY (id),
+    KEY order_taxes_order_id_index (order_id),
+    CONSTRAINT order_taxes_order_id_foreign
+        FOREIGN KEY (order_id) REFERENCES orders (id)
+        ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
@@ -0,0 +1,14 @@
+ALTER TABLE orders
+    DROP COLUMN weight,
+    DROP COLUMN shipping_service,
+    DROP COLUMN shipping_carrier,
+    DROP COLUMN shipping_method,
+    DROP COLUMN shipping;
+
+ALTER TABLE products
+    DROP COLUMN weight;
+
+DROP TABLE IF EXISTS shipments;
+DROP TABLE IF EXISTS shipping_methods;
+DROP TABLE IF EXISTS shipping_zone_areas;
+DROP TABLE IF EXISTS shipping_zones;
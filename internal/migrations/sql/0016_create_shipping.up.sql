@@ -0,0 +1,67 @@
+CREATE TABLE shipping_zones (
+    id INT UNSIGNED NOT NULL AUTO_INCREMENT,
+    name VARCHAR(255) NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+CREATE TABLE shipping_zone_areas (
+    id INT UNSIGNED NOT NULL AUTO_INCREMENT,
+    zone_id INT UNSIGNED NOT NULL,
+    country CHAR(2) NOT NULL,
+    region VARCHAR(64) NOT NULL DEFAULT '',
+    PRIMARY KEY (id),
+    UNIQUE KEY shipping_zone_areas_unique (country, region),
+    KEY shipping_zone_areas_zone_id_index (zone_id),
+    CONSTRAINT shipping_zone_areas_zone_id_foreign
+        FOREIGN KEY (zone_id) REFERENCES shipping_zones (id)
+        ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+CREATE TABLE shipping_methods (
+    id INT UNSIGNED NOT NULL AUTO_INCREMENT,
+    zone_id INT UNSIGNED NOT NULL,
+    name VARCHAR(255) NOT NULL,
+    kind VARCHAR(16) NOT NULL,
+    price DECIMAL(10, 2) NOT NULL,
+    per_kg DECIMAL(10, 2) NULL,
+    free_above DECIMAL(10, 2) NULL,
+    max_weight INT UNSIGNED NULL,
+    carrier VARCHAR(32) NOT NULL,
+    service VARCHAR(64) NOT NULL DEFAULT '',
+    active BOOLEAN NOT NULL DEFAULT TRUE,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    PRIMARY KEY (id),
+    KEY shipping_methods_zone_id_index (zone_id),
+    CONSTRAINT shipping_methods_zone_id_foreign
+        FOREIGN KEY (zone_id) REFERENCES shipping_zones (id)
+        ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+CREATE TABLE shipments (
+    id INT UNSIGNED NOT NULL AUTO_INCREMENT,
+    order_id INT UNSIGNED NOT NULL,
+    carrier VARCHAR(32) NOT NULL,
+    service VARCHAR(64) NOT NULL DEFAULT '',
+    tracking_number VARCHAR(64) NOT NULL,
+    tracking_url VARCHAR(512) NOT NULL DEFAULT '',
+    created_by VARCHAR(255) NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (id),
+    UNIQUE KEY shipments_tracking_unique (carrier, tracking_number),
+    KEY shipments_order_id_index (order_id),
+    CONSTRAINT shipments_order_id_foreign
+        FOREIGN KEY (order_id) REFERENCES orders (id)
+        ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+ALTER TABLE products
+    ADD COLUMN weight INT UNSIGNED NOT NULL DEFAULT 0 AFTER tax_class;
+
+ALTER TABLE orders
+    ADD COLUMN shipping DECIMAL(10, 2) NOT NULL DEFAULT 0 AFTER prices_include_tax,
+    ADD COLUMN shipping_method VARCHAR(255) NOT NULL DEFAULT '' AFTER ship_phone,
+    ADD COLUMN shipping_carrier VARCHAR(32) NOT NULL DEFAULT '' AFTER shipping_method,
+    ADD COLUMN shipping_service VARCHAR(64) NOT NULL DEFAULT '' AFTER shipping_carrier,
+    ADD COLUMN weight INT UNSIGNED NOT NULL DEFAULT 0 AFTER shipping_service;
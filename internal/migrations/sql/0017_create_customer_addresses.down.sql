@@ -0,0 +1,11 @@
+ALTER TABLE orders
+    DROP COLUMN bill_phone,
+    DROP COLUMN bill_country,
+    DROP COLUMN bill_postal_code,
+    DROP COLUMN bill_region,
+    DROP COLUMN bill_city,
+    DROP COLUMN bill_line2,
+    DROP COLUMN bill_line1,
+    DROP COLUMN bill_name;
+
+DROP TABLE IF EXISTS customer_addresses;
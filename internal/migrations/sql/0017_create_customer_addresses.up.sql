@@ -0,0 +1,32 @@
+CREATE TABLE customer_addresses (
+    id INT UNSIGNED NOT NULL AUTO_INCREMENT,
+    customer_id INT UNSIGNED NOT NULL,
+    kind VARCHAR(16) NOT NULL,
+    label VARCHAR(64) NOT NULL DEFAULT '',
+    name VARCHAR(255) NOT NULL,
+    line1 VARCHAR(255) NOT NULL,
+    line2 VARCHAR(255) NOT NULL DEFAULT '',
+    city VARCHAR(128) NOT NULL,
+    region VARCHAR(64) NOT NULL DEFAULT '',
+    postal_code VARCHAR(16) NOT NULL DEFAULT '',
+    country CHAR(2) NOT NULL,
+    phone VARCHAR(32) NOT NULL DEFAULT '',
+    is_default BOOLEAN NOT NULL DEFAULT FALSE,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    PRIMARY KEY (id),
+    KEY customer_addresses_customer_id_index (customer_id, kind),
+    CONSTRAINT customer_addresses_customer_id_foreign
+        FOREIGN KEY (customer_id) REFERENCES customers (id)
+        ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+ALTER TABLE orders
+    ADD COLUMN bill_name VARCHAR(255) NOT NULL DEFAULT '' AFTER ship_phone,
+    ADD COLUMN bill_line1 VARCHAR(255) NOT NULL DEFAULT '' AFTER bill_name,
+    ADD COLUMN bill_line2 VARCHAR(255) NOT NULL DEFAULT '' AFTER bill_line1,
+    ADD COLUMN bill_city VARCHAR(128) NOT NULL DEFAULT '' AFTER bill_line2,
+    ADD COLUMN bill_region VARCHAR(64) NOT NULL DEFAULT '' AFTER bill_city,
+    ADD COLUMN bill_postal_code VARCHAR(16) NOT NULL DEFAULT '' AFTER bill_region,
+    ADD COLUMN bill_country CHAR(2) NOT NULL DEFAULT '' AFTER bill_postal_code,
+    ADD COLUMN bill_phone VARCHAR(32) NOT NULL DEFAULT '' AFTER bill_country;
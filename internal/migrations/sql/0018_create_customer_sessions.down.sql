@@ -0,0 +1,5 @@
+ALTER TABLE customers
+    DROP COLUMN deleted_at;
+
+DROP TABLE IF EXISTS email_changes;
+DROP TABLE IF EXISTS customer_sessions;
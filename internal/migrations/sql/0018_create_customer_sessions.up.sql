@@ -0,0 +1,34 @@
+CREATE TABLE customer_sessions (
+    id INT UNSIGNED NOT NULL AUTO_INCREMENT,
+    customer_id INT UNSIGNED NOT NULL,
+    token_id CHAR(32) NOT NULL,
+    ip VARCHAR(45) NOT NULL DEFAULT '',
+    user_agent VARCHAR(255) NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    expires_at TIMESTAMP NOT NULL,
+    revoked_at TIMESTAMP NULL,
+    PRIMARY KEY (id),
+    UNIQUE KEY customer_sessions_token_id_unique (token_id),
+    KEY customer_sessions_customer_id_index (customer_id, created_at),
+    CONSTRAINT customer_sessions_customer_id_foreign
+        FOREIGN KEY (customer_id) REFERENCES customers (id)
+        ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+CREATE TABLE email_changes (
+    id INT UNSIGNED NOT NULL AUTO_INCREMENT,
+    customer_id INT UNSIGNED NOT NULL,
+    email VARCHAR(255) NOT NULL,
+    token_hash CHAR(64) NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    expires_at TIMESTAMP NOT NULL,
+    PRIMARY KEY (id),
+    UNIQUE KEY email_changes_customer_id_unique (customer_id),
+    UNIQUE KEY email_changes_token_hash_unique (token_hash),
+    CONSTRAINT email_changes_customer_id_foreign
+        FOREIGN KEY (customer_id) REFERENCES customers (id)
+        ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+ALTER TABLE customers
+    ADD COLUMN deleted_at TIMESTAMP NULL AFTER created_at;
@@ -0,0 +1,35 @@
+CREATE TABLE wishlists (
+    id INT UNSIGNED NOT NULL AUTO_INCREMENT,
+    customer_id INT UNSIGNED NOT NULL,
+    name VARCHAR(64) NOT NULL,
+    share_token CHAR(43) NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    PRIMARY KEY (id),
+    UNIQUE KEY wishlists_customer_id_name_unique (customer_id, name),
+    UNIQUE KEY wishlists_share_token_unique (share_token),
+    CONSTRAINT wishlists_customer_id_foreign
+        FOREIGN KEY (customer_id) REFERENCES customers (id)
+        ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+CREATE TABLE wishlist_items (
+    id INT UNSIGNED NOT NULL AUTO_INCREMENT,
+    wishlist_id INT UNSIGNED NOT NULL,
+    product_id INT UNSIGNED NOT NULL,
+    variant_id INT UNSIGNED NULL,
+    notify_restock BOOLEAN NOT NULL DEFAULT FALSE,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (id),
+    KEY wishlist_items_wishlist_id_index (wishlist_id, product_id),
+    KEY wishlist_items_variant_id_index (variant_id, notify_restock),
+    CONSTRAINT wishlist_items_wishlist_id_foreign
+        FOREIGN KEY (wishlist_id) REFERENCES wishlists (id)
+        ON DELETE CASCADE,
+    CONSTRAINT wishlist_items_product_id_foreign
+        FOREIGN KEY (product_id) REFERENCES products (product_id)
+        ON DELETE CASCADE,
+    CONSTRAINT wishlist_items_variant_id_foreign
+        FOREIGN KEY (variant_id) REFERENCES product_variants (id)
+        ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
@@ -0,0 +1,5 @@
+ALTER TABLE products
+    DROP COLUMN rating_count,
+    DROP COLUMN rating_average;
+
+DROP TABLE IF EXISTS product_reviews;
@@ -0,0 +1,30 @@
+CREATE TABLE product_reviews (
+    id INT UNSIGNED NOT NULL AUTO_INCREMENT,
+    product_id INT UNSIGNED NOT NULL,
+    customer_id INT UNSIGNED NOT NULL,
+    rating TINYINT UNSIGNED NOT NULL,
+    title VARCHAR(120) NOT NULL DEFAULT '',
+    body TEXT NOT NULL,
+    verified BOOLEAN NOT NULL DEFAULT FALSE,
+    status VARCHAR(16) NOT NULL DEFAULT 'pending',
+    moderated_by VARCHAR(255) NOT NULL DEFAULT '',
+    moderation_note VARCHAR(255) NOT NULL DEFAULT '',
+    moderated_at TIMESTAMP NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    PRIMARY KEY (id),
+    UNIQUE KEY product_reviews_product_id_customer_id_unique (product_id, customer_id),
+    KEY product_reviews_product_id_status_index (product_id, status, rating),
+    KEY product_reviews_status_index (status, created_at),
+    KEY product_reviews_customer_id_index (customer_id),
+    CONSTRAINT product_reviews_product_id_foreign
+        FOREIGN KEY (product_id) REFERENCES products (product_id)
+        ON DELETE CASCADE,
+    CONSTRAINT product_reviews_customer_id_foreign
+        FOREIGN KEY (customer_id) REFERENCES customers (id)
+        ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+ALTER TABLE products
+    ADD COLUMN rating_average DECIMAL(3, 2) NOT NULL DEFAULT 0.00 AFTER available,
+    ADD COLUMN rating_count INT UNSIGNED NOT NULL DEFAULT 0 AFTER rating_average;
@@ -0,0 +1,19 @@
+CREATE TABLE product_images (
+    id INT UNSIGNED NOT NULL AUTO_INCREMENT,
+    product_id INT UNSIGNED NOT NULL,
+    position SMALLINT UNSIGNED NOT NULL DEFAULT 0,
+    storage_key VARCHAR(255) NOT NULL,
+    original_format VARCHAR(8) NOT NULL,
+    variant_format VARCHAR(8) NOT NULL,
+    width INT UNSIGNED NOT NULL,
+    height INT UNSIGNED NOT NULL,
+    bytes INT UNSIGNED NOT NULL,
+    alt VARCHAR(255) NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (id),
+    UNIQUE KEY product_images_storage_key_unique (storage_key),
+    KEY product_images_product_id_index (product_id, position),
+    CONSTRAINT product_images_product_id_foreign
+        FOREIGN KEY (product_id) REFERENCES products (product_id)
+        ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
@@ -0,0 +1,3 @@
+ALTER TABLE shipments ADD KEY shipments_order_id_index (order_id);
+
+ALTER TABLE shipments DROP KEY shipments_order_id_unique;
@@ -0,0 +1,5 @@
+-- An order ships in one parcel. The unique key takes over the foreign key
+-- from the plain index before that one goes.
+ALTER TABLE shipments ADD UNIQUE KEY shipments_order_id_unique (order_id);
+
+ALTER TABLE shipments DROP KEY shipments_order_id_index;
@@ -0,0 +1 @@
+DROP TABLE shop_settings;
@@ -0,0 +1,11 @@
+-- Price columns are plain decimals of the shop currency, so the currency
+-- they were written in is kept next to them. A shop that already took
+-- orders records the currency it charged them in.
+CREATE TABLE shop_settings (
+    name VARCHAR(64) NOT NULL,
+    value VARCHAR(255) NOT NULL,
+    PRIMARY KEY (name)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+INSERT INTO shop_settings (name, value)
+SELECT 'currency', currency FROM orders ORDER BY id DESC LIMIT 1;
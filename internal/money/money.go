@@ -0,0 +1,286 @@
+package money
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrInvalidAmount   = errors.New("invalid amount")
+	ErrTooPrecise      = errors.New("amount has more decimals than the currency allows")
+	ErrInvalidCurrency = errors.New("invalid currency")
+)
+
+// exponents lists the currencies whose minor unit is not a hundredth.
+var exponents = map[string]int{
+	"BHD": 3, "CLP": 0, "ISK": 0, "JOD": 3, "JPY": 0, "KRW": 0,
+	"KWD": 3, "OMR": 3, "PYG": 0, "TND": 3, "UGX": 0, "VND": 0,
+}
+
+// Exponent returns how many decimals the minor unit of currency has.
+func Exponent(currency string) int {
+	if exponent, ok := exponents[currency]; ok {
+		return exponent
+	}
+	return 2
+}
+
+// ValidCurrency reports whether code looks like an ISO 4217 code.
+func ValidCurrency(code string) bool {
+	if len(code) != 3 {
+		return false
+	}
+	for _, r := range code {
+		if r < 'A' || r > 'Z' {
+			return false
+		}
+	}
+	return true
+}
+
+func scale(currency string) int64 {
+	return int64(math.Pow10(Exponent(currency)))
+}
+
+// Money is an amount in minor units of its currency, cents for USD, so sums
+// and comparisons are exact. Rounding only happens when multiplying by a
+// fraction, such as a tax or exchange rate, and goes half away from zero.
+type Money struct {
+	// Amount is in minor units of Currency.
+	Amount   int64
+	Currency string
+}
+
+func New(amount int64, currency string) Money {
+	return Money{Amount: amount, Currency: currency}
+}
+
+// Zero returns no money in currency.
+func Zero(currency string) Money {
+	return Money{Currency: currency}
+}
+
+// Parse reads a decimal amount such as "19.99" or "-5" in currency.
+// Decimals beyond the minor unit are only accepted when they are zeros,
+// so "1999.00" is a valid JPY amount and "0.125" is not a valid USD one.
+func Parse(value, currency string) (Money, error) {
+	if !ValidCurrency(currency) {
+		return Money{}, ErrInvalidCurrency
+	}
+
+	negative := strings.HasPrefix(value, "-")
+	whole, fraction, _ := strings.Cut(strings.TrimPrefix(value, "-"), ".")
+	if whole == "" || len(whole) > 15 || !digits(whole) || !digits(fraction) {
+		return Money{}, ErrInvalidAmount
+	}
+
+	exponent := Exponent(currency)
+	if len(fraction) > exponent {
+		if strings.Trim(fraction[exponent:], "0") != "" {
+			return Money{}, ErrTooPrecise
+		}
+		fraction = fraction[:exponent]
+	}
+	fraction += strings.Repeat("0", exponent-len(fraction))
+
+	amount, err := strconv.ParseInt(whole+fraction, 10, 64)
+	if err != nil {
+		return Money{}, ErrInvalidAmount
+	}
+	if negative {
+		amount = -amount
+	}
+	return Money{Amount: amount, Currency: currency}, nil
+}
+
+func digits(value string) bool {
+	for _, r := range value {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// String formats the amount as a plain decimal, "19.99", without the
+// currency.
+func (m Money) String() string {
+	exponent := Exponent(m.Currency)
+	amount := m.Amount
+	sign := ""
+	if amount < 0 {
+		sign, amount = "-", -amount
+	}
+	if exponent == 0 {
+		return sign + strconv.FormatInt(amount, 10)
+	}
+	unit := scale(m.Currency)
+	return fmt.Sprintf("%s%d.%0*d", sign, amount/unit, exponent, amount%unit)
+}
+
+type jsonMoney struct {
+	Amount   string `json:"amount"`
+	Currency string `json:"currency"`
+}
+
+// MarshalJSON writes the amount as a decimal string, which JSON clients
+// cannot mangle the way they do floating point numbers.
+func (m Money) MarshalJSON() ([]byte, error) {
+	return json.Marshal(jsonMoney{Amount: m.String(), Currency: m.Currency})
+}
+
+func (m *Money) UnmarshalJSON(data []byte) error {
+	var decoded jsonMoney
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return err
+	}
+	parsed, err := Parse(decoded.Amount, decoded.Currency)
+	if err != nil {
+		return err
+	}
+	*m = parsed
+	return nil
+}
+
+// Value stores the amount as a decimal string, which MySQL converts to the
+// DECIMAL columns exactly.
+func (m Money) Value() (driver.Value, error) {
+	return m.String(), nil
+}
+
+func (m Money) IsZero() bool {
+	return m.Amount == 0
+}
+
+func (m Money) IsNegative() bool {
+	return m.Amount < 0
+}
+
+func (m Money) mustMatch(other Money) {
+	if m.Currency != other.Currency {
+		panic(fmt.Sprintf("money: %s and %s amounts mixed", m.Currency, other.Currency))
+	}
+}
+
+// Add returns m + other. Mixing currencies is a programming error and
+// panics.
+func (m Money) Add(other Money) Money {
+	m.mustMatch(other)
+	return Money{Amount: m.Amount + other.Amount, Currency: m.Currency}
+}
+
+func (m Money) Sub(other Money) Money {
+	m.mustMatch(other)
+	return Money{Amount: m.Amount - other.Amount, Currency: m.Currency}
+}
+
+func (m Money) Neg() Money {
+	return Money{Amount: -m.Amount, Currency: m.Currency}
+}
+
+// Times multiplies by a whole quantity, which never needs rounding.
+func (m Money) Times(quantity int) Money {
+	return Money{Amount: m.Amount * int64(quantity), Currency: m.Currency}
+}
+
+// Cmp compares m with other, returning -1, 0 or +1.
+func (m Money) Cmp(other Money) int {
+	m.mustMatch(other)
+	switch {
+	case m.Amount < other.Amount:
+		return -1
+	case m.Amount > other.Amount:
+		return 1
+	}
+	return 0
+}
+
+// Min returns the smaller of m and other.
+func (m Money) Min(other Money) Money {
+	if m.Cmp(other) <= 0 {
+		return m
+	}
+	return other
+}
+
+// Mul multiplies by a fraction, rounding half away from zero.
+func (m Money) Mul(factor *big.Rat) Money {
+	product := new(big.Rat).Mul(new(big.Rat).SetInt64(m.Amount), factor)
+	return Money{Amount: roundRat(product), Currency: m.Currency}
+}
+
+// roundRat rounds half away from zero.
+func roundRat(value *big.Rat) int64 {
+	num := new(big.Int).Abs(value.Num())
+	den := value.Denom()
+	quotient, remainder := new(big.Int).QuoRem(num, den, new(big.Int))
+	if remainder.Mul(remainder, big.NewInt(2)).Cmp(den) >= 0 {
+		quotient.Add(quotient, big.NewInt(1))
+	}
+	if value.Sign() < 0 {
+		quotient.Neg(quotient)
+	}
+	return quotient.Int64()
+}
+
+// Allocate splits m in parts proportional to weights. Each part is
+// rounded down and the cents left over go, one each, to the parts that
+// lost the most in rounding, so the parts always add up to m.
+func (m Money) Allocate(weights []int64) []Money {
+	parts := make([]Money, len(weights))
+	var total int64
+	for i, weight := range weights {
+		parts[i] = Zero(m.Currency)
+		total += weight
+	}
+	if total == 0 {
+		return parts
+	}
+
+	sign := int64(1)
+	amount := m.Amount
+	if amount < 0 {
+		sign, amount = -1, -amount
+	}
+
+	remainders := make([]*big.Int, len(weights))
+	allocated := int64(0)
+	for i, weight := range weights {
+		share := new(big.Int).Mul(big.NewInt(amount), big.NewInt(weight))
+		quotient, remainder := share.QuoRem(share, big.NewInt(total), new(big.Int))
+		parts[i].Amount = quotient.Int64()
+		remainders[i] = remainder
+		allocated += parts[i].Amount
+	}
+
+	for left := amount - allocated; left > 0; left-- {
+		best := -1
+		for i := range weights {
+			if weights[i] > 0 && (best == -1 || remainders[i].Cmp(remainders[best]) > 0) {
+				best = i
+			}
+		}
+		parts[best].Amount++
+		remainders[best] = big.NewInt(-1)
+	}
+
+	for i := range parts {
+		parts[i].Amount *= sign
+	}
+	return parts
+}
+
+// Sum adds amounts up, in currency when there are none.
+func Sum(currency string, amounts ...Money) Money {
+	total := Zero(currency)
+	for _, amount := range amounts {
+		total = total.Add(amount)
+	}
+	return total
+}
@@ -0,0 +1,133 @@
+package money
+
+import (
+	"errors"
+	"math/big"
+	"reflect"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		value    string
+		currency string
+		want     int64
+		err      error
+	}{
+		{"19.99", "USD", 1999, nil},
+		{"19.9", "USD", 1990, nil},
+		{"-5", "USD", -500, nil},
+		{"1999", "JPY", 1999, nil},
+		{"1999.00", "JPY", 1999, nil},
+		{"1999.5", "JPY", 0, ErrTooPrecise},
+		{"1.234", "KWD", 1234, nil},
+		{"0.125", "USD", 0, ErrTooPrecise},
+		{"0.120", "USD", 12, nil},
+		{"", "USD", 0, ErrInvalidAmount},
+		{".5", "USD", 0, ErrInvalidAmount},
+		{"1e3", "USD", 0, ErrInvalidAmount},
+		{"1", "usd", 0, ErrInvalidCurrency},
+	}
+	for _, test := range tests {
+		got, err := Parse(test.value, test.currency)
+		if !errors.Is(err, test.err) {
+			t.Errorf("Parse(%q, %s) error = %v, want %v", test.value, test.currency, err, test.err)
+			continue
+		}
+		if err == nil && got.Amount != test.want {
+			t.Errorf("Parse(%q, %s) = %d, want %d", test.value, test.currency, got.Amount, test.want)
+		}
+	}
+}
+
+func TestString(t *testing.T) {
+	tests := []struct {
+		money Money
+		want  string
+	}{
+		{New(1999, "USD"), "19.99"},
+		{New(5, "USD"), "0.05"},
+		{New(-5, "USD"), "-0.05"},
+		{New(1999, "JPY"), "1999"},
+		{New(1234, "KWD"), "1.234"},
+		{New(-1001, "BHD"), "-1.001"},
+	}
+	for _, test := range tests {
+		if got := test.money.String(); got != test.want {
+			t.Errorf("%#v.String() = %q, want %q", test.money, got, test.want)
+		}
+	}
+}
+
+func TestMul(t *testing.T) {
+	tests := []struct {
+		amount int64
+		factor string
+		want   int64
+	}{
+		{1000, "0.2", 200},
+		{1999, "0.2", 400},   // 399.8
+		{25, "0.5", 13},      // 12.5 rounds away from zero
+		{-25, "0.5", -13},    // and so do negative halves
+		{15, "0.3", 5},       // 4.5
+		{1001, "0.1", 100},   // 100.1
+		{999, "21/121", 173}, // 173.38, VAT included in a 21% price
+		{333, "1/3", 111},    // exact
+		{100, "-0.075", -8},  // -7.5
+	}
+	for _, test := range tests {
+		factor, _ := new(big.Rat).SetString(test.factor)
+		if got := New(test.amount, "USD").Mul(factor); got.Amount != test.want {
+			t.Errorf("%d × %s = %d, want %d", test.amount, test.factor, got.Amount, test.want)
+		}
+	}
+}
+
+func TestAllocate(t *testing.T) {
+	tests := []struct {
+		amount  int64
+		weights []int64
+		want    []int64
+	}{
+		{100, []int64{1, 1}, []int64{50, 50}},
+		{100, []int64{1, 1, 1}, []int64{34, 33, 33}},
+		{-100, []int64{1, 1, 1}, []int64{-34, -33, -33}},
+		{10, []int64{1, 2}, []int64{3, 7}},
+		{5, []int64{0, 1}, []int64{0, 5}},
+		{1, []int64{0, 3, 3}, []int64{0, 1, 0}},
+		{7, []int64{0, 0}, []int64{0, 0}},
+		{1000, []int64{1999, 2999, 4999}, []int64{200, 300, 500}},
+		{0, []int64{1, 2}, []int64{0, 0}},
+	}
+	for _, test := range tests {
+		parts := New(test.amount, "USD").Allocate(test.weights)
+		got := make([]int64, len(parts))
+		var sum int64
+		for i, part := range parts {
+			got[i] = part.Amount
+			sum += part.Amount
+			if part.Currency != "USD" {
+				t.Errorf("Allocate(%d, %v) part %d is in %s", test.amount, test.weights, i, part.Currency)
+			}
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("Allocate(%d, %v) = %v, want %v", test.amount, test.weights, got, test.want)
+		}
+		var total int64
+		for _, weight := range test.weights {
+			total += weight
+		}
+		if total != 0 && sum != test.amount {
+			t.Errorf("Allocate(%d, %v) parts add up to %d", test.amount, test.weights, sum)
+		}
+	}
+}
+
+func TestMixedCurrenciesPanic(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("adding USD to EUR did not panic")
+		}
+	}()
+	New(1, "USD").Add(New(1, "EUR"))
+}
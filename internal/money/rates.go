@@ -0,0 +1,117 @@
+package money
+
+import (
+	"database/sql"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+// Rates converts amounts of a base currency for display. Prices are only
+// ever charged in the base currency.
+type Rates struct {
+	Base  string
+	rates map[string]*big.Rat
+}
+
+// ParseRates reads a table like "EUR=0.92,GBP=0.79", each rate being how
+// much of that currency one unit of base buys.
+func ParseRates(base, table string) (Rates, error) {
+	rates := Rates{Base: base, rates: map[string]*big.Rat{base: big.NewRat(1, 1)}}
+
+	for _, entry := range strings.Split(table, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		code, value, _ := strings.Cut(entry, "=")
+		code = strings.ToUpper(strings.TrimSpace(code))
+		rate, ok := new(big.Rat).SetString(strings.TrimSpace(value))
+		if !ValidCurrency(code) || !ok || rate.Sign() <= 0 {
+			return Rates{}, fmt.Errorf("invalid exchange rate %q", entry)
+		}
+		rates.rates[code] = rate
+	}
+
+	return rates, nil
+}
+
+// Currencies lists the currencies amounts can be shown in.
+func (r Rates) Currencies() []string {
+	codes := make([]string, 0, len(r.rates))
+	for code := range r.rates {
+		codes = append(codes, code)
+	}
+	return codes
+}
+
+func (r Rates) Supports(currency string) bool {
+	_, ok := r.rates[currency]
+	return ok
+}
+
+// Convert turns an amount of the base currency into currency, rounding
+// half away from zero to the minor unit of currency.
+func (r Rates) Convert(m Money, currency string) (Money, error) {
+	rate, ok := r.rates[currency]
+	if !ok || m.Currency != r.Base {
+		return Money{}, ErrInvalidCurrency
+	}
+
+	// Minor units of the base to minor units of currency.
+	factor := new(big.Rat).Mul(rate, big.NewRat(scale(currency), scale(m.Currency)))
+	converted := New(m.Amount, currency).Mul(factor)
+	return converted, nil
+}
+
+// Column scans a DECIMAL column into dest as an amount of currency.
+func Column(dest *Money, currency string) sql.Scanner {
+	return column{dest: dest, currency: currency}
+}
+
+// NullColumn scans a nullable DECIMAL column, leaving dest nil for NULL.
+func NullColumn(dest **Money, currency string) sql.Scanner {
+	return nullColumn{dest: dest, currency: currency}
+}
+
+type column struct {
+	dest     *Money
+	currency string
+}
+
+func (c column) Scan(value any) error {
+	var text string
+	switch v := value.(type) {
+	case []byte:
+		text = string(v)
+	case string:
+		text = v
+	default:
+		return fmt.Errorf("money: cannot scan %T", value)
+	}
+
+	parsed, err := Parse(text, c.currency)
+	if err != nil {
+		return err
+	}
+	*c.dest = parsed
+	return nil
+}
+
+type nullColumn struct {
+	dest     **Money
+	currency string
+}
+
+func (c nullColumn) Scan(value any) error {
+	if value == nil {
+		*c.dest = nil
+		return nil
+	}
+	var m Money
+	if err := (column{dest: &m, currency: c.currency}).Scan(value); err != nil {
+		return err
+	}
+	*c.dest = &m
+	return nil
+}
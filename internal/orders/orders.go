@@ -0,0 +1,503 @@
+package orders
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base32"
+	"errors"
+	"fmt"
+	"html"
+	"log"
+	"time"
+
+	"basicthreads/internal/addresses"
+	"basicthreads/internal/carts"
+	"basicthreads/internal/config"
+	"basicthreads/internal/database"
+	"basicthreads/internal/inventory"
+	"basicthreads/internal/mailer"
+	"basicthreads/internal/money"
+	"basicthreads/internal/products"
+	"basicthreads/internal/shipping"
+	"basicthreads/internal/tax"
+)
+
+const (
+	StatusPending   = "pending"
+	StatusPaid      = "paid"
+	StatusShipped   = "shipped"
+	StatusDelivered = "delivered"
+	StatusCancelled = "cancelled"
+	StatusRefunded  = "refunded"
+)
+
+// transitions lists where an order can go from each status. Cancelled and
+// refunded orders are final.
+var transitions = map[string][]string{
+	StatusPending:   {StatusPaid, StatusCancelled},
+	StatusPaid:      {StatusShipped, StatusCancelled, StatusRefunded},
+	StatusShipped:   {StatusDelivered, StatusRefunded},
+	StatusDelivered: {StatusRefunded},
+}
+
+var (
+	ErrNotFound       = database.ErrNotFound
+	ErrEmptyCart      = errors.New("cart is empty")
+	ErrUnavailable    = errors.New("some items in the cart are no longer available")
+	ErrInvalidStatus  = errors.New("unknown order status")
+	ErrStatusChanged  = database.ErrStatusChanged
+	ErrNotEnoughStock = database.ErrInsufficientStock
+	ErrDiscountUsedUp = database.ErrPromotionUsedUp
+	ErrCartChanged    = database.ErrCartChanged
+)
+
+// TransitionError is returned for a status change the state machine does
+// not allow.
+type TransitionError struct {
+	From string
+	To   string
+}
+
+func (e *TransitionError) Error() string {
+	return "cannot change an order from " + e.From + " to " + e.To
+}
+
+// ValidStatus reports whether status is one an order can have.
+func ValidStatus(status string) bool {
+	switch status {
+	case StatusPending, StatusPaid, StatusShipped, StatusDelivered, StatusCancelled, StatusRefunded:
+		return true
+	}
+	return false
+}
+
+func CanTransition(from, to string) bool {
+	for _, next := range transitions[from] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
+
+func newReference() (string, error) {
+	raw := make([]byte, 5)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	return "BT-" + base32.StdEncoding.EncodeToString(raw), nil
+}
+
+// CheckoutInput is what the customer picks at checkout: where the order
+// goes and the shipping method, one of the rates quoted for the address.
+// Addresses come from the address book by id, or the shipping one is typed
+// in. Without either the default addresses are used, and without a billing
+// address the order is billed to where it ships.
+type CheckoutInput struct {
+	ShippingAddressID int
+	ShippingAddress   database.Address
+	BillingAddressID  int
+	ShippingMethod    int
+}
+
+// bookAddress returns an address of a kind from the address book of a
+// customer, reporting anything else under field.
+func bookAddress(ctx context.Context, customerID, id int, kind, field string) (database.Address, error) {
+	address, err := addresses.Get(ctx, customerID, id)
+	if errors.Is(err, addresses.ErrNotFound) || (err == nil && address.Kind != kind) {
+		return database.Address{}, products.ValidationError{field: "must be a " + kind + " address of your address book"}
+	}
+	return address.Address, err
+}
+
+// checkoutAddresses works out where an order ships and who it is billed
+// to. The order keeps a copy, so later changes to the address book do not
+// change it.
+func checkoutAddresses(ctx context.Context, customerID int, in CheckoutInput) (database.Address, database.Address, error) {
+	var shipping, billing database.Address
+	var err error
+	switch {
+	case in.ShippingAddressID != 0:
+		shipping, err = bookAddress(ctx, customerID, in.ShippingAddressID, addresses.KindShipping, "shipping_address")
+	case in.ShippingAddress != database.Address{}:
+		shipping = addresses.Normalize(in.ShippingAddress)
+		err = addresses.Validate(shipping)
+	default:
+		var saved database.CustomerAddress
+		saved, err = addresses.Default(ctx, customerID, addresses.KindShipping)
+		if errors.Is(err, addresses.ErrNotFound) {
+			err = products.ValidationError{"shipping_address": "is required, type one in or add one to your address book"}
+		}
+		shipping = saved.Address
+	}
+	if err != nil {
+		return shipping, billing, err
+	}
+
+	if in.BillingAddressID != 0 {
+		billing, err = bookAddress(ctx, customerID, in.BillingAddressID, addresses.KindBilling, "billing_address")
+		return shipping, billing, err
+	}
+	saved, err := addresses.Default(ctx, customerID, addresses.KindBilling)
+	if errors.Is(err, addresses.ErrNotFound) {
+		return shipping, shipping, nil
+	}
+	return shipping, saved.Address, err
+}
+
+// Checkout turns the cart of a customer into a pending order shipped to
+// the address in the input, with the discounts the cart shows, the taxes
+// of the address and the price of the shipping method. The stock of every
+// variant is reserved until the order is paid or cancelled, and the cart
+// is emptied.
+func Checkout(ctx context.Context, customerID int, email string, in CheckoutInput) (database.Order, error) {
+	address, billing, err := checkoutAddresses(ctx, customerID, in)
+	if err != nil {
+		return database.Order{}, err
+	}
+
+	cart, err := carts.Get(ctx, carts.Owner{CustomerID: customerID})
+	if err != nil {
+		return database.Order{}, err
+	}
+	if len(cart.Items) == 0 {
+		return database.Order{}, ErrEmptyCart
+	}
+
+	reference, err := newReference()
+	if err != nil {
+		return database.Order{}, err
+	}
+
+	order := database.Order{
+		Reference:       reference,
+		CustomerID:      customerID,
+		Email:           email,
+		Status:          StatusPending,
+		Currency:        cart.Subtotal.Currency,
+		Subtotal:        cart.Subtotal,
+		Discount:        cart.Discount,
+		Total:           cart.Total,
+		ShippingAddress: address,
+		BillingAddress:  billing,
+		Weight:          cart.Weight,
+	}
+	for _, applied := range cart.Discounts {
+		promotionID := applied.PromotionID
+		order.Discounts = append(order.Discounts, database.OrderDiscount{
+			PromotionID: &promotionID,
+			Code:        applied.Code,
+			Name:        applied.Name,
+			Amount:      applied.Amount,
+		})
+	}
+	quantities := map[int]int{}
+	for _, line := range cart.Items {
+		if !line.Available {
+			return database.Order{}, ErrUnavailable
+		}
+		productID := line.ProductID
+		order.Items = append(order.Items, database.OrderItem{
+			ProductID: &productID,
+			VariantID: line.VariantID,
+			Name:      line.Name,
+			SKU:       line.SKU,
+			Size:      line.Size,
+			Color:     line.Color,
+			Image:     line.Image,
+			UnitPrice: line.UnitPrice,
+			Quantity:  line.Quantity,
+			LineTotal: line.LineTotal,
+			Discount:  line.Discount,
+			TaxClass:  line.TaxClass,
+		})
+		if line.VariantID != nil {
+			quantities[*line.VariantID] += line.Quantity
+		}
+	}
+
+	if err := applyTax(ctx, &order); err != nil {
+		return database.Order{}, err
+	}
+	if err := applyShipping(ctx, &order, cart.Total, in.ShippingMethod); err != nil {
+		return database.Order{}, err
+	}
+
+	if len(quantities) > 0 {
+		if err := inventory.Reserve(ctx, reference, quantities); err != nil {
+			return database.Order{}, err
+		}
+	}
+
+	cartID, err := database.FindCart(ctx, customerID, "")
+	if err != nil {
+		inventory.Release(ctx, reference)
+		return database.Order{}, err
+	}
+
+	created, err := database.CreateOrder(ctx, order, cartID, email)
+	if err != nil {
+		inventory.Release(ctx, reference)
+	}
+	return created, err
+}
+
+// applyTax works out the taxes of an order from its shipping address and
+// adds them to the total unless prices already include them.
+func applyTax(ctx context.Context, order *database.Order) error {
+	lines := make([]tax.Line, len(order.Items))
+	for i, item := range order.Items {
+		lines[i] = tax.Line{Class: item.TaxClass, Amount: item.LineTotal.Sub(item.Discount)}
+	}
+
+	result, err := tax.Quote(ctx, order.ShippingAddress.Country, order.ShippingAddress.Region, lines, order.Currency)
+	if err != nil {
+		return err
+	}
+
+	for i := range order.Items {
+		order.Items[i].Tax = result.Lines[i]
+	}
+	for _, entry := range result.Breakdown {
+		order.Taxes = append(order.Taxes, database.OrderTax(entry))
+	}
+	order.Tax = result.Tax
+	order.PricesIncludeTax = result.Inclusive
+	if !result.Inclusive {
+		order.Total = order.Total.Add(result.Tax)
+	}
+	return nil
+}
+
+// applyShipping adds the price of the shipping method to an order. The
+// method must be one quoted for its address; free shipping thresholds are
+// measured against goods, what the items cost after discounts.
+func applyShipping(ctx context.Context, order *database.Order, goods money.Money, methodID int) error {
+	rates, err := shipping.Quote(ctx, order.ShippingAddress.Country, order.ShippingAddress.Region, order.Weight, goods)
+	if err != nil {
+		return err
+	}
+	if len(rates) == 0 {
+		return products.ValidationError{"shipping_method": "no shipping method reaches this address"}
+	}
+
+	for _, rate := range rates {
+		if rate.MethodID == methodID {
+			order.Shipping = rate.Price
+			order.ShippingMethod = rate.Name
+			order.ShippingCarrier = rate.Carrier
+			order.ShippingService = rate.Service
+			order.Total = order.Total.Add(rate.Price)
+			return nil
+		}
+	}
+	return products.ValidationError{"shipping_method": "must be one of the rates quoted for this address"}
+}
+
+// Get returns an order of a customer. Orders of other customers are not
+// found.
+func Get(ctx context.Context, customerID, id int) (database.Order, error) {
+	order, err := database.GetOrder(ctx, id)
+	if err == nil && order.CustomerID != customerID {
+		return database.Order{}, ErrNotFound
+	}
+	return order, err
+}
+
+// Page is one page of an order listing.
+type Page struct {
+	Items      []database.Order `json:"items"`
+	NextOffset *int             `json:"next_offset,omitempty"`
+	Total      int              `json:"total"`
+}
+
+// List returns a page of orders, newest first. Customers pass their own id
+// in the filter; admins search across every customer.
+func List(ctx context.Context, filter database.OrderFilter) (Page, error) {
+	if filter.Limit < 1 || filter.Limit > products.MaxLimit {
+		filter.Limit = products.DefaultLimit
+	}
+	if filter.Status != "" && !ValidStatus(filter.Status) {
+		return Page{}, ErrInvalidStatus
+	}
+
+	items, total, err := database.ListOrders(ctx, filter)
+	if err != nil {
+		return Page{}, err
+	}
+
+	page := Page{Items: items, Total: total}
+	if next := filter.Offset + len(items); next < total {
+		page.NextOffset = &next
+	}
+	return page, nil
+}
+
+// Transition moves an order to another status, keeping stock in step: paying
+// turns the reservations into sales, cancelling gives the stock back and
+// the promotion uses of the order.
+// Refunds leave stock alone, returned goods are recorded through inventory.
+func Transition(ctx context.Context, id int, to, actor, note string) (database.Order, error) {
+	if !ValidStatus(to) {
+		return database.Order{}, ErrInvalidStatus
+	}
+
+	order, err := database.GetOrder(ctx, id)
+	if err != nil {
+		return order, err
+	}
+	return transition(ctx, order, to, actor, note)
+}
+
+func transition(ctx context.Context, order database.Order, to, actor, note string) (database.Order, error) {
+	id := order.ID
+	if !CanTransition(order.Status, to) {
+		return order, &TransitionError{From: order.Status, To: to}
+	}
+
+	stock := database.StockKeep
+	switch {
+	case order.Status == StatusPending && to == StatusPaid && hasVariants(order):
+		stock = database.StockCommit
+	case order.Status == StatusPending && to == StatusCancelled:
+		stock = database.StockRelease
+	case order.Status == StatusPaid && to == StatusCancelled && hasVariants(order):
+		// A paid order that never shipped puts its goods back on the shelf.
+		stock = database.StockReturn
+	}
+
+	changes, err := database.ChangeOrderStatus(ctx, id, order.Status, to, stock, actor, note)
+	if err != nil {
+		return order, err
+	}
+	inventory.Restocked(ctx, changes...)
+
+	return database.GetOrder(ctx, id)
+}
+
+// hasVariants reports whether an order has lines with stock reserved for
+// them at checkout.
+func hasVariants(order database.Order) bool {
+	for _, item := range order.Items {
+		if item.VariantID != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// Ship books the parcel of a paid order with the carrier of its shipping
+// method, records the shipment and marks the order shipped. The customer
+// gets an email with the tracking number. An order is booked once: calls
+// that lose a race fail with ErrStatusChanged.
+func Ship(ctx context.Context, id int, actor string) (database.Order, error) {
+	order, err := database.GetOrder(ctx, id)
+	if err != nil {
+		return order, err
+	}
+	if !CanTransition(order.Status, StatusShipped) {
+		return order, &TransitionError{From: order.Status, To: StatusShipped}
+	}
+
+	carrier, err := shipping.CarrierFor(order.ShippingCarrier)
+	if err != nil {
+		return order, err
+	}
+	shipment, err := database.ShipOrder(ctx, id, actor, func() (database.Shipment, error) {
+		label, err := carrier.Ship(ctx, shipping.ShipmentRequest{
+			Reference: order.Reference,
+			Service:   order.ShippingService,
+			To:        order.ShippingAddress,
+			Weight:    order.Weight,
+		})
+		return database.Shipment{
+			Carrier:        carrier.Name(),
+			Service:        order.ShippingService,
+			TrackingNumber: label.TrackingNumber,
+			TrackingURL:    label.TrackingURL,
+		}, err
+	})
+	if err != nil {
+		return order, err
+	}
+
+	shipped, err := database.GetOrder(ctx, id)
+	if err != nil {
+		return shipped, err
+	}
+	notifyShipped(ctx, shipped, shipment)
+	return shipped, nil
+}
+
+// notifyShipped emails the customer the tracking number of a shipment.
+func notifyShipped(ctx context.Context, order database.Order, shipment database.Shipment) {
+	tracking := html.EscapeString(shipment.TrackingNumber)
+	if shipment.TrackingURL != "" {
+		tracking = fmt.Sprintf(`<a href="%s">%s</a>`, html.EscapeString(shipment.TrackingURL), tracking)
+	}
+
+	err := mailer.Send(ctx, mailer.Message{
+		To:      []mailer.Address{{Email: order.Email}},
+		Subject: "Tu pedido " + order.Reference + " fue enviado",
+		HTML: fmt.Sprintf(
+			"<html><body><p>Tu pedido <b>%s</b> va en camino con %s.</p><p>Número de seguimiento: %s</p></body></html>",
+			html.EscapeString(order.Reference),
+			html.EscapeString(order.ShippingMethod),
+			tracking,
+		),
+	})
+	if err != nil {
+		log.Printf("sending shipped email for %s: %v", order.Reference, err)
+	}
+}
+
+// ExpirePending cancels the orders that stayed pending longer than their
+// stock reservations last.
+func ExpirePending(ctx context.Context) (int, error) {
+	ids, err := database.GetStalePendingOrders(ctx, config.Load().ReservationTTL)
+	if err != nil {
+		return 0, err
+	}
+
+	expired := 0
+	for _, id := range ids {
+		order, err := database.GetOrder(ctx, id)
+		if err != nil {
+			return expired, err
+		}
+		// Paid while we were looking: leave it alone.
+		if order.Status != StatusPending {
+			continue
+		}
+
+		_, err = transition(ctx, order, StatusCancelled, "system", "payment not received in time")
+		if errors.Is(err, ErrStatusChanged) {
+			continue
+		}
+		if err != nil {
+			return expired, err
+		}
+		expired++
+	}
+	return expired, nil
+}
+
+// Sweep cancels stale pending orders every interval until ctx is done.
+func Sweep(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			expired, err := ExpirePending(ctx)
+			if err != nil && !errors.Is(err, context.Canceled) {
+				log.Printf("expiring pending orders: %v", err)
+			} else if expired > 0 {
+				log.Printf("cancelled %d unpaid orders", expired)
+			}
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package orders
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCanTransition(t *testing.T) {
+	statuses := []string{StatusPending, StatusPaid, StatusShipped, StatusDelivered, StatusCancelled, StatusRefunded}
+	allowed := map[string]bool{
+		StatusPending + ">" + StatusPaid:       true,
+		StatusPending + ">" + StatusCancelled:  true,
+		StatusPaid + ">" + StatusShipped:       true,
+		StatusPaid + ">" + StatusCancelled:     true,
+		StatusPaid + ">" + StatusRefunded:      true,
+		StatusShipped + ">" + StatusDelivered:  true,
+		StatusShipped + ">" + StatusRefunded:   true,
+		StatusDelivered + ">" + StatusRefunded: true,
+	}
+	for _, from := range statuses {
+		for _, to := range statuses {
+			want := allowed[from+">"+to]
+			if got := CanTransition(from, to); got != want {
+				t.Errorf("CanTransition(%s, %s) = %v, want %v", from, to, got, want)
+			}
+		}
+	}
+	if CanTransition("", StatusPaid) || CanTransition(StatusPending, "lost") {
+		t.Error("CanTransition allowed an unknown status")
+	}
+}
+
+func TestValidStatus(t *testing.T) {
+	tests := []struct {
+		status string
+		want   bool
+	}{
+		{StatusPending, true},
+		{StatusPaid, true},
+		{StatusShipped, true},
+		{StatusDelivered, true},
+		{StatusCancelled, true},
+		{StatusRefunded, true},
+		{"", false},
+		{"Paid", false},
+		{"lost", false},
+	}
+	for _, test := range tests {
+		if got := ValidStatus(test.status); got != test.want {
+			t.Errorf("ValidStatus(%q) = %v, want %v", test.status, got, test.want)
+		}
+	}
+}
+
+func TestNewReference(t *testing.T) {
+	seen := map[string]bool{}
+	for i := 0; i < 100; i++ {
+		reference, err := newReference()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasPrefix(reference, "BT-") || len(reference) != 11 {
+			t.Fatalf("newReference() = %q, want BT- and 8 characters", reference)
+		}
+		if seen[reference] {
+			t.Fatalf("newReference() repeated %q", reference)
+		}
+		seen[reference] = true
+	}
+}
@@ -0,0 +1,301 @@
+package payments
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Payment methods the fake gateway understands when confirming an intent.
+const (
+	FakeCardSuccess = "pm_card_visa"
+	FakeCardDecline = "pm_card_chargeDeclined"
+	// FakeCardDelayed succeeds, but its webhooks arrive after DelayedBy.
+	FakeCardDelayed = "pm_card_delayed"
+)
+
+// FakeGateway is a local stand-in for the Stripe API, enough for the Stripe
+// provider to run against in development. Intents live in memory. Besides
+// the calls the shop makes, it accepts
+//
+//	POST /v1/payment_intents/{id}/confirm  payment_method=pm_card_visa
+//
+// which plays the part of the customer paying in the browser.
+type FakeGateway struct {
+	WebhookURL    string
+	WebhookSecret string
+	// Delay is how long webhooks wait before being sent.
+	Delay time.Duration
+	// DelayedBy is how long webhooks of FakeCardDelayed payments wait.
+	DelayedBy time.Duration
+
+	mu          sync.Mutex
+	intents     map[string]*fakeIntent
+	idempotency map[string][]byte
+	client      http.Client
+}
+
+type fakeIntent struct {
+	stripeObject
+	CaptureMethod string `json:"capture_method"`
+	delay         time.Duration
+}
+
+func NewFakeGateway(webhookURL, webhookSecret string, delay time.Duration) *FakeGateway {
+	return &FakeGateway{
+		WebhookURL:    webhookURL,
+		WebhookSecret: webhookSecret,
+		Delay:         delay,
+		DelayedBy:     30 * time.Second,
+		intents:       map[string]*fakeIntent{},
+		idempotency:   map[string][]byte{},
+		client:        http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func fakeID(prefix string) string {
+	raw := make([]byte, 12)
+	rand.Read(raw)
+	return prefix + "_" + hex.EncodeToString(raw)
+}
+
+func fakeError(w http.ResponseWriter, code int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]any{"error": map[string]string{"message": message}})
+}
+
+func (g *FakeGateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost && r.Method != http.MethodGet {
+		fakeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		fakeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	key := r.Header.Get("Idempotency-Key")
+	if key != "" && r.Method == http.MethodPost {
+		if body, ok := g.idempotency[key]; ok {
+			w.Header().Set("Content-Type", "application/json")
+			w.Write(body)
+			return
+		}
+	}
+
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	var result any
+	var code int
+	var message string
+	switch {
+	case r.Method == http.MethodPost && len(parts) == 2 && parts[1] == "payment_intents":
+		result, code, message = g.create(r)
+	case r.Method == http.MethodGet && len(parts) == 3 && parts[1] == "payment_intents":
+		result, code, message = g.get(parts[2])
+	case r.Method == http.MethodPost && len(parts) == 4 && parts[1] == "payment_intents" && parts[3] == "confirm":
+		result, code, message = g.confirm(parts[2], r.PostForm.Get("payment_method"))
+	case r.Method == http.MethodPost && len(parts) == 4 && parts[1] == "payment_intents" && parts[3] == "capture":
+		result, code, message = g.capture(parts[2])
+	case r.Method == http.MethodPost && len(parts) == 2 && parts[1] == "refunds":
+		result, code, message = g.refund(r)
+	default:
+		code, message = http.StatusNotFound, "unknown endpoint "+r.URL.Path
+	}
+	if message != "" {
+		fakeError(w, code, message)
+		return
+	}
+
+	body, err := json.Marshal(result)
+	if err != nil {
+		fakeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if key != "" && r.Method == http.MethodPost {
+		g.idempotency[key] = body
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(body)
+}
+
+func (g *FakeGateway) create(r *http.Request) (any, int, string) {
+	amount, err := strconv.ParseInt(r.PostForm.Get("amount"), 10, 64)
+	if err != nil || amount <= 0 {
+		return nil, http.StatusBadRequest, "amount must be a positive integer"
+	}
+
+	intent := &fakeIntent{
+		stripeObject: stripeObject{
+			ID:       fakeID("pi"),
+			Object:   "payment_intent",
+			Status:   "requires_payment_method",
+			Amount:   amount,
+			Currency: r.PostForm.Get("currency"),
+			Metadata: map[string]string{"reference": r.PostForm.Get("metadata[reference]")},
+		},
+		CaptureMethod: r.PostForm.Get("capture_method"),
+		delay:         g.Delay,
+	}
+	intent.ClientSecret = intent.ID + "_secret_" + fakeID("cs")
+	g.intents[intent.ID] = intent
+	return intent, http.StatusOK, ""
+}
+
+func (g *FakeGateway) get(id string) (any, int, string) {
+	intent, ok := g.intents[id]
+	if !ok {
+		return nil, http.StatusNotFound, "no such payment_intent: " + id
+	}
+	return intent, http.StatusOK, ""
+}
+
+// confirm decides the payment the way the card network would: declines
+// report a failure, everything else waits to be captured.
+func (g *FakeGateway) confirm(id, method string) (any, int, string) {
+	intent, ok := g.intents[id]
+	if !ok {
+		return nil, http.StatusNotFound, "no such payment_intent: " + id
+	}
+	if intent.Status != "requires_payment_method" {
+		return nil, http.StatusBadRequest, "payment_intent has status " + intent.Status
+	}
+
+	switch method {
+	case FakeCardDecline:
+		g.send(intent, EventFailed)
+		return intent, http.StatusOK, ""
+	case FakeCardDelayed:
+		intent.delay = g.DelayedBy
+	case FakeCardSuccess, "":
+	default:
+		return nil, http.StatusBadRequest, "unknown payment_method " + method
+	}
+
+	if intent.CaptureMethod == "manual" {
+		intent.Status = "requires_capture"
+		g.send(intent, EventCapturable)
+	} else {
+		intent.Status = "succeeded"
+		g.send(intent, EventSucceeded)
+	}
+	return intent, http.StatusOK, ""
+}
+
+func (g *FakeGateway) capture(id string) (any, int, string) {
+	intent, ok := g.intents[id]
+	if !ok {
+		return nil, http.StatusNotFound, "no such payment_intent: " + id
+	}
+	if intent.Status != "requires_capture" {
+		return nil, http.StatusBadRequest, "payment_intent has status " + intent.Status
+	}
+
+	intent.Status = "succeeded"
+	g.send(intent, EventSucceeded)
+	return intent, http.StatusOK, ""
+}
+
+func (g *FakeGateway) refund(r *http.Request) (any, int, string) {
+	intent, ok := g.intents[r.PostForm.Get("payment_intent")]
+	if !ok {
+		return nil, http.StatusNotFound, "no such payment_intent: " + r.PostForm.Get("payment_intent")
+	}
+	if intent.Status != "succeeded" {
+		return nil, http.StatusBadRequest, "payment_intent has status " + intent.Status
+	}
+
+	amount := intent.Amount - intent.AmountRefunded
+	if value := r.PostForm.Get("amount"); value != "" {
+		requested, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || requested <= 0 || requested > amount {
+			return nil, http.StatusBadRequest, "amount must be between 1 and " + strconv.FormatInt(amount, 10)
+		}
+		amount = requested
+	}
+	if amount == 0 {
+		return nil, http.StatusBadRequest, "charge has already been refunded"
+	}
+	intent.AmountRefunded += amount
+
+	g.send(intent, EventRefunded)
+	return map[string]any{
+		"id":             fakeID("re"),
+		"object":         "refund",
+		"status":         "succeeded",
+		"amount":         amount,
+		"payment_intent": intent.ID,
+	}, http.StatusOK, ""
+}
+
+// send posts a signed webhook about intent after its delay. It copies the
+// intent first, later changes must not leak into an event already decided.
+func (g *FakeGateway) send(intent *fakeIntent, kind string) {
+	object := any(intent.stripeObject)
+	if kind == EventRefunded {
+		object = map[string]any{
+			"id":              fakeID("ch"),
+			"object":          "charge",
+			"amount":          intent.Amount,
+			"amount_refunded": intent.AmountRefunded,
+			"currency":        intent.Currency,
+			"payment_intent":  intent.ID,
+			"metadata":        intent.Metadata,
+		}
+	}
+	payload, err := json.Marshal(map[string]any{
+		"id":   fakeID("evt"),
+		"type": kind,
+		"data": map[string]any{"object": object},
+	})
+	if err != nil {
+		log.Printf("fake gateway: encoding %s: %v", kind, err)
+		return
+	}
+	delay := intent.delay
+
+	go func() {
+		time.Sleep(delay)
+		// Retry a few times like a real gateway would.
+		for attempt := 1; attempt <= 3; attempt++ {
+			if err := g.post(payload); err != nil {
+				log.Printf("fake gateway: sending %s, attempt %d: %v", kind, attempt, err)
+				time.Sleep(time.Duration(attempt) * time.Second)
+				continue
+			}
+			log.Printf("fake gateway: sent %s for %s", kind, intent.ID)
+			return
+		}
+	}()
+}
+
+func (g *FakeGateway) post(payload []byte) error {
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req, err := http.NewRequest(http.MethodPost, g.WebhookURL, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Stripe-Signature", "t="+timestamp+",v1="+Sign(g.WebhookSecret, timestamp, payload))
+
+	res, err := g.client.Do(req)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	if res.StatusCode >= 300 {
+		return fmt.Errorf("webhook answered %s", res.Status)
+	}
+	return nil
+}
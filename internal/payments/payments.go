@@ -0,0 +1,67 @@
+package payments
+
+import (
+	"context"
+	"errors"
+	"net/http"
+)
+
+// Event types the shop reacts to. They follow the names Stripe uses.
+const (
+	EventCapturable = "payment_intent.amount_capturable_updated"
+	EventSucceeded  = "payment_intent.succeeded"
+	EventFailed     = "payment_intent.payment_failed"
+	EventRefunded   = "charge.refunded"
+)
+
+var (
+	ErrInvalidSignature = errors.New("invalid webhook signature")
+	ErrInvalidEvent     = errors.New("invalid webhook event")
+)
+
+// Intent is a payment the customer authorizes and the shop captures
+// afterwards. Amounts are in minor units, cents for USD.
+type Intent struct {
+	ID           string
+	Status       string
+	ClientSecret string `json:",omitempty"`
+	Amount       int64
+	Currency     string
+	Reference    string
+}
+
+type Refund struct {
+	ID       string
+	IntentID string
+	Status   string
+	Amount   int64
+}
+
+// Event is a verified webhook notification about an intent.
+type Event struct {
+	ID     string
+	Type   string
+	Intent Intent
+}
+
+// IntentRequest asks for an intent paying the order with reference.
+type IntentRequest struct {
+	Reference string
+	Amount    int64
+	Currency  string
+	Email     string
+}
+
+// Provider is a payment gateway. Implementations must make CreateIntent,
+// Capture and Refund safe to retry.
+type Provider interface {
+	Name() string
+	CreateIntent(ctx context.Context, request IntentRequest) (Intent, error)
+	Capture(ctx context.Context, intentID string) (Intent, error)
+	// Refund gives back amount of a captured intent, or all of it when
+	// amount is 0.
+	Refund(ctx context.Context, intentID string, amount int64) (Refund, error)
+	// ParseWebhook checks the signature of a webhook request and decodes
+	// the event it carries.
+	ParseWebhook(payload []byte, header http.Header) (Event, error)
+}
@@ -0,0 +1,214 @@
+package payments
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strings"
+
+	"basicthreads/internal/config"
+	"basicthreads/internal/database"
+	"basicthreads/internal/orders"
+)
+
+var (
+	ErrNotPayable = errors.New("only pending orders can be paid")
+	ErrNotPaid    = errors.New("order has no captured payment")
+)
+
+// actor is who the order history says changed an order on behalf of the
+// payment provider.
+const actor = "payments"
+
+// New returns the provider the configuration points at.
+func New(cfg config.Config) Provider {
+	return NewStripe(cfg.PaymentURL, cfg.PaymentSecretKey, cfg.PaymentWebhookSecret)
+}
+
+// Start opens a payment for a pending order of a customer and returns the
+// intent the client confirms. Starting twice returns the same intent.
+func Start(ctx context.Context, provider Provider, customerID, orderID int) (Intent, error) {
+	order, err := orders.Get(ctx, customerID, orderID)
+	if err != nil {
+		return Intent{}, err
+	}
+	if order.Status != orders.StatusPending {
+		return Intent{}, ErrNotPayable
+	}
+
+	intent, err := provider.CreateIntent(ctx, IntentRequest{
+		Reference: order.Reference,
+		Amount:    order.Total.Amount,
+		Currency:  order.Total.Currency,
+		Email:     order.Email,
+	})
+	if err != nil {
+		return intent, err
+	}
+
+	err = database.SavePayment(ctx, database.Payment{
+		OrderID:  order.ID,
+		Provider: provider.Name(),
+		IntentID: intent.ID,
+		Status:   intent.Status,
+		Amount:   order.Total,
+	})
+	return intent, err
+}
+
+// Handle applies a verified webhook event. Events already handled are
+// ignored, so retries from the provider are harmless. When handling fails
+// the event is forgotten again and the provider's next retry gets another
+// go.
+func Handle(ctx context.Context, provider Provider, event Event) error {
+	fresh, err := database.RecordPaymentEvent(ctx, provider.Name(), event.ID, event.Type)
+	if err != nil || !fresh {
+		return err
+	}
+
+	if err := handle(ctx, provider, event); err != nil {
+		if forgetErr := database.ForgetPaymentEvent(ctx, provider.Name(), event.ID); forgetErr != nil {
+			log.Printf("forgetting payment event %s: %v", event.ID, forgetErr)
+		}
+		return err
+	}
+	return nil
+}
+
+func handle(ctx context.Context, provider Provider, event Event) error {
+	payment, err := database.GetPayment(ctx, provider.Name(), event.Intent.ID)
+	if errors.Is(err, database.ErrNotFound) {
+		// Not one of ours, such as a payment taken outside the shop.
+		log.Printf("ignoring %s for unknown intent %s", event.Type, event.Intent.ID)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	order, err := database.GetOrder(ctx, payment.OrderID)
+	if err != nil {
+		return err
+	}
+
+	switch event.Type {
+	case EventCapturable:
+		// The customer authorized the payment. Capture it only while the
+		// order still waits for it; an order cancelled meanwhile lets the
+		// authorization lapse.
+		payment.Status = "requires_capture"
+		if order.Status == orders.StatusPending {
+			intent, err := provider.Capture(ctx, event.Intent.ID)
+			if err != nil {
+				return err
+			}
+			payment.Status = intent.Status
+		}
+
+	case EventSucceeded:
+		payment.Status = "succeeded"
+		switch order.Status {
+		case orders.StatusPending:
+			// Only the amount the order asks for pays it. Anything else goes
+			// back to the customer and the order keeps waiting.
+			if event.Intent.Amount != order.Total.Amount || !strings.EqualFold(event.Intent.Currency, order.Total.Currency) {
+				log.Printf("payment %s of %d %s does not match order %s total of %d %s, refunding it",
+					event.Intent.ID, event.Intent.Amount, event.Intent.Currency, order.Reference, order.Total.Amount, order.Total.Currency)
+				if _, err := provider.Refund(ctx, event.Intent.ID, 0); err != nil {
+					return err
+				}
+				break
+			}
+			_, err := orders.Transition(ctx, order.ID, orders.StatusPaid, actor, "payment "+event.Intent.ID)
+			if errors.Is(err, database.ErrInsufficientStock) {
+				// The reservation lapsed and the stock was sold to someone
+				// else before the payment came in. Treat it like an order
+				// paid after it expired.
+				log.Printf("order %s paid after its stock was gone, cancelling and refunding", order.Reference)
+				if _, err := orders.Transition(ctx, order.ID, orders.StatusCancelled, actor, "out of stock when paid"); err != nil {
+					return err
+				}
+				if _, err := provider.Refund(ctx, event.Intent.ID, 0); err != nil {
+					return err
+				}
+				break
+			}
+			if err != nil {
+				return err
+			}
+		case orders.StatusCancelled:
+			// Paid too late: the order expired and its stock is gone, so
+			// the money goes back.
+			if _, err := provider.Refund(ctx, event.Intent.ID, 0); err != nil {
+				return err
+			}
+		}
+
+	case EventFailed:
+		payment.Status = "failed"
+
+	case EventRefunded:
+		payment.Status = "refunded"
+		if orders.CanTransition(order.Status, orders.StatusRefunded) {
+			if _, err := orders.Transition(ctx, order.ID, orders.StatusRefunded, actor, "refund of "+event.Intent.ID); err != nil {
+				return err
+			}
+		}
+
+	default:
+		return nil
+	}
+
+	return database.SavePayment(ctx, payment)
+}
+
+// RefundOrder gives back the whole payment of an order and moves it to
+// status: refunded, or cancelled for a paid order that never shipped.
+//
+// The status change is claimed before the provider is asked for the
+// money, so two admins cannot refund the same order twice. If the refund
+// then fails the order is left in its new status with the payment still
+// succeeded, and calling RefundOrder again finishes the job; the provider
+// refund is idempotent per intent.
+func RefundOrder(ctx context.Context, provider Provider, orderID int, status, by, note string) (database.Order, error) {
+	order, err := database.GetOrder(ctx, orderID)
+	if err != nil {
+		return order, err
+	}
+
+	payment, err := database.GetOrderPayment(ctx, orderID)
+	if errors.Is(err, database.ErrNotFound) || (err == nil && payment.Status != "succeeded") {
+		return order, ErrNotPaid
+	}
+	if err != nil {
+		return order, err
+	}
+
+	if order.Status != status {
+		if !orders.CanTransition(order.Status, status) {
+			return order, &orders.TransitionError{From: order.Status, To: status}
+		}
+		order, err = orders.Transition(ctx, orderID, status, by, note)
+		if err != nil {
+			return order, err
+		}
+	}
+
+	if _, err := provider.Refund(ctx, payment.IntentID, 0); err != nil {
+		return order, err
+	}
+	payment.Status = "refunded"
+	if err := database.SavePayment(ctx, payment); err != nil {
+		return order, err
+	}
+
+	return order, nil
+}
+
+// Captured reports whether the latest payment of an order took the money.
+func Captured(ctx context.Context, orderID int) (bool, error) {
+	payment, err := database.GetOrderPayment(ctx, orderID)
+	if errors.Is(err, database.ErrNotFound) {
+		return false, nil
+	}
+	return payment.Status == "succeeded", err
+}
@@ -0,0 +1,176 @@
+package payments
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"basicthreads/internal/database"
+	"basicthreads/internal/dbtest"
+	"basicthreads/internal/money"
+	"basicthreads/internal/orders"
+)
+
+// testProvider refunds by remembering the intents, or fails with refundErr.
+type testProvider struct {
+	refundErr error
+	refunds   []string
+}
+
+func (p *testProvider) Name() string { return "test" }
+
+func (p *testProvider) CreateIntent(ctx context.Context, request IntentRequest) (Intent, error) {
+	return Intent{ID: "pi_" + request.Reference, Status: "requires_payment_method", Amount: request.Amount, Currency: request.Currency}, nil
+}
+
+func (p *testProvider) Capture(ctx context.Context, intentID string) (Intent, error) {
+	return Intent{ID: intentID, Status: "succeeded"}, nil
+}
+
+func (p *testProvider) Refund(ctx context.Context, intentID string, amount int64) (Refund, error) {
+	if p.refundErr != nil {
+		return Refund{}, p.refundErr
+	}
+	p.refunds = append(p.refunds, intentID)
+	return Refund{ID: "re_" + intentID, IntentID: intentID, Status: "succeeded", Amount: amount}, nil
+}
+
+func (p *testProvider) ParseWebhook(payload []byte, header http.Header) (Event, error) {
+	return Event{}, ErrInvalidEvent
+}
+
+// paidOrder adds an order of two units at 10.00 in status with a payment
+// of pi_test in paymentStatus.
+func paidOrder(t *testing.T, status, paymentStatus string) int {
+	t.Helper()
+	db := dbtest.Open(t)
+	customerID := dbtest.Customer(t, db, "buyer@example.com", "secret")
+	productID, variantID := dbtest.Variant(t, db, "TEE-M", "10.00", 5)
+	orderID := dbtest.Order(t, db, customerID, productID, variantID, status, "10.00", 2)
+	err := database.SavePayment(context.Background(), database.Payment{
+		OrderID:  orderID,
+		Provider: "test",
+		IntentID: "pi_test",
+		Status:   paymentStatus,
+		Amount:   money.New(2000, "USD"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return orderID
+}
+
+func TestRefundOrderRetriesFailedRefund(t *testing.T) {
+	ctx := context.Background()
+	orderID := paidOrder(t, orders.StatusPaid, "succeeded")
+
+	failing := &testProvider{refundErr: errors.New("gateway down")}
+	if _, err := RefundOrder(ctx, failing, orderID, orders.StatusCancelled, "admin", ""); err == nil {
+		t.Fatal("RefundOrder with a failing provider succeeded")
+	}
+	order, err := database.GetOrder(ctx, orderID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if order.Status != orders.StatusCancelled {
+		t.Errorf("status after failed refund = %s, want %s", order.Status, orders.StatusCancelled)
+	}
+	if captured, err := Captured(ctx, orderID); err != nil || !captured {
+		t.Fatalf("Captured after failed refund = %v, %v, want true", captured, err)
+	}
+
+	provider := &testProvider{}
+	order, err = RefundOrder(ctx, provider, orderID, orders.StatusCancelled, "admin", "")
+	if err != nil {
+		t.Fatalf("retrying RefundOrder: %v", err)
+	}
+	if order.Status != orders.StatusCancelled {
+		t.Errorf("status after retry = %s, want %s", order.Status, orders.StatusCancelled)
+	}
+	if len(provider.refunds) != 1 || provider.refunds[0] != "pi_test" {
+		t.Errorf("refunds = %v, want [pi_test]", provider.refunds)
+	}
+	payment, err := database.GetOrderPayment(ctx, orderID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if payment.Status != "refunded" {
+		t.Errorf("payment status = %s, want refunded", payment.Status)
+	}
+
+	if _, err := RefundOrder(ctx, provider, orderID, orders.StatusCancelled, "admin", ""); !errors.Is(err, ErrNotPaid) {
+		t.Errorf("refunding twice: err = %v, want %v", err, ErrNotPaid)
+	}
+}
+
+func TestRefundOrderRejectsTransition(t *testing.T) {
+	ctx := context.Background()
+	orderID := paidOrder(t, orders.StatusShipped, "succeeded")
+
+	provider := &testProvider{}
+	_, err := RefundOrder(ctx, provider, orderID, orders.StatusCancelled, "admin", "")
+	var transitionErr *orders.TransitionError
+	if !errors.As(err, &transitionErr) {
+		t.Fatalf("err = %v, want a TransitionError", err)
+	}
+	if len(provider.refunds) != 0 {
+		t.Errorf("refunds = %v, want none", provider.refunds)
+	}
+	if captured, err := Captured(ctx, orderID); err != nil || !captured {
+		t.Errorf("Captured = %v, %v, want true", captured, err)
+	}
+}
+
+func TestHandleRefundsMismatchedPayment(t *testing.T) {
+	ctx := context.Background()
+	orderID := paidOrder(t, orders.StatusPending, "requires_capture")
+
+	event := Event{
+		ID:     "evt_short",
+		Type:   EventSucceeded,
+		Intent: Intent{ID: "pi_test", Status: "succeeded", Amount: 1500, Currency: "usd"},
+	}
+	provider := &testProvider{}
+	for i := 0; i < 2; i++ {
+		if err := Handle(ctx, provider, event); err != nil {
+			t.Fatalf("delivery %d: %v", i+1, err)
+		}
+	}
+	if len(provider.refunds) != 1 || provider.refunds[0] != "pi_test" {
+		t.Errorf("refunds = %v, want [pi_test] once", provider.refunds)
+	}
+	order, err := database.GetOrder(ctx, orderID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if order.Status != orders.StatusPending {
+		t.Errorf("status = %s, want %s", order.Status, orders.StatusPending)
+	}
+}
+
+func TestHandleForgetsFailedEvent(t *testing.T) {
+	ctx := context.Background()
+	orderID := paidOrder(t, orders.StatusCancelled, "requires_capture")
+
+	event := Event{
+		ID:     "evt_late",
+		Type:   EventSucceeded,
+		Intent: Intent{ID: "pi_test", Status: "succeeded", Amount: 2000, Currency: "usd"},
+	}
+	failing := &testProvider{refundErr: errors.New("gateway down")}
+	if err := Handle(ctx, failing, event); err == nil {
+		t.Fatal("Handle with a failing provider succeeded")
+	}
+
+	provider := &testProvider{}
+	if err := Handle(ctx, provider, event); err != nil {
+		t.Fatalf("redelivery: %v", err)
+	}
+	if len(provider.refunds) != 1 {
+		t.Errorf("refunds on redelivery = %v, want one", provider.refunds)
+	}
+	if captured, err := Captured(ctx, orderID); err != nil || !captured {
+		t.Errorf("Captured = %v, %v, want true until the refund is confirmed", captured, err)
+	}
+}
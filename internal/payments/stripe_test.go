@@ -0,0 +1,88 @@
+package payments
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+)
+
+const testSecret = "whsec_test"
+
+var testPayload = []byte(`{"id":"evt_1","type":"payment_intent.succeeded","data":{"object":{"id":"pi_1","object":"payment_intent","status":"succeeded","amount":2000,"currency":"usd","metadata":{"reference":"BT-TEST"}}}}`)
+
+func TestSign(t *testing.T) {
+	got := Sign(testSecret, "1700000000", []byte(`{"id":"evt_1"}`))
+	want := "c89214b5b5da833daed6f0b8c5bb6bd58cea9022bd80ccc78230f3942d632925"
+	if got != want {
+		t.Errorf("Sign = %s, want %s", got, want)
+	}
+}
+
+func TestParseWebhook(t *testing.T) {
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+	old := strconv.FormatInt(time.Now().Add(-webhookTolerance-time.Minute).Unix(), 10)
+	future := strconv.FormatInt(time.Now().Add(webhookTolerance+time.Minute).Unix(), 10)
+	valid := Sign(testSecret, now, testPayload)
+
+	tests := []struct {
+		name      string
+		secret    string
+		signature string
+		payload   []byte
+		err       error
+	}{
+		{"valid", testSecret, "t=" + now + ",v1=" + valid, testPayload, nil},
+		{"one of several v1", testSecret, "t=" + now + ",v1=" + Sign("whsec_old", now, testPayload) + ",v1=" + valid + ",v0=ignored", testPayload, nil},
+		{"no valid v1", testSecret, "t=" + now + ",v1=" + Sign("whsec_old", now, testPayload), testPayload, ErrInvalidSignature},
+		{"wrong secret", "whsec_other", "t=" + now + ",v1=" + valid, testPayload, ErrInvalidSignature},
+		{"empty secret", "", "t=" + now + ",v1=" + Sign("", now, testPayload), testPayload, ErrInvalidSignature},
+		{"tampered payload", testSecret, "t=" + now + ",v1=" + valid, append([]byte(" "), testPayload...), ErrInvalidSignature},
+		{"timestamp not signed", testSecret, "t=" + old + ",v1=" + valid, testPayload, ErrInvalidSignature},
+		{"too old", testSecret, "t=" + old + ",v1=" + Sign(testSecret, old, testPayload), testPayload, ErrInvalidSignature},
+		{"too far ahead", testSecret, "t=" + future + ",v1=" + Sign(testSecret, future, testPayload), testPayload, ErrInvalidSignature},
+		{"no timestamp", testSecret, "v1=" + valid, testPayload, ErrInvalidSignature},
+		{"no signature", testSecret, "t=" + now, testPayload, ErrInvalidSignature},
+		{"no header", testSecret, "", testPayload, ErrInvalidSignature},
+		{"not an event", testSecret, "t=" + now + ",v1=" + Sign(testSecret, now, []byte(`{}`)), []byte(`{}`), ErrInvalidEvent},
+	}
+	for _, test := range tests {
+		stripe := NewStripe("http://localhost", "sk_test", test.secret)
+		header := http.Header{}
+		if test.signature != "" {
+			header.Set("Stripe-Signature", test.signature)
+		}
+		event, err := stripe.ParseWebhook(test.payload, header)
+		if !errors.Is(err, test.err) {
+			t.Errorf("%s: err = %v, want %v", test.name, err, test.err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		want := Event{
+			ID:     "evt_1",
+			Type:   EventSucceeded,
+			Intent: Intent{ID: "pi_1", Status: "succeeded", Amount: 2000, Currency: "usd", Reference: "BT-TEST"},
+		}
+		if event != want {
+			t.Errorf("%s: event = %+v, want %+v", test.name, event, want)
+		}
+	}
+}
+
+func TestParseWebhookCharge(t *testing.T) {
+	payload := []byte(`{"id":"evt_2","type":"charge.refunded","data":{"object":{"id":"ch_1","object":"charge","status":"succeeded","amount":2000,"currency":"usd","payment_intent":"pi_1"}}}`)
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+	header := http.Header{}
+	header.Set("Stripe-Signature", "t="+now+",v1="+Sign(testSecret, now, payload))
+
+	event, err := NewStripe("http://localhost", "sk_test", testSecret).ParseWebhook(payload, header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if event.Type != EventRefunded || event.Intent.ID != "pi_1" {
+		t.Errorf("event = %+v, want %s of pi_1", event, EventRefunded)
+	}
+}
@@ -0,0 +1,145 @@
+package products
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+
+	"basicthreads/internal/categories"
+	"basicthreads/internal/database"
+	"basicthreads/internal/money"
+)
+
+const (
+	DefaultLimit = 20
+	MaxLimit     = 100
+)
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+type ListParams struct {
+	// CategoryID limits the listing to a category and its descendants.
+	CategoryID int
+	MinPrice   *money.Money
+	MaxPrice   *money.Money
+	Available  *bool
+	Sizes      []string
+	Colors     []string
+	Sort       string
+	Cursor     string
+	// Offset pages by position instead of by cursor when Cursor is empty.
+	Offset int
+	Limit  int
+}
+
+type Page struct {
+	Items      []database.Product `json:"items"`
+	NextCursor string             `json:"next_cursor,omitempty"`
+	NextOffset *int               `json:"next_offset,omitempty"`
+	Total      int                `json:"total"`
+}
+
+// cursor is what an opaque next_cursor decodes to. The sort is included so
+// a cursor cannot be replayed against a listing ordered differently.
+type cursor struct {
+	Sort  string `json:"s"`
+	ID    int    `json:"i"`
+	Price string `json:"p,omitempty"`
+	Name  string `json:"n,omitempty"`
+}
+
+func encodeCursor(sort string, product database.Product) string {
+	body, _ := json.Marshal(cursor{
+		Sort:  sort,
+		ID:    product.ID,
+		Price: product.Price.String(),
+		Name:  product.Name,
+	})
+	return base64.RawURLEncoding.EncodeToString(body)
+}
+
+func decodeCursor(sort, value string) (*database.ProductKey, error) {
+	body, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	var decoded cursor
+	if err := json.Unmarshal(body, &decoded); err != nil || decoded.Sort != sort || decoded.ID <= 0 {
+		return nil, ErrInvalidCursor
+	}
+	price := money.Zero(database.Currency())
+	if decoded.Price != "" {
+		if price, err = money.Parse(decoded.Price, database.Currency()); err != nil {
+			return nil, ErrInvalidCursor
+		}
+	}
+	return &database.ProductKey{ID: decoded.ID, Price: price, Name: decoded.Name}, nil
+}
+
+func ValidSort(sort string) bool {
+	switch sort {
+	case database.SortNewest, database.SortPriceAsc, database.SortPriceDesc, database.SortNameAsc, database.SortNameDesc:
+		return true
+	}
+	return false
+}
+
+// List returns one page of the public catalog.
+func List(ctx context.Context, params ListParams) (Page, error) {
+	if params.Sort == "" {
+		params.Sort = database.SortNewest
+	}
+	if params.Limit <= 0 {
+		params.Limit = DefaultLimit
+	}
+	if params.Limit > MaxLimit {
+		params.Limit = MaxLimit
+	}
+
+	filter := database.ProductFilter{
+		MinPrice:  params.MinPrice,
+		MaxPrice:  params.MaxPrice,
+		Available: params.Available,
+		Sizes:     params.Sizes,
+		Colors:    params.Colors,
+		Sort:      params.Sort,
+		Limit:     params.Limit,
+	}
+
+	if params.Cursor != "" {
+		after, err := decodeCursor(params.Sort, params.Cursor)
+		if err != nil {
+			return Page{}, err
+		}
+		filter.After = after
+	} else {
+		filter.Offset = params.Offset
+	}
+
+	if params.CategoryID != 0 {
+		all, err := database.GetAllCategories(ctx)
+		if err != nil {
+			return Page{}, err
+		}
+		filter.CategoryIDs = categories.Descendants(all, params.CategoryID)
+		if filter.CategoryIDs == nil {
+			filter.CategoryIDs = []int{}
+		}
+	}
+
+	items, more, total, err := database.ListProducts(ctx, filter)
+	if err != nil {
+		return Page{}, err
+	}
+
+	page := Page{Items: items, Total: total}
+	if more {
+		page.NextCursor = encodeCursor(params.Sort, items[len(items)-1])
+		if params.Cursor == "" {
+			next := params.Offset + len(items)
+			page.NextOffset = &next
+		}
+	}
+	return page, nil
+}
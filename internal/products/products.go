@@ -0,0 +1,273 @@
+package products
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"basicthreads/internal/database"
+	"basicthreads/internal/money"
+)
+
+var (
+	ErrNotFound        = database.ErrNotFound
+	ErrVersionConflict = database.ErrVersionConflict
+	ErrNotDeleted      = errors.New("product is not deleted")
+)
+
+// DefaultTaxClass is the tax class of products that do not say otherwise.
+const DefaultTaxClass = "standard"
+
+var taxClassPattern = regexp.MustCompile(`^[a-z0-9_-]{1,32}$`)
+
+// ValidTaxClass reports whether class can name a tax class, such as
+// "standard", "reduced" or "zero".
+func ValidTaxClass(class string) bool {
+	return taxClassPattern.MatchString(class)
+}
+
+// ValidationError lists every problem found in a product, keyed by field.
+type ValidationError map[string]string
+
+func (v ValidationError) Error() string {
+	problems := make([]string, 0, len(v))
+	for field, problem := range v {
+		problems = append(problems, field+": "+problem)
+	}
+	return strings.Join(problems, "; ")
+}
+
+type Input struct {
+	Name  string
+	Price money.Money
+	// TaxClass is optional: "" keeps a product's current class and gives
+	// new products DefaultTaxClass.
+	TaxClass string
+	// Weight is the shipping weight in grams. nil keeps a product's
+	// current weight and gives new products none.
+	Weight      *int
+	Description string
+	Image       string
+	// Available is optional: nil keeps a product's current availability
+	// and makes new products available.
+	Available  *bool
+	Categories []int
+}
+
+// PriceProblem says what is wrong with a catalog price, or returns "" when
+// it is fine. Prices must be positive, in the catalog currency and fit the
+// price columns.
+func PriceProblem(price money.Money) string {
+	switch {
+	case price.Currency != database.Currency():
+		return "must be in " + database.Currency()
+	case price.Amount <= 0:
+		return "must be greater than zero"
+	case price.Amount >= int64(1e8*math.Pow10(money.Exponent(price.Currency))):
+		return "is too large"
+	}
+	return ""
+}
+
+func (in *Input) normalize() {
+	in.Name = strings.TrimSpace(in.Name)
+	in.TaxClass = strings.ToLower(strings.TrimSpace(in.TaxClass))
+	in.Description = strings.TrimSpace(in.Description)
+	in.Image = strings.TrimSpace(in.Image)
+	in.Categories = dedupe(in.Categories)
+}
+
+func (in Input) validate(ctx context.Context) error {
+	problems := ValidationError{}
+
+	if in.Name == "" {
+		problems["name"] = "is required"
+	} else if len(in.Name) > 255 {
+		problems["name"] = "must be at most 255 characters"
+	}
+
+	if problem := PriceProblem(in.Price); problem != "" {
+		problems["price"] = problem
+	}
+
+	if in.TaxClass != "" && !ValidTaxClass(in.TaxClass) {
+		problems["tax_class"] = "must be 1 to 32 lowercase letters, digits, dashes or underscores"
+	}
+
+	if in.Weight != nil && (*in.Weight < 0 || *in.Weight > 1000000) {
+		problems["weight"] = "must be between 0 and 1000000 grams"
+	}
+
+	if in.Image != "" && !validImage(in.Image) {
+		problems["image"] = "must be an http(s) URL or an absolute path"
+	}
+
+	if err := validateCategories(ctx, in.Categories, problems); err != nil {
+		return err
+	}
+
+	if len(problems) > 0 {
+		return problems
+	}
+	return nil
+}
+
+func validImage(image string) bool {
+	if strings.HasPrefix(image, "/") {
+		return true
+	}
+	parsed, err := url.Parse(image)
+	return err == nil && (parsed.Scheme == "http" || parsed.Scheme == "https") && parsed.Host != ""
+}
+
+func validateCategories(ctx context.Context, ids []int, problems ValidationError) error {
+	if len(ids) == 0 {
+		return nil
+	}
+
+	all, err := database.GetAllCategories(ctx)
+	if err != nil {
+		return err
+	}
+	known := make(map[int]bool, len(all))
+	for _, category := range all {
+		known[category.ID] = true
+	}
+
+	for _, id := range ids {
+		if !known[id] {
+			problems["categories"] = fmt.Sprintf("category %d does not exist", id)
+			break
+		}
+	}
+	return nil
+}
+
+// dedupe drops repeated ids, keeping nil as nil so callers can tell "not
+// given" from "none".
+func dedupe(ids []int) []int {
+	if ids == nil {
+		return nil
+	}
+	seen := map[int]bool{}
+	unique := []int{}
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			unique = append(unique, id)
+		}
+	}
+	return unique
+}
+
+func Get(ctx context.Context, id int) (database.AdminProduct, error) {
+	return database.GetAdminProduct(ctx, id)
+}
+
+func Create(ctx context.Context, actor string, in Input) (database.AdminProduct, error) {
+	in.normalize()
+	if err := in.validate(ctx); err != nil {
+		return database.AdminProduct{}, err
+	}
+
+	if in.TaxClass == "" {
+		in.TaxClass = DefaultTaxClass
+	}
+	weight := 0
+	if in.Weight != nil {
+		weight = *in.Weight
+	}
+
+	return database.CreateProduct(ctx, actor, database.AdminProduct{
+		Name:        in.Name,
+		Price:       in.Price,
+		TaxClass:    in.TaxClass,
+		Weight:      weight,
+		Description: in.Description,
+		Image:       in.Image,
+		Available:   in.Available == nil || *in.Available,
+		Categories:  in.Categories,
+	})
+}
+
+// Update replaces the editable fields of a product that is still at
+// version. Categories are only replaced when in.Categories is not nil.
+func Update(ctx context.Context, actor string, id, version int, in Input) (database.AdminProduct, error) {
+	in.normalize()
+	if in.Categories == nil {
+		current, err := database.GetAdminProduct(ctx, id)
+		if err != nil {
+			return current, err
+		}
+		in.Categories = current.Categories
+	}
+	if err := in.validate(ctx); err != nil {
+		return database.AdminProduct{}, err
+	}
+
+	return database.ChangeProduct(ctx, id, version, actor, "update", func(product *database.AdminProduct) error {
+		product.Name = in.Name
+		product.Price = in.Price
+		if in.TaxClass != "" {
+			product.TaxClass = in.TaxClass
+		}
+		if in.Weight != nil {
+			product.Weight = *in.Weight
+		}
+		product.Description = in.Description
+		product.Image = in.Image
+		if in.Available != nil {
+			product.Available = *in.Available
+		}
+		product.Categories = in.Categories
+		return nil
+	})
+}
+
+func SetCategories(ctx context.Context, actor string, id, version int, categories []int) (database.AdminProduct, error) {
+	categories = dedupe(categories)
+	problems := ValidationError{}
+	if err := validateCategories(ctx, categories, problems); err != nil {
+		return database.AdminProduct{}, err
+	}
+	if len(problems) > 0 {
+		return database.AdminProduct{}, problems
+	}
+
+	return database.ChangeProduct(ctx, id, version, actor, "categories", func(product *database.AdminProduct) error {
+		product.Categories = categories
+		return nil
+	})
+}
+
+// Delete hides a product from the catalog. The row and its category links
+// are kept so the product can be restored.
+func Delete(ctx context.Context, actor string, id, version int) (database.AdminProduct, error) {
+	return database.ChangeProduct(ctx, id, version, actor, "delete", func(product *database.AdminProduct) error {
+		now := time.Now()
+		product.DeletedAt = &now
+		return nil
+	})
+}
+
+func Restore(ctx context.Context, actor string, id, version int) (database.AdminProduct, error) {
+	return database.ChangeProduct(ctx, id, version, actor, "restore", func(product *database.AdminProduct) error {
+		if product.DeletedAt == nil {
+			return ErrNotDeleted
+		}
+		product.DeletedAt = nil
+		return nil
+	})
+}
+
+func Audit(ctx context.Context, id int) ([]database.AuditEntry, error) {
+	if _, err := database.GetAdminProduct(ctx, id); err != nil {
+		return nil, err
+	}
+	return database.GetProductAudit(ctx, id)
+}
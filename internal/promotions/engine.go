@@ -0,0 +1,257 @@
+package promotions
+
+import (
+	"context"
+	"math/big"
+	"slices"
+	"sort"
+	"time"
+
+	"basicthreads/internal/categories"
+	"basicthreads/internal/database"
+	"basicthreads/internal/money"
+)
+
+// Line is a cart line as the promotion engine sees it.
+type Line struct {
+	ProductID int
+	UnitPrice money.Money
+	Quantity  int
+	// categories holds the categories of the product and their ancestors,
+	// so a promotion on "Hombre" also covers "Hombre > Camisas".
+	categories []int
+}
+
+// Applied is one promotion taken off a cart.
+type Applied struct {
+	PromotionID int
+	Code        string `json:",omitempty"`
+	Name        string
+	Amount      money.Money
+}
+
+type Result struct {
+	Applied []Applied
+	// Lines has the discount of each line, in the order the lines came in.
+	Lines    []money.Money
+	Discount money.Money
+}
+
+// Price works out the discounts of a cart. customerID is 0 for guests and
+// code is the discount code entered on the cart, if any.
+//
+// Promotions stack in a fixed order: highest priority first, then oldest
+// first. Each one is worked out on what the earlier ones left of every
+// line, so the discounts can never add up to more than the cart. An
+// exclusive promotion applies alone: it is skipped when another promotion
+// came first, and nothing else is applied after it.
+func Price(ctx context.Context, customerID int, code string, subtotal money.Money, lines []Line) (Result, error) {
+	result := emptyResult(subtotal.Currency, len(lines))
+	if len(lines) == 0 {
+		return result, nil
+	}
+
+	candidates, err := database.GetActivePromotions(ctx, NormalizeCode(code))
+	if err != nil {
+		return result, err
+	}
+
+	uses := map[int]int{}
+	if customerID != 0 {
+		if uses, err = database.GetCustomerRedemptions(ctx, customerID); err != nil {
+			return result, err
+		}
+	}
+
+	now := time.Now()
+	usable := candidates[:0]
+	needsCategories := false
+	for _, promotion := range candidates {
+		if inWindow(promotion, now) && withinLimits(promotion, customerID, uses) {
+			usable = append(usable, promotion)
+			needsCategories = needsCategories || promotion.Scope == ScopeCategory
+		}
+	}
+	if len(usable) == 0 {
+		return result, nil
+	}
+
+	if needsCategories {
+		if err := addCategories(ctx, lines); err != nil {
+			return result, err
+		}
+	}
+	return apply(usable, lines, subtotal), nil
+}
+
+func emptyResult(currency string, lines int) Result {
+	result := Result{
+		Applied:  []Applied{},
+		Lines:    make([]money.Money, lines),
+		Discount: money.Zero(currency),
+	}
+	for i := range result.Lines {
+		result.Lines[i] = money.Zero(currency)
+	}
+	return result
+}
+
+func addCategories(ctx context.Context, lines []Line) error {
+	ids := []int{}
+	for _, line := range lines {
+		ids = append(ids, line.ProductID)
+	}
+	links, err := database.GetCategoryIDsOf(ctx, ids)
+	if err != nil {
+		return err
+	}
+	all, err := database.GetAllCategories(ctx)
+	if err != nil {
+		return err
+	}
+
+	for i, line := range lines {
+		lines[i].categories = nil
+		for _, id := range links[line.ProductID] {
+			for _, category := range categories.Path(all, id) {
+				lines[i].categories = append(lines[i].categories, category.ID)
+			}
+		}
+	}
+	return nil
+}
+
+func apply(candidates []database.Promotion, lines []Line, subtotal money.Money) Result {
+	currency := subtotal.Currency
+	result := emptyResult(currency, len(lines))
+
+	remaining := make([]money.Money, len(lines))
+	for i, line := range lines {
+		remaining[i] = line.UnitPrice.Times(line.Quantity)
+	}
+
+	ordered := slices.Clone(candidates)
+	sort.SliceStable(ordered, func(i, j int) bool {
+		if ordered[i].Priority != ordered[j].Priority {
+			return ordered[i].Priority > ordered[j].Priority
+		}
+		return ordered[i].ID < ordered[j].ID
+	})
+
+	for _, promotion := range ordered {
+		if promotion.Exclusive && len(result.Applied) > 0 {
+			continue
+		}
+		if !sameCurrency(promotion, currency) {
+			continue
+		}
+		if promotion.MinSubtotal != nil && subtotal.Cmp(*promotion.MinSubtotal) < 0 {
+			continue
+		}
+
+		shares := discount(promotion, lines, remaining)
+		amount := money.Sum(currency, shares...)
+		if amount.IsZero() {
+			continue
+		}
+
+		for i, share := range shares {
+			remaining[i] = remaining[i].Sub(share)
+			result.Lines[i] = result.Lines[i].Add(share)
+		}
+		result.Discount = result.Discount.Add(amount)
+		result.Applied = append(result.Applied, Applied{
+			PromotionID: promotion.ID,
+			Code:        promotion.Code,
+			Name:        promotion.Name,
+			Amount:      amount,
+		})
+
+		if promotion.Exclusive {
+			break
+		}
+	}
+
+	return result
+}
+
+// sameCurrency skips promotions written for another shop currency.
+func sameCurrency(promotion database.Promotion, currency string) bool {
+	if promotion.Amount != nil && promotion.Amount.Currency != currency {
+		return false
+	}
+	return promotion.MinSubtotal == nil || promotion.MinSubtotal.Currency == currency
+}
+
+func covers(promotion database.Promotion, line Line) bool {
+	switch promotion.Scope {
+	case ScopeCategory:
+		return slices.Contains(line.categories, promotion.TargetID)
+	case ScopeProduct:
+		return line.ProductID == promotion.TargetID
+	}
+	return true
+}
+
+// discount returns what promotion takes off each line, given what is left
+// of the lines.
+func discount(promotion database.Promotion, lines []Line, remaining []money.Money) []money.Money {
+	currency := remaining[0].Currency
+	weights := make([]int64, len(lines))
+	base := money.Zero(currency)
+	for i, line := range lines {
+		if covers(promotion, line) && remaining[i].Amount > 0 {
+			weights[i] = remaining[i].Amount
+			base = base.Add(remaining[i])
+		}
+	}
+	if base.IsZero() {
+		return money.Zero(currency).Allocate(weights)
+	}
+
+	switch promotion.Kind {
+	case KindPercentage:
+		return base.Mul(big.NewRat(int64(promotion.Percent), 100)).Allocate(weights)
+	case KindFixed:
+		return promotion.Amount.Min(base).Allocate(weights)
+	case KindBuyXGetY:
+		return freeUnits(promotion, lines, remaining, weights)
+	}
+	return money.Zero(currency).Allocate(weights)
+}
+
+// freeUnits lines up every covered unit from the most to the least
+// expensive and, in each full group of buy + get units, discounts the get
+// cheapest ones.
+func freeUnits(promotion database.Promotion, lines []Line, remaining []money.Money, weights []int64) []money.Money {
+	type unit struct {
+		line  int
+		price money.Money
+	}
+	units := []unit{}
+	for i, line := range lines {
+		if weights[i] == 0 {
+			continue
+		}
+		for n := 0; n < line.Quantity; n++ {
+			units = append(units, unit{line: i, price: line.UnitPrice})
+		}
+	}
+	sort.SliceStable(units, func(i, j int) bool {
+		return units[i].price.Amount > units[j].price.Amount
+	})
+
+	shares := money.Zero(remaining[0].Currency).Allocate(weights)
+	off := big.NewRat(int64(promotion.Percent), 100)
+	group := promotion.BuyQuantity + promotion.GetQuantity
+	for start := 0; start+group <= len(units); start += group {
+		for _, u := range units[start+promotion.BuyQuantity : start+group] {
+			shares[u.line] = shares[u.line].Add(u.price.Mul(off))
+		}
+	}
+
+	for i := range shares {
+		shares[i] = shares[i].Min(remaining[i])
+	}
+	return shares
+}
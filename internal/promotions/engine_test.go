@@ -0,0 +1,194 @@
+package promotions
+
+import (
+	"reflect"
+	"testing"
+
+	"basicthreads/internal/database"
+	"basicthreads/internal/money"
+)
+
+func usd(amount int64) *money.Money {
+	m := money.New(amount, "USD")
+	return &m
+}
+
+// cart has two shirts at 10.00 and a pair of socks at 5.00, the socks in
+// category 7.
+func cart() []Line {
+	return []Line{
+		{ProductID: 1, UnitPrice: money.New(1000, "USD"), Quantity: 2, categories: []int{3}},
+		{ProductID: 2, UnitPrice: money.New(500, "USD"), Quantity: 1, categories: []int{3, 7}},
+	}
+}
+
+func TestApply(t *testing.T) {
+	tests := []struct {
+		name       string
+		promotions []database.Promotion
+		// applied lists the ids of the promotions taken, in order.
+		applied  []int
+		lines    []int64
+		discount int64
+	}{
+		{
+			name:       "percentage",
+			promotions: []database.Promotion{{ID: 1, Kind: KindPercentage, Percent: 10, Scope: ScopeOrder}},
+			applied:    []int{1},
+			lines:      []int64{200, 50},
+			discount:   250,
+		},
+		{
+			name: "stacking works on what the higher priority left",
+			promotions: []database.Promotion{
+				{ID: 1, Kind: KindFixed, Amount: usd(500), Scope: ScopeOrder},
+				{ID: 2, Kind: KindPercentage, Percent: 10, Scope: ScopeOrder, Priority: 1},
+			},
+			applied:  []int{2, 1},
+			lines:    []int64{600, 150},
+			discount: 750,
+		},
+		{
+			name: "stacking in the other order",
+			promotions: []database.Promotion{
+				{ID: 1, Kind: KindFixed, Amount: usd(500), Scope: ScopeOrder, Priority: 1},
+				{ID: 2, Kind: KindPercentage, Percent: 10, Scope: ScopeOrder},
+			},
+			applied:  []int{1, 2},
+			lines:    []int64{560, 140},
+			discount: 700,
+		},
+		{
+			name: "same priority goes oldest first",
+			promotions: []database.Promotion{
+				{ID: 2, Kind: KindPercentage, Percent: 10, Scope: ScopeOrder},
+				{ID: 1, Kind: KindFixed, Amount: usd(500), Scope: ScopeOrder},
+			},
+			applied:  []int{1, 2},
+			lines:    []int64{560, 140},
+			discount: 700,
+		},
+		{
+			name: "exclusive skipped after another promotion",
+			promotions: []database.Promotion{
+				{ID: 1, Kind: KindPercentage, Percent: 10, Scope: ScopeOrder, Priority: 1},
+				{ID: 2, Kind: KindFixed, Amount: usd(500), Scope: ScopeOrder, Exclusive: true},
+			},
+			applied:  []int{1},
+			lines:    []int64{200, 50},
+			discount: 250,
+		},
+		{
+			name: "exclusive first stops the others",
+			promotions: []database.Promotion{
+				{ID: 1, Kind: KindFixed, Amount: usd(500), Scope: ScopeOrder, Priority: 1, Exclusive: true},
+				{ID: 2, Kind: KindPercentage, Percent: 10, Scope: ScopeOrder},
+			},
+			applied:  []int{1},
+			lines:    []int64{400, 100},
+			discount: 500,
+		},
+		{
+			name: "stacked discounts never pass the cart",
+			promotions: []database.Promotion{
+				{ID: 1, Kind: KindFixed, Amount: usd(2000), Scope: ScopeOrder, Priority: 1},
+				{ID: 2, Kind: KindFixed, Amount: usd(2000), Scope: ScopeOrder},
+			},
+			applied:  []int{1, 2},
+			lines:    []int64{2000, 500},
+			discount: 2500,
+		},
+		{
+			name:       "minimum subtotal not reached",
+			promotions: []database.Promotion{{ID: 1, Kind: KindPercentage, Percent: 10, Scope: ScopeOrder, MinSubtotal: usd(3000)}},
+			applied:    []int{},
+			lines:      []int64{0, 0},
+		},
+		{
+			name:       "other currency",
+			promotions: []database.Promotion{{ID: 1, Kind: KindFixed, Amount: &money.Money{Amount: 500, Currency: "EUR"}, Scope: ScopeOrder}},
+			applied:    []int{},
+			lines:      []int64{0, 0},
+		},
+		{
+			name:       "product scope",
+			promotions: []database.Promotion{{ID: 1, Kind: KindPercentage, Percent: 10, Scope: ScopeProduct, TargetID: 2}},
+			applied:    []int{1},
+			lines:      []int64{0, 50},
+			discount:   50,
+		},
+		{
+			name:       "category scope",
+			promotions: []database.Promotion{{ID: 1, Kind: KindFixed, Amount: usd(300), Scope: ScopeCategory, TargetID: 7}},
+			applied:    []int{1},
+			lines:      []int64{0, 300},
+			discount:   300,
+		},
+		{
+			name:       "buy 2 get the cheapest free",
+			promotions: []database.Promotion{{ID: 1, Kind: KindBuyXGetY, Percent: 100, BuyQuantity: 2, GetQuantity: 1, Scope: ScopeOrder}},
+			applied:    []int{1},
+			lines:      []int64{0, 500},
+			discount:   500,
+		},
+		{
+			name:       "buy 1 get 1 half off, only full groups",
+			promotions: []database.Promotion{{ID: 1, Kind: KindBuyXGetY, Percent: 50, BuyQuantity: 1, GetQuantity: 1, Scope: ScopeOrder}},
+			applied:    []int{1},
+			lines:      []int64{500, 0},
+			discount:   500,
+		},
+		{
+			name:       "buy X get Y without enough units",
+			promotions: []database.Promotion{{ID: 1, Kind: KindBuyXGetY, Percent: 100, BuyQuantity: 3, GetQuantity: 1, Scope: ScopeOrder}},
+			applied:    []int{},
+			lines:      []int64{0, 0},
+		},
+		{
+			name:       "buy X get Y on one product",
+			promotions: []database.Promotion{{ID: 1, Kind: KindBuyXGetY, Percent: 100, BuyQuantity: 1, GetQuantity: 1, Scope: ScopeProduct, TargetID: 1}},
+			applied:    []int{1},
+			lines:      []int64{1000, 0},
+			discount:   1000,
+		},
+		{
+			name: "free units only take what is left of a line",
+			promotions: []database.Promotion{
+				{ID: 1, Kind: KindPercentage, Percent: 50, Scope: ScopeOrder, Priority: 1},
+				{ID: 2, Kind: KindBuyXGetY, Percent: 100, BuyQuantity: 2, GetQuantity: 1, Scope: ScopeOrder},
+			},
+			applied:  []int{1, 2},
+			lines:    []int64{1000, 500},
+			discount: 1500,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			result := apply(test.promotions, cart(), money.New(2500, "USD"))
+
+			applied := []int{}
+			var sum int64
+			for _, promotion := range result.Applied {
+				applied = append(applied, promotion.PromotionID)
+				sum += promotion.Amount.Amount
+			}
+			if !reflect.DeepEqual(applied, test.applied) {
+				t.Errorf("applied %v, want %v", applied, test.applied)
+			}
+			lines := []int64{}
+			for _, line := range result.Lines {
+				lines = append(lines, line.Amount)
+			}
+			if !reflect.DeepEqual(lines, test.lines) {
+				t.Errorf("line discounts %v, want %v", lines, test.lines)
+			}
+			if result.Discount.Amount != test.discount {
+				t.Errorf("discount %d, want %d", result.Discount.Amount, test.discount)
+			}
+			if sum != result.Discount.Amount {
+				t.Errorf("applied promotions add up to %d, discount is %d", sum, result.Discount.Amount)
+			}
+		})
+	}
+}
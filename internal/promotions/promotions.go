@@ -0,0 +1,285 @@
+package promotions
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"strings"
+	"time"
+
+	"basicthreads/internal/categories"
+	"basicthreads/internal/database"
+	"basicthreads/internal/money"
+	"basicthreads/internal/products"
+)
+
+const (
+	KindPercentage = "percentage"
+	KindFixed      = "fixed"
+	KindBuyXGetY   = "buy_x_get_y"
+)
+
+const (
+	ScopeOrder    = "order"
+	ScopeCategory = "category"
+	ScopeProduct  = "product"
+)
+
+var (
+	ErrNotFound      = database.ErrNotFound
+	ErrDuplicate     = errors.New("another promotion already uses this code")
+	ErrCodeNotFound  = errors.New("discount code not found")
+	ErrCodeInactive  = errors.New("discount code is not valid at this time")
+	ErrCodeUsedUp    = errors.New("discount code has been used up")
+	ErrTargetMissing = errors.New("the category or product of the promotion does not exist")
+)
+
+var codePattern = regexp.MustCompile(`^[A-Z0-9_-]{3,64}$`)
+
+// Input describes a promotion. See database.Promotion for the meaning of
+// each field.
+type Input struct {
+	Name          string
+	Code          string
+	Kind          string
+	Percent       int
+	Amount        *money.Money
+	Scope         string
+	TargetID      int
+	MinSubtotal   *money.Money
+	BuyQuantity   int
+	GetQuantity   int
+	Priority      int
+	Exclusive     bool
+	UsageLimit    *int
+	CustomerLimit *int
+	StartsAt      *time.Time
+	EndsAt        *time.Time
+	Active        bool
+}
+
+// NormalizeCode is how codes are stored and compared: trimmed and upper
+// case, so "summer10" and "SUMMER10 " are the same code.
+func NormalizeCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+func (in *Input) normalize() {
+	in.Name = strings.TrimSpace(in.Name)
+	in.Code = NormalizeCode(in.Code)
+	if in.Scope == "" {
+		in.Scope = ScopeOrder
+	}
+	if in.Scope == ScopeOrder {
+		in.TargetID = 0
+	}
+	if in.Kind == KindBuyXGetY && in.Percent == 0 {
+		in.Percent = 100
+	}
+}
+
+func (in Input) validate() error {
+	problems := products.ValidationError{}
+
+	if in.Name == "" {
+		problems["name"] = "is required"
+	} else if len(in.Name) > 255 {
+		problems["name"] = "must be at most 255 characters"
+	}
+	if in.Code != "" && !codePattern.MatchString(in.Code) {
+		problems["code"] = "must be 3 to 64 letters, digits, dashes or underscores"
+	}
+
+	switch in.Kind {
+	case KindPercentage:
+		if in.Percent < 1 || in.Percent > 100 {
+			problems["percent"] = "must be between 1 and 100"
+		}
+	case KindFixed:
+		if in.Amount == nil {
+			problems["amount"] = "is required"
+		} else if problem := products.PriceProblem(*in.Amount); problem != "" {
+			problems["amount"] = problem
+		}
+	case KindBuyXGetY:
+		if in.BuyQuantity < 1 || in.BuyQuantity > 99 {
+			problems["buy"] = "must be between 1 and 99"
+		}
+		if in.GetQuantity < 1 || in.GetQuantity > 99 {
+			problems["get"] = "must be between 1 and 99"
+		}
+		if in.Percent < 1 || in.Percent > 100 {
+			problems["percent"] = "must be between 1 and 100"
+		}
+	default:
+		problems["kind"] = "must be one of percentage, fixed, buy_x_get_y"
+	}
+
+	switch in.Scope {
+	case ScopeOrder:
+	case ScopeCategory, ScopeProduct:
+		if in.TargetID < 1 {
+			problems["target"] = "is required for " + in.Scope + " promotions"
+		}
+	default:
+		problems["scope"] = "must be one of order, category, product"
+	}
+
+	if in.MinSubtotal != nil {
+		if in.MinSubtotal.Currency != database.Currency() {
+			problems["min_subtotal"] = "must be in " + database.Currency()
+		} else if in.MinSubtotal.IsNegative() {
+			problems["min_subtotal"] = "cannot be negative"
+		}
+	}
+	if in.UsageLimit != nil && *in.UsageLimit < 1 {
+		problems["usage_limit"] = "must be at least 1"
+	}
+	if in.CustomerLimit != nil && *in.CustomerLimit < 1 {
+		problems["customer_limit"] = "must be at least 1"
+	}
+	if in.StartsAt != nil && in.EndsAt != nil && !in.EndsAt.After(*in.StartsAt) {
+		problems["ends_at"] = "must be after starts_at"
+	}
+
+	if len(problems) > 0 {
+		return problems
+	}
+	return nil
+}
+
+func (in Input) promotion() database.Promotion {
+	return database.Promotion{
+		Name:          in.Name,
+		Code:          in.Code,
+		Kind:          in.Kind,
+		Percent:       in.Percent,
+		Amount:        in.Amount,
+		Scope:         in.Scope,
+		TargetID:      in.TargetID,
+		MinSubtotal:   in.MinSubtotal,
+		BuyQuantity:   in.BuyQuantity,
+		GetQuantity:   in.GetQuantity,
+		Priority:      in.Priority,
+		Exclusive:     in.Exclusive,
+		UsageLimit:    in.UsageLimit,
+		CustomerLimit: in.CustomerLimit,
+		StartsAt:      in.StartsAt,
+		EndsAt:        in.EndsAt,
+		Active:        in.Active,
+	}
+}
+
+func checkTarget(ctx context.Context, in Input) error {
+	switch in.Scope {
+	case ScopeCategory:
+		all, err := database.GetAllCategories(ctx)
+		if err != nil {
+			return err
+		}
+		if categories.Descendants(all, in.TargetID) == nil {
+			return ErrTargetMissing
+		}
+	case ScopeProduct:
+		_, err := database.GetAdminProduct(ctx, in.TargetID)
+		if errors.Is(err, database.ErrNotFound) {
+			return ErrTargetMissing
+		}
+		return err
+	}
+	return nil
+}
+
+func List(ctx context.Context) ([]database.Promotion, error) {
+	return database.GetPromotions(ctx)
+}
+
+func Get(ctx context.Context, id int) (database.Promotion, error) {
+	return database.GetPromotion(ctx, id)
+}
+
+func Create(ctx context.Context, in Input) (database.Promotion, error) {
+	in.normalize()
+	if err := in.validate(); err != nil {
+		return database.Promotion{}, err
+	}
+	if err := checkTarget(ctx, in); err != nil {
+		return database.Promotion{}, err
+	}
+
+	promotion, err := database.CreatePromotion(ctx, in.promotion())
+	if errors.Is(err, database.ErrDuplicate) {
+		return promotion, ErrDuplicate
+	}
+	return promotion, err
+}
+
+// Update replaces a promotion. Orders that already used it keep the
+// discount they got.
+func Update(ctx context.Context, id int, in Input) (database.Promotion, error) {
+	in.normalize()
+	if err := in.validate(); err != nil {
+		return database.Promotion{}, err
+	}
+	if _, err := database.GetPromotion(ctx, id); err != nil {
+		return database.Promotion{}, err
+	}
+	if err := checkTarget(ctx, in); err != nil {
+		return database.Promotion{}, err
+	}
+
+	promotion := in.promotion()
+	promotion.ID = id
+	promotion, err := database.UpdatePromotion(ctx, promotion)
+	if errors.Is(err, database.ErrDuplicate) {
+		return promotion, ErrDuplicate
+	}
+	return promotion, err
+}
+
+// Check tells whether code can be entered on the cart of customerID, 0 for
+// guests, right now. Whether the cart meets its conditions is only known
+// when the cart is priced.
+func Check(ctx context.Context, customerID int, code string) (database.Promotion, error) {
+	promotion, err := database.GetPromotionByCode(ctx, NormalizeCode(code))
+	if errors.Is(err, database.ErrNotFound) {
+		return promotion, ErrCodeNotFound
+	}
+	if err != nil {
+		return promotion, err
+	}
+
+	if !promotion.Active || !inWindow(promotion, time.Now()) {
+		return promotion, ErrCodeInactive
+	}
+
+	uses := map[int]int{}
+	if customerID != 0 && promotion.CustomerLimit != nil {
+		if uses, err = database.GetCustomerRedemptions(ctx, customerID); err != nil {
+			return promotion, err
+		}
+	}
+	if !withinLimits(promotion, customerID, uses) {
+		return promotion, ErrCodeUsedUp
+	}
+	return promotion, nil
+}
+
+func inWindow(promotion database.Promotion, now time.Time) bool {
+	if promotion.StartsAt != nil && now.Before(*promotion.StartsAt) {
+		return false
+	}
+	return promotion.EndsAt == nil || now.Before(*promotion.EndsAt)
+}
+
+// withinLimits checks the usage limits of a promotion. Guests are only held
+// to the overall limit; their own is checked at checkout, once they log in.
+func withinLimits(promotion database.Promotion, customerID int, uses map[int]int) bool {
+	if promotion.UsageLimit != nil && promotion.Used >= *promotion.UsageLimit {
+		return false
+	}
+	if customerID != 0 && promotion.CustomerLimit != nil && uses[promotion.ID] >= *promotion.CustomerLimit {
+		return false
+	}
+	return true
+}
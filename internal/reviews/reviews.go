@@ -0,0 +1,247 @@
+package reviews
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"basicthreads/internal/config"
+	"basicthreads/internal/database"
+	"basicthreads/internal/products"
+)
+
+const (
+	DefaultLimit = 20
+	MaxLimit     = 100
+)
+
+var (
+	ErrNotFound        = database.ErrNotFound
+	ErrProductNotFound = errors.New("product not found")
+	ErrNotPurchased    = errors.New("only customers who bought the product can review it")
+)
+
+// Sorts lists the orders reviews can be listed in.
+var Sorts = []string{
+	database.ReviewSortNewest,
+	database.ReviewSortOldest,
+	database.ReviewSortRatingDesc,
+	database.ReviewSortRatingAsc,
+}
+
+// Statuses lists the moderation statuses.
+var Statuses = []string{database.ReviewPending, database.ReviewApproved, database.ReviewRejected}
+
+func ValidSort(sort string) bool {
+	for _, valid := range Sorts {
+		if sort == valid {
+			return true
+		}
+	}
+	return sort == ""
+}
+
+func ValidStatus(status string) bool {
+	for _, valid := range Statuses {
+		if status == valid {
+			return true
+		}
+	}
+	return false
+}
+
+type Page struct {
+	Items      []database.Review `json:"items"`
+	NextOffset *int              `json:"next_offset,omitempty"`
+	Total      int               `json:"total"`
+}
+
+// Input is what a customer writes in a review.
+type Input struct {
+	Rating int
+	Title  string
+	Body   string
+}
+
+func (in *Input) normalize() {
+	in.Title = strings.TrimSpace(in.Title)
+	in.Body = strings.TrimSpace(in.Body)
+}
+
+func (in Input) validate() error {
+	problems := products.ValidationError{}
+	if in.Rating < 1 || in.Rating > 5 {
+		problems["rating"] = "must be between 1 and 5"
+	}
+	if len(in.Title) > 120 {
+		problems["title"] = "must be at most 120 characters"
+	}
+	if in.Body == "" {
+		problems["body"] = "is required"
+	} else if len(in.Body) > 5000 {
+		problems["body"] = "must be at most 5000 characters"
+	}
+	if len(problems) > 0 {
+		return problems
+	}
+	return nil
+}
+
+// list returns one page of the reviews matching filter.
+func list(ctx context.Context, filter database.ReviewFilter) (Page, error) {
+	if filter.Limit == 0 {
+		filter.Limit = DefaultLimit
+	}
+	items, total, err := database.GetReviews(ctx, filter)
+	if err != nil {
+		return Page{}, err
+	}
+
+	page := Page{Items: items, Total: total}
+	if next := filter.Offset + len(items); next < total {
+		page.NextOffset = &next
+	}
+	return page, nil
+}
+
+// ListParams narrows the reviews of a product shown to shoppers.
+type ListParams struct {
+	// Rating keeps the reviews with that many stars, 0 keeps all.
+	Rating   int
+	Verified *bool
+	Sort     string
+	Offset   int
+	Limit    int
+}
+
+// ForProduct lists the approved reviews of a product.
+func ForProduct(ctx context.Context, productID int, params ListParams) (Page, error) {
+	if err := checkParams(params.Rating, params.Sort); err != nil {
+		return Page{}, err
+	}
+	page, err := list(ctx, database.ReviewFilter{
+		ProductID: productID,
+		Status:    database.ReviewApproved,
+		Rating:    params.Rating,
+		Verified:  params.Verified,
+		Sort:      params.Sort,
+		Offset:    params.Offset,
+		Limit:     params.Limit,
+	})
+	for i := range page.Items {
+		hideModeration(&page.Items[i])
+	}
+	return page, err
+}
+
+// hideModeration drops who moderated a review and why, which is for staff.
+func hideModeration(review *database.Review) {
+	review.ModeratedBy = ""
+	review.ModerationNote = ""
+	review.ModeratedAt = nil
+}
+
+// Queue lists reviews for moderators, by default the pending ones oldest
+// first, so they are handled in the order they came in.
+func Queue(ctx context.Context, status string, params ListParams) (Page, error) {
+	if status == "" {
+		status = database.ReviewPending
+	}
+	if !ValidStatus(status) {
+		return Page{}, products.ValidationError{"status": "must be pending, approved or rejected"}
+	}
+	if err := checkParams(params.Rating, params.Sort); err != nil {
+		return Page{}, err
+	}
+	if params.Sort == "" {
+		params.Sort = database.ReviewSortOldest
+	}
+	return list(ctx, database.ReviewFilter{
+		Status:   status,
+		Rating:   params.Rating,
+		Verified: params.Verified,
+		Sort:     params.Sort,
+		Offset:   params.Offset,
+		Limit:    params.Limit,
+	})
+}
+
+// Mine lists the reviews a customer wrote, whatever their status.
+func Mine(ctx context.Context, customerID int, offset, limit int) (Page, error) {
+	page, err := list(ctx, database.ReviewFilter{CustomerID: customerID, Offset: offset, Limit: limit})
+	for i := range page.Items {
+		hideModeration(&page.Items[i])
+	}
+	return page, err
+}
+
+func checkParams(rating int, sort string) error {
+	problems := products.ValidationError{}
+	if rating < 0 || rating > 5 {
+		problems["rating"] = "must be between 1 and 5"
+	}
+	if !ValidSort(sort) {
+		problems["sort"] = "must be one of " + strings.Join(Sorts, ", ")
+	}
+	if len(problems) > 0 {
+		return problems
+	}
+	return nil
+}
+
+// Write saves the review of a customer on a product, replacing the one they
+// left before. Reviews wait for moderation before they are shown. Unless
+// the shop takes reviews from anyone, only buyers of the product may write
+// one; otherwise reviews of non-buyers are flagged as unverified.
+func Write(ctx context.Context, customerID, productID int, in Input) (database.Review, error) {
+	in.normalize()
+	if err := in.validate(); err != nil {
+		return database.Review{}, err
+	}
+
+	product, err := database.GetAdminProduct(ctx, productID)
+	if errors.Is(err, database.ErrNotFound) || (err == nil && product.DeletedAt != nil) {
+		return database.Review{}, ErrProductNotFound
+	}
+	if err != nil {
+		return database.Review{}, err
+	}
+
+	verified, err := database.HasPurchased(ctx, customerID, productID)
+	if err != nil {
+		return database.Review{}, err
+	}
+	if !verified && config.Load().VerifiedReviewsOnly {
+		return database.Review{}, ErrNotPurchased
+	}
+
+	return database.SaveReview(ctx, database.Review{
+		ProductID:  productID,
+		CustomerID: customerID,
+		Rating:     in.Rating,
+		Title:      in.Title,
+		Body:       in.Body,
+		Verified:   verified,
+	})
+}
+
+func Delete(ctx context.Context, customerID, id int) error {
+	return database.DeleteReview(ctx, customerID, id)
+}
+
+// Moderate approves or rejects a review. The note says why, for the other
+// moderators.
+func Moderate(ctx context.Context, id int, status, actor, note string) (database.Review, error) {
+	note = strings.TrimSpace(note)
+	problems := products.ValidationError{}
+	if status != database.ReviewApproved && status != database.ReviewRejected {
+		problems["status"] = "must be approved or rejected"
+	}
+	if len(note) > 255 {
+		problems["note"] = "must be at most 255 characters"
+	}
+	if len(problems) > 0 {
+		return database.Review{}, problems
+	}
+	return database.ModerateReview(ctx, id, status, actor, note)
+}
@@ -0,0 +1,228 @@
+package search
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"basicthreads/internal/database"
+)
+
+// Field weights for the in-process index. A word in the product name says
+// more about the product than the same word in its description.
+const (
+	nameWeight        = 3
+	categoryWeight    = 2
+	descriptionWeight = 1
+	// prefixFactor discounts matches where the query term is only the start
+	// of the indexed word.
+	prefixFactor = 0.5
+)
+
+type Document struct {
+	Product       database.Product
+	Categories    []int
+	CategoryNames []string
+}
+
+// MemoryIndex is an inverted index held in memory. It is used by tests and
+// by small deployments that set SEARCH_BACKEND=memory.
+type MemoryIndex struct {
+	mu        sync.RWMutex
+	documents map[int]Document
+	// postings maps each word to the weight it carries in every product
+	// that contains it.
+	postings map[string]map[int]float64
+	words    []string
+	// stale wakes Watch up to reload the index.
+	stale chan struct{}
+}
+
+func NewMemoryIndex(documents []Document) *MemoryIndex {
+	index := &MemoryIndex{stale: make(chan struct{}, 1)}
+	index.Replace(documents)
+	return index
+}
+
+// LoadMemoryIndex builds an index of the current catalog.
+func LoadMemoryIndex(ctx context.Context) (*MemoryIndex, error) {
+	documents, err := loadDocuments(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return NewMemoryIndex(documents), nil
+}
+
+func loadDocuments(ctx context.Context) ([]Document, error) {
+	all, err := database.GetAllCategories(ctx)
+	if err != nil {
+		return nil, err
+	}
+	names := make(map[int]string, len(all))
+	for _, category := range all {
+		names[category.ID] = category.Name
+	}
+
+	links, err := database.GetProductCategoryIDs(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	products := database.GetProducts(ctx)
+	documents := make([]Document, 0, len(products))
+	for _, product := range products {
+		document := Document{Product: product, Categories: links[product.ID]}
+		for _, id := range document.Categories {
+			document.CategoryNames = append(document.CategoryNames, names[id])
+		}
+		documents = append(documents, document)
+	}
+	return documents, nil
+}
+
+// Reload rebuilds the index from the database.
+func (m *MemoryIndex) Reload(ctx context.Context) error {
+	documents, err := loadDocuments(ctx)
+	if err != nil {
+		return err
+	}
+	m.Replace(documents)
+	return nil
+}
+
+// Invalidate asks Watch to reload the index soon. Calls made while a
+// reload is pending are folded into it, and it never blocks.
+func (m *MemoryIndex) Invalidate() {
+	select {
+	case m.stale <- struct{}{}:
+	default:
+	}
+}
+
+// Watch keeps the index in step with the catalog until ctx is done. It
+// reloads after every Invalidate, and every interval to pick up changes
+// made some other way, such as by another instance or the seed command.
+func (m *MemoryIndex) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-m.stale:
+		}
+		if err := m.Reload(ctx); err != nil && !errors.Is(err, context.Canceled) {
+			log.Printf("reloading the search index: %v", err)
+		}
+	}
+}
+
+// Replace swaps the indexed documents for a new set.
+func (m *MemoryIndex) Replace(documents []Document) {
+	byID := make(map[int]Document, len(documents))
+	postings := map[string]map[int]float64{}
+
+	add := func(text string, id int, weight float64) {
+		for _, term := range Terms(text) {
+			if postings[term] == nil {
+				postings[term] = map[int]float64{}
+			}
+			postings[term][id] += weight
+		}
+	}
+
+	for _, document := range documents {
+		id := document.Product.ID
+		byID[id] = document
+		add(document.Product.Name, id, nameWeight)
+		add(document.Product.Description, id, descriptionWeight)
+		for _, name := range document.CategoryNames {
+			add(name, id, categoryWeight)
+		}
+	}
+
+	words := make([]string, 0, len(postings))
+	for word := range postings {
+		words = append(words, word)
+	}
+	sort.Strings(words)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.documents = byID
+	m.postings = postings
+	m.words = words
+}
+
+func (m *MemoryIndex) Search(ctx context.Context, query string) ([]Hit, error) {
+	terms := Terms(query)
+	if len(terms) == 0 {
+		return []Hit{}, nil
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var scores map[int]float64
+	for i, term := range terms {
+		termScores := m.match(term, i == len(terms)-1)
+		if scores == nil {
+			scores = termScores
+			continue
+		}
+		for id := range scores {
+			if score, ok := termScores[id]; ok {
+				scores[id] += score
+			} else {
+				delete(scores, id)
+			}
+		}
+	}
+
+	hits := make([]Hit, 0, len(scores))
+	for id, score := range scores {
+		document := m.documents[id]
+		hits = append(hits, Hit{Product: document.Product, Score: score, Categories: document.Categories})
+	}
+	sort.Slice(hits, func(i, j int) bool {
+		if hits[i].Score != hits[j].Score {
+			return hits[i].Score > hits[j].Score
+		}
+		return hits[i].Product.ID < hits[j].Product.ID
+	})
+	return hits, nil
+}
+
+// match scores every product containing term. When prefix is set, words
+// starting with term match too, at a discount.
+func (m *MemoryIndex) match(term string, prefix bool) map[int]float64 {
+	scores := map[int]float64{}
+	for id, weight := range m.postings[term] {
+		scores[id] = weight
+	}
+	if !prefix {
+		return scores
+	}
+
+	start := sort.SearchStrings(m.words, term)
+	for _, word := range m.words[start:] {
+		if !strings.HasPrefix(word, term) {
+			break
+		}
+		if word == term {
+			continue
+		}
+		for id, weight := range m.postings[word] {
+			if score := weight * prefixFactor; score > scores[id] {
+				scores[id] = score
+			}
+		}
+	}
+	return scores
+}
@@ -0,0 +1,170 @@
+package search
+
+import (
+	"context"
+	"database/sql"
+	"strconv"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
+
+	"basicthreads/internal/database"
+	"basicthreads/internal/money"
+)
+
+var tracer = otel.Tracer("basicthreads/internal/search")
+
+// maxHits caps how many matches the MySQL searcher ranks and facets.
+const maxHits = 1000
+
+// MySQLSearcher uses the FULLTEXT indexes on products and categories. The
+// columns use an accent-insensitive collation, so folded terms still match
+// accented text.
+type MySQLSearcher struct {
+	db *sql.DB
+}
+
+func NewMySQLSearcher(db *sql.DB) *MySQLSearcher {
+	return &MySQLSearcher{db: db}
+}
+
+// booleanTerm turns a folded term into a boolean mode word, as a prefix
+// when prefix is set.
+func booleanTerm(term string, prefix bool) string {
+	if prefix {
+		return term + "*"
+	}
+	return term
+}
+
+func (s *MySQLSearcher) Search(ctx context.Context, query string) ([]Hit, error) {
+	ctx, span := tracer.Start(ctx, "MySQLSearcher.Search",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(semconv.DBSystemMySQL),
+	)
+	defer span.End()
+
+	hits, err := s.search(ctx, query)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	return hits, err
+}
+
+func (s *MySQLSearcher) search(ctx context.Context, query string) ([]Hit, error) {
+	terms := Terms(query)
+	if len(terms) == 0 {
+		return []Hit{}, nil
+	}
+
+	words := make([]string, len(terms))
+	for i, term := range terms {
+		words[i] = booleanTerm(term, i == len(terms)-1)
+	}
+	all := strings.Join(words, " ")
+
+	args := []any{all, all, all}
+	where := []string{"p.deleted_at IS NULL"}
+	// Each term has to appear somewhere: in the product itself or in the
+	// name of one of its categories.
+	for _, word := range words {
+		where = append(where, `(MATCH(p.name, p.description) AGAINST (? IN BOOLEAN MODE)
+			OR EXISTS (
+				SELECT 1 FROM categories_product AS cp
+				INNER JOIN categories AS c ON c.id = cp.id_category
+				WHERE cp.id_product = p.product_id AND MATCH(c.name) AGAINST (? IN BOOLEAN MODE)
+			))`)
+		args = append(args, word, word)
+	}
+	args = append(args, maxHits)
+
+	rows, err := s.db.QueryContext(
+		ctx,
+		`SELECT p.product_id, p.name, p.price, p.description, p.img, p.available, p.rating_average, p.rating_count,
+			MATCH(p.name) AGAINST (? IN BOOLEAN MODE) * 3
+			+ MATCH(p.name, p.description) AGAINST (? IN BOOLEAN MODE)
+			+ COALESCE((
+				SELECT MAX(MATCH(c.name) AGAINST (? IN BOOLEAN MODE)) FROM categories_product AS cp
+				INNER JOIN categories AS c ON c.id = cp.id_category
+				WHERE cp.id_product = p.product_id
+			), 0) * 2 AS score,
+			COALESCE((
+				SELECT GROUP_CONCAT(cp.id_category) FROM categories_product AS cp
+				WHERE cp.id_product = p.product_id
+			), '') AS categories
+		FROM products AS p
+		WHERE `+strings.Join(where, " AND ")+`
+		ORDER BY score DESC, p.product_id
+		LIMIT ?`,
+		args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	hits := []Hit{}
+	for rows.Next() {
+		var hit Hit
+		var categories string
+		err := rows.Scan(
+			&hit.Product.ID,
+			&hit.Product.Name,
+			money.Column(&hit.Product.Price, database.Currency()),
+			&hit.Product.Description,
+			&hit.Product.Image,
+			&hit.Product.Available,
+			&hit.Product.Rating,
+			&hit.Product.ReviewCount,
+			&hit.Score,
+			&categories,
+		)
+		if err != nil {
+			return nil, err
+		}
+		hit.Categories = parseIDList(categories)
+		hits = append(hits, hit)
+	}
+	return hits, rows.Err()
+}
+
+func parseIDList(list string) []int {
+	ids := []int{}
+	for _, part := range strings.Split(list, ",") {
+		if id, err := strconv.Atoi(part); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// Rebuild asks InnoDB to merge the pending changes of the FULLTEXT indexes,
+// which keeps relevance scores accurate after many catalog edits. Setting
+// innodb_optimize_fulltext_only needs a user allowed to change globals.
+func (s *MySQLSearcher) Rebuild(ctx context.Context) error {
+	conn, err := s.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	_, err = conn.ExecContext(ctx, "SET GLOBAL innodb_optimize_fulltext_only = ON")
+	if err != nil {
+		return err
+	}
+	defer conn.ExecContext(context.Background(), "SET GLOBAL innodb_optimize_fulltext_only = OFF")
+
+	for _, table := range []string{"products", "categories"} {
+		if _, err := conn.ExecContext(ctx, "OPTIMIZE TABLE "+table); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var _ Searcher = (*MySQLSearcher)(nil)
+var _ Searcher = (*MemoryIndex)(nil)
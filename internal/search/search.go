@@ -0,0 +1,249 @@
+package search
+
+import (
+	"context"
+	"math"
+	"sort"
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/runes"
+	"golang.org/x/text/transform"
+	"golang.org/x/text/unicode/norm"
+
+	"basicthreads/internal/database"
+	"basicthreads/internal/money"
+)
+
+// Searcher finds the products matching a free text query, best match
+// first. Every term must match; the last term also matches as a prefix so
+// results follow the user while they type.
+type Searcher interface {
+	Search(ctx context.Context, query string) ([]Hit, error)
+}
+
+type Hit struct {
+	Product    database.Product
+	Score      float64
+	Categories []int
+}
+
+type Request struct {
+	Query string
+	// CategoryID and PriceBand narrow the results to one facet value.
+	CategoryID int
+	PriceBand  string
+	Limit      int
+	Offset     int
+}
+
+type Result struct {
+	database.Product
+	Score float64 `json:"score"`
+}
+
+type CategoryFacet struct {
+	ID    int    `json:"id"`
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+}
+
+type PriceBandFacet struct {
+	Label string       `json:"label"`
+	Min   money.Money  `json:"min"`
+	Max   *money.Money `json:"max,omitempty"`
+	Count int          `json:"count"`
+}
+
+type Facets struct {
+	Categories []CategoryFacet  `json:"categories"`
+	PriceBands []PriceBandFacet `json:"price_bands"`
+}
+
+type Results struct {
+	Items  []Result `json:"items"`
+	Total  int      `json:"total"`
+	Facets Facets   `json:"facets"`
+}
+
+type priceBand struct {
+	label    string
+	min, max int64
+}
+
+// priceBands are the price facets in whole units of the catalog currency,
+// each including min and excluding max. The last band has no upper bound.
+var priceBands = []priceBand{
+	{"0-25", 0, 25},
+	{"25-50", 25, 50},
+	{"50-100", 50, 100},
+	{"100+", 100, 0},
+}
+
+func ValidPriceBand(label string) bool {
+	for _, band := range priceBands {
+		if band.label == label {
+			return true
+		}
+	}
+	return false
+}
+
+func (b priceBand) bounds(currency string) (money.Money, *money.Money) {
+	unit := int64(math.Pow10(money.Exponent(currency)))
+	min := money.New(b.min*unit, currency)
+	if b.max == 0 {
+		return min, nil
+	}
+	max := money.New(b.max*unit, currency)
+	return min, &max
+}
+
+func (b priceBand) contains(price money.Money) bool {
+	min, max := b.bounds(price.Currency)
+	return price.Cmp(min) >= 0 && (max == nil || price.Cmp(*max) < 0)
+}
+
+// Run searches with searcher and builds one page of results. Facet counts
+// cover every hit of the query, before the CategoryID and PriceBand
+// filters, so the client can offer the other values of a facet.
+func Run(ctx context.Context, searcher Searcher, request Request) (Results, error) {
+	results := Results{Items: []Result{}}
+
+	if len(Terms(request.Query)) == 0 {
+		results.Facets = Facets{Categories: []CategoryFacet{}, PriceBands: []PriceBandFacet{}}
+		return results, nil
+	}
+
+	hits, err := searcher.Search(ctx, request.Query)
+	if err != nil {
+		return results, err
+	}
+
+	all, err := database.GetAllCategories(ctx)
+	if err != nil {
+		return results, err
+	}
+	results.Facets = facets(hits, all)
+
+	filtered := hits[:0:0]
+	for _, hit := range hits {
+		if request.CategoryID != 0 && !containsInt(hit.Categories, request.CategoryID) {
+			continue
+		}
+		if request.PriceBand != "" && !inBand(request.PriceBand, hit.Product.Price) {
+			continue
+		}
+		filtered = append(filtered, hit)
+	}
+
+	results.Total = len(filtered)
+	if request.Offset < len(filtered) {
+		filtered = filtered[request.Offset:]
+	} else {
+		filtered = nil
+	}
+	if request.Limit > 0 && len(filtered) > request.Limit {
+		filtered = filtered[:request.Limit]
+	}
+	for _, hit := range filtered {
+		results.Items = append(results.Items, Result{Product: hit.Product, Score: hit.Score})
+	}
+
+	return results, nil
+}
+
+func facets(hits []Hit, all []database.Category) Facets {
+	names := make(map[int]string, len(all))
+	for _, category := range all {
+		names[category.ID] = category.Name
+	}
+
+	counts := map[int]int{}
+	for _, hit := range hits {
+		for _, id := range hit.Categories {
+			counts[id]++
+		}
+	}
+
+	facets := Facets{Categories: []CategoryFacet{}, PriceBands: []PriceBandFacet{}}
+	for id, count := range counts {
+		if name, ok := names[id]; ok {
+			facets.Categories = append(facets.Categories, CategoryFacet{ID: id, Name: name, Count: count})
+		}
+	}
+	sort.Slice(facets.Categories, func(i, j int) bool {
+		a, b := facets.Categories[i], facets.Categories[j]
+		if a.Count != b.Count {
+			return a.Count > b.Count
+		}
+		return a.Name < b.Name
+	})
+
+	for _, band := range priceBands {
+		facet := PriceBandFacet{Label: band.label}
+		facet.Min, facet.Max = band.bounds(database.Currency())
+		for _, hit := range hits {
+			if band.contains(hit.Product.Price) {
+				facet.Count++
+			}
+		}
+		facets.PriceBands = append(facets.PriceBands, facet)
+	}
+
+	return facets
+}
+
+func inBand(label string, price money.Money) bool {
+	for _, band := range priceBands {
+		if band.label == label {
+			return band.contains(price)
+		}
+	}
+	return false
+}
+
+func containsInt(values []int, value int) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// stopwords are common Spanish words that would match almost every product.
+var stopwords = map[string]bool{
+	"a": true, "al": true, "con": true, "de": true, "del": true, "el": true,
+	"en": true, "la": true, "las": true, "lo": true, "los": true, "para": true,
+	"por": true, "sin": true, "su": true, "un": true, "una": true, "y": true,
+}
+
+// Fold lowercases text and strips accents, so "Camisón" and "camison"
+// compare equal.
+func Fold(text string) string {
+	folded, _, err := transform.String(
+		transform.Chain(norm.NFD, runes.Remove(runes.In(unicode.Mn)), norm.NFC),
+		text,
+	)
+	if err != nil {
+		folded = text
+	}
+	return strings.ToLower(folded)
+}
+
+// Terms splits a query or a document into folded words, dropping
+// punctuation and stopwords.
+func Terms(text string) []string {
+	words := strings.FieldsFunc(Fold(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+
+	terms := words[:0]
+	for _, word := range words {
+		if !stopwords[word] {
+			terms = append(terms, word)
+		}
+	}
+	return terms
+}
@@ -0,0 +1,152 @@
+package search
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"basicthreads/internal/database"
+	"basicthreads/internal/money"
+)
+
+func TestTerms(t *testing.T) {
+	tests := []struct {
+		text string
+		want []string
+	}{
+		{"Camisón de ALGODÓN", []string{"camison", "algodon"}},
+		{"pingüino, niño & año", []string{"pinguino", "nino", "ano"}},
+		{"talla-XL 2024", []string{"talla", "xl", "2024"}},
+		{"de la y", []string{}},
+		{"", []string{}},
+	}
+	for _, test := range tests {
+		got := Terms(test.text)
+		if len(got) == 0 && len(test.want) == 0 {
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("Terms(%q) = %q, want %q", test.text, got, test.want)
+		}
+	}
+}
+
+func testIndex() *MemoryIndex {
+	product := func(id int, name, description string, cents int64) database.Product {
+		return database.Product{ID: id, Name: name, Description: description, Price: money.New(cents, "USD")}
+	}
+	return NewMemoryIndex([]Document{
+		{Product: product(1, "Camiseta básica", "Hecha de algodón", 1000), Categories: []int{10}, CategoryNames: []string{"Camisetas"}},
+		{Product: product(2, "Pantalón chino", "Combina con cualquier camiseta", 3000), Categories: []int{20}, CategoryNames: []string{"Pantalones"}},
+		{Product: product(3, "Camisón de algodón", "Para dormir", 12000), Categories: []int{30}, CategoryNames: []string{"Pijamas"}},
+		{Product: product(4, "Gorra", "Visera curva", 2500), Categories: []int{10, 40}, CategoryNames: []string{"Camisetas", "Accesorios"}},
+	})
+}
+
+func TestMemoryIndexSearch(t *testing.T) {
+	index := testIndex()
+
+	tests := []struct {
+		query string
+		want  []int
+	}{
+		// The name outweighs the description, and a whole word in the
+		// description ties with a prefix of a category name.
+		{"camiseta", []int{1, 2, 4}},
+		{"algodon", []int{3, 1}},
+		// Accents and case are folded on both sides.
+		{"ALGODÓN", []int{3, 1}},
+		{"camisón", []int{3}},
+		// The last term matches as a prefix, at a discount, ties by id.
+		{"cami", []int{1, 3, 4, 2}},
+		// Every term must match, and only the last one as a prefix.
+		{"camiseta algodon", []int{1}},
+		{"cami algodon", []int{}},
+		{"camiseta de algodon", []int{1}},
+		{"de la", []int{}},
+		{"zapatos", []int{}},
+	}
+	for _, test := range tests {
+		hits, err := index.Search(context.Background(), test.query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := []int{}
+		for _, hit := range hits {
+			got = append(got, hit.Product.ID)
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("Search(%q) = %v, want %v", test.query, got, test.want)
+		}
+	}
+}
+
+func TestMemoryIndexScores(t *testing.T) {
+	hits, err := testIndex().Search(context.Background(), "camiseta algodon")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hits) != 1 || hits[0].Score != nameWeight+descriptionWeight {
+		t.Fatalf("hits = %+v, want product 1 scoring %d", hits, nameWeight+descriptionWeight)
+	}
+	if !reflect.DeepEqual(hits[0].Categories, []int{10}) {
+		t.Errorf("categories = %v, want [10]", hits[0].Categories)
+	}
+}
+
+func TestFacets(t *testing.T) {
+	hits, err := testIndex().Search(context.Background(), "cami")
+	if err != nil {
+		t.Fatal(err)
+	}
+	all := []database.Category{{ID: 10, Name: "Camisetas"}, {ID: 20, Name: "Pantalones"}, {ID: 30, Name: "Pijamas"}, {ID: 40, Name: "Accesorios"}}
+
+	got := facets(hits, all)
+
+	wantCategories := []CategoryFacet{
+		{ID: 10, Name: "Camisetas", Count: 2},
+		{ID: 40, Name: "Accesorios", Count: 1},
+		{ID: 20, Name: "Pantalones", Count: 1},
+		{ID: 30, Name: "Pijamas", Count: 1},
+	}
+	if !reflect.DeepEqual(got.Categories, wantCategories) {
+		t.Errorf("category facets = %+v, want %+v", got.Categories, wantCategories)
+	}
+
+	counts := map[string]int{}
+	for _, band := range got.PriceBands {
+		counts[band.Label] = band.Count
+	}
+	wantCounts := map[string]int{"0-25": 1, "25-50": 2, "50-100": 0, "100+": 1}
+	if !reflect.DeepEqual(counts, wantCounts) {
+		t.Errorf("price band counts = %v, want %v", counts, wantCounts)
+	}
+	last := got.PriceBands[len(got.PriceBands)-1]
+	if last.Max != nil || last.Min.Amount != 10000 {
+		t.Errorf("last band = %s to %v, want 100.00 and up", last.Min, last.Max)
+	}
+}
+
+func TestInBand(t *testing.T) {
+	tests := []struct {
+		band  string
+		cents int64
+		want  bool
+	}{
+		{"0-25", 0, true},
+		{"0-25", 2499, true},
+		{"0-25", 2500, false},
+		{"25-50", 2500, true},
+		{"100+", 10000, true},
+		{"100+", 9999, false},
+		{"unknown", 1000, false},
+	}
+	for _, test := range tests {
+		if got := inBand(test.band, money.New(test.cents, "USD")); got != test.want {
+			t.Errorf("inBand(%s, %d) = %v, want %v", test.band, test.cents, got, test.want)
+		}
+	}
+	if !ValidPriceBand("50-100") || ValidPriceBand("50-99") {
+		t.Error("ValidPriceBand does not match the bands")
+	}
+}
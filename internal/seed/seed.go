@@ -0,0 +1,169 @@
+package seed
+
+import (
+	"context"
+	"embed"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+)
+
+// FixtureVersion is the fixture format this package understands. Files with
+// a different version are rejected instead of being half-loaded.
+const FixtureVersion = 1
+
+//go:embed fixtures/*.json
+var embedded embed.FS
+
+type Category struct {
+	ID       int    `json:"id"`
+	Name     string `json:"name"`
+	ParentID int    `json:"parent_id,omitempty"`
+}
+
+type Product struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+	// Price keeps the decimal exactly as written in the file.
+	Price       json.Number `json:"price"`
+	Description string      `json:"description"`
+	Image       string      `json:"image"`
+	Categories  []int       `json:"categories"`
+}
+
+type Customer struct {
+	Name     string `json:"name"`
+	Email    string `json:"email"`
+	Phone    string `json:"phone"`
+	Password string `json:"password"`
+	Role     string `json:"role"`
+}
+
+type Fixtures struct {
+	Version    int        `json:"version"`
+	Categories []Category `json:"categories"`
+	Products   []Product  `json:"products"`
+	Customers  []Customer `json:"customers"`
+}
+
+// Store is where fixtures are written. Every method must be idempotent so
+// that running the seed twice leaves the same data behind.
+type Store interface {
+	Reset(ctx context.Context) error
+	UpsertCategory(ctx context.Context, category Category) error
+	UpsertProduct(ctx context.Context, product Product) error
+	UpsertCustomer(ctx context.Context, customer Customer) error
+}
+
+// Load reads every *.json fixture file from dir, or the fixtures embedded in
+// the binary when dir is empty, and merges them into one set.
+func Load(dir string) (Fixtures, error) {
+	var fsys fs.FS = embedded
+	root := "fixtures"
+	if dir != "" {
+		fsys = os.DirFS(dir)
+		root = "."
+	}
+
+	names, err := fs.Glob(fsys, path.Join(root, "*.json"))
+	if err != nil {
+		return Fixtures{}, err
+	}
+	if len(names) == 0 {
+		return Fixtures{}, fmt.Errorf("no fixture files found in %q", dir)
+	}
+
+	all := Fixtures{Version: FixtureVersion}
+	for _, name := range names {
+		body, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			return Fixtures{}, err
+		}
+
+		var file Fixtures
+		if err := json.Unmarshal(body, &file); err != nil {
+			return Fixtures{}, fmt.Errorf("%s: %w", name, err)
+		}
+		if file.Version != FixtureVersion {
+			return Fixtures{}, fmt.Errorf("%s: unsupported fixture version %d", name, file.Version)
+		}
+
+		all.Categories = append(all.Categories, file.Categories...)
+		all.Products = append(all.Products, file.Products...)
+		all.Customers = append(all.Customers, file.Customers...)
+	}
+
+	return all, all.validate()
+}
+
+func (f Fixtures) validate() error {
+	categories := map[int]bool{}
+	for _, category := range f.Categories {
+		if category.ID == 0 || category.Name == "" {
+			return fmt.Errorf("category %+v needs an id and a name", category)
+		}
+		if categories[category.ID] {
+			return fmt.Errorf("category %d is defined twice", category.ID)
+		}
+		if category.ParentID != 0 && !categories[category.ParentID] {
+			return fmt.Errorf("category %d must come after its parent %d", category.ID, category.ParentID)
+		}
+		categories[category.ID] = true
+	}
+
+	products := map[int]bool{}
+	for _, product := range f.Products {
+		if product.ID == 0 || product.Name == "" {
+			return fmt.Errorf("product %+v needs an id and a name", product)
+		}
+		if products[product.ID] {
+			return fmt.Errorf("product %d is defined twice", product.ID)
+		}
+		for _, id := range product.Categories {
+			if !categories[id] {
+				return fmt.Errorf("product %d references unknown category %d", product.ID, id)
+			}
+		}
+		products[product.ID] = true
+	}
+
+	for _, customer := range f.Customers {
+		if customer.Email == "" || customer.Password == "" {
+			return fmt.Errorf("customer %q needs an email and a password", customer.Name)
+		}
+		if customer.Role != "customer" && customer.Role != "admin" {
+			return fmt.Errorf("customer %s has unknown role %q", customer.Email, customer.Role)
+		}
+	}
+
+	return nil
+}
+
+// Run writes the fixtures to store, clearing it first when reset is set.
+func Run(ctx context.Context, store Store, fixtures Fixtures, reset bool) error {
+	if reset {
+		if err := store.Reset(ctx); err != nil {
+			return fmt.Errorf("resetting store: %w", err)
+		}
+	}
+
+	for _, category := range fixtures.Categories {
+		if err := store.UpsertCategory(ctx, category); err != nil {
+			return fmt.Errorf("category %d: %w", category.ID, err)
+		}
+	}
+	for _, product := range fixtures.Products {
+		if err := store.UpsertProduct(ctx, product); err != nil {
+			return fmt.Errorf("product %d: %w", product.ID, err)
+		}
+	}
+	for _, customer := range fixtures.Customers {
+		if err := store.UpsertCustomer(ctx, customer); err != nil {
+			return fmt.Errorf("customer %s: %w", customer.Email, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,178 @@
+package seed
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+)
+
+type MySQLStore struct {
+	db *sql.DB
+}
+
+func NewMySQLStore(db *sql.DB) *MySQLStore {
+	return &MySQLStore{db: db}
+}
+
+func (s *MySQLStore) Reset(ctx context.Context) error {
+	// Children before parents, so no foreign key is left dangling. Orders,
+	// carts and everything else hanging off customers and products go with
+	// them; shop settings such as tax rates, shipping zones and promotions
+	// stay.
+	statements := []string{
+		"DELETE FROM product_images",
+		"DELETE FROM product_reviews",
+		"DELETE FROM wishlist_items",
+		"DELETE FROM wishlists",
+		"DELETE FROM data_exports",
+		"DELETE FROM contact_messages",
+		"DELETE FROM email_changes",
+		"DELETE FROM customer_sessions",
+		"DELETE FROM customer_addresses",
+		"DELETE FROM shipments",
+		"DELETE FROM order_taxes",
+		"DELETE FROM order_discounts",
+		"DELETE FROM promotion_redemptions",
+		"UPDATE promotions SET used = 0",
+		"DELETE FROM payment_events",
+		"DELETE FROM payments",
+		"DELETE FROM order_status_history",
+		"DELETE FROM order_items",
+		"DELETE FROM orders",
+		"DELETE FROM cart_items",
+		"DELETE FROM carts",
+		"DELETE FROM stock_movements",
+		"DELETE FROM stock_reservations",
+		"DELETE FROM product_variants",
+		"DELETE FROM product_audit",
+		"DELETE FROM categories_product",
+		"DELETE FROM products",
+		// Detach the tree first so the self-referencing foreign key does not
+		// depend on the order rows are deleted in.
+		"UPDATE categories SET parent_category_id = NULL",
+		"DELETE FROM categories",
+		"DELETE FROM customers",
+	}
+	for _, statement := range statements {
+		if _, err := s.db.ExecContext(ctx, statement); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *MySQLStore) UpsertCategory(ctx context.Context, category Category) error {
+	var parentID any
+	if category.ParentID != 0 {
+		parentID = category.ParentID
+	}
+	_, err := s.db.ExecContext(
+		ctx,
+		"INSERT INTO categories (id, name, parent_category_id) VALUES (?, ?, ?) ON DUPLICATE KEY UPDATE name = VALUES(name), parent_category_id = VALUES(parent_category_id)",
+		category.ID,
+		category.Name,
+		parentID,
+	)
+	return err
+}
+
+func (s *MySQLStore) UpsertProduct(ctx context.Context, product Product) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(
+		ctx,
+		"INSERT INTO products (product_id, name, price, description, img) VALUES (?, ?, ?, ?, ?) ON DUPLICATE KEY UPDATE name = VALUES(name), price = VALUES(price), description = VALUES(description), img = VALUES(img)",
+		product.ID,
+		product.Name,
+		product.Price,
+		product.Description,
+		product.Image,
+	)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, "DELETE FROM categories_product WHERE id_product = ?", product.ID)
+	if err != nil {
+		return err
+	}
+	for _, categoryID := range product.Categories {
+		_, err = tx.ExecContext(
+			ctx,
+			"INSERT INTO categories_product (id_product, id_category) VALUES (?, ?)",
+			product.ID,
+			categoryID,
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (s *MySQLStore) UpsertCustomer(ctx context.Context, customer Customer) error {
+	_, err := s.db.ExecContext(
+		ctx,
+		"INSERT INTO customers (name, email, phone, password, role) VALUES (?, ?, ?, MD5(?), ?) ON DUPLICATE KEY UPDATE name = VALUES(name), phone = VALUES(phone), password = VALUES(password), role = VALUES(role)",
+		customer.Name,
+		customer.Email,
+		customer.Phone,
+		customer.Password,
+		customer.Role,
+	)
+	return err
+}
+
+// MemoryStore keeps seeded data in maps. It backs tests and the
+// `seed --store memory` dry run.
+type MemoryStore struct {
+	mu         sync.Mutex
+	Categories map[int]Category
+	Products   map[int]Product
+	Customers  map[string]Customer
+}
+
+func NewMemoryStore() *MemoryStore {
+	store := &MemoryStore{}
+	store.Reset(context.Background())
+	return store
+}
+
+func (s *MemoryStore) Reset(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.Categories = map[int]Category{}
+	s.Products = map[int]Product{}
+	s.Customers = map[string]Customer{}
+	return nil
+}
+
+func (s *MemoryStore) UpsertCategory(ctx context.Context, category Category) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.Categories[category.ID] = category
+	return nil
+}
+
+func (s *MemoryStore) UpsertProduct(ctx context.Context, product Product) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.Products[product.ID] = product
+	return nil
+}
+
+func (s *MemoryStore) UpsertCustomer(ctx context.Context, customer Customer) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.Customers[customer.Email] = customer
+	return nil
+}
@@ -0,0 +1,108 @@
+package shipping
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"strings"
+	"sync"
+
+	"basicthreads/internal/database"
+)
+
+var ErrUnknownCarrier = errors.New("unknown carrier")
+
+// ShipmentRequest asks a carrier to pick up the parcel of an order.
+type ShipmentRequest struct {
+	Reference string
+	Service   string
+	To        database.Address
+	// Weight is in grams.
+	Weight int
+}
+
+// Label is what a carrier hands back for a parcel it takes.
+type Label struct {
+	TrackingNumber string
+	TrackingURL    string
+}
+
+// Carrier books parcels with a shipping company.
+type Carrier interface {
+	Name() string
+	Ship(ctx context.Context, request ShipmentRequest) (Label, error)
+}
+
+var (
+	carriersMu sync.RWMutex
+	carriers   = map[string]Carrier{}
+)
+
+// Register makes a carrier available to shipping methods under its name.
+func Register(carrier Carrier) {
+	carriersMu.Lock()
+	defer carriersMu.Unlock()
+	carriers[carrier.Name()] = carrier
+}
+
+// CarrierFor returns the registered carrier called name.
+func CarrierFor(name string) (Carrier, error) {
+	carriersMu.RLock()
+	defer carriersMu.RUnlock()
+	carrier, ok := carriers[name]
+	if !ok {
+		return nil, ErrUnknownCarrier
+	}
+	return carrier, nil
+}
+
+func init() {
+	Register(NewFakeCarrier())
+}
+
+// trackingAlphabet leaves out letters easy to mistake for digits.
+const trackingAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+
+// FakeCarrier is a local carrier for development: it accepts every parcel
+// and makes up a tracking number. Parcels live in memory.
+type FakeCarrier struct {
+	mu      sync.Mutex
+	parcels map[string]ShipmentRequest
+}
+
+func NewFakeCarrier() *FakeCarrier {
+	return &FakeCarrier{parcels: map[string]ShipmentRequest{}}
+}
+
+func (f *FakeCarrier) Name() string {
+	return "fake"
+}
+
+func (f *FakeCarrier) Ship(ctx context.Context, request ShipmentRequest) (Label, error) {
+	raw := make([]byte, 12)
+	if _, err := rand.Read(raw); err != nil {
+		return Label{}, err
+	}
+	var number strings.Builder
+	number.WriteString("FK")
+	for _, b := range raw {
+		number.WriteByte(trackingAlphabet[int(b)%len(trackingAlphabet)])
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.parcels[number.String()] = request
+
+	return Label{
+		TrackingNumber: number.String(),
+		TrackingURL:    "https://tracking.example.com/" + number.String(),
+	}, nil
+}
+
+// Parcel returns what was shipped under a tracking number.
+func (f *FakeCarrier) Parcel(trackingNumber string) (ShipmentRequest, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	request, ok := f.parcels[trackingNumber]
+	return request, ok
+}
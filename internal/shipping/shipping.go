@@ -0,0 +1,291 @@
+package shipping
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"strings"
+
+	"basicthreads/internal/database"
+	"basicthreads/internal/money"
+	"basicthreads/internal/products"
+	"basicthreads/internal/tax"
+)
+
+// Kinds of shipping methods.
+const (
+	KindFlat   = "flat"
+	KindWeight = "weight"
+)
+
+var (
+	ErrNotFound  = database.ErrNotFound
+	ErrDuplicate = errors.New("another zone already covers one of the areas")
+
+	countryPattern = regexp.MustCompile(`^[A-Z]{2}$`)
+)
+
+// Rate is what a shipping method costs for a cart.
+type Rate struct {
+	MethodID int
+	Name     string
+	Carrier  string
+	Service  string `json:",omitempty"`
+	Price    money.Money
+}
+
+// Quote lists the methods that can ship weight grams of goods worth goods
+// to an address in country and region, with their prices. Addresses no
+// zone covers get no rates.
+func Quote(ctx context.Context, country, region string, weight int, goods money.Money) ([]Rate, error) {
+	rates := []Rate{}
+	zoneID, err := database.FindShippingZone(ctx, strings.ToUpper(country), tax.NormalizeRegion(region))
+	if errors.Is(err, database.ErrNotFound) {
+		return rates, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	methods, err := database.GetActiveShippingMethods(ctx, zoneID)
+	if err != nil {
+		return nil, err
+	}
+	for _, method := range methods {
+		if price, ok := Price(method, weight, goods); ok {
+			rates = append(rates, Rate{
+				MethodID: method.ID,
+				Name:     method.Name,
+				Carrier:  method.Carrier,
+				Service:  method.Service,
+				Price:    price,
+			})
+		}
+	}
+	return rates, nil
+}
+
+// Price works out what method charges for weight grams of goods worth
+// goods. It reports false when the parcel is too heavy for the method.
+// Weight methods charge every started kilogram.
+func Price(method database.ShippingMethod, weight int, goods money.Money) (money.Money, bool) {
+	if method.MaxWeight != nil && weight > *method.MaxWeight {
+		return money.Money{}, false
+	}
+	if method.FreeAbove != nil && goods.Cmp(*method.FreeAbove) >= 0 {
+		return money.Zero(method.Price.Currency), true
+	}
+
+	price := method.Price
+	if method.Kind == KindWeight && method.PerKg != nil {
+		kilograms := (weight + 999) / 1000
+		price = price.Add(method.PerKg.Times(kilograms))
+	}
+	return price, true
+}
+
+// ParseAreas reads a list of areas like "SV, GT, US:CA", where a region
+// follows its country after a colon.
+func ParseAreas(value string) []database.ShippingArea {
+	areas := []database.ShippingArea{}
+	for _, part := range strings.Split(value, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		country, region, _ := strings.Cut(part, ":")
+		areas = append(areas, database.ShippingArea{
+			Country: strings.ToUpper(strings.TrimSpace(country)),
+			Region:  tax.NormalizeRegion(region),
+		})
+	}
+	return areas
+}
+
+// ZoneInput describes a shipping zone and the areas it covers.
+type ZoneInput struct {
+	Name  string
+	Areas []database.ShippingArea
+}
+
+func (in ZoneInput) validate() error {
+	problems := products.ValidationError{}
+
+	if in.Name == "" {
+		problems["name"] = "is required"
+	} else if len(in.Name) > 255 {
+		problems["name"] = "must be at most 255 characters"
+	}
+	if len(in.Areas) == 0 {
+		problems["areas"] = "must list at least one country"
+	}
+	seen := map[database.ShippingArea]bool{}
+	for _, area := range in.Areas {
+		if !countryPattern.MatchString(area.Country) || len(area.Region) > 64 {
+			problems["areas"] = "must be two letter ISO country codes, optionally followed by :region"
+			break
+		}
+		if seen[area] {
+			problems["areas"] = "must not repeat an area"
+			break
+		}
+		seen[area] = true
+	}
+
+	if len(problems) > 0 {
+		return problems
+	}
+	return nil
+}
+
+func Zones(ctx context.Context) ([]database.ShippingZone, error) {
+	return database.GetShippingZones(ctx)
+}
+
+func CreateZone(ctx context.Context, in ZoneInput) (database.ShippingZone, error) {
+	in.Name = strings.TrimSpace(in.Name)
+	if err := in.validate(); err != nil {
+		return database.ShippingZone{}, err
+	}
+
+	zone, err := database.CreateShippingZone(ctx, database.ShippingZone{Name: in.Name, Areas: in.Areas})
+	if errors.Is(err, database.ErrDuplicate) {
+		return zone, ErrDuplicate
+	}
+	return zone, err
+}
+
+func UpdateZone(ctx context.Context, id int, in ZoneInput) (database.ShippingZone, error) {
+	in.Name = strings.TrimSpace(in.Name)
+	if err := in.validate(); err != nil {
+		return database.ShippingZone{}, err
+	}
+
+	zone, err := database.UpdateShippingZone(ctx, database.ShippingZone{ID: id, Name: in.Name, Areas: in.Areas})
+	if errors.Is(err, database.ErrDuplicate) {
+		return zone, ErrDuplicate
+	}
+	return zone, err
+}
+
+func DeleteZone(ctx context.Context, id int) error {
+	return database.DeleteShippingZone(ctx, id)
+}
+
+// MethodInput describes a shipping method. PerKg is only used by weight
+// methods; FreeAbove and MaxWeight are optional.
+type MethodInput struct {
+	Name      string
+	Kind      string
+	Price     money.Money
+	PerKg     *money.Money
+	FreeAbove *money.Money
+	MaxWeight *int
+	Carrier   string
+	Service   string
+	Active    bool
+}
+
+func (in *MethodInput) normalize() {
+	in.Name = strings.TrimSpace(in.Name)
+	in.Kind = strings.ToLower(strings.TrimSpace(in.Kind))
+	if in.Kind == "" {
+		in.Kind = KindFlat
+	}
+	in.Carrier = strings.ToLower(strings.TrimSpace(in.Carrier))
+	in.Service = strings.TrimSpace(in.Service)
+	if in.Kind == KindFlat {
+		in.PerKg = nil
+	}
+}
+
+func (in MethodInput) validate() error {
+	problems := products.ValidationError{}
+
+	if in.Name == "" {
+		problems["name"] = "is required"
+	} else if len(in.Name) > 255 {
+		problems["name"] = "must be at most 255 characters"
+	}
+	switch in.Kind {
+	case KindFlat:
+	case KindWeight:
+		if in.PerKg == nil {
+			problems["per_kg"] = "is required for weight based methods"
+		}
+	default:
+		problems["kind"] = "must be flat or weight"
+	}
+	if in.Price.IsNegative() {
+		problems["price"] = "must not be negative"
+	}
+	if in.PerKg != nil && in.PerKg.IsNegative() {
+		problems["per_kg"] = "must not be negative"
+	}
+	if in.FreeAbove != nil && in.FreeAbove.IsNegative() {
+		problems["free_above"] = "must not be negative"
+	}
+	if in.MaxWeight != nil && *in.MaxWeight <= 0 {
+		problems["max_weight"] = "must be a positive number of grams"
+	}
+	if _, err := CarrierFor(in.Carrier); err != nil {
+		problems["carrier"] = "is not a known carrier"
+	}
+	if len(in.Service) > 64 {
+		problems["service"] = "must be at most 64 characters"
+	}
+
+	if len(problems) > 0 {
+		return problems
+	}
+	return nil
+}
+
+func (in MethodInput) method() database.ShippingMethod {
+	return database.ShippingMethod{
+		Name:      in.Name,
+		Kind:      in.Kind,
+		Price:     in.Price,
+		PerKg:     in.PerKg,
+		FreeAbove: in.FreeAbove,
+		MaxWeight: in.MaxWeight,
+		Carrier:   in.Carrier,
+		Service:   in.Service,
+		Active:    in.Active,
+	}
+}
+
+// CreateMethod adds a shipping method to a zone.
+func CreateMethod(ctx context.Context, zoneID int, in MethodInput) (database.ShippingMethod, error) {
+	in.normalize()
+	if err := in.validate(); err != nil {
+		return database.ShippingMethod{}, err
+	}
+	if _, err := database.GetShippingZone(ctx, zoneID); err != nil {
+		return database.ShippingMethod{}, err
+	}
+
+	method := in.method()
+	method.ZoneID = zoneID
+	return database.CreateShippingMethod(ctx, method)
+}
+
+func UpdateMethod(ctx context.Context, id int, in MethodInput) (database.ShippingMethod, error) {
+	in.normalize()
+	if err := in.validate(); err != nil {
+		return database.ShippingMethod{}, err
+	}
+	current, err := database.GetShippingMethod(ctx, id)
+	if err != nil {
+		return current, err
+	}
+
+	method := in.method()
+	method.ID = id
+	method.ZoneID = current.ZoneID
+	return database.UpdateShippingMethod(ctx, method)
+}
+
+func DeleteMethod(ctx context.Context, id int) error {
+	return database.DeleteShippingMethod(ctx, id)
+}
@@ -0,0 +1,122 @@
+package shipping
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"basicthreads/internal/database"
+	"basicthreads/internal/money"
+)
+
+func usd(amount int64) *money.Money {
+	m := money.New(amount, "USD")
+	return &m
+}
+
+func grams(weight int) *int {
+	return &weight
+}
+
+func TestPrice(t *testing.T) {
+	flat := database.ShippingMethod{Kind: KindFlat, Price: money.New(500, "USD")}
+	byWeight := database.ShippingMethod{Kind: KindWeight, Price: money.New(300, "USD"), PerKg: usd(150)}
+	freeAbove := flat
+	freeAbove.FreeAbove = usd(5000)
+	limited := byWeight
+	limited.MaxWeight = grams(2000)
+	limitedFree := flat
+	limitedFree.MaxWeight = grams(2000)
+	limitedFree.FreeAbove = usd(0)
+
+	tests := []struct {
+		name   string
+		method database.ShippingMethod
+		weight int
+		goods  int64
+		want   int64
+		ok     bool
+	}{
+		{"flat", flat, 12000, 1000, 500, true},
+		{"weight, nothing", byWeight, 0, 1000, 300, true},
+		{"weight, one gram", byWeight, 1, 1000, 450, true},
+		{"weight, one kilogram", byWeight, 1000, 1000, 450, true},
+		{"weight, started second kilogram", byWeight, 1001, 1000, 600, true},
+		{"free above", freeAbove, 500, 5000, 0, true},
+		{"just under free", freeAbove, 500, 4999, 500, true},
+		{"at the weight limit", limited, 2000, 1000, 600, true},
+		{"too heavy, even when free", limitedFree, 2001, 1000, 0, false},
+	}
+	for _, test := range tests {
+		price, ok := Price(test.method, test.weight, money.New(test.goods, "USD"))
+		if ok != test.ok {
+			t.Errorf("%s: ok = %v, want %v", test.name, ok, test.ok)
+			continue
+		}
+		if ok && (price.Amount != test.want || price.Currency != "USD") {
+			t.Errorf("%s: price = %d %s, want %d USD", test.name, price.Amount, price.Currency, test.want)
+		}
+	}
+}
+
+func TestParseAreas(t *testing.T) {
+	tests := map[string][]database.ShippingArea{
+		"":               {},
+		"SV":             {{Country: "SV"}},
+		"sv, gt ,":       {{Country: "SV"}, {Country: "GT"}},
+		"US:ca, US: ny ": {{Country: "US", Region: "CA"}, {Country: "US", Region: "NY"}},
+	}
+	for value, want := range tests {
+		if got := ParseAreas(value); !reflect.DeepEqual(got, want) {
+			t.Errorf("ParseAreas(%q) = %v, want %v", value, got, want)
+		}
+	}
+}
+
+func TestFakeCarrier(t *testing.T) {
+	registered, err := CarrierFor("fake")
+	if err != nil {
+		t.Fatalf("the fake carrier is not registered: %v", err)
+	}
+	if _, ok := registered.(*FakeCarrier); !ok {
+		t.Fatalf("CarrierFor(fake) = %T", registered)
+	}
+	if _, err := CarrierFor("pigeon"); !errors.Is(err, ErrUnknownCarrier) {
+		t.Errorf("CarrierFor(pigeon) error = %v, want ErrUnknownCarrier", err)
+	}
+
+	carrier := NewFakeCarrier()
+	requests := []ShipmentRequest{
+		{Reference: "BT-1", Service: "standard", To: database.Address{Name: "Ana", City: "San Salvador", Country: "SV"}, Weight: 800},
+		{Reference: "BT-2", Service: "express", To: database.Address{Name: "Luis", City: "Austin", Region: "TX", Country: "US"}, Weight: 2500},
+	}
+	seen := map[string]bool{}
+	for _, request := range requests {
+		label, err := carrier.Ship(context.Background(), request)
+		if err != nil {
+			t.Fatalf("shipping %s: %v", request.Reference, err)
+		}
+		number := label.TrackingNumber
+		if len(number) != 14 || !strings.HasPrefix(number, "FK") || strings.Trim(number[2:], trackingAlphabet) != "" {
+			t.Errorf("tracking number %q is not FK and 12 characters of the alphabet", number)
+		}
+		if label.TrackingURL != "https://tracking.example.com/"+number {
+			t.Errorf("tracking URL %q does not point at %s", label.TrackingURL, number)
+		}
+		if seen[number] {
+			t.Errorf("tracking number %s given twice", number)
+		}
+		seen[number] = true
+
+		parcel, ok := carrier.Parcel(number)
+		if !ok || !reflect.DeepEqual(parcel, request) {
+			t.Errorf("Parcel(%s) = %v, %v, want %v", number, parcel, ok, request)
+		}
+	}
+
+	if _, ok := carrier.Parcel("FK000000000000"); ok {
+		t.Error("Parcel found a tracking number that was never given")
+	}
+}
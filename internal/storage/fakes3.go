@@ -0,0 +1,147 @@
+package storage
+
+import (
+	"crypto/hmac"
+	"encoding/xml"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// signatureTolerance is how far the date of a signed request may be from
+// the clock of the fake.
+const signatureTolerance = 15 * time.Minute
+
+// FakeS3 is a local stand-in for an S3-compatible service, enough for the
+// S3 store to run against in development. Objects live in memory and every
+// bucket exists. Requests must be signed with the configured credentials.
+type FakeS3 struct {
+	Region    string
+	AccessKey string
+	SecretKey string
+
+	mu      sync.Mutex
+	objects map[string]fakeObject
+}
+
+type fakeObject struct {
+	data        []byte
+	contentType string
+	modified    time.Time
+}
+
+func NewFakeS3(region, accessKey, secretKey string) *FakeS3 {
+	return &FakeS3{
+		Region:    region,
+		AccessKey: accessKey,
+		SecretKey: secretKey,
+		objects:   map[string]fakeObject{},
+	}
+}
+
+func (f *FakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		fakeS3Error(w, http.StatusBadRequest, "IncompleteBody", err.Error())
+		return
+	}
+	if code, message := f.verify(r, body); code != "" {
+		fakeS3Error(w, http.StatusForbidden, code, message)
+		return
+	}
+
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	if bucket == "" || !ValidKey(key) {
+		fakeS3Error(w, http.StatusBadRequest, "InvalidURI", "expected /bucket/key")
+		return
+	}
+	name := bucket + "/" + key
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	switch r.Method {
+	case http.MethodPut:
+		f.objects[name] = fakeObject{
+			data:        body,
+			contentType: r.Header.Get("Content-Type"),
+			modified:    time.Now().UTC().Truncate(time.Second),
+		}
+		w.WriteHeader(http.StatusOK)
+	case http.MethodGet, http.MethodHead:
+		object, ok := f.objects[name]
+		if !ok {
+			fakeS3Error(w, http.StatusNotFound, "NoSuchKey", "The specified key does not exist.")
+			return
+		}
+		if object.contentType != "" {
+			w.Header().Set("Content-Type", object.contentType)
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(object.data)))
+		w.Header().Set("Last-Modified", object.modified.Format(http.TimeFormat))
+		w.WriteHeader(http.StatusOK)
+		if r.Method == http.MethodGet {
+			w.Write(object.data)
+		}
+	case http.MethodDelete:
+		delete(f.objects, name)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		fakeS3Error(w, http.StatusMethodNotAllowed, "MethodNotAllowed", "The specified method is not allowed.")
+	}
+}
+
+// verify checks the signature of a request the way S3 does, returning the
+// S3 error code when it does not hold.
+func (f *FakeS3) verify(r *http.Request, body []byte) (string, string) {
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, signingAlgorithm+" ") {
+		return "AccessDenied", "Requests must be signed with " + signingAlgorithm
+	}
+	fields := map[string]string{}
+	for _, part := range strings.Split(strings.TrimPrefix(auth, signingAlgorithm+" "), ",") {
+		name, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+		fields[name] = value
+	}
+
+	amzDate := r.Header.Get("X-Amz-Date")
+	date, err := time.Parse(amzDateFormat, amzDate)
+	if err != nil {
+		return "AccessDenied", "X-Amz-Date is missing or malformed"
+	}
+	if skew := time.Since(date); skew > signatureTolerance || skew < -signatureTolerance {
+		return "RequestTimeTooSkewed", "The difference between the request time and the current time is too large."
+	}
+	if fields["Credential"] != f.AccessKey+"/"+credentialScope(amzDate, f.Region) {
+		return "InvalidAccessKeyId", "The access key or credential scope is not recognised."
+	}
+	signed := fields["SignedHeaders"]
+	for _, name := range strings.Split(signedHeaders, ";") {
+		if !slices.Contains(strings.Split(signed, ";"), name) {
+			return "AccessDenied", "SignedHeaders must include " + name
+		}
+	}
+
+	sum := r.Header.Get("X-Amz-Content-Sha256")
+	if sum != sha256Hex(body) {
+		return "XAmzContentSHA256Mismatch", "The provided x-amz-content-sha256 header does not match what was computed."
+	}
+	expected := signature(r, signed, sum, amzDate, f.Region, f.SecretKey)
+	if !hmac.Equal([]byte(fields["Signature"]), []byte(expected)) {
+		return "SignatureDoesNotMatch", "The request signature we calculated does not match the signature you provided."
+	}
+	return "", ""
+}
+
+func fakeS3Error(w http.ResponseWriter, status int, code, message string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	xml.NewEncoder(w).Encode(struct {
+		XMLName xml.Name `xml:"Error"`
+		s3Error
+	}{s3Error: s3Error{Code: code, Message: message}})
+}
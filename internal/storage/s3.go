@@ -0,0 +1,278 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+)
+
+const (
+	signingAlgorithm = "AWS4-HMAC-SHA256"
+	amzDateFormat    = "20060102T150405Z"
+	signedHeaders    = "host;x-amz-content-sha256;x-amz-date"
+)
+
+// S3Store keeps blobs in a bucket of an S3-compatible service, such as
+// MinIO or the fake-s3 command. Objects are addressed path style, so the
+// endpoint needs no per-bucket DNS.
+type S3Store struct {
+	Endpoint  string
+	Region    string
+	Bucket    string
+	AccessKey string
+	SecretKey string
+	client    *http.Client
+}
+
+func NewS3Store(endpoint, region, bucket, accessKey, secretKey string) *S3Store {
+	return &S3Store{
+		Endpoint:  strings.TrimSuffix(endpoint, "/"),
+		Region:    region,
+		Bucket:    bucket,
+		AccessKey: accessKey,
+		SecretKey: secretKey,
+		client:    &http.Client{Transport: otelhttp.NewTransport(http.DefaultTransport), Timeout: time.Minute},
+	}
+}
+
+type s3Error struct {
+	Code    string `xml:"Code"`
+	Message string `xml:"Message"`
+}
+
+// do sends a signed request for an object and returns the response when
+// its status is below 300. A missing object is ErrNotFound.
+func (s *S3Store) do(ctx context.Context, method, key string, body []byte, contentType string) (*http.Response, error) {
+	if !ValidKey(key) {
+		return nil, ErrInvalidKey
+	}
+	req, err := http.NewRequestWithContext(ctx, method, s.Endpoint+"/"+s.Bucket+"/"+key, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	sign(req, body, s.Region, s.AccessKey, s.SecretKey, time.Now())
+
+	res, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode < 300 {
+		return res, nil
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusNotFound {
+		return nil, ErrNotFound
+	}
+	var failure s3Error
+	payload, _ := io.ReadAll(io.LimitReader(res.Body, 64<<10))
+	xml.Unmarshal(payload, &failure)
+	return nil, fmt.Errorf("s3 %s %s: %s: %s %s", method, key, res.Status, failure.Code, failure.Message)
+}
+
+func (s *S3Store) Put(ctx context.Context, key string, data []byte, contentType string) error {
+	if contentType == "" {
+		contentType = contentTypeOf(key)
+	}
+	res, err := s.do(ctx, http.MethodPut, key, data, contentType)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	return nil
+}
+
+// Open downloads the whole object, so the caller can seek in it. Blobs are
+// images of a few megabytes at most.
+func (s *S3Store) Open(ctx context.Context, key string) (io.ReadSeekCloser, Info, error) {
+	res, err := s.do(ctx, http.MethodGet, key, nil, "")
+	if err != nil {
+		return nil, Info{}, err
+	}
+	defer res.Body.Close()
+
+	data, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, Info{}, err
+	}
+	return readSeekNopCloser{bytes.NewReader(data)}, objectInfo(key, res, int64(len(data))), nil
+}
+
+// Stat asks for the headers of the object only.
+func (s *S3Store) Stat(ctx context.Context, key string) (Info, error) {
+	res, err := s.do(ctx, http.MethodHead, key, nil, "")
+	if err != nil {
+		return Info{}, err
+	}
+	res.Body.Close()
+
+	size := res.ContentLength
+	if size < 0 {
+		size, err = strconv.ParseInt(res.Header.Get("Content-Length"), 10, 64)
+		if err != nil {
+			return Info{}, fmt.Errorf("s3 HEAD %s: no Content-Length", key)
+		}
+	}
+	return objectInfo(key, res, size), nil
+}
+
+func objectInfo(key string, res *http.Response, size int64) Info {
+	info := Info{Size: size, ContentType: res.Header.Get("Content-Type")}
+	if info.ContentType == "" {
+		info.ContentType = contentTypeOf(key)
+	}
+	if modified, err := http.ParseTime(res.Header.Get("Last-Modified")); err == nil {
+		info.ModTime = modified
+	}
+	return info
+}
+
+func (s *S3Store) Delete(ctx context.Context, key string) error {
+	res, err := s.do(ctx, http.MethodDelete, key, nil, "")
+	if errors.Is(err, ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	return nil
+}
+
+type readSeekNopCloser struct {
+	*bytes.Reader
+}
+
+func (readSeekNopCloser) Close() error {
+	return nil
+}
+
+// sign adds AWS Signature Version 4 headers to req. Only the host, payload
+// hash and date are signed, which every S3-compatible service accepts.
+func sign(req *http.Request, body []byte, region, accessKey, secretKey string, now time.Time) {
+	payloadHash := sha256Hex(body)
+	amzDate := now.UTC().Format(amzDateFormat)
+
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	req.Header.Set("X-Amz-Date", amzDate)
+
+	scope := credentialScope(amzDate, region)
+	req.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		signingAlgorithm, accessKey, scope, signedHeaders,
+		signature(req, signedHeaders, payloadHash, amzDate, region, secretKey)))
+}
+
+func credentialScope(amzDate, region string) string {
+	return amzDate[:8] + "/" + region + "/s3/aws4_request"
+}
+
+// signature computes the request signature over the headers named in
+// signed, a semicolon separated list of lowercase names in order.
+func signature(req *http.Request, signed, payloadHash, amzDate, region, secretKey string) string {
+	canonical := strings.Join([]string{
+		req.Method,
+		canonicalPath(req),
+		canonicalQuery(req),
+		canonicalHeaders(req, signed),
+		signed,
+		payloadHash,
+	}, "\n")
+	scope := credentialScope(amzDate, region)
+	toSign := signingAlgorithm + "\n" + amzDate + "\n" + scope + "\n" + sha256Hex([]byte(canonical))
+
+	key := hmacSHA256([]byte("AWS4"+secretKey), amzDate[:8])
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	return hex.EncodeToString(hmacSHA256(key, toSign))
+}
+
+// canonicalPath escapes each segment of the path once, as S3 expects.
+func canonicalPath(req *http.Request) string {
+	segments := strings.Split(req.URL.Path, "/")
+	for i, segment := range segments {
+		segments[i] = escape(segment)
+	}
+	if path := strings.Join(segments, "/"); path != "" {
+		return path
+	}
+	return "/"
+}
+
+// canonicalHeaders writes a name:value line for each signed header, with
+// the runs of spaces in values folded.
+func canonicalHeaders(req *http.Request, signed string) string {
+	var out strings.Builder
+	for _, name := range strings.Split(signed, ";") {
+		value := strings.Join(req.Header.Values(name), ",")
+		if name == "host" {
+			value = req.Host
+			if value == "" {
+				value = req.URL.Host
+			}
+		}
+		out.WriteString(name + ":" + strings.Join(strings.Fields(value), " ") + "\n")
+	}
+	return out.String()
+}
+
+func canonicalQuery(req *http.Request) string {
+	query := req.URL.Query()
+	names := make([]string, 0, len(query))
+	for name := range query {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var pairs []string
+	for _, name := range names {
+		values := query[name]
+		sort.Strings(values)
+		for _, value := range values {
+			pairs = append(pairs, escape(name)+"="+escape(value))
+		}
+	}
+	return strings.Join(pairs, "&")
+}
+
+// escape percent-encodes everything but the unreserved characters, as
+// SigV4 requires.
+func escape(value string) string {
+	var out strings.Builder
+	for _, b := range []byte(value) {
+		switch {
+		case 'A' <= b && b <= 'Z', 'a' <= b && b <= 'z', '0' <= b && b <= '9', b == '-', b == '.', b == '_', b == '~':
+			out.WriteByte(b)
+		default:
+			fmt.Fprintf(&out, "%%%02X", b)
+		}
+	}
+	return out.String()
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
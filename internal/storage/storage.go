@@ -0,0 +1,68 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+
+	"basicthreads/internal/config"
+)
+
+var (
+	ErrNotFound   = errors.New("blob not found")
+	ErrInvalidKey = errors.New("invalid blob key")
+
+	keyPattern = regexp.MustCompile(`^[A-Za-z0-9_-][A-Za-z0-9._-]*(/[A-Za-z0-9_-][A-Za-z0-9._-]*)*$`)
+)
+
+// Info describes a stored blob.
+type Info struct {
+	Size        int64
+	ContentType string
+	ModTime     time.Time
+}
+
+// BlobStore keeps named blobs, such as uploaded images. Keys are slash
+// separated paths without dot segments.
+type BlobStore interface {
+	Put(ctx context.Context, key string, data []byte, contentType string) error
+	// Open returns the content of a blob, or ErrNotFound.
+	Open(ctx context.Context, key string) (io.ReadSeekCloser, Info, error)
+	// Stat describes a blob without reading it, or returns ErrNotFound.
+	Stat(ctx context.Context, key string) (Info, error)
+	// Delete removes a blob. Deleting a missing blob is not an error.
+	Delete(ctx context.Context, key string) error
+}
+
+// ValidKey reports whether key can name a blob in every store.
+func ValidKey(key string) bool {
+	return len(key) <= 512 && keyPattern.MatchString(key) && !strings.Contains(key, "..")
+}
+
+// contentTypeOf guesses the type of a blob from the extension of its key.
+func contentTypeOf(key string) string {
+	if value := mime.TypeByExtension(path.Ext(key)); value != "" {
+		return value
+	}
+	return "application/octet-stream"
+}
+
+// New returns the store the configuration asks for.
+func New(cfg config.Config) (BlobStore, error) {
+	switch cfg.StorageBackend {
+	case "", "local":
+		return NewLocalStore(cfg.MediaDir), nil
+	case "s3":
+		if cfg.S3AccessKey == "" || cfg.S3SecretKey == "" {
+			return nil, errors.New("S3_ACCESS_KEY and S3_SECRET_KEY must be set for the s3 storage backend")
+		}
+		return NewS3Store(cfg.S3Endpoint, cfg.S3Region, cfg.S3Bucket, cfg.S3AccessKey, cfg.S3SecretKey), nil
+	}
+	return nil, fmt.Errorf("unknown storage backend %q", cfg.StorageBackend)
+}
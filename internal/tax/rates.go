@@ -0,0 +1,103 @@
+package tax
+
+import (
+	"context"
+	"math/big"
+	"regexp"
+	"strings"
+
+	"basicthreads/internal/database"
+	"basicthreads/internal/products"
+)
+
+var (
+	ErrNotFound = database.ErrNotFound
+
+	countryPattern = regexp.MustCompile(`^[A-Z]{2}$`)
+	ratePattern    = regexp.MustCompile(`^\d{1,3}(\.\d{1,4})?$`)
+)
+
+// RateInput describes a tax rate. Region is empty for rates that apply to
+// the whole country.
+type RateInput struct {
+	Country  string
+	Region   string
+	TaxClass string
+	Name     string
+	Rate     string
+}
+
+func (in *RateInput) normalize() {
+	in.Country = strings.ToUpper(strings.TrimSpace(in.Country))
+	in.Region = NormalizeRegion(in.Region)
+	in.TaxClass = strings.ToLower(strings.TrimSpace(in.TaxClass))
+	if in.TaxClass == "" {
+		in.TaxClass = products.DefaultTaxClass
+	}
+	in.Name = strings.TrimSpace(in.Name)
+	in.Rate = strings.TrimSpace(in.Rate)
+}
+
+// NormalizeRegion is how regions are stored and matched against
+// addresses: trimmed and upper case, "CA" or "SAN SALVADOR".
+func NormalizeRegion(region string) string {
+	return strings.ToUpper(strings.TrimSpace(region))
+}
+
+func (in RateInput) validate() error {
+	problems := products.ValidationError{}
+
+	if !countryPattern.MatchString(in.Country) {
+		problems["country"] = "must be a two letter ISO country code"
+	}
+	if len(in.Region) > 64 {
+		problems["region"] = "must be at most 64 characters"
+	}
+	if !products.ValidTaxClass(in.TaxClass) {
+		problems["tax_class"] = "must be 1 to 32 lowercase letters, digits, dashes or underscores"
+	}
+	if in.Name == "" {
+		problems["name"] = "is required"
+	} else if len(in.Name) > 64 {
+		problems["name"] = "must be at most 64 characters"
+	}
+	if !ratePattern.MatchString(in.Rate) || percent(in.Rate).Cmp(big.NewRat(100, 1)) > 0 {
+		problems["rate"] = "must be a percentage between 0 and 100 with at most 4 decimals"
+	}
+
+	if len(problems) > 0 {
+		return problems
+	}
+	return nil
+}
+
+func Rates(ctx context.Context) ([]database.TaxRate, error) {
+	rates, err := database.GetTaxRates(ctx)
+	for i := range rates {
+		rates[i].Rate = formatRate(rates[i].Rate)
+	}
+	return rates, err
+}
+
+// SaveRate creates a rate, or updates the rate of the one with the same
+// country, region, class and name.
+func SaveRate(ctx context.Context, in RateInput) (database.TaxRate, error) {
+	in.normalize()
+	if err := in.validate(); err != nil {
+		return database.TaxRate{}, err
+	}
+
+	rate, err := database.SaveTaxRate(ctx, database.TaxRate{
+		Country:  in.Country,
+		Region:   in.Region,
+		TaxClass: in.TaxClass,
+		Name:     in.Name,
+		Rate:     in.Rate,
+	})
+	rate.Rate = formatRate(rate.Rate)
+	return rate, err
+}
+
+func DeleteRate(ctx context.Context, id int) error {
+	return database.DeleteTaxRate(ctx, id)
+}
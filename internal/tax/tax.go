@@ -0,0 +1,144 @@
+package tax
+
+import (
+	"context"
+	"math/big"
+	"strings"
+
+	"basicthreads/internal/config"
+	"basicthreads/internal/database"
+	"basicthreads/internal/money"
+)
+
+const (
+	// RoundLine rounds the tax of every line and adds the rounded amounts.
+	RoundLine = "line"
+	// RoundInvoice adds up the exact tax of every line and rounds each tax
+	// once, then splits it back over the lines.
+	RoundInvoice = "invoice"
+)
+
+// Line is an order line as tax sees it: its class and what the customer
+// pays for it after discounts.
+type Line struct {
+	Class  string
+	Amount money.Money
+}
+
+// Entry is one tax of an order, such as "IVA 13%".
+type Entry struct {
+	Name string
+	// Rate is the percentage, "13" or "7.25".
+	Rate string
+	// Taxable is the amount the tax is charged on, without the tax.
+	Taxable money.Money
+	Amount  money.Money
+}
+
+type Result struct {
+	// Lines has the tax of each line, in the order the lines came in.
+	Lines     []money.Money
+	Breakdown []Entry
+	Tax       money.Money
+	// Inclusive is true when the amounts already had the tax in them.
+	Inclusive bool
+}
+
+// Quote works out the tax of lines shipped to an address in country and
+// region, with the catalog and rounding settings from the configuration.
+func Quote(ctx context.Context, country, region string, lines []Line, currency string) (Result, error) {
+	rates, err := database.GetTaxRatesFor(ctx, country, region)
+	if err != nil {
+		return Result{}, err
+	}
+	cfg := config.Load()
+	return Compute(rates, lines, currency, cfg.PricesIncludeTax, cfg.TaxRounding), nil
+}
+
+// Compute works out the tax of lines under rates, which must all apply to
+// the shipping address. Every rate for the class of a line is charged on
+// it. When inclusive, line amounts already have the tax in them and the tax
+// is worked out of them rather than added.
+func Compute(rates []database.TaxRate, lines []Line, currency string, inclusive bool, rounding string) Result {
+	result := Result{
+		Lines:     make([]money.Money, len(lines)),
+		Breakdown: []Entry{},
+		Tax:       money.Zero(currency),
+		Inclusive: inclusive,
+	}
+	for i := range result.Lines {
+		result.Lines[i] = money.Zero(currency)
+	}
+
+	// Rates of the same class add up, which matters for inclusive prices:
+	// 100 with two 10% taxes has 100/1.2 net, not 100/1.1 for each.
+	classTotal := map[string]*big.Rat{}
+	for _, rate := range rates {
+		total, ok := classTotal[rate.TaxClass]
+		if !ok {
+			total = new(big.Rat)
+			classTotal[rate.TaxClass] = total
+		}
+		total.Add(total, percent(rate.Rate))
+	}
+
+	for _, rate := range rates {
+		// factor turns a line amount into the tax, and net into the amount
+		// the tax is charged on.
+		factor := new(big.Rat).Quo(percent(rate.Rate), big.NewRat(100, 1))
+		net := big.NewRat(1, 1)
+		if inclusive {
+			divisor := new(big.Rat).Add(big.NewRat(1, 1), new(big.Rat).Quo(classTotal[rate.TaxClass], big.NewRat(100, 1)))
+			factor.Quo(factor, divisor)
+			net.Quo(net, divisor)
+		}
+
+		entry := Entry{Name: rate.Name, Rate: formatRate(rate.Rate), Taxable: money.Zero(currency), Amount: money.Zero(currency)}
+		shares := make([]money.Money, len(lines))
+		weights := make([]int64, len(lines))
+		base := money.Zero(currency)
+		for i, line := range lines {
+			shares[i] = money.Zero(currency)
+			if line.Class != rate.TaxClass || line.Amount.Amount <= 0 {
+				continue
+			}
+			weights[i] = line.Amount.Amount
+			base = base.Add(line.Amount)
+			if rounding != RoundInvoice {
+				shares[i] = line.Amount.Mul(factor)
+			}
+		}
+		if base.IsZero() {
+			continue
+		}
+		if rounding == RoundInvoice {
+			shares = base.Mul(factor).Allocate(weights)
+		}
+
+		for i, share := range shares {
+			result.Lines[i] = result.Lines[i].Add(share)
+			entry.Amount = entry.Amount.Add(share)
+		}
+		entry.Taxable = base.Mul(net)
+		result.Breakdown = append(result.Breakdown, entry)
+		result.Tax = result.Tax.Add(entry.Amount)
+	}
+
+	return result
+}
+
+func percent(rate string) *big.Rat {
+	value, ok := new(big.Rat).SetString(rate)
+	if !ok {
+		return new(big.Rat)
+	}
+	return value
+}
+
+// formatRate drops the zeros MySQL pads DECIMAL rates with.
+func formatRate(rate string) string {
+	if strings.Contains(rate, ".") {
+		rate = strings.TrimRight(strings.TrimRight(rate, "0"), ".")
+	}
+	return rate
+}
@@ -0,0 +1,180 @@
+package tax
+
+import (
+	"reflect"
+	"testing"
+
+	"basicthreads/internal/database"
+	"basicthreads/internal/money"
+)
+
+func lines(class string, amounts ...int64) []Line {
+	list := []Line{}
+	for _, amount := range amounts {
+		list = append(list, Line{Class: class, Amount: money.New(amount, "USD")})
+	}
+	return list
+}
+
+func TestCompute(t *testing.T) {
+	vat5 := []database.TaxRate{{TaxClass: "standard", Name: "VAT", Rate: "5.0000"}}
+
+	tests := []struct {
+		name      string
+		rates     []database.TaxRate
+		lines     []Line
+		inclusive bool
+		rounding  string
+		// want has the tax of each line, taxable and entries the taxable
+		// amount and tax of each breakdown entry.
+		want    []int64
+		tax     int64
+		taxable []int64
+		entries []int64
+	}{
+		{
+			name:     "exclusive, rounded per line",
+			rates:    vat5,
+			lines:    lines("standard", 10, 10, 10),
+			rounding: RoundLine,
+			want:     []int64{1, 1, 1},
+			tax:      3,
+			taxable:  []int64{30},
+			entries:  []int64{3},
+		},
+		{
+			name:     "exclusive, rounded per invoice",
+			rates:    vat5,
+			lines:    lines("standard", 10, 10, 10),
+			rounding: RoundInvoice,
+			want:     []int64{1, 1, 0},
+			tax:      2,
+			taxable:  []int64{30},
+			entries:  []int64{2},
+		},
+		{
+			name:      "inclusive, rounded per line",
+			rates:     vat5,
+			lines:     lines("standard", 10, 10, 10),
+			inclusive: true,
+			rounding:  RoundLine,
+			want:      []int64{0, 0, 0},
+			tax:       0,
+			taxable:   []int64{29},
+			entries:   []int64{0},
+		},
+		{
+			name:      "inclusive, rounded per invoice",
+			rates:     vat5,
+			lines:     lines("standard", 10, 10, 10),
+			inclusive: true,
+			rounding:  RoundInvoice,
+			want:      []int64{1, 0, 0},
+			tax:       1,
+			taxable:   []int64{29},
+			entries:   []int64{1},
+		},
+		{
+			name:      "inclusive takes the tax out of the price",
+			rates:     []database.TaxRate{{TaxClass: "standard", Name: "IVA", Rate: "21"}},
+			lines:     lines("standard", 12100),
+			inclusive: true,
+			rounding:  RoundLine,
+			want:      []int64{2100},
+			tax:       2100,
+			taxable:   []int64{10000},
+			entries:   []int64{2100},
+		},
+		{
+			name: "inclusive rates of a class add up",
+			rates: []database.TaxRate{
+				{TaxClass: "standard", Name: "State", Rate: "10"},
+				{TaxClass: "standard", Name: "City", Rate: "10"},
+			},
+			lines:     lines("standard", 12000),
+			inclusive: true,
+			rounding:  RoundLine,
+			want:      []int64{2000},
+			tax:       2000,
+			taxable:   []int64{10000, 10000},
+			entries:   []int64{1000, 1000},
+		},
+		{
+			name: "exclusive rates each charged on the line",
+			rates: []database.TaxRate{
+				{TaxClass: "standard", Name: "State", Rate: "6.2500"},
+				{TaxClass: "standard", Name: "City", Rate: "1.0000"},
+			},
+			lines:    lines("standard", 1999),
+			rounding: RoundLine,
+			want:     []int64{145},
+			tax:      145,
+			taxable:  []int64{1999, 1999},
+			entries:  []int64{125, 20},
+		},
+		{
+			name:     "other classes, free and negative lines are not taxed",
+			rates:    vat5,
+			lines:    append(lines("standard", 1000, 0, -500), lines("reduced", 1000)...),
+			rounding: RoundInvoice,
+			want:     []int64{50, 0, 0, 0},
+			tax:      50,
+			taxable:  []int64{1000},
+			entries:  []int64{50},
+		},
+		{
+			name:     "no rates",
+			lines:    lines("standard", 1000),
+			rounding: RoundLine,
+			want:     []int64{0},
+			taxable:  []int64{},
+			entries:  []int64{},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			result := Compute(test.rates, test.lines, "USD", test.inclusive, test.rounding)
+
+			got := []int64{}
+			for _, line := range result.Lines {
+				got = append(got, line.Amount)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("line taxes %v, want %v", got, test.want)
+			}
+			if result.Tax.Amount != test.tax {
+				t.Errorf("tax %d, want %d", result.Tax.Amount, test.tax)
+			}
+			if result.Inclusive != test.inclusive {
+				t.Errorf("inclusive %v, want %v", result.Inclusive, test.inclusive)
+			}
+
+			taxable, entries := []int64{}, []int64{}
+			for _, entry := range result.Breakdown {
+				taxable = append(taxable, entry.Taxable.Amount)
+				entries = append(entries, entry.Amount.Amount)
+			}
+			if !reflect.DeepEqual(taxable, test.taxable) {
+				t.Errorf("taxable amounts %v, want %v", taxable, test.taxable)
+			}
+			if !reflect.DeepEqual(entries, test.entries) {
+				t.Errorf("breakdown taxes %v, want %v", entries, test.entries)
+			}
+		})
+	}
+}
+
+func TestFormatRate(t *testing.T) {
+	tests := map[string]string{
+		"13.0000": "13",
+		"7.2500":  "7.25",
+		"10":      "10",
+		"0.5000":  "0.5",
+	}
+	for rate, want := range tests {
+		if got := formatRate(rate); got != want {
+			t.Errorf("formatRate(%q) = %q, want %q", rate, got, want)
+		}
+	}
+}
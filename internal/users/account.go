@@ -0,0 +1,211 @@
+package users
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"html"
+	"log"
+	"net/mail"
+	"strings"
+	"time"
+
+	"basicthreads/internal/database"
+	"basicthreads/internal/mailer"
+	"basicthreads/internal/products"
+)
+
+// emailChangeTTL is how long the token that confirms a new email works.
+const emailChangeTTL = 24 * time.Hour
+
+// MinPasswordLength applies to passwords set from the account page.
+const MinPasswordLength = 8
+
+var (
+	ErrNotFound      = database.ErrNotFound
+	ErrWrongPassword = errors.New("current password is wrong")
+	ErrEmailTaken    = errors.New("email is already registered")
+	ErrInvalidToken  = errors.New("confirmation token is invalid or expired")
+)
+
+func randomHex(size int) (string, error) {
+	raw := make([]byte, size)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(raw), nil
+}
+
+// startSession records a login of the customer with email and returns the
+// id its token carries.
+func startSession(ctx context.Context, email string, client Client) (string, error) {
+	customerID, err := database.GetCustomerID(ctx, email)
+	if err != nil {
+		return "", err
+	}
+	tokenID, err := randomHex(16)
+	if err != nil {
+		return "", err
+	}
+
+	if len(client.UserAgent) > 255 {
+		client.UserAgent = client.UserAgent[:255]
+	}
+	err = database.CreateSession(ctx, database.Session{
+		CustomerID: customerID,
+		TokenID:    tokenID,
+		IP:         client.IP,
+		UserAgent:  client.UserAgent,
+	}, sessionTTL)
+	return tokenID, err
+}
+
+// SessionActive reports whether a token still belongs to a live session.
+// Tokens without an id predate sessions and are no longer accepted.
+func SessionActive(ctx context.Context, tokenID string) (bool, error) {
+	if tokenID == "" {
+		return false, nil
+	}
+	return database.SessionActive(ctx, tokenID)
+}
+
+func Profile(ctx context.Context, customerID int) (database.Customer, error) {
+	return database.GetCustomer(ctx, customerID)
+}
+
+// UpdateProfile changes the name and phone of a customer.
+func UpdateProfile(ctx context.Context, customerID int, name, phone string) (database.Customer, error) {
+	name = strings.TrimSpace(name)
+	phone = strings.TrimSpace(phone)
+
+	problems := products.ValidationError{}
+	if name == "" {
+		problems["name"] = "is required"
+	} else if len(name) > 255 {
+		problems["name"] = "must be at most 255 characters"
+	}
+	if phone == "" {
+		problems["phone"] = "is required"
+	} else if len(phone) > 32 {
+		problems["phone"] = "must be at most 32 characters"
+	}
+	if len(problems) > 0 {
+		return database.Customer{}, problems
+	}
+
+	if err := database.UpdateCustomerProfile(ctx, customerID, name, phone); err != nil {
+		return database.Customer{}, err
+	}
+	return database.GetCustomer(ctx, customerID)
+}
+
+// checkPassword turns a wrong password into ErrWrongPassword.
+func checkPassword(ctx context.Context, customerID int, password string) error {
+	matches, err := database.CheckPassword(ctx, customerID, password)
+	if err != nil {
+		return err
+	}
+	if !matches {
+		return ErrWrongPassword
+	}
+	return nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// RequestEmailChange emails a confirmation token to the new address. The
+// email only changes once the token comes back through ConfirmEmailChange,
+// which proves the customer reads the new address.
+func RequestEmailChange(ctx context.Context, customerID int, password, email string) error {
+	email = strings.TrimSpace(email)
+	if parsed, err := mail.ParseAddress(email); err != nil || parsed.Address != email || len(email) > 255 {
+		return products.ValidationError{"email": "must be a valid email address"}
+	}
+	if err := checkPassword(ctx, customerID, password); err != nil {
+		return err
+	}
+	if database.ValidateUserExists(ctx, email) {
+		return ErrEmailTaken
+	}
+	customer, err := database.GetCustomer(ctx, customerID)
+	if err != nil {
+		return err
+	}
+
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return err
+	}
+	token := base64.RawURLEncoding.EncodeToString(raw)
+	if err := database.SaveEmailChange(ctx, customerID, email, hashToken(token), emailChangeTTL); err != nil {
+		return err
+	}
+
+	return mailer.Send(ctx, mailer.Message{
+		To:      []mailer.Address{{Name: customer.Name, Email: email}},
+		Subject: "Confirma tu nuevo correo",
+		HTML: fmt.Sprintf(
+			"<html><body><p>Hola, %s.</p><p>Para usar este correo en tu cuenta de Threads, confirma con este código antes de 24 horas:</p><p><b>%s</b></p><p>Si no pediste el cambio, ignora este mensaje.</p></body></html>",
+			html.EscapeString(customer.Name),
+			token,
+		),
+	})
+}
+
+// ConfirmEmailChange applies the email change a token was sent for. Every
+// session of the customer ends, so they log in again with the new email.
+// The old address is told about the change.
+func ConfirmEmailChange(ctx context.Context, token string) error {
+	customer, email, err := database.ConfirmEmailChange(ctx, hashToken(token))
+	switch {
+	case errors.Is(err, database.ErrNotFound):
+		return ErrInvalidToken
+	case errors.Is(err, database.ErrDuplicate):
+		return ErrEmailTaken
+	case err != nil:
+		return err
+	}
+
+	err = mailer.Send(ctx, mailer.Message{
+		To:      []mailer.Address{{Name: customer.Name, Email: customer.Email}},
+		Subject: "Tu correo de Threads cambió",
+		HTML: fmt.Sprintf(
+			"<html><body><p>Hola, %s.</p><p>Tu cuenta ahora usa el correo <b>%s</b>. Si no fuiste tú, contáctanos.</p></body></html>",
+			html.EscapeString(customer.Name),
+			html.EscapeString(email),
+		),
+	})
+	if err != nil {
+		log.Printf("sending email change notice to customer %d: %v", customer.ID, err)
+	}
+	return nil
+}
+
+// ChangePassword sets a new password after checking the current one, and
+// signs out every session but the one making the change.
+func ChangePassword(ctx context.Context, customerID int, current, password, sessionID string) error {
+	if len(password) < MinPasswordLength {
+		return products.ValidationError{"password": fmt.Sprintf("must be at least %d characters", MinPasswordLength)}
+	}
+	if err := checkPassword(ctx, customerID, current); err != nil {
+		return err
+	}
+	return database.ChangePassword(ctx, customerID, password, sessionID)
+}
+
+// DeleteAccount anonymizes a customer once they confirm with their
+// password. Their orders stay for the accounts, with the email and
+// addresses copied onto them at checkout.
+func DeleteAccount(ctx context.Context, customerID int, password string) error {
+	if err := checkPassword(ctx, customerID, password); err != nil {
+		return err
+	}
+	return database.AnonymizeCustomer(ctx, customerID)
+}
@@ -1,16 +1,18 @@
 package users
 
 import (
+	"context"
 	"fmt"
-	"io"
-	"net/http"
-	"strings"
+	"html"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/labstack/echo/v4"
 
+	"basicthreads/internal/carts"
+	"basicthreads/internal/config"
 	"basicthreads/internal/database"
+	"basicthreads/internal/mailer"
 )
 
 type jwtCustomClaims struct {
@@ -19,7 +21,20 @@ type jwtCustomClaims struct {
 	jwt.RegisteredClaims
 }
 
-func LoginUser(email, password string) echo.Map {
+// sessionTTL is how long a login lasts.
+const sessionTTL = 4 * time.Hour
+
+// Client is where a login comes from, kept in the login history.
+type Client struct {
+	IP        string
+	UserAgent string
+}
+
+// LoginUser checks the credentials and returns a signed token, recording
+// the login as a session the token names. When the customer shopped as a
+// guest first, cartToken names the guest cart, which is merged into their
+// own.
+func LoginUser(ctx context.Context, email, password, cartToken string, client Client) echo.Map {
 	if len(email) == 0 || len(password) == 0 {
 		response := echo.Map{
 			"status":  "error",
@@ -29,7 +44,7 @@ func LoginUser(email, password string) echo.Map {
 		return response
 	}
 
-	authUser := database.AuthUser(email, password)
+	authUser := database.AuthUser(ctx, email, password)
 
 	if !authUser {
 		response := echo.Map{
@@ -41,11 +56,42 @@ func LoginUser(email, password string) echo.Map {
 		return response
 	}
 
+	role, err := database.GetCustomerRole(ctx, email)
+	if err != nil {
+		response := echo.Map{
+			"status":  "error",
+			"code":    500,
+			"message": "Internal server error",
+			"error":   "internal_server_error",
+		}
+
+		return response
+	}
+
+	if cartToken != "" {
+		if err := mergeCart(ctx, email, cartToken); err != nil {
+			fmt.Println(err)
+		}
+	}
+
+	tokenID, err := startSession(ctx, email, client)
+	if err != nil {
+		response := echo.Map{
+			"status":  "error",
+			"code":    500,
+			"message": "Internal server error",
+			"error":   "internal_server_error",
+		}
+
+		return response
+	}
+
 	claims := &jwtCustomClaims{
 		email,
-		true,
+		role == "admin",
 		jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour * 4)),
+			ID:        tokenID,
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(sessionTTL)),
 		},
 	}
 
@@ -53,7 +99,7 @@ func LoginUser(email, password string) echo.Map {
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
 
 	// Generate encoded token and send it as response.
-	t, err := token.SignedString([]byte("secret"))
+	t, err := token.SignedString([]byte(config.Load().JWTSecret))
 	if err != nil {
 		response := echo.Map{
 			"status":  "error",
@@ -74,7 +120,17 @@ func LoginUser(email, password string) echo.Map {
 	return response
 }
 
-func RegisterUser(name, email, phone, password string) echo.Map {
+// mergeCart never fails the login: the worst case is a guest cart left
+// behind.
+func mergeCart(ctx context.Context, email, cartToken string) error {
+	customerID, err := database.GetCustomerID(ctx, email)
+	if err != nil {
+		return err
+	}
+	return carts.Merge(ctx, cartToken, customerID)
+}
+
+func RegisterUser(ctx context.Context, name, email, phone, password string) echo.Map {
 	if len(name) == 0 || len(email) == 0 || len(phone) == 0 || len(password) == 0 {
 		response := echo.Map{
 			"status":  "error",
@@ -86,7 +142,7 @@ func RegisterUser(name, email, phone, password string) echo.Map {
 		return response
 	}
 
-	userExists := database.ValidateUserExists(email)
+	userExists := database.ValidateUserExists(ctx, email)
 	if userExists {
 		response := echo.Map{
 			"status":  "error",
@@ -97,7 +153,7 @@ func RegisterUser(name, email, phone, password string) echo.Map {
 		return response
 	}
 
-	err := database.RegisterUser(name, email, phone, password)
+	err := database.RegisterUser(ctx, name, email, phone, password)
 	if err != nil {
 		response := echo.Map{
 			"status":  "error",
@@ -108,7 +164,7 @@ func RegisterUser(name, email, phone, password string) echo.Map {
 		return response
 	}
 
-	sendMailRegister(email, name)
+	sendMailRegister(ctx, email, name)
 	response := echo.Map{
 		"status":  "success",
 		"code":    200,
@@ -118,23 +174,11 @@ func RegisterUser(name, email, phone, password string) echo.Map {
 	return response
 }
 
-func sendMailRegister(email, name string) {
-	url := "https://api.brevo.com/v3/smtp/email"
-	method := "POST"
-
-	payload := strings.NewReader(`{  
-   "sender":{  
-      "name":"Basic Threads",
-      "email":"basic@threads.com"
-   },
-   "to":[  
-      {  
-         "email":"` + email + `",
-         "name":"` + name + `"
-      }
-   ],
-   "subject":"Bienvenido a Threads",
-   "htmlContent":'<!doctype html>
+func sendMailRegister(ctx context.Context, email, name string) {
+	err := mailer.Send(ctx, mailer.Message{
+		To:      []mailer.Address{{Name: name, Email: email}},
+		Subject: "Bienvenido a Threads",
+		HTML: `<!doctype html>
 <html>
   <body>
     <div
@@ -160,7 +204,7 @@ func sendMailRegister(email, name string) {
               <div
                 style="font-size:16px;font-weight:bold;text-align:center;padding:12px 24px 16px 24px"
               >
-                Hola, ` + name + ` 👋,
+                Hola, ` + html.EscapeString(name) + ` 👋,
               </div>
               <div
                 style="color:#171717;background-color:#fefffc;font-size:16px;font-weight:bold;text-align:center;padding:12px 24px 12px 24px"
@@ -254,38 +298,14 @@ func sendMailRegister(email, name string) {
       </table>
     </div>
   </body>
-</html>'
-}`)
-
-	client := &http.Client{}
-	req, err := http.NewRequest(method, url, payload)
-	if err != nil {
-		fmt.Println(err)
-		return
-	}
-	req.Header.Add("accept", "application/json")
-	req.Header.Add(
-		"api-key",
-		"",
-	)
-	req.Header.Add("content-type", "application/json")
-
-	res, err := client.Do(req)
-	if err != nil {
-		fmt.Println(err)
-		return
-	}
-	defer res.Body.Close()
-
-	body, err := io.ReadAll(res.Body)
+</html>`,
+	})
 	if err != nil {
 		fmt.Println(err)
-		return
 	}
-	fmt.Println(string(body))
 }
 
-func ContactForm(name, email, message string) echo.Map {
+func ContactForm(ctx context.Context, name, email, message string) echo.Map {
 	if len(name) == 0 || len(email) == 0 || len(message) == 0 {
 		response := echo.Map{
 			"status":  "error",
@@ -295,7 +315,20 @@ func ContactForm(name, email, message string) echo.Map {
 		return response
 	}
 
-	sendMailContact(email, name, message)
+	// Messages are kept so they can be included in data exports.
+	if err := database.SaveContactMessage(ctx, name, email, message); err != nil {
+		fmt.Println(err)
+		response := echo.Map{
+			"status":  "error",
+			"code":    500,
+			"message": "Internal server error",
+			"error":   "internal_server_error",
+		}
+
+		return response
+	}
+
+	sendMailContact(ctx, email, name, message)
 
 	response := echo.Map{
 		"status":  "success",
@@ -306,55 +339,13 @@ func ContactForm(name, email, message string) echo.Map {
 	return response
 }
 
-func sendMailContact(email, name, message string) {
-	url := "https://api.brevo.com/v3/smtp/email"
-	method := "POST"
-
-	payload := strings.NewReader(`{  
-   "sender":{  
-      "name":"Basic Threads",
-      "email":"basic@threads.com"
-    },
-    "to":[
-      { 
-      "email":"mr1937012020@unab.edu.sv",
-        "name":"Basic Threads"
-      }
-    ],
-    "subject":"Threads - Nuevo comentario recibido",
-    "htmlContent":"<!doctype html><html><body><div style=\'background-color:#eff4f3;color:#242424;font-family:Charter,\"Bitstream Charter\",\"Sitka Text\",Cambria,serif;font-size:16px;font-weight:400;letter-spacing:.15008px;line-height:1.5;margin:0;padding:32px 0;min-height:100%;width:100%\'><table align=\"center\" width=\"100%\" style=\"margin:0 auto;max-width:600px;background-color:#dcdcdc\" role=\"presentation\" cellspacing=\"0\" cellpadding=\"0\" border=\"3\"><tbody><tr style=\"width:100%\"><td><div style=\"padding:0 24px 0 4px;background-color:#fcf8f8;text-align:center\"><a href=\"https://es.shein.com\" style=\"text-decoration:none\" target=\"_blank\"></a><hr></div><div style=\"font-size:16px;font-weight:700;text-align:center;padding:12px 24px 16px 24px\">¡Nuevo comentario recibido!</div><div style=\"color:#171717;background-color:#fefffc;font-size:16px;font-weight:700;text-align:center;padding:12px 24px 12px 24px\">Hemos recibido un nuevo comentario de un cliente. A continuación, se detallan los datos del cliente:</div><div style=\"font-size:13px;font-weight:700;text-align:center;padding:16px 24px 16px 24px\"><div class=\"container\"><div class=\"form-field\"><label for=\"nombre\">Nombre:</label><br><br><span>` + name + `</span><hr></div><div class=\"form-field\"><label for=\"email\">Correo Electrónico:</label><br><br><span>` + email + `</span><hr></div><div class=\"form-field\"><label for=\"comentarios\">Comentario:</label><br><br><span>` + message + `</span><hr></div></div></div><br><div style=\"text-align:center;padding:20px 24px 24px 24px\"><a href=\"https://www.usewaypoint.com\" style=\"color:#0a0a0a;font-size:17px;font-weight:700;background-color:#f4f8fa;border-radius:64px;display:block;padding:8px 12px;text-decoration:none\" target=\"_blank\"><span>Basic Threads</span></a></div></td></tr></tbody></table></div></body></html>"
-
-}`)
-
-	client := &http.Client{}
-	req, err := http.NewRequest(method, url, payload)
-	if err != nil {
-		fmt.Println(err)
-		return
-	}
-
-	req.Header.Add("accept", "application/json")
-
-	req.Header.Add(
-		"api-key",
-		"",
-	)
-	req.Header.Add("content-type", "application/json")
-
-	res, err := client.Do(req)
-	if err != nil {
-		fmt.Println(err)
-		return
-	}
-
-	defer res.Body.Close()
-
-	body, err := io.ReadAll(res.Body)
+func sendMailContact(ctx context.Context, email, name, message string) {
+	err := mailer.Send(ctx, mailer.Message{
+		To:      []mailer.Address{{Name: "Basic Threads", Email: config.Load().NotifyEmail}},
+		Subject: "Threads - Nuevo comentario recibido",
+		HTML:    `<!doctype html><html><body><div style='background-color:#eff4f3;color:#242424;font-family:Charter,"Bitstream Charter","Sitka Text",Cambria,serif;font-size:16px;font-weight:400;letter-spacing:.15008px;line-height:1.5;margin:0;padding:32px 0;min-height:100%;width:100%'><table align="center" width="100%" style="margin:0 auto;max-width:600px;background-color:#dcdcdc" role="presentation" cellspacing="0" cellpadding="0" border="3"><tbody><tr style="width:100%"><td><div style="padding:0 24px 0 4px;background-color:#fcf8f8;text-align:center"><a href="https://es.shein.com" style="text-decoration:none" target="_blank"></a><hr></div><div style="font-size:16px;font-weight:700;text-align:center;padding:12px 24px 16px 24px">¡Nuevo comentario recibido!</div><div style="color:#171717;background-color:#fefffc;font-size:16px;font-weight:700;text-align:center;padding:12px 24px 12px 24px">Hemos recibido un nuevo comentario de un cliente. A continuación, se detallan los datos del cliente:</div><div style="font-size:13px;font-weight:700;text-align:center;padding:16px 24px 16px 24px"><div class="container"><div class="form-field"><label for="nombre">Nombre:</label><br><br><span>` + html.EscapeString(name) + `</span><hr></div><div class="form-field"><label for="email">Correo Electrónico:</label><br><br><span>` + html.EscapeString(email) + `</span><hr></div><div class="form-field"><label for="comentarios">Comentario:</label><br><br><span>` + html.EscapeString(message) + `</span><hr></div></div></div><br><div style="text-align:center;padding:20px 24px 24px 24px"><a href="https://www.usewaypoint.com" style="color:#0a0a0a;font-size:17px;font-weight:700;background-color:#f4f8fa;border-radius:64px;display:block;padding:8px 12px;text-decoration:none" target="_blank"><span>Basic Threads</span></a></div></td></tr></tbody></table></div></body></html>`,
+	})
 	if err != nil {
-
 		fmt.Println(err)
-		return
 	}
-
-	fmt.Println(string(body))
 }
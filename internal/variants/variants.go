@@ -0,0 +1,168 @@
+package variants
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"strings"
+
+	"basicthreads/internal/database"
+	"basicthreads/internal/money"
+	"basicthreads/internal/products"
+)
+
+var (
+	ErrNotFound        = database.ErrNotFound
+	ErrProductNotFound = errors.New("product not found")
+	ErrDuplicate       = errors.New("another variant already uses this SKU, barcode or size and color")
+)
+
+var skuPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{0,63}$`)
+
+// Input describes a variant. Stock is only read on create, where it is
+// recorded as the first receipt; after that stock moves through inventory.
+type Input struct {
+	SKU     string
+	Size    string
+	Color   string
+	Price   *money.Money
+	Barcode string
+	Stock   int
+}
+
+func (in *Input) normalize() {
+	in.SKU = strings.ToUpper(strings.TrimSpace(in.SKU))
+	in.Size = strings.ToUpper(strings.TrimSpace(in.Size))
+	in.Color = strings.ToLower(strings.TrimSpace(in.Color))
+	in.Barcode = strings.TrimSpace(in.Barcode)
+}
+
+func (in Input) validate() error {
+	problems := products.ValidationError{}
+
+	if !skuPattern.MatchString(in.SKU) {
+		problems["sku"] = "must be 1 to 64 letters, digits, dots, dashes or underscores"
+	}
+	if len(in.Size) > 16 {
+		problems["size"] = "must be at most 16 characters"
+	}
+	if len(in.Color) > 32 {
+		problems["color"] = "must be at most 32 characters"
+	}
+	if in.Price != nil {
+		if problem := products.PriceProblem(*in.Price); problem != "" {
+			problems["price"] = problem
+		}
+	}
+	if in.Barcode != "" && !ValidGTIN(in.Barcode) {
+		problems["barcode"] = "must be a valid EAN-8, UPC-A, EAN-13 or GTIN-14 code"
+	}
+	if in.Stock < 0 {
+		problems["stock"] = "cannot be negative"
+	}
+
+	if len(problems) > 0 {
+		return problems
+	}
+	return nil
+}
+
+// ValidGTIN checks the length and check digit of a GTIN barcode.
+func ValidGTIN(code string) bool {
+	switch len(code) {
+	case 8, 12, 13, 14:
+	default:
+		return false
+	}
+
+	sum := 0
+	for i := len(code) - 2; i >= 0; i-- {
+		digit := int(code[i] - '0')
+		if digit < 0 || digit > 9 {
+			return false
+		}
+		// Weights alternate 3, 1, 3... starting next to the check digit.
+		if (len(code)-2-i)%2 == 0 {
+			digit *= 3
+		}
+		sum += digit
+	}
+
+	check := int(code[len(code)-1] - '0')
+	return check >= 0 && check <= 9 && (10-sum%10)%10 == check
+}
+
+func List(ctx context.Context, productID int) ([]database.Variant, error) {
+	if _, err := database.GetAdminProduct(ctx, productID); err != nil {
+		if errors.Is(err, database.ErrNotFound) {
+			return nil, ErrProductNotFound
+		}
+		return nil, err
+	}
+	return database.GetProductVariants(ctx, productID)
+}
+
+func Create(ctx context.Context, actor string, productID int, in Input) (database.Variant, error) {
+	in.normalize()
+	if err := in.validate(); err != nil {
+		return database.Variant{}, err
+	}
+
+	product, err := database.GetAdminProduct(ctx, productID)
+	if errors.Is(err, database.ErrNotFound) || product.DeletedAt != nil {
+		return database.Variant{}, ErrProductNotFound
+	}
+	if err != nil {
+		return database.Variant{}, err
+	}
+
+	variant, err := database.CreateVariant(ctx, database.Variant{
+		ProductID: productID,
+		SKU:       in.SKU,
+		Size:      in.Size,
+		Color:     in.Color,
+		Price:     in.Price,
+		Barcode:   in.Barcode,
+	})
+	if errors.Is(err, database.ErrDuplicate) {
+		return variant, ErrDuplicate
+	}
+	if err != nil || in.Stock == 0 {
+		return variant, err
+	}
+
+	_, err = database.RecordMovement(ctx, variant.ID, database.MovementReceipt, in.Stock, "", actor, "initial stock")
+	variant.Stock = in.Stock
+	return variant, err
+}
+
+func Update(ctx context.Context, id int, in Input) (database.Variant, error) {
+	in.normalize()
+	if err := in.validate(); err != nil {
+		return database.Variant{}, err
+	}
+
+	variant, err := database.GetVariant(ctx, id)
+	if err != nil {
+		return variant, err
+	}
+
+	variant.SKU = in.SKU
+	variant.Size = in.Size
+	variant.Color = in.Color
+	variant.Price = in.Price
+	variant.Barcode = in.Barcode
+
+	err = database.UpdateVariant(ctx, variant)
+	if errors.Is(err, database.ErrDuplicate) {
+		return variant, ErrDuplicate
+	}
+	return variant, err
+}
+
+func Delete(ctx context.Context, id int) error {
+	if _, err := database.GetVariant(ctx, id); err != nil {
+		return err
+	}
+	return database.DeleteVariant(ctx, id)
+}
@@ -0,0 +1,289 @@
+package wishlists
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"html"
+	"log"
+	"strconv"
+	"strings"
+
+	"basicthreads/internal/config"
+	"basicthreads/internal/database"
+	"basicthreads/internal/mailer"
+	"basicthreads/internal/products"
+)
+
+// Caps on the wishlists of a customer and the items of each.
+const (
+	MaxWishlists = 20
+	MaxItems     = 200
+)
+
+var (
+	ErrNotFound        = database.ErrNotFound
+	ErrProductNotFound = errors.New("product not found")
+	ErrDuplicateName   = errors.New("a wishlist with that name already exists")
+	ErrTooMany         = fmt.Errorf("a customer can keep at most %d wishlists", MaxWishlists)
+	ErrFull            = fmt.Errorf("a wishlist holds at most %d items", MaxItems)
+)
+
+// Input names a wishlist and says whether it has a share link.
+type Input struct {
+	Name   string
+	Shared bool
+}
+
+func (in Input) validate() error {
+	problems := products.ValidationError{}
+	if in.Name == "" {
+		problems["name"] = "is required"
+	} else if len(in.Name) > 64 {
+		problems["name"] = "must be at most 64 characters"
+	}
+	if len(problems) > 0 {
+		return problems
+	}
+	return nil
+}
+
+// shareToken returns an unguessable token for a share link.
+func shareToken() (string, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(raw), nil
+}
+
+func List(ctx context.Context, customerID int) ([]database.Wishlist, error) {
+	return database.GetWishlists(ctx, customerID)
+}
+
+// Get returns a wishlist of a customer with its items.
+func Get(ctx context.Context, customerID, id int) (database.Wishlist, error) {
+	wishlist, err := database.GetWishlist(ctx, customerID, id)
+	if err != nil {
+		return wishlist, err
+	}
+	wishlist.Items, err = database.GetWishlistItems(ctx, wishlist.ID)
+	return wishlist, err
+}
+
+// Shared returns the wishlist a share token is for, with the items still
+// in the catalog. The token itself is left out, as the reader has it.
+func Shared(ctx context.Context, token string) (database.Wishlist, error) {
+	if token == "" {
+		return database.Wishlist{}, ErrNotFound
+	}
+	wishlist, err := database.GetSharedWishlist(ctx, token)
+	if err != nil {
+		return wishlist, err
+	}
+	items, err := database.GetWishlistItems(ctx, wishlist.ID)
+	if err != nil {
+		return wishlist, err
+	}
+
+	wishlist.ShareToken = ""
+	wishlist.Items = []database.WishlistItem{}
+	for _, item := range items {
+		if item.Live {
+			item.NotifyRestock = false
+			wishlist.Items = append(wishlist.Items, item)
+		}
+	}
+	wishlist.ItemCount = len(wishlist.Items)
+	return wishlist, nil
+}
+
+func Create(ctx context.Context, customerID int, in Input) (database.Wishlist, error) {
+	in.Name = strings.TrimSpace(in.Name)
+	if err := in.validate(); err != nil {
+		return database.Wishlist{}, err
+	}
+	count, err := database.CountWishlists(ctx, customerID)
+	if err != nil {
+		return database.Wishlist{}, err
+	}
+	if count >= MaxWishlists {
+		return database.Wishlist{}, ErrTooMany
+	}
+
+	wishlist := database.Wishlist{CustomerID: customerID, Name: in.Name}
+	if in.Shared {
+		if wishlist.ShareToken, err = shareToken(); err != nil {
+			return wishlist, err
+		}
+	}
+	wishlist, err = database.CreateWishlist(ctx, wishlist)
+	if errors.Is(err, database.ErrDuplicate) {
+		return wishlist, ErrDuplicateName
+	}
+	return wishlist, err
+}
+
+// Update renames a wishlist and turns its share link on or off. A list
+// that stays shared keeps its token, so links already handed out work.
+func Update(ctx context.Context, customerID, id int, in Input) (database.Wishlist, error) {
+	in.Name = strings.TrimSpace(in.Name)
+	if err := in.validate(); err != nil {
+		return database.Wishlist{}, err
+	}
+	wishlist, err := database.GetWishlist(ctx, customerID, id)
+	if err != nil {
+		return wishlist, err
+	}
+
+	wishlist.Name = in.Name
+	switch {
+	case !in.Shared:
+		wishlist.ShareToken = ""
+	case wishlist.ShareToken == "":
+		if wishlist.ShareToken, err = shareToken(); err != nil {
+			return wishlist, err
+		}
+	}
+	wishlist, err = database.UpdateWishlist(ctx, wishlist)
+	if errors.Is(err, database.ErrDuplicate) {
+		return wishlist, ErrDuplicateName
+	}
+	return wishlist, err
+}
+
+func Delete(ctx context.Context, customerID, id int) error {
+	return database.DeleteWishlist(ctx, customerID, id)
+}
+
+// AddItem puts a product on a wishlist, optionally narrowed to a variant.
+// Adding an item that is already there only updates its restock flag,
+// which needs a variant.
+func AddItem(ctx context.Context, customerID, id, productID int, variantID *int, notify bool) (database.Wishlist, error) {
+	wishlist, err := database.GetWishlist(ctx, customerID, id)
+	if err != nil {
+		return wishlist, err
+	}
+	if notify && variantID == nil {
+		return wishlist, products.ValidationError{"notify": "needs a variant"}
+	}
+
+	product, err := database.GetAdminProduct(ctx, productID)
+	if errors.Is(err, database.ErrNotFound) || (err == nil && (product.DeletedAt != nil || !product.Available)) {
+		return wishlist, ErrProductNotFound
+	}
+	if err != nil {
+		return wishlist, err
+	}
+	if variantID != nil {
+		variants, err := database.GetProductVariants(ctx, productID)
+		if err != nil {
+			return wishlist, err
+		}
+		found := false
+		for _, variant := range variants {
+			found = found || variant.ID == *variantID
+		}
+		if !found {
+			return wishlist, products.ValidationError{"variant": "does not belong to this product"}
+		}
+	}
+
+	count, err := database.CountWishlistItems(ctx, wishlist.ID)
+	if err != nil {
+		return wishlist, err
+	}
+	// A full list may still change the flag of an item it has.
+	if count >= MaxItems {
+		items, err := database.GetWishlistItems(ctx, wishlist.ID)
+		if err != nil {
+			return wishlist, err
+		}
+		if !hasItem(items, productID, variantID) {
+			return wishlist, ErrFull
+		}
+	}
+
+	if _, err := database.SaveWishlistItem(ctx, wishlist.ID, productID, variantID, notify); err != nil {
+		return wishlist, err
+	}
+	return Get(ctx, customerID, id)
+}
+
+func hasItem(items []database.WishlistItem, productID int, variantID *int) bool {
+	for _, item := range items {
+		if item.ProductID != productID {
+			continue
+		}
+		if (item.VariantID == nil && variantID == nil) || (item.VariantID != nil && variantID != nil && *item.VariantID == *variantID) {
+			return true
+		}
+	}
+	return false
+}
+
+func RemoveItem(ctx context.Context, customerID, id, itemID int) (database.Wishlist, error) {
+	wishlist, err := database.GetWishlist(ctx, customerID, id)
+	if err != nil {
+		return wishlist, err
+	}
+	if err := database.DeleteWishlistItem(ctx, wishlist.ID, itemID); err != nil {
+		return wishlist, err
+	}
+	return Get(ctx, customerID, id)
+}
+
+// NotifyRestocked emails the customers waiting for a variant that just
+// came back in stock. Each wishlist item asks for one email, and is claimed
+// before it is sent.
+func NotifyRestocked(ctx context.Context, variantID int) {
+	subscribers, err := database.GetRestockSubscribers(ctx, variantID)
+	if err != nil {
+		log.Printf("finding restock subscribers of variant %d: %v", variantID, err)
+		return
+	}
+
+	link := config.Load().PublicURL + "/product/"
+	for _, s := range subscribers {
+		claimed, err := database.ClaimRestockNotification(ctx, s.ItemID)
+		if err != nil {
+			log.Printf("claiming restock email for wishlist item %d: %v", s.ItemID, err)
+			continue
+		}
+		if !claimed {
+			// Another restock got to this customer first.
+			continue
+		}
+
+		details := []string{}
+		for _, value := range []string{s.Size, s.Color} {
+			if value != "" {
+				details = append(details, html.EscapeString(value))
+			}
+		}
+		variant := html.EscapeString(s.SKU)
+		if len(details) > 0 {
+			variant = strings.Join(details, ", ")
+		}
+
+		err = mailer.Send(ctx, mailer.Message{
+			To:      []mailer.Address{{Name: s.Name, Email: s.Email}},
+			Subject: "De nuevo disponible: " + s.ProductName,
+			HTML: fmt.Sprintf(
+				`<html><body><p>Hola, %s.</p><p><b>%s</b> (%s), de tu lista de deseos, vuelve a estar disponible.</p><p><a href="%s">Ver producto</a></p></body></html>`,
+				html.EscapeString(s.Name),
+				html.EscapeString(s.ProductName),
+				variant,
+				html.EscapeString(link+strconv.Itoa(s.ProductID)),
+			),
+		})
+		if err != nil {
+			log.Printf("sending restock email for wishlist item %d: %v", s.ItemID, err)
+			if err := database.RestoreRestockNotification(ctx, s.ItemID); err != nil {
+				log.Printf("restoring restock flag of wishlist item %d: %v", s.ItemID, err)
+			}
+		}
+	}
+}